@@ -22,6 +22,8 @@ print_usage() {
     echo "  --version <ver>     all-smi version to build (default: v0.9.0)"
     echo "  --port <port>       Port for all-smi API (default: 9090)"
     echo "  --interval <sec>    Metrics collection interval in seconds (default: 5)"
+    echo "  --registry <url>    Host registration service; starts algalon-agent to register"
+    echo "                      this worker (e.g. http://10.128.0.10:8430)"
     echo "  --help              Show this help message"
     echo ""
     echo "Description:"
@@ -74,11 +76,15 @@ setup_worker() {
     local version="${1:-v0.9.0}"
     local port="${2:-9090}"
     local interval="${3:-5}"
+    local registry="${4:-}"
 
     echo -e "${BLUE}🏗️  Setting up Algalon Worker (Hardware Metrics Exporter)...${NC}"
     echo "   🏷️  all-smi version: ${version}"
     echo "   🔌 Port: ${port}"
     echo "   ⏱️  Interval: ${interval}s"
+    if [[ -n "${registry}" ]]; then
+        echo "   🛰️  Registry: ${registry}"
+    fi
     echo ""
     
     check_hardware_runtime
@@ -87,6 +93,10 @@ setup_worker() {
     export ALL_SMI_VERSION="${version}"
     export ALL_SMI_PORT="${port}"
     export ALL_SMI_INTERVAL="${interval}"
+    if [[ -n "${registry}" ]]; then
+        export ALGALON_REGISTRY_URL="${registry}"
+        export COMPOSE_PROFILES="agent"
+    fi
     
     echo "🏗️ Generating Dockerfile for all-smi ${version}..."
     ./generate-dockerfile.sh "${version}" "${port}"
@@ -143,6 +153,7 @@ setup_worker() {
 ALL_SMI_VERSION="v0.9.0"
 ALL_SMI_PORT="9090"
 ALL_SMI_INTERVAL="5"
+ALGALON_REGISTRY_URL=""
 
 while [[ $# -gt 0 ]]; do
     case $1 in
@@ -158,6 +169,10 @@ while [[ $# -gt 0 ]]; do
             ALL_SMI_INTERVAL="$2"
             shift 2
             ;;
+        --registry)
+            ALGALON_REGISTRY_URL="$2"
+            shift 2
+            ;;
         --help|-h)
             print_usage
             exit 0
@@ -172,4 +187,4 @@ done
 
 # Main script logic
 check_docker
-setup_worker "${ALL_SMI_VERSION}" "${ALL_SMI_PORT}" "${ALL_SMI_INTERVAL}"
\ No newline at end of file
+setup_worker "${ALL_SMI_VERSION}" "${ALL_SMI_PORT}" "${ALL_SMI_INTERVAL}" "${ALGALON_REGISTRY_URL}"
\ No newline at end of file
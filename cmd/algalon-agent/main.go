@@ -0,0 +1,154 @@
+// Command algalon-agent runs on a worker and keeps it registered with the
+// monitoring host's algalon-registry service. It registers once all-smi
+// answers on its port, re-registers on every heartbeat, and deregisters on
+// SIGTERM or when GCE announces a preemption or host maintenance, so the
+// host stops scraping the worker before it disappears.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/appleparan/Algalon/pkg/agent"
+	"github.com/appleparan/Algalon/pkg/config"
+	"github.com/appleparan/Algalon/pkg/discovery"
+	"github.com/appleparan/Algalon/pkg/envfile"
+	"github.com/appleparan/Algalon/pkg/targets"
+)
+
+func main() {
+	var (
+		registryURL string
+		advertise   string
+		envFile     string
+		port        int
+		heartbeat   time.Duration
+		waitReady   bool
+		gceNotices  bool
+	)
+	labels := labelFlag{}
+
+	flag.StringVar(&registryURL, "registry", "", "Registration service URL, e.g. http://10.128.0.10:8430 (overrides ALGALON_REGISTRY_URL)")
+	flag.StringVar(&advertise, "advertise", "", "host:port the monitoring host scrapes (default: the local IP routed to the registry and the all-smi port)")
+	flag.StringVar(&envFile, "env", "", "Worker env file providing ALL_SMI_PORT, WORKER_LABELS and ALGALON_REGISTRY_URL, e.g. algalon_worker/.env")
+	flag.IntVar(&port, "port", 0, "all-smi port (overrides ALL_SMI_PORT)")
+	flag.Var(labels, "label", "Extra target label as name=value; may be repeated")
+	flag.DurationVar(&heartbeat, "heartbeat", agent.DefaultHeartbeatInterval, "Interval between heartbeats")
+	flag.BoolVar(&waitReady, "wait-ready", true, "Wait until all-smi exports all_smi_info before registering")
+	flag.BoolVar(&gceNotices, "gce-notices", true, "Deregister on GCE preemption and host maintenance notices")
+	flag.Parse()
+
+	logger := log.New(os.Stderr, "algalon-agent: ", log.LstdFlags)
+
+	lookup := os.LookupEnv
+	if envFile != "" {
+		env, err := envfile.ReadFile(envFile)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		lookup = envfile.Chain(os.LookupEnv, env.Lookup)
+	}
+	worker, err := config.WorkerFromEnv(lookup)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if port != 0 {
+		worker.Port = port
+	}
+	if registryURL != "" {
+		worker.RegistryURL = registryURL
+	}
+	if err := worker.Validate(); err != nil {
+		logger.Fatal(err)
+	}
+	registryURL = worker.RegistryURL
+	if registryURL == "" {
+		logger.Fatal("no registry URL; use --registry or ALGALON_REGISTRY_URL")
+	}
+	for name, value := range labels {
+		worker.Labels[name] = value
+	}
+
+	if advertise == "" {
+		ip, err := agent.AdvertiseAddress(registryURL)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		advertise = net.JoinHostPort(ip, strconv.Itoa(worker.Port))
+	}
+	target, err := targets.ParseTarget(advertise, worker.Port)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	a := &agent.Agent{
+		RegistryURL:       registryURL,
+		Target:            target.String(),
+		Labels:            worker.Labels,
+		HeartbeatInterval: heartbeat,
+		Logger:            logger,
+	}
+	if waitReady {
+		local := net.JoinHostPort("localhost", strconv.Itoa(worker.Port))
+		a.Ready = func(ctx context.Context) error { return waitForAllSmi(ctx, local) }
+	}
+	if gceNotices {
+		a.Notices = []agent.Notice{agent.PreemptionNotice(), agent.MaintenanceNotice()}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	logger.Printf("advertising %s to %s", a.Target, registryURL)
+	if err := a.Run(ctx); err != nil {
+		logger.Fatal(err)
+	}
+}
+
+// waitForAllSmi polls address until its /metrics page exports all_smi_info.
+func waitForAllSmi(ctx context.Context, address string) error {
+	scanner := discovery.NewScanner(targets.DefaultPort)
+	for {
+		if ok, _ := scanner.Probe(ctx, address); ok {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// labelFlag collects repeated -label name=value flags.
+type labelFlag map[string]string
+
+func (l labelFlag) String() string {
+	pairs := make([]string, 0, len(l))
+	for name, value := range l {
+		pairs = append(pairs, name+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (l labelFlag) Set(value string) error {
+	name, labelValue, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("label %q must be name=value", value)
+	}
+	if err := targets.ValidateGroupLabelName(name); err != nil {
+		return err
+	}
+	l[name] = labelValue
+	return nil
+}
@@ -0,0 +1,99 @@
+// Command algalon-cost-report reports how many hours each GPU sat idle over
+// a window and what those hours cost. It reads all_smi_gpu_utilization from
+// VictoriaMetrics with query_range and prices each GPU by its gpu_type label.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/appleparan/Algalon/pkg/cost"
+	"github.com/appleparan/Algalon/pkg/vmclient"
+	"github.com/prometheus/common/model"
+)
+
+func main() {
+	var (
+		vmURL     string
+		query     string
+		window    string
+		endFlag   string
+		step      time.Duration
+		threshold float64
+		prices    string
+		format    string
+		output    string
+	)
+
+	flag.StringVar(&vmURL, "victoriametrics", vmclient.DefaultURL, "VictoriaMetrics URL")
+	flag.StringVar(&query, "query", cost.DefaultQuery, "GPU utilization in percent, one series per GPU (e.g. add a cluster selector)")
+	flag.StringVar(&window, "window", "7d", "Report window ending at -end, e.g. 24h, 7d or 30d")
+	flag.StringVar(&endFlag, "end", "", "End of the window as RFC 3339 (default: now)")
+	flag.DurationVar(&step, "step", cost.DefaultStep, "Resolution of the utilization history")
+	flag.Float64Var(&threshold, "threshold", cost.DefaultThreshold, "A GPU below this utilization in percent is idle")
+	flag.StringVar(&prices, "prices", "", "YAML price table per gpu_type, e.g. examples/cost/gpu-prices.yml (default: built-in on-demand prices)")
+	flag.StringVar(&format, "format", cost.FormatMarkdown, "Output format: csv, json or markdown")
+	flag.StringVar(&output, "output", "", "Write the report to this file instead of stdout")
+	flag.Parse()
+
+	if err := cost.CheckFormat(format); err != nil {
+		fatal(err)
+	}
+	length, err := model.ParseDuration(window)
+	if err != nil {
+		fatal(fmt.Errorf("invalid -window %q: %v", window, err))
+	}
+	end := time.Now()
+	if endFlag != "" {
+		if end, err = time.Parse(time.RFC3339, endFlag); err != nil {
+			fatal(fmt.Errorf("invalid -end %q: expected RFC 3339, e.g. 2026-03-01T00:00:00Z", endFlag))
+		}
+	}
+
+	table := cost.DefaultPrices()
+	if prices != "" {
+		if table, err = cost.LoadPrices(prices); err != nil {
+			fatal(err)
+		}
+	}
+
+	reporter := &cost.Reporter{
+		Querier:   vmclient.NewClient(vmURL),
+		Prices:    table,
+		Query:     query,
+		Threshold: threshold,
+		Step:      step,
+	}
+	report, err := reporter.Generate(context.Background(), end.Add(-time.Duration(length)), end)
+	if err != nil {
+		fatal(err)
+	}
+
+	if output == "" {
+		if err := cost.Write(os.Stdout, report, format); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	out, err := os.Create(output)
+	if err != nil {
+		fatal(err)
+	}
+	if err := cost.Write(out, report, format); err != nil {
+		out.Close()
+		fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "✅ Report for %d GPUs written to %s\n", len(report.Rows), output)
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+	os.Exit(1)
+}
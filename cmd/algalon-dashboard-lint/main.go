@@ -0,0 +1,72 @@
+// Command algalon-dashboard-lint checks that every panel and template variable
+// query in the Grafana dashboards parses as PromQL and selects only metrics
+// listed in the metric catalog.
+//
+// Usage: algalon-dashboard-lint [-exporters all-smi,vmagent] [dashboard.json|dir ...]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/appleparan/Algalon/pkg/catalog"
+	"github.com/appleparan/Algalon/pkg/dashboards"
+)
+
+func main() {
+	exporters := flag.String("exporters", "", "Comma-separated exporters whose metrics dashboards may query (default: every exporter in the catalog)")
+	catalogFile := flag.String("catalog", "", "Metric catalog YAML to use instead of the built-in one")
+	flag.Parse()
+
+	metrics := catalog.Default()
+	if *catalogFile != "" {
+		data, err := os.ReadFile(*catalogFile)
+		if err != nil {
+			fatal(err)
+		}
+		if metrics, err = catalog.Parse(data); err != nil {
+			fatal(err)
+		}
+	}
+
+	var allowed []string
+	if *exporters != "" {
+		for _, name := range strings.Split(*exporters, ",") {
+			allowed = append(allowed, strings.TrimSpace(name))
+		}
+	}
+
+	linter, err := dashboards.NewLinter(metrics, allowed...)
+	if err != nil {
+		fatal(err)
+	}
+
+	paths := flag.Args()
+	if len(paths) == 0 {
+		paths = []string{filepath.Join("grafana", "dashboards")}
+	}
+
+	fmt.Printf("🔍 Linting dashboards against metric catalog v%d...\n", metrics.Version)
+
+	count, issues, err := linter.LintPaths(paths...)
+	if err != nil {
+		fatal(err)
+	}
+
+	for _, issue := range issues {
+		fmt.Printf("   ❌ %s\n", issue)
+	}
+	if len(issues) > 0 {
+		fatal(fmt.Errorf("%d problem(s) found in %d dashboard(s)", len(issues), count))
+	}
+
+	fmt.Printf("✅ All queries in %d dashboard(s) use known metrics\n", count)
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+	os.Exit(1)
+}
@@ -0,0 +1,141 @@
+// Command algalon-discovery scans a network for all-smi workers and registers
+// them in the all-smi file_sd target file. It accepts the same flags as
+// algalon_host/scripts/worker-discovery.sh but probes hosts concurrently and
+// only registers endpoints that export all_smi_info.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/appleparan/Algalon/pkg/discovery"
+	"github.com/appleparan/Algalon/pkg/history"
+	"github.com/appleparan/Algalon/pkg/registry"
+	"github.com/appleparan/Algalon/pkg/targets"
+)
+
+func main() {
+	var (
+		network     string
+		port        int
+		file        string
+		historyDir  string
+		logFile     string
+		daemon      bool
+		dryRun      bool
+		concurrency int
+	)
+	interval := discovery.DefaultInterval
+	timeout := discovery.DefaultTimeout
+
+	flag.StringVar(&network, "network", "", "Network range to scan (e.g., 192.168.1.0/24); auto-detected if empty")
+	flag.StringVar(&network, "n", "", "Shorthand for --network")
+	flag.IntVar(&port, "port", discovery.DefaultPort, "Port to scan for workers")
+	flag.IntVar(&port, "p", discovery.DefaultPort, "Shorthand for --port")
+	flag.Var((*seconds)(&interval), "interval", "Discovery interval in seconds (or a Go duration) in daemon mode")
+	flag.Var((*seconds)(&interval), "i", "Shorthand for --interval")
+	flag.StringVar(&file, "file", registry.DefaultTargetsFile, "Path to targets configuration file")
+	flag.StringVar(&file, "f", registry.DefaultTargetsFile, "Shorthand for --file")
+	flag.StringVar(&historyDir, "history", history.DefaultDir, "Directory to record every change of the target directory in; empty disables history")
+	flag.StringVar(&logFile, "log", "/var/log/worker-discovery.log", "Path to discovery log file (daemon mode)")
+	flag.StringVar(&logFile, "l", "/var/log/worker-discovery.log", "Shorthand for --log")
+	flag.BoolVar(&daemon, "daemon", false, "Run as daemon (continuous discovery)")
+	flag.BoolVar(&daemon, "d", false, "Shorthand for --daemon")
+	flag.BoolVar(&dryRun, "dry-run", false, "Show what would be discovered without registration")
+	flag.IntVar(&concurrency, "concurrency", discovery.DefaultConcurrency, "Number of hosts probed in parallel")
+	flag.DurationVar(&timeout, "timeout", timeout, "Per-host probe timeout")
+	flag.Parse()
+
+	logger := log.New(os.Stderr, "algalon-discovery: ", log.LstdFlags)
+
+	if daemon {
+		f, err := os.OpenFile(logFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			logger.Printf("WARNING: cannot open log file %s: %v", logFile, err)
+		} else {
+			defer f.Close()
+			logger.SetOutput(io.MultiWriter(os.Stderr, f))
+		}
+	}
+
+	if network == "" {
+		detected, err := discovery.DetectNetwork()
+		if err != nil {
+			logger.Fatalf("%v; please specify network range with --network", err)
+		}
+		network = detected
+		logger.Printf("auto-detected network range: %s", network)
+	}
+
+	cfg, err := targets.ConfigFromEnv(os.LookupEnv)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	store, err := registry.NewStore(file, targets.DefaultLabels(cfg.Cluster, cfg.Environment))
+	if err != nil {
+		logger.Fatal(err)
+	}
+	store.OnChange = history.Recorder(historyDir, filepath.Dir(file), "algalon-discovery", logger)
+
+	scanner := discovery.NewScanner(port)
+	scanner.Concurrency = concurrency
+	scanner.Timeout = timeout
+
+	d := &discovery.Discoverer{
+		Scanner: scanner,
+		Network: network,
+		Store:   store,
+		DryRun:  dryRun,
+		Logger:  logger,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if daemon {
+		if err := d.RunDaemon(ctx, interval); err != nil {
+			logger.Fatal(err)
+		}
+		return
+	}
+
+	fresh, err := d.RunOnce(ctx)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if dryRun {
+		fmt.Printf("Dry run completed - %d new workers would be registered\n", len(fresh))
+		return
+	}
+	fmt.Printf("✅ Worker discovery completed - %d new workers registered in %s\n", len(fresh), store.Path())
+}
+
+// seconds is a duration flag that also accepts a bare number of seconds, as
+// worker-discovery.sh's --interval does.
+type seconds time.Duration
+
+func (s *seconds) String() string {
+	return time.Duration(*s).String()
+}
+
+func (s *seconds) Set(value string) error {
+	if n, err := strconv.Atoi(value); err == nil {
+		*s = seconds(time.Duration(n) * time.Second)
+		return nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid interval %q", value)
+	}
+	*s = seconds(d)
+	return nil
+}
@@ -0,0 +1,111 @@
+// Command algalon-gce-discovery keeps node/targets/all-smi-gce.yml in sync
+// with the worker instances created by the algalon-worker Terraform module.
+// It lists instances labeled component=algalon-worker (optionally narrowed
+// by cluster and environment) through the Compute Engine API, so workers
+// added after terraform apply are scraped and preempted workers are
+// dropped. With -fake it reads instances from a JSON file instead, for
+// running without cloud credentials.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/appleparan/Algalon/pkg/discovery"
+	"github.com/appleparan/Algalon/pkg/history"
+	"github.com/appleparan/Algalon/pkg/registry"
+	"github.com/appleparan/Algalon/pkg/targets"
+)
+
+func main() {
+	var (
+		project     string
+		zone        string
+		cluster     string
+		environment string
+		port        int
+		file        string
+		registered  string
+		historyDir  string
+		fake        string
+		interval    time.Duration
+		grace       time.Duration
+		daemon      bool
+		dryRun      bool
+	)
+
+	flag.StringVar(&project, "project", "", "GCP project to list instances in (default: the project of this VM)")
+	flag.StringVar(&zone, "zone", "", "Only list instances in this zone (default: all zones)")
+	flag.StringVar(&cluster, "cluster", "", "Only discover workers with this cluster label")
+	flag.StringVar(&environment, "environment", "", "Only discover workers with this environment label")
+	flag.IntVar(&port, "port", discovery.DefaultPort, "all-smi port on the workers")
+	flag.StringVar(&file, "file", filepath.Join(filepath.Dir(registry.DefaultTargetsFile), discovery.DefaultInstanceFile), "Path of the file_sd target file to maintain")
+	flag.StringVar(&registered, "registry-file", registry.DefaultTargetsFile, "algalon-registry target file whose workers are left out of --file; empty keeps them")
+	flag.StringVar(&historyDir, "history", history.DefaultDir, "Directory to record every change of the target directory in; empty disables history")
+	flag.StringVar(&fake, "fake", "", "Read instances from this JSON file instead of the Compute Engine API")
+	flag.DurationVar(&interval, "interval", time.Minute, "Discovery interval in daemon mode")
+	flag.DurationVar(&grace, "grace", discovery.DefaultGracePeriod, "How long to keep a worker that disappeared from the instance list")
+	flag.BoolVar(&daemon, "daemon", false, "Run as daemon (continuous discovery)")
+	flag.BoolVar(&dryRun, "dry-run", false, "Log changes without writing the target file")
+	flag.Parse()
+
+	logger := log.New(os.Stderr, "algalon-gce-discovery: ", log.LstdFlags)
+
+	labels := targets.CopyLabels(discovery.WorkerLabels)
+	if cluster != "" {
+		labels["cluster"] = cluster
+	}
+	if environment != "" {
+		labels["environment"] = environment
+	}
+
+	var compute discovery.Compute
+	if fake != "" {
+		fc, err := discovery.LoadFakeCompute(fake)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		compute = fc
+	} else {
+		compute = discovery.NewGCEClient(project, zone)
+	}
+
+	d := &discovery.InstanceDiscoverer{
+		Compute:      compute,
+		Labels:       labels,
+		Port:         port,
+		Path:         file,
+		GracePeriod:  grace,
+		DryRun:       dryRun,
+		Logger:       logger,
+		RegistryPath: registered,
+		OnChange:     history.Recorder(historyDir, filepath.Dir(file), "algalon-gce-discovery", logger),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if daemon {
+		if err := d.RunDaemon(ctx, interval); err != nil {
+			logger.Fatal(err)
+		}
+		return
+	}
+
+	changes, err := d.RunOnce(ctx)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if dryRun {
+		fmt.Printf("Dry run completed - %d targets, %d would be added, %d removed\n", changes.Targets, len(changes.Added), len(changes.Removed))
+		return
+	}
+	fmt.Printf("✅ Instance discovery completed - %d targets (%d added, %d removed) in %s\n", changes.Targets, len(changes.Added), len(changes.Removed), file)
+}
@@ -0,0 +1,121 @@
+// Command algalon-notify runs the alert notification gateway on the
+// monitoring host. vmalert posts alerts to it (--notifier.url) or an
+// Alertmanager forwards them to /webhook; the gateway groups them by cluster
+// and instance, drops repeats and delivers each group to the webhook, chat
+// and email receivers configured by ALERT_WEBHOOK_URL, SLACK_WEBHOOK_URL and
+// ALERT_SMTP_* in the host env file.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/appleparan/Algalon/pkg/config"
+	"github.com/appleparan/Algalon/pkg/envfile"
+	"github.com/appleparan/Algalon/pkg/notify"
+)
+
+func main() {
+	var (
+		listen    string
+		envFile   string
+		groupWait time.Duration
+		repeat    time.Duration
+		dryRun    bool
+	)
+
+	flag.StringVar(&listen, "listen", ":8440", "Address to receive alerts on")
+	flag.StringVar(&envFile, "env", "", "Host env file with the receivers, e.g. examples/host-configs/production-host.env")
+	flag.DurationVar(&groupWait, "group-wait", notify.DefaultGroupWait, "How long to collect alerts before sending a group")
+	flag.DurationVar(&repeat, "repeat", notify.DefaultRepeatInterval, "How often to resend a group that is still firing (0 disables)")
+	flag.BoolVar(&dryRun, "dry-run", false, "Log notifications instead of sending them")
+	flag.Parse()
+
+	logger := log.New(os.Stderr, "algalon-notify: ", log.LstdFlags)
+
+	lookup := os.LookupEnv
+	if envFile != "" {
+		env, err := envfile.ReadFile(envFile)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		lookup = envfile.Chain(os.LookupEnv, env.Lookup)
+	}
+	host, err := config.HostFromEnv(lookup)
+	if err == nil {
+		err = host.Validate()
+	}
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	sinks := receivers(host.Alertmanager)
+	if dryRun {
+		sinks = []notify.Sink{&notify.LogSink{Logger: logger}}
+	}
+	if len(sinks) == 0 {
+		logger.Fatal("no receivers configured; set ALERT_WEBHOOK_URL, SLACK_WEBHOOK_URL or ALERT_SMTP_ADDR, or use -dry-run")
+	}
+
+	gateway := notify.New(sinks...)
+	gateway.RepeatInterval = repeat
+	gateway.Logger = logger
+
+	server := &http.Server{
+		Addr:              listen,
+		Handler:           notify.NewHandler(gateway, logger),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := gateway.RunDaemon(ctx, groupWait); err != nil {
+			logger.Fatal(err)
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	logger.Printf("listening on %s", listen)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Fatal(err)
+	}
+	<-done
+}
+
+// receivers returns a sink for every receiver set in the host config.
+func receivers(am config.Alertmanager) []notify.Sink {
+	var sinks []notify.Sink
+	if am.WebhookURL != "" {
+		sinks = append(sinks, &notify.WebhookSink{URL: am.WebhookURL})
+	}
+	if am.SlackWebhookURL != "" {
+		sinks = append(sinks, &notify.ChatSink{URL: am.SlackWebhookURL})
+	}
+	if am.SMTP.Addr != "" {
+		sinks = append(sinks, &notify.SMTPSink{
+			Addr:     am.SMTP.Addr,
+			From:     am.SMTP.From,
+			To:       am.SMTP.To,
+			Username: am.SMTP.Username,
+			Password: am.SMTP.Password,
+		})
+	}
+	return sinks
+}
@@ -0,0 +1,97 @@
+// Command algalon-reaper removes stale entries from the all-smi target file.
+// Targets whose up series in VictoriaMetrics stayed 0 for the whole grace
+// period, typically preemptible workers that were deleted, are moved to a
+// quarantine file that VMAgent does not read. Quarantined targets that serve
+// metrics again are restored; the others are deleted after the retention
+// period. Every change is logged.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/appleparan/Algalon/pkg/discovery"
+	"github.com/appleparan/Algalon/pkg/history"
+	"github.com/appleparan/Algalon/pkg/reaper"
+	"github.com/appleparan/Algalon/pkg/registry"
+	"github.com/appleparan/Algalon/pkg/vmclient"
+)
+
+func main() {
+	var (
+		vmURL      string
+		selector   string
+		file       string
+		quarantine string
+		historyDir string
+		grace      time.Duration
+		retention  time.Duration
+		probe      bool
+		interval   time.Duration
+		daemon     bool
+		dryRun     bool
+	)
+
+	flag.StringVar(&vmURL, "victoriametrics", vmclient.DefaultURL, "VictoriaMetrics URL")
+	flag.StringVar(&selector, "selector", reaper.DefaultSelector, "up series of the targets in --file")
+	flag.StringVar(&file, "file", registry.DefaultTargetsFile, "Path of the file_sd target file to reap")
+	flag.StringVar(&quarantine, "quarantine", "", "Path of the quarantine file (default: "+reaper.DefaultQuarantineFile+" next to --file)")
+	flag.StringVar(&historyDir, "history", history.DefaultDir, "Directory to record every change of the target directory in; empty disables history")
+	flag.DurationVar(&grace, "grace", reaper.DefaultGracePeriod, "How long a target must have been down before it is quarantined")
+	flag.DurationVar(&retention, "retention", reaper.DefaultRetention, "How long a target stays in quarantine before it is removed")
+	flag.BoolVar(&probe, "probe", true, "Restore quarantined targets that serve all-smi metrics again")
+	flag.DurationVar(&interval, "interval", time.Minute, "Reaper interval in daemon mode")
+	flag.BoolVar(&daemon, "daemon", false, "Run as daemon (continuous reaping)")
+	flag.BoolVar(&dryRun, "dry-run", false, "Log changes without writing any file")
+	flag.Parse()
+
+	logger := log.New(os.Stderr, "algalon-reaper: ", log.LstdFlags)
+
+	if quarantine == "" {
+		quarantine = filepath.Join(filepath.Dir(file), reaper.DefaultQuarantineFile)
+	}
+
+	r := &reaper.Reaper{
+		Querier:        vmclient.NewClient(vmURL),
+		Selector:       selector,
+		Path:           file,
+		QuarantinePath: quarantine,
+		GracePeriod:    grace,
+		Retention:      retention,
+		DryRun:         dryRun,
+		Logger:         logger,
+		OnChange:       history.Recorder(historyDir, filepath.Dir(file), "algalon-reaper", logger),
+	}
+	if probe {
+		r.Prober = discovery.NewScanner(discovery.DefaultPort)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if daemon {
+		if err := r.RunDaemon(ctx, interval); err != nil {
+			logger.Fatal(err)
+		}
+		return
+	}
+
+	changes, err := r.RunOnce(ctx)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if dryRun {
+		fmt.Printf("Dry run completed - %d targets, %d would be quarantined, %d restored, %d removed\n",
+			changes.Targets, len(changes.Quarantined), len(changes.Restored), len(changes.Removed))
+		return
+	}
+	fmt.Printf("✅ Reaper completed - %d targets, %d quarantined (%d new, %d restored, %d removed)\n",
+		changes.Targets, changes.InQuarantine, len(changes.Quarantined), len(changes.Restored), len(changes.Removed))
+}
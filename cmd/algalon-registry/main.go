@@ -0,0 +1,69 @@
+// Command algalon-registry runs the worker registration service on the
+// monitoring host. Workers are added and removed over HTTP and written to the
+// all-smi file_sd target file, which VMAgent re-reads every
+// --promscrape.fileSDCheckInterval (30s in docker-compose.yml), so no
+// container restart is needed.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/appleparan/Algalon/pkg/history"
+	"github.com/appleparan/Algalon/pkg/registry"
+	"github.com/appleparan/Algalon/pkg/targets"
+)
+
+func main() {
+	cfg, err := targets.ConfigFromEnv(os.LookupEnv)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	listen := flag.String("listen", ":8430", "Address to serve the registration API on")
+	file := flag.String("file", registry.DefaultTargetsFile, "Path to the all-smi file_sd target file")
+	historyDir := flag.String("history", history.DefaultDir, "Directory to record every change of the target directory in; empty disables history")
+	flag.StringVar(&cfg.Cluster, "cluster", cfg.Cluster, "Default cluster label (overrides ALGALON_CLUSTER)")
+	flag.StringVar(&cfg.Environment, "environment", cfg.Environment, "Default environment label (overrides ALGALON_ENVIRONMENT)")
+	flag.Parse()
+
+	logger := log.New(os.Stderr, "algalon-registry: ", log.LstdFlags)
+
+	store, err := registry.NewStore(*file, targets.DefaultLabels(cfg.Cluster, cfg.Environment))
+	if err != nil {
+		logger.Fatal(err)
+	}
+	logger.Printf("loaded %d workers from %s", len(store.List()), store.Path())
+
+	store.OnChange = history.Recorder(*historyDir, filepath.Dir(*file), "algalon-registry", logger)
+
+	server := &http.Server{
+		Addr:              *listen,
+		Handler:           registry.NewHandler(store, logger),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+		logger.Print("shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	logger.Printf("listening on %s", *listen)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Fatal(err)
+	}
+}
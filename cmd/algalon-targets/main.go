@@ -0,0 +1,154 @@
+// Command algalon-targets generates node/targets/all-smi-targets.yml from the
+// ALGALON_* environment variables, or dcgm-targets.yml with -exporter
+// dcgm-exporter. It accepts the same flags as
+// algalon_host/generate-targets.sh but validates every target before
+// writing the file. With -spec it instead writes one all-smi-<cluster>.yml,
+// and dcgm-<cluster>.yml where dcgm-exporter runs, per cluster of a
+// declarative spec such as examples/target-specs/multi-cluster.yml.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/appleparan/Algalon/pkg/history"
+	"github.com/appleparan/Algalon/pkg/targets"
+)
+
+func main() {
+	cfg, err := targets.ConfigFromEnv(os.LookupEnv)
+	if err != nil {
+		fatal(err)
+	}
+
+	output := flag.String("output", "", "Path of the file_sd target file to write (default: node/targets/<exporter file>, e.g. "+targets.DefaultFileName+")")
+	spec := flag.String("spec", "", "Cluster spec (YAML or JSON) to generate one file per cluster and exporter from")
+	dir := flag.String("dir", filepath.Join("node", "targets"), "Directory for the files generated from -spec")
+	historyDir := flag.String("history", history.DefaultDir, "Directory to record every change of the target directory in; empty disables history")
+	prune := flag.Bool("prune", false, "With -spec, remove target files of the spec's exporters in -dir that the spec does not produce")
+	exporter := flag.String("exporter", cfg.Exporter, "Exporter the targets run: all-smi or dcgm-exporter (overrides ALGALON_EXPORTER)")
+	flag.StringVar(&cfg.Targets, "targets", cfg.Targets, "Comma-separated list of worker targets (overrides ALGALON_TARGETS)")
+	flag.StringVar(&cfg.Cluster, "cluster", cfg.Cluster, "Cluster name (overrides ALGALON_CLUSTER)")
+	flag.StringVar(&cfg.Environment, "environment", cfg.Environment, "Environment name (overrides ALGALON_ENVIRONMENT)")
+	flag.IntVar(&cfg.DefaultPort, "default-port", cfg.DefaultPort, "Port used for targets without one (overrides ALGALON_DEFAULT_PORT)")
+	flag.StringVar(&cfg.Metadata.GPUType, "gpu-type", cfg.Metadata.GPUType, "GPU type label, e.g. nvidia-tesla-t4 (overrides ALGALON_GPU_TYPE)")
+	flag.IntVar(&cfg.Metadata.GPUsPerInstance, "gpus-per-instance", cfg.Metadata.GPUsPerInstance, "GPUs per instance label (overrides ALGALON_GPUS_PER_INSTANCE)")
+	flag.StringVar(&cfg.Metadata.Zone, "zone", cfg.Metadata.Zone, "Zone label, e.g. us-central1-a (overrides ALGALON_ZONE)")
+	flag.StringVar(&cfg.Metadata.Preemptible, "preemptible", cfg.Metadata.Preemptible, "Preemptible label, true or false (overrides ALGALON_PREEMPTIBLE)")
+	flag.StringVar(&cfg.InstancePrefix, "instance-prefix", cfg.InstancePrefix, "Label the targets <prefix>-1, <prefix>-2, ... as instance_name (overrides ALGALON_INSTANCE_PREFIX)")
+	flag.Parse()
+
+	if *exporter != cfg.Exporter {
+		selected, err := targets.LookupExporter(*exporter)
+		if err != nil {
+			fatal(err)
+		}
+		cfg.Exporter = selected.Name
+		// Targets without a port use the exporter's unless one was given.
+		portSet := false
+		flag.Visit(func(f *flag.Flag) { portSet = portSet || f.Name == "default-port" })
+		if _, ok := os.LookupEnv("ALGALON_DEFAULT_PORT"); !ok && !portSet {
+			cfg.DefaultPort = selected.Port
+		}
+	}
+	if *output == "" {
+		*output = filepath.Join("node", "targets", cfg.FileName())
+	}
+
+	if *spec != "" {
+		if err := generateFromSpec(*spec, *dir, *prune); err != nil {
+			fatal(err)
+		}
+		record(*historyDir, *dir, "generate targets from "+filepath.Base(*spec))
+		return
+	}
+
+	groups, err := cfg.Groups()
+	if err != nil {
+		fatal(err)
+	}
+
+	fmt.Println("🎯 Generating targets configuration...")
+	fmt.Printf("   📍 Targets: %s\n", cfg.Targets)
+	fmt.Printf("   🏷️  Cluster: %s\n", cfg.Cluster)
+	fmt.Printf("   🌍 Environment: %s\n", cfg.Environment)
+	fmt.Printf("   📡 Exporter: %s\n", groups[0].Labels["job"])
+	fmt.Printf("   🔌 Default port: %d\n", cfg.DefaultPort)
+	for _, line := range cfg.MetadataSummary(len(groups)) {
+		fmt.Printf("   🏷️  %s\n", line)
+	}
+
+	unlock, err := targets.LockDir(filepath.Dir(*output))
+	if err != nil {
+		fatal(err)
+	}
+	err = targets.WriteFile(*output, groups)
+	unlock()
+	if err != nil {
+		fatal(err)
+	}
+
+	fmt.Printf("✅ Targets configuration generated: %s\n", *output)
+	record(*historyDir, filepath.Dir(*output), "generate targets")
+	fmt.Println("🔄 VMAgent picks up the change within its fileSDCheckInterval")
+}
+
+func generateFromSpec(path, dir string, prune bool) error {
+	spec, err := targets.ReadSpec(path)
+	if err != nil {
+		return err
+	}
+	files, err := spec.Files()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("🎯 Generating %d target files from %s...\n", len(files), path)
+	unlock, err := targets.LockDir(dir)
+	if err != nil {
+		return err
+	}
+	removed, err := targets.WriteFiles(dir, files, prune)
+	unlock()
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		count := 0
+		for _, group := range file.Groups {
+			count += len(group.Targets)
+		}
+		fmt.Printf("   ✅ %s: %d groups, %d targets\n", filepath.Join(dir, file.Name), len(file.Groups), count)
+	}
+	for _, path := range removed {
+		fmt.Printf("   🗑️  Removed %s\n", path)
+	}
+
+	fmt.Println("🔄 VMAgent picks up the change within its fileSDCheckInterval")
+	return nil
+}
+
+// record snapshots targetsDir in the target history after a generator run.
+// A failure is reported but does not fail the run, whose files are already
+// written.
+func record(dir, targetsDir, message string) {
+	if dir == "" {
+		return
+	}
+	version, err := history.New(dir, targetsDir).Record(history.DefaultAuthor(), message)
+	switch {
+	case errors.Is(err, history.ErrNoChange):
+	case err != nil:
+		fmt.Printf("⚠️  Failed to record target history: %v\n", err)
+	default:
+		fmt.Printf("📚 Recorded as version %d\n", version.Number)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+	os.Exit(1)
+}
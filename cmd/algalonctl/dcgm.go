@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+
+	"github.com/appleparan/Algalon/pkg/catalog"
+	"github.com/appleparan/Algalon/pkg/dcgm"
+)
+
+func (a *app) dcgmFile() string {
+	return filepath.Join(a.workerDir(), dcgm.DefaultFileName)
+}
+
+func (a *app) dcgmGenerate(args []string) error {
+	fs := flag.NewFlagSet("dcgm generate", flag.ExitOnError)
+	profile := fs.String("profile", dcgm.ProfileDefault, "DCGM profile to write")
+	output := fs.String("output", a.dcgmFile(), "Output dcgm-exporter counter file")
+	list := fs.Bool("list", false, "List the profiles instead of writing one")
+	fs.Parse(args)
+
+	if *list {
+		for _, p := range dcgm.Profiles() {
+			fields, err := dcgm.Build(p.Name, catalog.Default())
+			if err != nil {
+				return err
+			}
+			fmt.Printf("   %-16s %2d fields: %s\n", p.Name, len(fields), p.Description)
+		}
+		return nil
+	}
+	return writeDCGMProfile(*output, *profile)
+}
+
+// writeDCGMProfile writes the counters of profile to path.
+func writeDCGMProfile(path, profile string) error {
+	fields, err := dcgm.WriteProfile(path, profile, catalog.Default())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✅ DCGM counters generated: %s (%s profile, %d fields)\n", path, profile, len(fields))
+	return nil
+}
+
+func (a *app) dcgmLint(args []string) error {
+	fs := flag.NewFlagSet("dcgm lint", flag.ExitOnError)
+	fs.Parse(args)
+
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{a.dcgmFile(), filepath.Join(a.workerDir(), "default-counters.csv")}
+	}
+
+	metrics := catalog.Default()
+	fmt.Printf("🔍 Checking DCGM counters against metric catalog v%d...\n", metrics.Version)
+	failed := 0
+	for _, path := range paths {
+		fields, err := dcgm.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		issues := dcgm.Validate(fields, metrics)
+		for _, issue := range issues {
+			fmt.Printf("   ❌ %s: %s\n", path, issue)
+		}
+		if len(issues) > 0 {
+			failed++
+			continue
+		}
+		fmt.Printf("   ✅ %s: %d fields\n", path, len(fields))
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d counter files have problems", failed, len(paths))
+	}
+	return nil
+}
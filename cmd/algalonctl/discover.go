@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/appleparan/Algalon/pkg/discovery"
+	"github.com/appleparan/Algalon/pkg/registry"
+	"github.com/appleparan/Algalon/pkg/targets"
+)
+
+func (a *app) discover(args []string) error {
+	fs := flag.NewFlagSet("discover", flag.ExitOnError)
+	network := fs.String("network", "", "Network range to scan (e.g., 192.168.1.0/24); auto-detected if empty")
+	port := fs.Int("port", discovery.DefaultPort, "Port to scan for workers")
+	file := fs.String("file", a.targetsFile(), "Path to targets configuration file")
+	cluster := fs.String("cluster", targets.DefaultCluster, "Cluster label for new targets")
+	environment := fs.String("environment", targets.DefaultEnvironment, "Environment label for new targets")
+	dryRun := fs.Bool("dry-run", false, "Show what would be discovered without registration")
+	concurrency := fs.Int("concurrency", discovery.DefaultConcurrency, "Number of hosts probed in parallel")
+	timeout := fs.Duration("timeout", discovery.DefaultTimeout, "Per-host probe timeout")
+	fs.Parse(args)
+
+	logger := log.New(os.Stderr, "algalonctl: ", log.LstdFlags)
+
+	if *network == "" {
+		detected, err := discovery.DetectNetwork()
+		if err != nil {
+			return fmt.Errorf("%v; please specify network range with -network", err)
+		}
+		*network = detected
+		logger.Printf("auto-detected network range: %s", *network)
+	}
+
+	store, err := registry.NewStore(*file, targets.DefaultLabels(*cluster, *environment))
+	if err != nil {
+		return err
+	}
+
+	scanner := discovery.NewScanner(*port)
+	scanner.Concurrency = *concurrency
+	scanner.Timeout = *timeout
+
+	d := &discovery.Discoverer{
+		Scanner: scanner,
+		Network: *network,
+		Store:   store,
+		DryRun:  *dryRun,
+		Logger:  logger,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	fresh, err := d.RunOnce(ctx)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		fmt.Printf("Dry run completed - %d new workers would be registered\n", len(fresh))
+		return nil
+	}
+	fmt.Printf("✅ Worker discovery completed - %d new workers registered in %s\n", len(fresh), store.Path())
+	if len(fresh) > 0 {
+		a.record(store.Path(), fmt.Sprintf("discover %d workers on %s", len(fresh), *network))
+	}
+	return nil
+}
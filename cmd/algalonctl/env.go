@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/appleparan/Algalon/pkg/envfile"
+)
+
+// loadEnv reads the env file at path, if any, and returns a lookup that
+// prefers the process environment over it together with the file's
+// variables.
+func loadEnv(path string) (func(string) (string, bool), envfile.Env, error) {
+	if path == "" {
+		return os.LookupEnv, envfile.Env{}, nil
+	}
+
+	env, err := envfile.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	fmt.Printf("📄 Loaded %d settings from %s\n", len(env), path)
+	return envfile.Chain(os.LookupEnv, env.Lookup), env, nil
+}
+
+// overrideLookup returns lookup with key set to value, e.g. to pass a flag
+// through code that reads the environment.
+func overrideLookup(lookup func(string) (string, bool), key, value string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		if name == key {
+			return value, true
+		}
+		return lookup(name)
+	}
+}
+
+// compose runs docker compose in dir with env added to the process
+// environment, streaming its output.
+func compose(dir string, env []string, args ...string) error {
+	cmd := exec.Command("docker", append([]string{"compose"}, args...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("docker compose %s failed in %s: %v", strings.Join(args, " "), dir, err)
+	}
+	return nil
+}
+
+// checkDocker fails early with the same hint the setup scripts print.
+func checkDocker() error {
+	if _, err := exec.LookPath("docker"); err != nil {
+		return fmt.Errorf("docker is not installed; please install Docker first")
+	}
+	if err := exec.Command("docker", "compose", "version").Run(); err != nil {
+		return fmt.Errorf("docker compose is not available; please install the Docker Compose plugin")
+	}
+	return nil
+}
+
+// waitForHTTP polls url until it answers 200 OK or timeout expires.
+func waitForHTTP(url string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s did not become ready within %s", url, timeout)
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/appleparan/Algalon/pkg/history"
+)
+
+func (a *app) historyDir() string {
+	return filepath.Join(a.hostDir(), "backups", "history")
+}
+
+// record snapshots the directory of targetsFile after a targets command
+// changed it. A failure is reported but does not fail the command, whose
+// change is already written.
+func (a *app) record(targetsFile, message string) {
+	h := history.New(a.historyDir(), filepath.Dir(targetsFile))
+	version, err := h.Record(history.DefaultAuthor(), message)
+	switch {
+	case errors.Is(err, history.ErrNoChange):
+	case err != nil:
+		fmt.Printf("⚠️  Failed to record target history: %v\n", err)
+	default:
+		fmt.Printf("📚 Recorded as version %d\n", version.Number)
+	}
+}
+
+func (a *app) historyFlags(name string) (*flag.FlagSet, *string, *string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	dir := fs.String("history", a.historyDir(), "Target history directory")
+	targetsDir := fs.String("dir", filepath.Dir(a.targetsFile()), "Target directory the history tracks")
+	return fs, dir, targetsDir
+}
+
+func (a *app) targetsHistory(args []string) error {
+	fs, dir, targetsDir := a.historyFlags("targets history")
+	fs.Parse(args)
+
+	versions, err := history.New(*dir, *targetsDir).Versions()
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		fmt.Printf("📚 No versions recorded in %s\n", *dir)
+		return nil
+	}
+
+	fmt.Printf("📚 %d version(s) in %s\n", len(versions), *dir)
+	for _, version := range versions {
+		fmt.Printf("   %4d  %s  %-16s %s (%s)\n", version.Number, version.Time.Local().Format("2006-01-02 15:04:05"),
+			version.Author, version.Message, strings.Join(version.Files, ", "))
+	}
+	return nil
+}
+
+func (a *app) targetsDiff(args []string) error {
+	fs, dir, targetsDir := a.historyFlags("targets diff")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		return fmt.Errorf("usage: algalonctl targets diff [flags] <version> [<version>|current]")
+	}
+	from, err := parseVersion(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	to := history.Current
+	if fs.NArg() == 2 {
+		if to, err = parseVersion(fs.Arg(1)); err != nil {
+			return err
+		}
+	}
+
+	diff, err := history.New(*dir, *targetsDir).Diff(from, to)
+	if err != nil {
+		return err
+	}
+	if diff == "" {
+		fmt.Println("✅ No differences")
+		return nil
+	}
+	fmt.Print(diff)
+	return nil
+}
+
+func (a *app) targetsRollback(args []string) error {
+	fs, dir, targetsDir := a.historyFlags("targets rollback")
+	author := fs.String("author", history.DefaultAuthor(), "Author recorded for the rollback")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: algalonctl targets rollback [flags] <version>")
+	}
+	number, err := parseVersion(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if number == history.Current {
+		return fmt.Errorf("cannot roll back to the current state")
+	}
+
+	fmt.Printf("🔄 Rolling back %s to version %d...\n", *targetsDir, number)
+	version, err := history.New(*dir, *targetsDir).Rollback(number, *author)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✅ Restored version %d (now version %d); every file parsed as file_sd\n", number, version.Number)
+	fmt.Println("🔄 VMAgent picks up the change within its fileSDCheckInterval")
+	return nil
+}
+
+func (a *app) targetsSnapshot(args []string) error {
+	fs, dir, targetsDir := a.historyFlags("targets snapshot")
+	author := fs.String("author", history.DefaultAuthor(), "Author recorded for the version")
+	message := fs.String("m", "manual snapshot", "Message recorded for the version")
+	fs.Parse(args)
+
+	version, err := history.New(*dir, *targetsDir).Record(*author, *message)
+	if errors.Is(err, history.ErrNoChange) {
+		fmt.Printf("ℹ️  No changes since version %d\n", version.Number)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("📚 Recorded version %d\n", version.Number)
+	return nil
+}
+
+// parseVersion accepts a version number, optionally prefixed with v, or
+// "current".
+func parseVersion(value string) (int, error) {
+	if value == "current" {
+		return history.Current, nil
+	}
+	number, err := strconv.Atoi(strings.TrimPrefix(value, "v"))
+	if err != nil || number < 1 {
+		return 0, fmt.Errorf("invalid version %q", value)
+	}
+	return number, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/appleparan/Algalon/pkg/config"
+)
+
+func (a *app) hostUp(args []string) error {
+	fs := flag.NewFlagSet("host up", flag.ExitOnError)
+	envFile := fs.String("env", "", "Host env file, e.g. examples/host-configs/basic-host.env")
+	targetList := fs.String("targets", "", "Comma-separated list of worker targets (overrides ALGALON_TARGETS and WORKER_TARGETS)")
+	cluster := fs.String("cluster", "", "Cluster name (overrides ALGALON_CLUSTER)")
+	environment := fs.String("environment", "", "Environment name (overrides ALGALON_ENVIRONMENT)")
+	wait := fs.Duration("wait", 2*time.Minute, "How long to wait for Grafana to become healthy (0 to skip)")
+	fs.Parse(args)
+
+	lookup, env, err := loadEnv(*envFile)
+	if err != nil {
+		return err
+	}
+	host, err := loadHost(lookup, *targetList, *cluster, *environment)
+	if err != nil {
+		return err
+	}
+	cfg := host.Targets
+
+	groups, err := cfg.Groups()
+	if err != nil {
+		return err
+	}
+	if err := checkDocker(); err != nil {
+		return err
+	}
+
+	fmt.Println("🏗️  Setting up Algalon Host (Monitoring & Visualization)...")
+	fmt.Printf("   🎯 Targets: %s\n", cfg.Targets)
+	fmt.Printf("   🏷️  Cluster: %s\n", cfg.Cluster)
+	fmt.Printf("   🌍 Environment: %s\n", cfg.Environment)
+
+	targetsFile := filepath.Join(filepath.Dir(a.targetsFile()), cfg.FileName())
+	if err := writeLocked(targetsFile, groups); err != nil {
+		return err
+	}
+	fmt.Printf("✅ Targets configuration generated: %s\n", targetsFile)
+	a.record(targetsFile, "host up")
+	scrapeConfig, err := host.Scrape.Config()
+	if err != nil {
+		return err
+	}
+	if err := writeScrape(a.scrapeFile(), scrapeConfig); err != nil {
+		return err
+	}
+	if err := writeRules(a.rulesFile(), host.Alerts); err != nil {
+		return err
+	}
+	if err := writeRecordingRules(a.recordingRulesFile()); err != nil {
+		return err
+	}
+
+	fmt.Println("🚀 Starting monitoring services...")
+	if err := compose(a.hostDir(), env.Environ(), "up", "-d"); err != nil {
+		return err
+	}
+
+	if *wait > 0 {
+		fmt.Println("⏳ Waiting for services to initialize...")
+		if err := waitForHTTP(host.Grafana.URL()+"/api/health", *wait); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("🎉 Algalon Host is ready!")
+	fmt.Println("📊 Access points:")
+	fmt.Printf("   - Grafana Dashboard: %s\n", host.Grafana.URL())
+	fmt.Printf("   - VictoriaMetrics: %s\n", host.VictoriaMetrics.URL())
+	return nil
+}
+
+func (a *app) hostDown(args []string) error {
+	fs := flag.NewFlagSet("host down", flag.ExitOnError)
+	volumes := fs.Bool("volumes", false, "Also remove the metrics and Grafana volumes")
+	fs.Parse(args)
+
+	if err := checkDocker(); err != nil {
+		return err
+	}
+
+	composeArgs := []string{"down"}
+	if *volumes {
+		composeArgs = append(composeArgs, "-v")
+	}
+
+	fmt.Println("🛑 Stopping monitoring services...")
+	if err := compose(a.hostDir(), nil, composeArgs...); err != nil {
+		return err
+	}
+	fmt.Println("✅ Algalon Host stopped")
+	return nil
+}
+
+// loadHost reads the host settings through lookup, applies the command-line
+// overrides and validates the result.
+func loadHost(lookup config.Lookup, targetList, cluster, environment string) (config.Host, error) {
+	host, err := config.HostFromEnv(lookup)
+	if err != nil {
+		return config.Host{}, err
+	}
+	overrideString(&host.Targets.Targets, targetList)
+	overrideString(&host.Targets.Cluster, cluster)
+	overrideString(&host.Targets.Environment, environment)
+
+	if err := host.Validate(); err != nil {
+		return config.Host{}, err
+	}
+	return host, nil
+}
+
+func overrideString(value *string, flagValue string) {
+	if flagValue != "" {
+		*value = flagValue
+	}
+}
@@ -0,0 +1,165 @@
+// Command algalonctl runs the Algalon host and worker lifecycle from one
+// binary. It replaces setup.sh, algalon_host/setup.sh, algalon_worker/setup.sh,
+// generate-targets.sh, register-worker.sh and worker-discovery.sh, and reads
+// the same env files as examples/host-configs and examples/worker-configs.
+//
+// Usage:
+//
+//	algalonctl [-root dir] host up|down [flags]
+//	algalonctl [-root dir] worker up|down [flags]
+//	algalonctl [-root dir] targets generate|add|remove|list [flags]
+//	algalonctl [-root dir] targets history|diff|rollback|snapshot [flags]
+//	algalonctl [-root dir] rules generate [flags]
+//	algalonctl [-root dir] scrape generate [flags]
+//	algalonctl [-root dir] dcgm generate|lint [flags]
+//	algalonctl [-root dir] discover [flags]
+//	algalonctl [-root dir] status [flags]
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// errUsage is returned for unknown commands; main prints the usage instead of
+// the error.
+var errUsage = errors.New("usage")
+
+const usage = `🌟 algalonctl - Algalon host and worker lifecycle
+
+Usage: algalonctl [-root dir] <command> [flags]
+
+Commands:
+  host up          Generate targets, scrape jobs and vmalert rules, and start the monitoring stack
+  host down        Stop the monitoring host
+  worker up        Build and start the all-smi exporter, dcgm-exporter with DCGM_PROFILE
+                   and algalon-agent with ALGALON_REGISTRY_URL
+  worker down      Stop the exporters
+  targets generate Write the target file from ALGALON_TARGETS or WORKER_TARGETS,
+                   or one file per cluster and exporter with -spec
+  targets add      Register a worker target
+  targets remove   Deregister a worker target
+  targets list     List registered worker targets
+  targets history  List recorded versions of the target directory
+  targets diff     Show the changes between two versions, or a version and the current files
+  targets rollback Restore a version after checking every file is valid file_sd
+  targets snapshot Record the current target files, e.g. after editing them by hand
+  rules generate   Write the vmalert alert rules from the ALERT_* thresholds and the recording rules
+  scrape generate  Write prometheus.yml from the host settings, one scrape job per exporter
+  dcgm generate    Write the dcgm-exporter counters of a profile (minimal, default, profiling-heavy)
+  dcgm lint        Check dcgm-exporter counter files against the metric catalog
+  discover         Scan a network for all-smi workers and register them
+  status           Check the host services and every registered worker
+
+Host and worker commands accept -env with a file from examples/host-configs
+or examples/worker-configs; exported variables override the file.
+Run 'algalonctl <command> -h' for the flags of a command.
+`
+
+// app holds the global flags shared by every command.
+type app struct {
+	// root is the Algalon checkout containing algalon_host and algalon_worker.
+	root string
+}
+
+func (a *app) hostDir() string {
+	return filepath.Join(a.root, "algalon_host")
+}
+
+func (a *app) workerDir() string {
+	return filepath.Join(a.root, "algalon_worker")
+}
+
+func (a *app) targetsFile() string {
+	return filepath.Join(a.hostDir(), "node", "targets", "all-smi-targets.yml")
+}
+
+func main() {
+	defaultRoot := os.Getenv("ALGALON_ROOT")
+	if defaultRoot == "" {
+		defaultRoot = "."
+	}
+
+	a := &app{}
+	flag.StringVar(&a.root, "root", defaultRoot, "Algalon checkout containing algalon_host and algalon_worker (default: $ALGALON_ROOT or .)")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+
+	err := a.run(flag.Args())
+	if errors.Is(err, errUsage) {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func (a *app) run(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	command, args := args[0], args[1:]
+	switch command {
+	case "host", "worker", "targets", "rules", "scrape", "dcgm":
+		if len(args) == 0 {
+			return errUsage
+		}
+		return a.runSubcommand(command, args[0], args[1:])
+	case "discover":
+		return a.discover(args)
+	case "status":
+		return a.status(args)
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return nil
+	}
+	return errUsage
+}
+
+func (a *app) runSubcommand(command, subcommand string, args []string) error {
+	switch command + " " + subcommand {
+	case "host up":
+		return a.hostUp(args)
+	case "host down":
+		return a.hostDown(args)
+	case "worker up":
+		return a.workerUp(args)
+	case "worker down":
+		return a.workerDown(args)
+	case "targets generate":
+		return a.targetsGenerate(args)
+	case "targets add":
+		return a.targetsAdd(args)
+	case "targets remove":
+		return a.targetsRemove(args)
+	case "targets list":
+		return a.targetsList(args)
+	case "targets history":
+		return a.targetsHistory(args)
+	case "targets diff":
+		return a.targetsDiff(args)
+	case "targets rollback":
+		return a.targetsRollback(args)
+	case "targets snapshot":
+		return a.targetsSnapshot(args)
+	case "rules generate":
+		return a.rulesGenerate(args)
+	case "scrape generate":
+		return a.scrapeGenerate(args)
+	case "dcgm generate":
+		return a.dcgmGenerate(args)
+	case "dcgm lint":
+		return a.dcgmLint(args)
+	}
+	return errUsage
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+	os.Exit(1)
+}
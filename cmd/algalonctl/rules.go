@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+
+	"github.com/appleparan/Algalon/pkg/config"
+	"github.com/appleparan/Algalon/pkg/rules"
+)
+
+func (a *app) rulesFile() string {
+	return filepath.Join(a.hostDir(), "rules", rules.DefaultAlertsFile)
+}
+
+func (a *app) recordingRulesFile() string {
+	return filepath.Join(a.hostDir(), "rules", rules.DefaultRecordingFile)
+}
+
+func (a *app) rulesGenerate(args []string) error {
+	fs := flag.NewFlagSet("rules generate", flag.ExitOnError)
+	envFile := fs.String("env", "", "Host env file with ALERT_* thresholds, e.g. examples/host-configs/production-host.env")
+	output := fs.String("output", a.rulesFile(), "Output vmalert rule file")
+	recordingOutput := fs.String("recording-output", a.recordingRulesFile(), "Output vmalert recording rule file")
+	fs.Parse(args)
+
+	lookup, _, err := loadEnv(*envFile)
+	if err != nil {
+		return err
+	}
+	host, err := config.HostFromEnv(lookup)
+	if err != nil {
+		return err
+	}
+	if err := host.Alerts.Validate(); err != nil {
+		return fmt.Errorf("invalid ALERT_* thresholds: %v", err)
+	}
+	if err := writeRules(*output, host.Alerts); err != nil {
+		return err
+	}
+	return writeRecordingRules(*recordingOutput)
+}
+
+// writeRules renders the alert pack for t to path.
+func writeRules(path string, t rules.Thresholds) error {
+	if err := rules.WriteFile(path, rules.AlertGroups(t)); err != nil {
+		return err
+	}
+	fmt.Printf("✅ Alert rules generated: %s\n", path)
+	fmt.Printf("   🌡️  GPU temperature: warning > %g°C, critical > %g°C\n", t.GPUTemperatureWarning, t.GPUTemperatureCritical)
+	fmt.Printf("   💾 GPU memory: > %g%%\n", t.GPUMemoryPercent)
+	return nil
+}
+
+// writeRecordingRules renders the recording rule pack to path.
+func writeRecordingRules(path string) error {
+	groups := rules.RecordingGroups()
+	if err := rules.WriteFile(path, groups); err != nil {
+		return err
+	}
+	fmt.Printf("✅ Recording rules generated: %s\n", path)
+	for _, group := range groups {
+		fmt.Printf("   📈 %s: %d series\n", group.Name, len(group.Rules))
+	}
+	return nil
+}
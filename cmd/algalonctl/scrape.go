@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/appleparan/Algalon/pkg/config"
+	"github.com/appleparan/Algalon/pkg/scrape"
+)
+
+func (a *app) scrapeFile() string {
+	return filepath.Join(a.hostDir(), scrape.DefaultFileName)
+}
+
+func (a *app) scrapeGenerate(args []string) error {
+	fs := flag.NewFlagSet("scrape generate", flag.ExitOnError)
+	envFile := fs.String("env", "", "Host env file with VMAGENT_SCRAPE_INTERVAL, ALL_SMI_INTERVAL and the per-job settings")
+	settingsFile := fs.String("settings", "", "YAML scrape settings with per-job relabeling, e.g. examples/scrape/relabel-settings.yml (replaces -env)")
+	exporterList := fs.String("exporters", "", "Comma-separated exporters to scrape, one job each (overrides SCRAPE_EXPORTERS)")
+	targetsDir := fs.String("targets-dir", "", "Target file directory inside the vmagent container (default "+scrape.DefaultTargetsDir+")")
+	output := fs.String("output", a.scrapeFile(), "Output vmagent scrape configuration")
+	fs.Parse(args)
+
+	var settings scrape.Settings
+	if *settingsFile != "" {
+		var err error
+		if settings, err = scrape.LoadSettings(*settingsFile); err != nil {
+			return err
+		}
+		if *exporterList != "" {
+			return fmt.Errorf("-exporters cannot be combined with -settings; list the jobs in the settings file")
+		}
+	} else {
+		lookup, _, err := loadEnv(*envFile)
+		if err != nil {
+			return err
+		}
+		if *exporterList != "" {
+			lookup = overrideLookup(lookup, "SCRAPE_EXPORTERS", *exporterList)
+		}
+		host, err := config.HostFromEnv(lookup)
+		if err != nil {
+			return err
+		}
+		settings = host.Scrape
+	}
+	overrideString(&settings.TargetsDir, *targetsDir)
+
+	cfg, err := settings.Config()
+	if err != nil {
+		return err
+	}
+	return writeScrape(*output, cfg)
+}
+
+// writeScrape renders the scrape configuration cfg to path.
+func writeScrape(path string, cfg scrape.Config) error {
+	if err := scrape.WriteFile(path, cfg); err != nil {
+		return err
+	}
+	fmt.Printf("✅ Scrape configuration generated: %s\n", path)
+	for _, job := range cfg.ScrapeConfigs {
+		fmt.Printf("   📡 %s: %s every %s, timeout %s\n", job.JobName, strings.Join(job.FileSDConfigs[0].Files, ", "), job.ScrapeInterval, job.ScrapeTimeout)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/appleparan/Algalon/pkg/config"
+	"github.com/appleparan/Algalon/pkg/discovery"
+	"github.com/appleparan/Algalon/pkg/registry"
+	"github.com/appleparan/Algalon/pkg/targets"
+)
+
+func (a *app) status(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	envFile := fs.String("env", "", "Host env file used to find the Grafana and VictoriaMetrics ports")
+	grafanaURL := fs.String("grafana", "", "Grafana base URL (default from GRAFANA_PORT)")
+	victoriaMetricsURL := fs.String("victoriametrics", "", "VictoriaMetrics base URL (default from VICTORIA_METRICS_PORT)")
+	file := fs.String("file", a.targetsFile(), "Path to targets configuration file")
+	timeout := fs.Duration("timeout", discovery.DefaultTimeout, "Timeout for each check")
+	fs.Parse(args)
+
+	lookup, _, err := loadEnv(*envFile)
+	if err != nil {
+		return err
+	}
+	host, err := config.HostFromEnv(lookup)
+	if err != nil {
+		return err
+	}
+	if *grafanaURL == "" {
+		*grafanaURL = host.Grafana.URL()
+	}
+	if *victoriaMetricsURL == "" {
+		*victoriaMetricsURL = host.VictoriaMetrics.URL()
+	}
+
+	failed := 0
+	report := func(name string, err error) {
+		if err != nil {
+			failed++
+			fmt.Printf("   ❌ %s: %v\n", name, err)
+			return
+		}
+		fmt.Printf("   ✅ %s\n", name)
+	}
+
+	fmt.Println("📊 Host services:")
+	report("Grafana "+*grafanaURL, waitForHTTP(*grafanaURL+"/api/health", *timeout))
+	report("VictoriaMetrics "+*victoriaMetricsURL, waitForHTTP(*victoriaMetricsURL+"/health", *timeout))
+
+	store, err := registry.NewStore(*file, nil)
+	if err != nil {
+		return err
+	}
+	workers := store.List()
+
+	fmt.Printf("🖥️  Workers (%d in %s):\n", len(workers), store.Path())
+	scanner := discovery.NewScanner(targets.DefaultPort)
+	scanner.Timeout = *timeout
+	for _, worker := range workers {
+		report(worker.Target, probeWorker(scanner, worker.Target, *timeout))
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	fmt.Println("✅ All checks passed")
+	return nil
+}
+
+func probeWorker(scanner *discovery.Scanner, target string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ok, err := scanner.Probe(ctx, target)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("/metrics does not export all_smi_info")
+	}
+	return nil
+}
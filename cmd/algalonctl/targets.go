@@ -0,0 +1,241 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/appleparan/Algalon/pkg/registry"
+	"github.com/appleparan/Algalon/pkg/targets"
+)
+
+func (a *app) targetsGenerate(args []string) error {
+	fs := flag.NewFlagSet("targets generate", flag.ExitOnError)
+	envFile := fs.String("env", "", "Host env file, e.g. examples/host-configs/basic-host.env")
+	output := fs.String("output", "", "Path of the file_sd target file to write (default: the exporter's file, e.g. "+a.targetsFile()+")")
+	exporter := fs.String("exporter", "", "Exporter the targets run: all-smi or dcgm-exporter (overrides ALGALON_EXPORTER)")
+	targetList := fs.String("targets", "", "Comma-separated list of worker targets (overrides ALGALON_TARGETS and WORKER_TARGETS)")
+	cluster := fs.String("cluster", "", "Cluster name (overrides ALGALON_CLUSTER)")
+	environment := fs.String("environment", "", "Environment name (overrides ALGALON_ENVIRONMENT)")
+	spec := fs.String("spec", "", "Cluster spec to generate one file per cluster and exporter from, next to -output")
+	prune := fs.Bool("prune", false, "With -spec, remove target files of the spec's exporters that the spec does not produce")
+	metadata := map[string]*string{
+		"ALGALON_GPU_TYPE":          fs.String("gpu-type", "", "GPU type label, e.g. nvidia-tesla-t4 (overrides ALGALON_GPU_TYPE)"),
+		"ALGALON_GPUS_PER_INSTANCE": fs.String("gpus-per-instance", "", "GPUs per instance label (overrides ALGALON_GPUS_PER_INSTANCE)"),
+		"ALGALON_ZONE":              fs.String("zone", "", "Zone label, e.g. us-central1-a (overrides ALGALON_ZONE)"),
+		"ALGALON_PREEMPTIBLE":       fs.String("preemptible", "", "Preemptible label, true or false (overrides ALGALON_PREEMPTIBLE)"),
+		"ALGALON_INSTANCE_PREFIX":   fs.String("instance-prefix", "", "Label the targets <prefix>-1, <prefix>-2, ... as instance_name (overrides ALGALON_INSTANCE_PREFIX)"),
+	}
+	fs.Parse(args)
+
+	if *spec != "" {
+		file := a.targetsFile()
+		if *output != "" {
+			file = *output
+		}
+		if err := generateFromSpec(*spec, filepath.Dir(file), *prune); err != nil {
+			return err
+		}
+		a.record(file, "generate targets from "+filepath.Base(*spec))
+		return nil
+	}
+
+	lookup, _, err := loadEnv(*envFile)
+	if err != nil {
+		return err
+	}
+	if *exporter != "" {
+		lookup = overrideLookup(lookup, "ALGALON_EXPORTER", *exporter)
+	}
+	for key, value := range metadata {
+		if *value != "" {
+			lookup = overrideLookup(lookup, key, *value)
+		}
+	}
+	host, err := loadHost(lookup, *targetList, *cluster, *environment)
+	if err != nil {
+		return err
+	}
+	cfg := host.Targets
+	if *output == "" {
+		*output = filepath.Join(filepath.Dir(a.targetsFile()), cfg.FileName())
+	}
+
+	groups, err := cfg.Groups()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("🎯 Generating targets configuration...")
+	fmt.Printf("   📍 Targets: %s\n", cfg.Targets)
+	fmt.Printf("   🏷️  Cluster: %s\n", cfg.Cluster)
+	fmt.Printf("   🌍 Environment: %s\n", cfg.Environment)
+	fmt.Printf("   📡 Exporter: %s\n", groups[0].Labels["job"])
+	for _, line := range cfg.MetadataSummary(len(groups)) {
+		fmt.Printf("   🏷️  %s\n", line)
+	}
+
+	if err := writeLocked(*output, groups); err != nil {
+		return err
+	}
+	fmt.Printf("✅ Targets configuration generated: %s\n", *output)
+	a.record(*output, "generate targets")
+	fmt.Println("🔄 VMAgent picks up the change within its fileSDCheckInterval")
+	return nil
+}
+
+func generateFromSpec(path, dir string, prune bool) error {
+	spec, err := targets.ReadSpec(path)
+	if err != nil {
+		return err
+	}
+	files, err := spec.Files()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("🎯 Generating %d target files from %s...\n", len(files), path)
+	unlock, err := targets.LockDir(dir)
+	if err != nil {
+		return err
+	}
+	removed, err := targets.WriteFiles(dir, files, prune)
+	unlock()
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		count := 0
+		for _, group := range file.Groups {
+			count += len(group.Targets)
+		}
+		fmt.Printf("   ✅ %s: %d groups, %d targets\n", filepath.Join(dir, file.Name), len(file.Groups), count)
+	}
+	for _, path := range removed {
+		fmt.Printf("   🗑️  Removed %s\n", path)
+	}
+
+	fmt.Println("🔄 VMAgent picks up the change within its fileSDCheckInterval")
+	return nil
+}
+
+// writeLocked writes a target file holding the lock of its directory, so
+// it does not interleave with algalon-registry or algalon-reaper updates.
+func writeLocked(path string, groups []targets.Group) error {
+	unlock, err := targets.LockDir(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return targets.WriteFile(path, groups)
+}
+
+func (a *app) targetsAdd(args []string) error {
+	fs := flag.NewFlagSet("targets add", flag.ExitOnError)
+	file := fs.String("file", a.targetsFile(), "Path to targets configuration file")
+	cluster := fs.String("cluster", targets.DefaultCluster, "Cluster label for new targets")
+	environment := fs.String("environment", targets.DefaultEnvironment, "Environment label for new targets")
+	labels := labelFlag{}
+	fs.Var(labels, "label", "Extra label as name=value; may be repeated")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: algalonctl targets add [flags] <host:port>")
+	}
+
+	store, err := registry.NewStore(*file, targets.DefaultLabels(*cluster, *environment))
+	if err != nil {
+		return err
+	}
+	worker, added, err := store.Add(registry.Worker{Target: fs.Arg(0), Labels: labels})
+	if err != nil {
+		return err
+	}
+
+	if added {
+		fmt.Printf("✅ Registered %s in %s\n", worker.Target, store.Path())
+	} else {
+		fmt.Printf("ℹ️  %s is already registered; labels updated if they changed\n", worker.Target)
+	}
+	a.record(*file, "register "+worker.Target)
+	return nil
+}
+
+func (a *app) targetsRemove(args []string) error {
+	fs := flag.NewFlagSet("targets remove", flag.ExitOnError)
+	file := fs.String("file", a.targetsFile(), "Path to targets configuration file")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: algalonctl targets remove [flags] <host:port>")
+	}
+
+	store, err := registry.NewStore(*file, nil)
+	if err != nil {
+		return err
+	}
+	if err := store.Remove(fs.Arg(0)); err != nil {
+		if errors.Is(err, registry.ErrNotFound) {
+			return fmt.Errorf("%s is not registered in %s", fs.Arg(0), store.Path())
+		}
+		return err
+	}
+
+	fmt.Printf("✅ Removed %s from %s\n", fs.Arg(0), store.Path())
+	a.record(*file, "deregister "+fs.Arg(0))
+	return nil
+}
+
+func (a *app) targetsList(args []string) error {
+	fs := flag.NewFlagSet("targets list", flag.ExitOnError)
+	file := fs.String("file", a.targetsFile(), "Path to targets configuration file")
+	fs.Parse(args)
+
+	store, err := registry.NewStore(*file, nil)
+	if err != nil {
+		return err
+	}
+
+	workers := store.List()
+	fmt.Printf("📋 %d worker(s) registered in %s\n", len(workers), store.Path())
+	for _, worker := range workers {
+		fmt.Printf("   - %s %s\n", worker.Target, formatLabels(worker.Labels))
+	}
+	return nil
+}
+
+func formatLabels(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, name+"="+labels[name])
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// labelFlag collects repeated -label name=value flags.
+type labelFlag map[string]string
+
+func (l labelFlag) String() string {
+	return formatLabels(l)
+}
+
+func (l labelFlag) Set(value string) error {
+	name, labelValue, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("label %q must be name=value", value)
+	}
+	if err := targets.ValidateGroupLabelName(name); err != nil {
+		return err
+	}
+	l[name] = labelValue
+	return nil
+}
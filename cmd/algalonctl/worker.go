@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/appleparan/Algalon/pkg/config"
+	"github.com/appleparan/Algalon/pkg/discovery"
+	"github.com/appleparan/Algalon/pkg/targets"
+)
+
+func (a *app) workerUp(args []string) error {
+	fs := flag.NewFlagSet("worker up", flag.ExitOnError)
+	envFile := fs.String("env", "", "Worker env file, e.g. examples/worker-configs/basic-worker.env")
+	version := fs.String("version", "", "all-smi version to build (overrides ALL_SMI_VERSION, default "+config.DefaultAllSmiVersion+")")
+	port := fs.Int("port", 0, "Port for the all-smi API (overrides ALL_SMI_PORT, default 9090)")
+	interval := fs.Int("interval", 0, "Metrics collection interval in seconds (overrides ALL_SMI_INTERVAL, default 5)")
+	wait := fs.Duration("wait", 2*time.Minute, "How long to wait for /metrics to export all_smi_info (0 to skip)")
+	fs.Parse(args)
+
+	lookup, env, err := loadEnv(*envFile)
+	if err != nil {
+		return err
+	}
+
+	worker, err := config.WorkerFromEnv(lookup)
+	if err != nil {
+		return err
+	}
+	overrideString(&worker.Version, *version)
+	if *port != 0 {
+		worker.Port = *port
+	}
+	if *interval != 0 {
+		worker.Interval = *interval
+	}
+	if err := worker.Validate(); err != nil {
+		return err
+	}
+	if err := checkDocker(); err != nil {
+		return err
+	}
+
+	fmt.Println("🏗️  Setting up Algalon Worker (Hardware Metrics Exporter)...")
+	fmt.Printf("   🏷️  all-smi version: %s\n", worker.Version)
+	fmt.Printf("   🔌 Port: %d\n", worker.Port)
+	fmt.Printf("   ⏱️  Interval: %ds\n", worker.Interval)
+
+	composeEnv := append(env.Environ(),
+		"ALL_SMI_VERSION="+worker.Version,
+		"ALL_SMI_PORT="+strconv.Itoa(worker.Port),
+		"ALL_SMI_INTERVAL="+strconv.Itoa(worker.Interval),
+	)
+	var profiles []string
+	if worker.DCGMProfile != "" {
+		fmt.Printf("   📡 dcgm-exporter: %s profile on port %d\n", worker.DCGMProfile, targets.DCGMExporter.Port)
+		if err := writeDCGMProfile(a.dcgmFile(), worker.DCGMProfile); err != nil {
+			return err
+		}
+		profiles = append(profiles, dcgmComposeProfile)
+	}
+	if worker.RegistryURL != "" {
+		fmt.Printf("   🛰️  algalon-agent: registering with %s\n", worker.RegistryURL)
+		composeEnv = append(composeEnv, "ALGALON_REGISTRY_URL="+worker.RegistryURL)
+		profiles = append(profiles, agentComposeProfile)
+	}
+	if len(profiles) > 0 {
+		composeEnv = append(composeEnv, "COMPOSE_PROFILES="+strings.Join(profiles, ","))
+	}
+
+	fmt.Printf("🏗️ Generating Dockerfile for all-smi %s...\n", worker.Version)
+	generate := exec.Command("./generate-dockerfile.sh", worker.Version, strconv.Itoa(worker.Port))
+	generate.Dir = a.workerDir()
+	generate.Stdout = os.Stdout
+	generate.Stderr = os.Stderr
+	if err := generate.Run(); err != nil {
+		return fmt.Errorf("generate-dockerfile.sh failed: %v", err)
+	}
+
+	fmt.Printf("🏗️ Building all-smi %s from source (this may take a few minutes)...\n", worker.Version)
+	if err := compose(a.workerDir(), composeEnv, "build"); err != nil {
+		return err
+	}
+
+	fmt.Printf("🚀 Starting all-smi Exporter on port %d...\n", worker.Port)
+	if err := compose(a.workerDir(), composeEnv, "up", "-d"); err != nil {
+		return err
+	}
+
+	if *wait > 0 {
+		fmt.Println("⏳ Waiting for all-smi to start...")
+		if err := waitForAllSmi(fmt.Sprintf("localhost:%d", worker.Port), *wait); err != nil {
+			return fmt.Errorf("%v; check logs with: docker compose logs all-smi", err)
+		}
+	}
+
+	fmt.Println("🎉 Algalon Worker is ready!")
+	fmt.Printf("📊 Metrics endpoint: http://localhost:%d/metrics\n", worker.Port)
+	if worker.DCGMProfile != "" {
+		fmt.Printf("📊 DCGM metrics endpoint: http://localhost:%d/metrics\n", targets.DCGMExporter.Port)
+	}
+	if worker.RegistryURL != "" {
+		fmt.Println("📝 algalon-agent registers this worker once all-smi is ready; check with: docker compose logs algalon-agent")
+	} else {
+		fmt.Println("📝 Next step: register this worker on the host with 'algalonctl targets add <ip>:<port>'")
+	}
+	return nil
+}
+
+// Compose profiles of the optional services in
+// algalon_worker/docker-compose.yml.
+const (
+	dcgmComposeProfile  = "dcgm"
+	agentComposeProfile = "agent"
+)
+
+func (a *app) workerDown(args []string) error {
+	fs := flag.NewFlagSet("worker down", flag.ExitOnError)
+	fs.Parse(args)
+
+	if err := checkDocker(); err != nil {
+		return err
+	}
+
+	fmt.Println("🛑 Stopping all-smi Exporter...")
+	// Enable every profile so a running dcgm-exporter and agent are stopped
+	// too; the agent deregisters the worker on the way down.
+	if err := compose(a.workerDir(), []string{"COMPOSE_PROFILES=" + dcgmComposeProfile + "," + agentComposeProfile}, "down"); err != nil {
+		return err
+	}
+	fmt.Println("✅ Algalon Worker stopped")
+	return nil
+}
+
+// waitForAllSmi polls address until its /metrics page exports all_smi_info.
+func waitForAllSmi(address string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	scanner := discovery.NewScanner(targets.DefaultPort)
+	for {
+		if ok, _ := scanner.Probe(ctx, address); ok {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("all-smi on %s did not become ready within %s", address, timeout)
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
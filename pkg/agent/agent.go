@@ -0,0 +1,297 @@
+// Package agent registers a worker with the monitoring host's registration
+// service (cmd/algalon-registry). It registers at boot, re-registers on
+// every heartbeat so the worker reappears if the host's target file was
+// regenerated or reaped, and deregisters on shutdown or when a Notice such
+// as a GCE preemption fires.
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Defaults for Agent.
+const (
+	DefaultRegistryPort      = 8430
+	DefaultHeartbeatInterval = time.Minute
+	DefaultRetryInterval     = 5 * time.Second
+	maxRetryInterval         = 2 * time.Minute
+	deregisterTimeout        = 10 * time.Second
+)
+
+// errPermanent marks registry responses that retrying will not fix, such as
+// a malformed target.
+var errPermanent = errors.New("rejected by registry")
+
+// Agent announces one worker to the registry.
+type Agent struct {
+	RegistryURL       string            // e.g. http://10.128.0.10:8430
+	Target            string            // host:port the host should scrape
+	Labels            map[string]string // extra target labels
+	HeartbeatInterval time.Duration
+	RetryInterval     time.Duration
+	HTTPClient        *http.Client
+	Logger            *log.Logger
+
+	// Ready, if set, is called before the first registration and should
+	// block until all-smi serves metrics.
+	Ready func(ctx context.Context) error
+
+	// Notices trigger deregistration before the context is cancelled,
+	// e.g. a GCE preemption notice. Once one fires, Run deregisters and
+	// then waits for ctx: returning would let Docker restart the agent,
+	// which would register the dying worker again.
+	Notices []Notice
+}
+
+type registration struct {
+	Target string            `json:"target"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Run registers the worker, retrying until the registry accepts it, then
+// heartbeats every HeartbeatInterval. When ctx is cancelled or a notice
+// fires it deregisters and returns.
+func (a *Agent) Run(ctx context.Context) error {
+	noticeCtx, cancelNotices := context.WithCancel(ctx)
+	defer cancelNotices()
+
+	noticed := make(chan string, len(a.Notices))
+	for _, notice := range a.Notices {
+		notice := notice
+		go func() {
+			if err := notice.Wait(noticeCtx); err == nil {
+				noticed <- notice.String()
+			}
+		}()
+	}
+
+	runCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	fired := make(chan struct{})
+	go func() {
+		select {
+		case reason := <-noticed:
+			a.Logger.Printf("received %s", reason)
+			close(fired)
+			stop()
+		case <-runCtx.Done():
+		}
+	}()
+
+	err := a.run(runCtx)
+	if err != nil && runCtx.Err() == nil {
+		return err
+	}
+
+	// Deregister with a fresh context: runCtx is already done.
+	deregisterCtx, cancel := context.WithTimeout(context.Background(), deregisterTimeout)
+	defer cancel()
+	if err := a.Deregister(deregisterCtx); err != nil {
+		return fmt.Errorf("failed to deregister %s: %v", a.Target, err)
+	}
+
+	select {
+	case <-fired:
+		a.Logger.Print("deregistered; waiting for the worker to shut down")
+		<-ctx.Done()
+	default:
+	}
+	return nil
+}
+
+func (a *Agent) run(ctx context.Context) error {
+	if a.Ready != nil {
+		a.Logger.Print("waiting for all-smi to become ready")
+		if err := a.Ready(ctx); err != nil {
+			return err
+		}
+	}
+
+	if err := a.registerWithRetry(ctx); err != nil {
+		return err
+	}
+
+	interval := a.HeartbeatInterval
+	if interval <= 0 {
+		interval = DefaultHeartbeatInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		created, err := a.Register(ctx)
+		switch {
+		case err != nil && ctx.Err() == nil:
+			a.Logger.Printf("heartbeat failed: %v", err)
+		case created:
+			a.Logger.Printf("re-registered %s; the host had dropped it", a.Target)
+		}
+	}
+}
+
+// registerWithRetry registers with exponential backoff until the registry
+// accepts the worker, rejects it outright, or ctx is done.
+func (a *Agent) registerWithRetry(ctx context.Context) error {
+	delay := a.RetryInterval
+	if delay <= 0 {
+		delay = DefaultRetryInterval
+	}
+
+	for {
+		created, err := a.Register(ctx)
+		if err == nil {
+			if created {
+				a.Logger.Printf("registered %s with %s", a.Target, a.RegistryURL)
+			} else {
+				a.Logger.Printf("%s was already registered with %s", a.Target, a.RegistryURL)
+			}
+			return nil
+		}
+		if errors.Is(err, errPermanent) {
+			return err
+		}
+		a.Logger.Printf("registration failed, retrying in %s: %v", delay, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxRetryInterval {
+			delay = maxRetryInterval
+		}
+	}
+}
+
+// Register sends one registration and reports whether the registry created
+// a new entry.
+func (a *Agent) Register(ctx context.Context) (bool, error) {
+	body, err := json.Marshal(registration{Target: a.Target, Labels: a.Labels})
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := a.do(ctx, http.MethodPost, a.endpoint("workers"), body)
+	if err != nil {
+		return false, err
+	}
+	switch resp.status {
+	case http.StatusCreated:
+		return true, nil
+	case http.StatusOK:
+		return false, nil
+	default:
+		return false, resp.err()
+	}
+}
+
+// Deregister removes the worker from the registry. A worker that is not
+// registered is not an error.
+func (a *Agent) Deregister(ctx context.Context) error {
+	resp, err := a.do(ctx, http.MethodDelete, a.endpoint("workers", a.Target), nil)
+	if err != nil {
+		return err
+	}
+	switch resp.status {
+	case http.StatusNoContent, http.StatusOK:
+		a.Logger.Printf("deregistered %s from %s", a.Target, a.RegistryURL)
+		return nil
+	case http.StatusNotFound:
+		a.Logger.Printf("%s was not registered with %s", a.Target, a.RegistryURL)
+		return nil
+	default:
+		return resp.err()
+	}
+}
+
+type response struct {
+	status int
+	body   string
+}
+
+func (r response) err() error {
+	var payload struct {
+		Error string `json:"error"`
+	}
+	message := r.body
+	if json.Unmarshal([]byte(r.body), &payload) == nil && payload.Error != "" {
+		message = payload.Error
+	}
+	if r.status >= 400 && r.status < 500 {
+		return fmt.Errorf("%w (HTTP %d): %s", errPermanent, r.status, message)
+	}
+	return fmt.Errorf("registry returned HTTP %d: %s", r.status, message)
+}
+
+func (a *Agent) do(ctx context.Context, method, endpoint string, body []byte) (response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return response{}, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := a.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return response{}, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return response{}, err
+	}
+	return response{status: resp.StatusCode, body: strings.TrimSpace(string(data))}, nil
+}
+
+func (a *Agent) endpoint(parts ...string) string {
+	escaped := make([]string, len(parts))
+	for i, part := range parts {
+		escaped[i] = url.PathEscape(part)
+	}
+	return strings.TrimRight(a.RegistryURL, "/") + "/" + strings.Join(escaped, "/")
+}
+
+// AdvertiseAddress returns the local IP address used to reach registryURL,
+// which is the address the host can scrape this worker on.
+func AdvertiseAddress(registryURL string) (string, error) {
+	u, err := url.Parse(registryURL)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid registry URL %q", registryURL)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "80")
+	}
+
+	// UDP "connects" only pick a route; nothing is sent.
+	conn, err := net.Dial("udp", host)
+	if err != nil {
+		return "", fmt.Errorf("cannot find a route to %s: %v", u.Host, err)
+	}
+	defer conn.Close()
+
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
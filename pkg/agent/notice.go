@@ -0,0 +1,120 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultMetadataURL is the GCE metadata server.
+const DefaultMetadataURL = "http://metadata.google.internal/computeMetadata/v1"
+
+// Notice blocks until the worker is about to go away. Wait returns nil when
+// the notice fires and ctx.Err() when ctx is done first.
+type Notice interface {
+	Wait(ctx context.Context) error
+	String() string
+}
+
+// MetadataNotice watches a GCE metadata key with wait_for_change and fires
+// when Fired reports true for its value. GCE gives preemptible and spot VMs
+// about 30 seconds between setting instance/preempted and stopping them.
+type MetadataNotice struct {
+	Name        string
+	Key         string
+	Fired       func(value string) bool
+	MetadataURL string
+	HTTPClient  *http.Client
+}
+
+// PreemptionNotice fires when GCE preempts this VM.
+func PreemptionNotice() *MetadataNotice {
+	return &MetadataNotice{
+		Name:        "GCE preemption notice",
+		Key:         "instance/preempted",
+		Fired:       func(value string) bool { return value == "TRUE" },
+		MetadataURL: DefaultMetadataURL,
+	}
+}
+
+// MaintenanceNotice fires when GCE is about to stop this VM for host
+// maintenance, which GPU workers get because they cannot live-migrate.
+func MaintenanceNotice() *MetadataNotice {
+	return &MetadataNotice{
+		Name:        "GCE host maintenance notice",
+		Key:         "instance/maintenance-event",
+		Fired:       func(value string) bool { return value == "TERMINATE_ON_HOST_MAINTENANCE" },
+		MetadataURL: DefaultMetadataURL,
+	}
+}
+
+func (n *MetadataNotice) String() string {
+	return n.Name
+}
+
+// Wait reads the metadata key once, so a notice that fired before Wait was
+// called, e.g. before the agent was restarted, is not missed, and then
+// long-polls it for changes. Errors, such as running outside GCE, are
+// retried with a delay so the agent keeps working without a metadata server.
+func (n *MetadataNotice) Wait(ctx context.Context) error {
+	client := n.HTTPClient
+	if client == nil {
+		// wait_for_change holds the request open for minutes.
+		client = &http.Client{}
+	}
+
+	etag, wait := "", false
+	for {
+		value, next, err := n.poll(ctx, client, etag, wait)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == nil {
+			if n.Fired(value) {
+				return nil
+			}
+			etag, wait = next, true
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(30 * time.Second):
+		}
+	}
+}
+
+// poll reads the key, or with wait blocks until it differs from etag.
+func (n *MetadataNotice) poll(ctx context.Context, client *http.Client, etag string, wait bool) (string, string, error) {
+	endpoint := fmt.Sprintf("%s/%s", n.MetadataURL, n.Key)
+	if wait {
+		endpoint += "?wait_for_change=true"
+		if etag != "" {
+			endpoint += "&last_etag=" + etag
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	if err != nil {
+		return "", "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("metadata server returned %s for %s", resp.Status, n.Key)
+	}
+	return strings.TrimSpace(string(body)), resp.Header.Get("ETag"), nil
+}
@@ -0,0 +1,126 @@
+// Package catalog is the versioned list of metric names exported by the
+// agents Algalon scrapes: all-smi, dcgm-exporter and the per-target series
+// vmagent adds. Dashboards and rule packs are checked against it.
+package catalog
+
+import (
+	_ "embed"
+	"fmt"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Exporter names used in the catalog.
+const (
+	AllSmi       = "all-smi"
+	DCGMExporter = "dcgm-exporter"
+	VMAgent      = "vmagent"
+)
+
+//go:embed metrics.yml
+var defaultCatalog []byte
+
+// Metric is one metric name and its Prometheus type.
+type Metric struct {
+	Name string `yaml:"name"`
+	Type string `yaml:"type"`
+	Help string `yaml:"help"`
+}
+
+// Exporter is the set of metrics one exporter release exposes.
+type Exporter struct {
+	Name    string   `yaml:"name"`
+	Version string   `yaml:"version"`
+	Metrics []Metric `yaml:"metrics"`
+}
+
+// Catalog is a versioned list of exporters.
+type Catalog struct {
+	Version   int        `yaml:"catalog_version"`
+	Exporters []Exporter `yaml:"exporters"`
+
+	byName map[string]entry
+}
+
+type entry struct {
+	exporter *Exporter
+	metric   *Metric
+}
+
+// Default returns the catalog shipped with Algalon in metrics.yml.
+func Default() *Catalog {
+	c, err := Parse(defaultCatalog)
+	if err != nil {
+		panic(fmt.Sprintf("embedded metrics.yml: %v", err))
+	}
+	return c
+}
+
+// Parse decodes and validates a catalog.
+func Parse(data []byte) (*Catalog, error) {
+	var c Catalog
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse metric catalog: %v", err)
+	}
+	if c.Version < 1 {
+		return nil, fmt.Errorf("metric catalog has no catalog_version")
+	}
+
+	c.byName = map[string]entry{}
+	exporters := map[string]bool{}
+	for i := range c.Exporters {
+		exporter := &c.Exporters[i]
+		if exporter.Name == "" {
+			return nil, fmt.Errorf("exporter %d has no name", i+1)
+		}
+		if exporters[exporter.Name] {
+			return nil, fmt.Errorf("exporter %s is listed twice", exporter.Name)
+		}
+		exporters[exporter.Name] = true
+
+		for j := range exporter.Metrics {
+			metric := &exporter.Metrics[j]
+			switch metric.Type {
+			case "gauge", "counter", "histogram", "summary":
+			default:
+				return nil, fmt.Errorf("metric %s has unknown type %q", metric.Name, metric.Type)
+			}
+			if previous, ok := c.byName[metric.Name]; ok {
+				return nil, fmt.Errorf("metric %s is listed by both %s and %s", metric.Name, previous.exporter.Name, exporter.Name)
+			}
+			c.byName[metric.Name] = entry{exporter: exporter, metric: metric}
+		}
+	}
+
+	return &c, nil
+}
+
+// Lookup returns the metric called name and the exporter that exposes it.
+func (c *Catalog) Lookup(name string) (*Metric, *Exporter, bool) {
+	e, ok := c.byName[name]
+	if !ok {
+		return nil, nil, false
+	}
+	return e.metric, e.exporter, true
+}
+
+// Exporter returns the exporter called name.
+func (c *Catalog) Exporter(name string) (*Exporter, bool) {
+	for i := range c.Exporters {
+		if c.Exporters[i].Name == name {
+			return &c.Exporters[i], true
+		}
+	}
+	return nil, false
+}
+
+// Names returns every metric name in the catalog, sorted.
+func (c *Catalog) Names() []string {
+	names := make([]string, 0, len(c.byName))
+	for name := range c.byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
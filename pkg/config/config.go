@@ -0,0 +1,151 @@
+// Package config loads the env files under examples/host-configs and
+// examples/worker-configs into typed settings. The setup scripts only
+// notice a bad value once docker compose is running; Validate catches it
+// up front, including combinations that are individually fine but do not
+// work together, such as HTTPS without a certificate.
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/appleparan/Algalon/pkg/envfile"
+	"github.com/appleparan/Algalon/pkg/targets"
+)
+
+// Lookup has the signature of os.LookupEnv.
+type Lookup func(string) (string, bool)
+
+// Resources holds the optional docker compose limits of one container.
+type Resources struct {
+	MemoryLimit string  // e.g. 512m, 2g; empty means unlimited
+	CPULimit    float64 // fractional CPUs; 0 means unlimited
+}
+
+var memoryPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?[bkmgBKMG]?$`)
+
+func (r Resources) validate(prefix string) error {
+	if r.MemoryLimit != "" && !memoryPattern.MatchString(r.MemoryLimit) {
+		return fmt.Errorf("invalid %sMEMORY_LIMIT %q: expected a size such as 512m or 2g", prefix, r.MemoryLimit)
+	}
+	if r.CPULimit < 0 {
+		return fmt.Errorf("invalid %sCPU_LIMIT %v: must not be negative", prefix, r.CPULimit)
+	}
+	return nil
+}
+
+// lookupString returns the trimmed value of key, or def when it is unset or
+// empty.
+func lookupString(lookup Lookup, key, def string) string {
+	if value, ok := lookup(key); ok && strings.TrimSpace(value) != "" {
+		return strings.TrimSpace(value)
+	}
+	return def
+}
+
+func lookupInt(lookup Lookup, key string, def int) (int, error) {
+	value := lookupString(lookup, key, "")
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: not a number", key, value)
+	}
+	return n, nil
+}
+
+func lookupBool(lookup Lookup, key string, def bool) (bool, error) {
+	value := lookupString(lookup, key, "")
+	if value == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q: expected true or false", key, value)
+	}
+	return b, nil
+}
+
+// lookupList splits a comma-separated setting, dropping empty entries.
+func lookupList(lookup Lookup, key string) []string {
+	var list []string
+	for _, item := range strings.Split(lookupString(lookup, key, ""), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func lookupFloat(lookup Lookup, key string, def float64) (float64, error) {
+	value := lookupString(lookup, key, "")
+	if value == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: not a number", key, value)
+	}
+	return f, nil
+}
+
+func lookupDuration(lookup Lookup, key string, def time.Duration) (time.Duration, error) {
+	value := lookupString(lookup, key, "")
+	if value == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: expected a duration such as 5s or 1m", key, value)
+	}
+	return d, nil
+}
+
+func lookupResources(lookup Lookup, prefix string) (Resources, error) {
+	r := Resources{MemoryLimit: lookupString(lookup, prefix+"MEMORY_LIMIT", "")}
+	if value := lookupString(lookup, prefix+"CPU_LIMIT", ""); value != "" {
+		cpus, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return Resources{}, fmt.Errorf("invalid %sCPU_LIMIT %q: not a number", prefix, value)
+		}
+		r.CPULimit = cpus
+	}
+	return r, nil
+}
+
+// ParseLabels parses a comma-separated list of name=value pairs as used by
+// VMAGENT_EXTERNAL_LABELS and WORKER_LABELS.
+func ParseLabels(value string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, labelValue, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("label %q must be name=value", pair)
+		}
+		name = strings.TrimSpace(name)
+		if err := targets.ValidateLabelName(name); err != nil {
+			return nil, err
+		}
+		if _, dup := labels[name]; dup {
+			return nil, fmt.Errorf("duplicate label %q", name)
+		}
+		labels[name] = strings.TrimSpace(labelValue)
+	}
+	return labels, nil
+}
+
+func readFile(path string) (Lookup, error) {
+	env, err := envfile.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return env.Lookup, nil
+}
@@ -0,0 +1,458 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/appleparan/Algalon/pkg/rules"
+	"github.com/appleparan/Algalon/pkg/scrape"
+	"github.com/appleparan/Algalon/pkg/targets"
+	"github.com/prometheus/common/model"
+)
+
+// Host defaults, matching algalon_host/docker-compose.yml and
+// examples/host-configs/README.md.
+const (
+	DefaultGrafanaPort              = 3000
+	DefaultGrafanaAdminUser         = "admin"
+	DefaultGrafanaAdminPassword     = "admin"
+	DefaultVictoriaMetricsPort      = 8428
+	DefaultVictoriaMetricsRetention = "30d"
+	DefaultScrapeInterval           = scrape.DefaultScrapeInterval
+	DefaultWorkerTargets            = "localhost:9090"
+	DefaultHostNetwork              = "algalon_monitoring"
+	DefaultSubnetCIDR               = "172.20.0.0/16"
+	DefaultLogLevel                 = "info"
+	DefaultHAReplicas               = 1
+
+	// DefaultAlertNotifierURL is algalon-notify on the Docker host, as
+	// vmalert reaches it from its container.
+	DefaultAlertNotifierURL = "http://host.docker.internal:8440"
+)
+
+// Host is a parsed algalon_host/.env.
+type Host struct {
+	Grafana         Grafana
+	VictoriaMetrics VictoriaMetrics
+	VMAgent         VMAgent
+
+	// Targets is the file_sd generator input. Targets comes from
+	// ALGALON_TARGETS or WORKER_TARGETS; Cluster and Environment from
+	// ALGALON_CLUSTER and ALGALON_ENVIRONMENT, falling back to the matching
+	// VMAGENT_EXTERNAL_LABELS entries.
+	Targets targets.Config
+
+	Network    string
+	SubnetCIDR string
+
+	HTTPS        HTTPS
+	HA           HA
+	Alertmanager Alertmanager
+	LogLevel     string
+
+	// Alerts are the vmalert rule thresholds from the ALERT_* settings.
+	Alerts rules.Thresholds
+
+	// Scrape renders prometheus.yml. The all-smi job is scraped every
+	// VMAGENT_SCRAPE_INTERVAL, which must not be shorter than the workers'
+	// ALL_SMI_INTERVAL; see lookupScrape for the per-job settings.
+	Scrape scrape.Settings
+}
+
+// Grafana holds the GRAFANA_* settings.
+type Grafana struct {
+	AdminUser      string
+	AdminPassword  string
+	Port           int
+	ExternalAccess bool
+	Resources      Resources
+}
+
+// VictoriaMetrics holds the VICTORIA_METRICS_* settings.
+type VictoriaMetrics struct {
+	Port           int
+	Retention      string
+	ExternalAccess bool
+	Resources      Resources
+}
+
+// VMAgent holds the VMAGENT_* settings.
+type VMAgent struct {
+	ScrapeInterval time.Duration
+	ExternalLabels map[string]string
+	Resources      Resources
+}
+
+// HTTPS holds ENABLE_HTTPS and the certificate it needs.
+type HTTPS struct {
+	Enabled  bool
+	CertPath string
+	KeyPath  string
+}
+
+// HA holds the HA_* settings of multi-cluster-host.env.
+type HA struct {
+	Enabled  bool
+	Replicas int
+}
+
+// Alertmanager holds ALERTMANAGER_ENABLED and its receivers.
+type Alertmanager struct {
+	Enabled bool
+
+	// NotifierURL is where vmalert sends alerts (ALERT_NOTIFIER_URL):
+	// algalon-notify by default, or an Alertmanager.
+	NotifierURL string
+
+	WebhookURL      string
+	SlackWebhookURL string
+	SMTP            SMTP
+}
+
+// SMTP holds the ALERT_SMTP_* settings of the email receiver.
+type SMTP struct {
+	Addr     string // host:port of the mail server
+	From     string
+	To       []string
+	Username string
+	Password string
+}
+
+// URL returns the Grafana address published on the local host.
+func (g Grafana) URL() string {
+	return fmt.Sprintf("http://localhost:%d", g.Port)
+}
+
+// URL returns the VictoriaMetrics address published on the local host.
+func (v VictoriaMetrics) URL() string {
+	return fmt.Sprintf("http://localhost:%d", v.Port)
+}
+
+// HostFromEnv reads the host settings through lookup, applying defaults for
+// anything unset. It only reports values that cannot be parsed; call
+// Validate for range and cross-field checks.
+func HostFromEnv(lookup Lookup) (Host, error) {
+	h := Host{
+		Grafana: Grafana{
+			AdminUser:     lookupString(lookup, "GRAFANA_ADMIN_USER", DefaultGrafanaAdminUser),
+			AdminPassword: lookupString(lookup, "GRAFANA_ADMIN_PASSWORD", DefaultGrafanaAdminPassword),
+		},
+		VictoriaMetrics: VictoriaMetrics{
+			Retention: lookupString(lookup, "VICTORIA_METRICS_RETENTION", DefaultVictoriaMetricsRetention),
+		},
+		Network:    lookupString(lookup, "HOST_NETWORK", DefaultHostNetwork),
+		SubnetCIDR: lookupString(lookup, "SUBNET_CIDR", DefaultSubnetCIDR),
+		HTTPS: HTTPS{
+			CertPath: lookupString(lookup, "SSL_CERT_PATH", ""),
+			KeyPath:  lookupString(lookup, "SSL_KEY_PATH", ""),
+		},
+		Alertmanager: Alertmanager{
+			NotifierURL:     lookupString(lookup, "ALERT_NOTIFIER_URL", DefaultAlertNotifierURL),
+			WebhookURL:      lookupString(lookup, "ALERT_WEBHOOK_URL", ""),
+			SlackWebhookURL: lookupString(lookup, "SLACK_WEBHOOK_URL", ""),
+			SMTP: SMTP{
+				Addr:     lookupString(lookup, "ALERT_SMTP_ADDR", ""),
+				From:     lookupString(lookup, "ALERT_SMTP_FROM", ""),
+				To:       lookupList(lookup, "ALERT_SMTP_TO"),
+				Username: lookupString(lookup, "ALERT_SMTP_USERNAME", ""),
+				Password: lookupString(lookup, "ALERT_SMTP_PASSWORD", ""),
+			},
+		},
+		LogLevel: lookupString(lookup, "LOG_LEVEL", DefaultLogLevel),
+	}
+
+	var err error
+	if h.Grafana.Port, err = lookupInt(lookup, "GRAFANA_PORT", DefaultGrafanaPort); err != nil {
+		return Host{}, err
+	}
+	if h.Grafana.ExternalAccess, err = lookupBool(lookup, "GRAFANA_EXTERNAL_ACCESS", true); err != nil {
+		return Host{}, err
+	}
+	if h.Grafana.Resources, err = lookupResources(lookup, "GRAFANA_"); err != nil {
+		return Host{}, err
+	}
+	if h.VictoriaMetrics.Port, err = lookupInt(lookup, "VICTORIA_METRICS_PORT", DefaultVictoriaMetricsPort); err != nil {
+		return Host{}, err
+	}
+	if h.VictoriaMetrics.ExternalAccess, err = lookupBool(lookup, "VICTORIA_METRICS_EXTERNAL_ACCESS", false); err != nil {
+		return Host{}, err
+	}
+	if h.VictoriaMetrics.Resources, err = lookupResources(lookup, "VICTORIA_METRICS_"); err != nil {
+		return Host{}, err
+	}
+
+	if h.VMAgent.ScrapeInterval, err = lookupDuration(lookup, "VMAGENT_SCRAPE_INTERVAL", DefaultScrapeInterval); err != nil {
+		return Host{}, err
+	}
+	if h.VMAgent.ExternalLabels, err = ParseLabels(lookupString(lookup, "VMAGENT_EXTERNAL_LABELS", "")); err != nil {
+		return Host{}, fmt.Errorf("invalid VMAGENT_EXTERNAL_LABELS: %v", err)
+	}
+	if h.VMAgent.Resources, err = lookupResources(lookup, "VMAGENT_"); err != nil {
+		return Host{}, err
+	}
+
+	if h.HTTPS.Enabled, err = lookupBool(lookup, "ENABLE_HTTPS", false); err != nil {
+		return Host{}, err
+	}
+	if h.HA.Enabled, err = lookupBool(lookup, "HA_ENABLED", false); err != nil {
+		return Host{}, err
+	}
+	if h.HA.Replicas, err = lookupInt(lookup, "HA_REPLICAS", DefaultHAReplicas); err != nil {
+		return Host{}, err
+	}
+	if h.Alertmanager.Enabled, err = lookupBool(lookup, "ALERTMANAGER_ENABLED", false); err != nil {
+		return Host{}, err
+	}
+
+	if h.Alerts, err = lookupThresholds(lookup); err != nil {
+		return Host{}, err
+	}
+
+	if h.Targets, err = targets.ConfigFromEnv(h.targetsLookup(lookup)); err != nil {
+		return Host{}, err
+	}
+
+	if h.Scrape, err = lookupScrape(lookup, h.VMAgent.ScrapeInterval); err != nil {
+		return Host{}, err
+	}
+
+	return h, nil
+}
+
+// lookupScrape reads the scrape job settings:
+//
+//	SCRAPE_EXPORTERS        exporters to scrape, default all-smi,dcgm-exporter
+//	ALL_SMI_INTERVAL        the workers' all-smi collection interval in seconds
+//	ALL_SMI_SCRAPE_TIMEOUT  all-smi job timeout, default 10s capped at the interval
+//	DCGM_SCRAPE_INTERVAL    dcgm-exporter job interval, default 15s
+//	DCGM_SCRAPE_TIMEOUT     dcgm-exporter job timeout, default 10s capped at the interval
+//
+// The all-smi job is scraped every interval, the VMAGENT_SCRAPE_INTERVAL;
+// the global interval stays scrape.DefaultGlobalScrapeInterval.
+func lookupScrape(lookup Lookup, interval time.Duration) (scrape.Settings, error) {
+	var s scrape.Settings
+
+	exporters := lookupList(lookup, "SCRAPE_EXPORTERS")
+	if len(exporters) == 0 {
+		for _, exporter := range targets.Exporters() {
+			exporters = append(exporters, exporter.Name)
+		}
+	}
+
+	for _, name := range exporters {
+		exporter, err := targets.LookupExporter(name)
+		if err != nil {
+			return scrape.Settings{}, fmt.Errorf("invalid SCRAPE_EXPORTERS: %v", err)
+		}
+		job := scrape.JobSettings{Exporter: exporter.Name}
+
+		switch exporter.Name {
+		case targets.AllSmi.Name:
+			collect, err := lookupInt(lookup, "ALL_SMI_INTERVAL", DefaultAllSmiInterval)
+			if err != nil {
+				return scrape.Settings{}, err
+			}
+			job.ScrapeInterval = model.Duration(interval)
+			job.MinInterval = model.Duration(time.Duration(collect) * time.Second)
+			timeout, err := lookupDuration(lookup, "ALL_SMI_SCRAPE_TIMEOUT", 0)
+			if err != nil {
+				return scrape.Settings{}, err
+			}
+			job.ScrapeTimeout = model.Duration(timeout)
+		case targets.DCGMExporter.Name:
+			jobInterval, err := lookupDuration(lookup, "DCGM_SCRAPE_INTERVAL", 0)
+			if err != nil {
+				return scrape.Settings{}, err
+			}
+			timeout, err := lookupDuration(lookup, "DCGM_SCRAPE_TIMEOUT", 0)
+			if err != nil {
+				return scrape.Settings{}, err
+			}
+			job.ScrapeInterval = model.Duration(jobInterval)
+			job.ScrapeTimeout = model.Duration(timeout)
+		}
+
+		s.Jobs = append(s.Jobs, job)
+	}
+	return s, nil
+}
+
+// lookupThresholds reads the ALERT_* settings over rules.DefaultThresholds.
+func lookupThresholds(lookup Lookup) (rules.Thresholds, error) {
+	t := rules.DefaultThresholds()
+
+	var err error
+	if t.TargetDownFor, err = lookupDuration(lookup, "ALERT_TARGET_DOWN_FOR", t.TargetDownFor); err != nil {
+		return rules.Thresholds{}, err
+	}
+	if t.GPUTemperatureWarning, err = lookupFloat(lookup, "ALERT_GPU_TEMPERATURE_WARNING", t.GPUTemperatureWarning); err != nil {
+		return rules.Thresholds{}, err
+	}
+	if t.GPUTemperatureCritical, err = lookupFloat(lookup, "ALERT_GPU_TEMPERATURE_CRITICAL", t.GPUTemperatureCritical); err != nil {
+		return rules.Thresholds{}, err
+	}
+	if t.GPUMemoryPercent, err = lookupFloat(lookup, "ALERT_GPU_MEMORY_PERCENT", t.GPUMemoryPercent); err != nil {
+		return rules.Thresholds{}, err
+	}
+	if t.For, err = lookupDuration(lookup, "ALERT_FOR", t.For); err != nil {
+		return rules.Thresholds{}, err
+	}
+	return t, nil
+}
+
+// targetsLookup lets targets.ConfigFromEnv read a host env file.
+func (h Host) targetsLookup(lookup Lookup) Lookup {
+	fallbacks := map[string]string{
+		"ALGALON_TARGETS":     lookupString(lookup, "WORKER_TARGETS", DefaultWorkerTargets),
+		"ALGALON_CLUSTER":     h.VMAgent.ExternalLabels["cluster"],
+		"ALGALON_ENVIRONMENT": h.VMAgent.ExternalLabels["environment"],
+	}
+
+	return func(key string) (string, bool) {
+		if value, ok := lookup(key); ok {
+			return value, true
+		}
+		value, ok := fallbacks[key]
+		return value, ok && value != ""
+	}
+}
+
+var (
+	retentionPattern = regexp.MustCompile(`^[0-9]+[hdwy]?$`)
+	logLevels        = map[string]bool{"debug": true, "info": true, "warn": true, "error": true}
+)
+
+// Validate checks ranges and the combinations the host stack depends on.
+func (h Host) Validate() error {
+	if h.Grafana.AdminUser == "" {
+		return fmt.Errorf("GRAFANA_ADMIN_USER must not be empty")
+	}
+	if err := targets.ValidatePort(h.Grafana.Port); err != nil {
+		return fmt.Errorf("invalid GRAFANA_PORT: %v", err)
+	}
+	if err := targets.ValidatePort(h.VictoriaMetrics.Port); err != nil {
+		return fmt.Errorf("invalid VICTORIA_METRICS_PORT: %v", err)
+	}
+	if h.Grafana.Port == h.VictoriaMetrics.Port {
+		return fmt.Errorf("GRAFANA_PORT and VICTORIA_METRICS_PORT are both %d", h.Grafana.Port)
+	}
+	if !retentionPattern.MatchString(h.VictoriaMetrics.Retention) {
+		return fmt.Errorf("invalid VICTORIA_METRICS_RETENTION %q: expected a period such as 7d, 4w or 1y", h.VictoriaMetrics.Retention)
+	}
+	if h.VMAgent.ScrapeInterval < time.Second {
+		return fmt.Errorf("invalid VMAGENT_SCRAPE_INTERVAL %s: must be at least 1s", h.VMAgent.ScrapeInterval)
+	}
+
+	if err := h.Grafana.Resources.validate("GRAFANA_"); err != nil {
+		return err
+	}
+	if err := h.VictoriaMetrics.Resources.validate("VICTORIA_METRICS_"); err != nil {
+		return err
+	}
+	if err := h.VMAgent.Resources.validate("VMAGENT_"); err != nil {
+		return err
+	}
+
+	if _, err := h.Targets.Groups(); err != nil {
+		return fmt.Errorf("invalid worker targets: %v", err)
+	}
+
+	if h.Network == "" {
+		return fmt.Errorf("HOST_NETWORK must not be empty")
+	}
+	if _, _, err := net.ParseCIDR(h.SubnetCIDR); err != nil {
+		return fmt.Errorf("invalid SUBNET_CIDR %q: %v", h.SubnetCIDR, err)
+	}
+
+	if h.HTTPS.Enabled && (h.HTTPS.CertPath == "" || h.HTTPS.KeyPath == "") {
+		return fmt.Errorf("ENABLE_HTTPS requires SSL_CERT_PATH and SSL_KEY_PATH")
+	}
+	if h.HA.Replicas < 1 {
+		return fmt.Errorf("invalid HA_REPLICAS %d: must be at least 1", h.HA.Replicas)
+	}
+	if h.HA.Enabled && h.HA.Replicas < 2 {
+		return fmt.Errorf("HA_ENABLED requires HA_REPLICAS of at least 2, got %d", h.HA.Replicas)
+	}
+
+	if err := validateURL("ALERT_NOTIFIER_URL", h.Alertmanager.NotifierURL); err != nil {
+		return err
+	}
+	if err := validateURL("ALERT_WEBHOOK_URL", h.Alertmanager.WebhookURL); err != nil {
+		return err
+	}
+	if err := validateURL("SLACK_WEBHOOK_URL", h.Alertmanager.SlackWebhookURL); err != nil {
+		return err
+	}
+	if err := h.Alertmanager.SMTP.validate(); err != nil {
+		return err
+	}
+	if h.Alertmanager.Enabled && h.Alertmanager.WebhookURL == "" && h.Alertmanager.SlackWebhookURL == "" && h.Alertmanager.SMTP.Addr == "" {
+		return fmt.Errorf("ALERTMANAGER_ENABLED requires ALERT_WEBHOOK_URL, SLACK_WEBHOOK_URL or ALERT_SMTP_ADDR")
+	}
+
+	if err := h.Alerts.Validate(); err != nil {
+		return fmt.Errorf("invalid ALERT_* thresholds: %v", err)
+	}
+
+	if _, err := h.Scrape.Config(); err != nil {
+		return fmt.Errorf("invalid scrape settings: %v", err)
+	}
+
+	if !logLevels[h.LogLevel] {
+		return fmt.Errorf("invalid LOG_LEVEL %q: expected debug, info, warn or error", h.LogLevel)
+	}
+
+	return nil
+}
+
+// validate checks the mail server address and that a configured receiver
+// has a sender and recipients with valid addresses.
+func (s SMTP) validate() error {
+	if s.Addr == "" {
+		return nil
+	}
+	if _, port, err := net.SplitHostPort(s.Addr); err != nil || port == "" {
+		return fmt.Errorf("invalid ALERT_SMTP_ADDR %q: expected host:port", s.Addr)
+	}
+	if s.From == "" || len(s.To) == 0 {
+		return fmt.Errorf("ALERT_SMTP_ADDR requires ALERT_SMTP_FROM and ALERT_SMTP_TO")
+	}
+	for _, address := range append([]string{s.From}, s.To...) {
+		if _, err := mail.ParseAddress(address); err != nil {
+			return fmt.Errorf("invalid email address %q: %v", address, err)
+		}
+	}
+	return nil
+}
+
+// validateURL accepts an empty value or an absolute URL.
+func validateURL(key, value string) error {
+	if value == "" {
+		return nil
+	}
+	if u, err := url.Parse(value); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid %s %q: expected an absolute URL", key, value)
+	}
+	return nil
+}
+
+// LoadHost reads and validates a host env file such as
+// examples/host-configs/basic-host.env.
+func LoadHost(path string) (Host, error) {
+	lookup, err := readFile(path)
+	if err != nil {
+		return Host{}, err
+	}
+	h, err := HostFromEnv(lookup)
+	if err != nil {
+		return Host{}, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := h.Validate(); err != nil {
+		return Host{}, fmt.Errorf("%s: %v", path, err)
+	}
+	return h, nil
+}
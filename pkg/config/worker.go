@@ -0,0 +1,118 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+
+	"github.com/appleparan/Algalon/pkg/dcgm"
+	"github.com/appleparan/Algalon/pkg/targets"
+)
+
+// Worker defaults, matching algalon_worker/setup.sh and
+// algalon_worker/docker-compose.yml.
+const (
+	DefaultAllSmiVersion  = "v0.9.0"
+	DefaultAllSmiPort     = targets.DefaultPort
+	DefaultAllSmiInterval = 5
+	DefaultHostIP         = "0.0.0.0"
+)
+
+// Worker is a parsed algalon_worker/.env.
+type Worker struct {
+	Version  string // all-smi git ref, e.g. v0.9.0 or main
+	Port     int
+	Interval int // seconds between all-smi collections
+	HostIP   string
+	Hostname string // empty means the system hostname
+	Labels   map[string]string
+
+	// DCGMProfile selects the dcgm-exporter counters from DCGM_PROFILE;
+	// empty means the worker runs all-smi only.
+	DCGMProfile string
+
+	// RegistryURL is the host's algalon-registry service, from
+	// ALGALON_REGISTRY_URL. When set, the worker runs algalon-agent to
+	// register itself; empty means targets are added on the host.
+	RegistryURL string
+
+	Resources Resources
+}
+
+// WorkerFromEnv reads the worker settings through lookup, applying defaults
+// for anything unset. It only reports values that cannot be parsed; call
+// Validate for range checks.
+func WorkerFromEnv(lookup Lookup) (Worker, error) {
+	w := Worker{
+		Version:  lookupString(lookup, "ALL_SMI_VERSION", DefaultAllSmiVersion),
+		HostIP:   lookupString(lookup, "HOST_IP", DefaultHostIP),
+		Hostname: lookupString(lookup, "HOSTNAME", ""),
+
+		DCGMProfile: lookupString(lookup, "DCGM_PROFILE", ""),
+		RegistryURL: lookupString(lookup, "ALGALON_REGISTRY_URL", ""),
+	}
+
+	var err error
+	if w.Port, err = lookupInt(lookup, "ALL_SMI_PORT", DefaultAllSmiPort); err != nil {
+		return Worker{}, err
+	}
+	if w.Interval, err = lookupInt(lookup, "ALL_SMI_INTERVAL", DefaultAllSmiInterval); err != nil {
+		return Worker{}, err
+	}
+	if w.Labels, err = ParseLabels(lookupString(lookup, "WORKER_LABELS", "")); err != nil {
+		return Worker{}, fmt.Errorf("invalid WORKER_LABELS: %v", err)
+	}
+	if w.Resources, err = lookupResources(lookup, ""); err != nil {
+		return Worker{}, err
+	}
+
+	return w, nil
+}
+
+// versionPattern matches the git refs generate-dockerfile.sh can fetch.
+var versionPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/-]*$`)
+
+// Validate checks the values all-smi and docker compose will be given.
+func (w Worker) Validate() error {
+	if !versionPattern.MatchString(w.Version) {
+		return fmt.Errorf("invalid ALL_SMI_VERSION %q: expected a release tag such as v0.9.0 or a branch", w.Version)
+	}
+	if err := targets.ValidatePort(w.Port); err != nil {
+		return fmt.Errorf("invalid ALL_SMI_PORT: %v", err)
+	}
+	if w.Interval < 1 {
+		return fmt.Errorf("invalid ALL_SMI_INTERVAL %d: must be a whole number of seconds >= 1", w.Interval)
+	}
+	if net.ParseIP(w.HostIP) == nil {
+		return fmt.Errorf("invalid HOST_IP %q: not an IP address", w.HostIP)
+	}
+	if w.DCGMProfile != "" {
+		if _, err := dcgm.LookupProfile(w.DCGMProfile); err != nil {
+			return fmt.Errorf("invalid DCGM_PROFILE: %v", err)
+		}
+	}
+	if err := validateURL("ALGALON_REGISTRY_URL", w.RegistryURL); err != nil {
+		return err
+	}
+	if err := w.Resources.validate(""); err != nil {
+		return err
+	}
+	return nil
+}
+
+// LoadWorker reads and validates a worker env file such as
+// examples/worker-configs/basic-worker.env.
+func LoadWorker(path string) (Worker, error) {
+	lookup, err := readFile(path)
+	if err != nil {
+		return Worker{}, err
+	}
+	w, err := WorkerFromEnv(lookup)
+	if err != nil {
+		return Worker{}, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := w.Validate(); err != nil {
+		return Worker{}, fmt.Errorf("%s: %v", path, err)
+	}
+	return w, nil
+}
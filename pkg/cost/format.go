@@ -0,0 +1,132 @@
+package cost
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Output formats.
+const (
+	FormatCSV      = "csv"
+	FormatJSON     = "json"
+	FormatMarkdown = "markdown"
+)
+
+var writers = map[string]func(io.Writer, *Report) error{
+	FormatCSV:      WriteCSV,
+	FormatJSON:     WriteJSON,
+	FormatMarkdown: WriteMarkdown,
+	"md":           WriteMarkdown,
+}
+
+// CheckFormat reports whether Write supports format.
+func CheckFormat(format string) error {
+	if _, ok := writers[format]; !ok {
+		return fmt.Errorf("unknown report format %q: expected csv, json or markdown", format)
+	}
+	return nil
+}
+
+// Write encodes report to w in format.
+func Write(w io.Writer, report *Report, format string) error {
+	if err := CheckFormat(format); err != nil {
+		return err
+	}
+	return writers[format](w, report)
+}
+
+var csvHeader = []string{
+	"cluster", "instance", "gpu", "gpu_name", "gpu_type",
+	"observed_hours", "idle_hours", "idle_percent", "price_per_hour", "idle_cost",
+}
+
+// WriteCSV writes one line per GPU. An unpriced GPU has an empty price and
+// cost rather than 0, so spreadsheets do not sum it as free.
+func WriteCSV(w io.Writer, report *Report) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+
+	for _, row := range report.Rows {
+		price, cost := "", ""
+		if row.Priced {
+			price, cost = formatFloat(row.PricePerHour, -1), formatFloat(row.IdleCost, 2)
+		}
+		record := []string{
+			row.Cluster, row.Instance, row.GPU, row.GPUName, row.GPUType,
+			formatFloat(row.ObservedHours, 2), formatFloat(row.IdleHours, 2), formatFloat(row.IdlePercent, 1),
+			price, cost,
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteJSON writes the report as indented JSON.
+func WriteJSON(w io.Writer, report *Report) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// WriteMarkdown writes a summary by gpu_type followed by one table row per
+// GPU, for pasting into an issue or a wiki page.
+func WriteMarkdown(w io.Writer, report *Report) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# Idle GPU Cost Report\n\n")
+	fmt.Fprintf(&b, "- Window: %s to %s (step %s)\n", report.Start.Format(time.RFC3339), report.End.Format(time.RFC3339), report.Step)
+	fmt.Fprintf(&b, "- Idle: `%s` below %s%%\n", report.Query, formatFloat(report.Threshold, -1))
+	fmt.Fprintf(&b, "- Total: %s idle GPU hours, %s %s\n", formatFloat(report.IdleHours, 2), formatFloat(report.IdleCost, 2), report.Currency)
+	if len(report.Unpriced) > 0 {
+		types := make([]string, 0, len(report.Unpriced))
+		for _, gpuType := range report.Unpriced {
+			types = append(types, "`"+gpuTypeName(gpuType)+"`")
+		}
+		fmt.Fprintf(&b, "- Not priced: %s\n", strings.Join(types, ", "))
+	}
+
+	fmt.Fprintf(&b, "\n## By GPU Type\n\n")
+	fmt.Fprintf(&b, "| GPU type | GPUs | Idle hours | Idle cost (%s) |\n", report.Currency)
+	fmt.Fprintf(&b, "|----------|-----:|-----------:|---------------:|\n")
+	for _, summary := range report.ByType {
+		fmt.Fprintf(&b, "| %s | %d | %s | %s |\n", gpuTypeName(summary.GPUType), summary.GPUs,
+			formatFloat(summary.IdleHours, 2), formatFloat(summary.IdleCost, 2))
+	}
+
+	fmt.Fprintf(&b, "\n## By GPU\n\n")
+	fmt.Fprintf(&b, "| Cluster | Instance | GPU | GPU type | Observed hours | Idle hours | Idle %% | Idle cost (%s) |\n", report.Currency)
+	fmt.Fprintf(&b, "|---------|----------|----:|----------|---------------:|-----------:|-------:|---------------:|\n")
+	for _, row := range report.Rows {
+		cost := "n/a"
+		if row.Priced {
+			cost = formatFloat(row.IdleCost, 2)
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | %s | %s | %s |\n", row.Cluster, row.Instance, row.GPU, gpuTypeName(row.GPUType),
+			formatFloat(row.ObservedHours, 2), formatFloat(row.IdleHours, 2), formatFloat(row.IdlePercent, 1), cost)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func formatFloat(f float64, precision int) string {
+	return strconv.FormatFloat(f, 'f', precision, 64)
+}
+
+func gpuTypeName(gpuType string) string {
+	if gpuType == "" {
+		return "(no gpu_type)"
+	}
+	return gpuType
+}
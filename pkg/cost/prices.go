@@ -0,0 +1,85 @@
+package cost
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Prices is the hourly price of one GPU by gpu_type, the accelerator name
+// the algalon-worker Terraform module and the cluster spec put in that
+// label.
+type Prices struct {
+	Currency string             `yaml:"currency"`
+	PerHour  map[string]float64 `yaml:"per_gpu_hour"`
+}
+
+// DefaultPrices returns Compute Engine on-demand list prices in us-central1
+// at the time of writing. Committed use, spot pricing and other regions
+// differ; pass a price file to use your own.
+func DefaultPrices() Prices {
+	return Prices{
+		Currency: "USD",
+		PerHour: map[string]float64{
+			"nvidia-tesla-t4":   0.35,
+			"nvidia-tesla-p4":   0.60,
+			"nvidia-tesla-p100": 1.46,
+			"nvidia-tesla-v100": 2.48,
+			"nvidia-tesla-a100": 2.93,
+			"nvidia-l4":         0.56,
+		},
+	}
+}
+
+// Lookup returns the hourly price of gpuType.
+func (p Prices) Lookup(gpuType string) (float64, bool) {
+	price, ok := p.PerHour[gpuType]
+	return price, ok
+}
+
+// Validate checks that a currency is set and no price is negative.
+func (p Prices) Validate() error {
+	if p.Currency == "" {
+		return fmt.Errorf("price table has no currency")
+	}
+	if len(p.PerHour) == 0 {
+		return fmt.Errorf("price table has no per_gpu_hour prices")
+	}
+
+	types := make([]string, 0, len(p.PerHour))
+	for gpuType := range p.PerHour {
+		types = append(types, gpuType)
+	}
+	sort.Strings(types)
+	for _, gpuType := range types {
+		if gpuType == "" {
+			return fmt.Errorf("price table has a price without a gpu_type")
+		}
+		if p.PerHour[gpuType] < 0 {
+			return fmt.Errorf("price of %s must not be negative, got %g", gpuType, p.PerHour[gpuType])
+		}
+	}
+	return nil
+}
+
+// LoadPrices reads a YAML price table such as examples/cost/gpu-prices.yml.
+func LoadPrices(path string) (Prices, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Prices{}, err
+	}
+
+	var prices Prices
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&prices); err != nil {
+		return Prices{}, fmt.Errorf("%s: invalid price table: %v", path, err)
+	}
+	if err := prices.Validate(); err != nil {
+		return Prices{}, fmt.Errorf("%s: %v", path, err)
+	}
+	return prices, nil
+}
@@ -0,0 +1,214 @@
+// Package cost reports what idle GPUs cost. It reads the utilization
+// history of every GPU from VictoriaMetrics, counts the hours each spent
+// below an idle threshold and prices them by the gpu_type label.
+package cost
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/appleparan/Algalon/pkg/vmclient"
+	"github.com/prometheus/common/model"
+)
+
+// Report defaults.
+const (
+	DefaultQuery     = "all_smi_gpu_utilization"
+	DefaultThreshold = 5.0 // percent utilization
+	DefaultWindow    = 7 * 24 * time.Hour
+	DefaultStep      = 5 * time.Minute
+)
+
+// RangeQuerier runs range queries; *vmclient.Client implements it.
+type RangeQuerier interface {
+	QueryRange(ctx context.Context, query string, start, end time.Time, step time.Duration) (vmclient.Matrix, error)
+}
+
+// Row is the idle time and cost of one GPU.
+type Row struct {
+	Cluster       string  `json:"cluster"`
+	Instance      string  `json:"instance"`
+	GPU           string  `json:"gpu"`
+	GPUName       string  `json:"gpu_name,omitempty"`
+	GPUType       string  `json:"gpu_type"`
+	ObservedHours float64 `json:"observed_hours"`
+	IdleHours     float64 `json:"idle_hours"`
+	IdlePercent   float64 `json:"idle_percent"`
+	PricePerHour  float64 `json:"price_per_hour"`
+	IdleCost      float64 `json:"idle_cost"`
+	Priced        bool    `json:"priced"`
+}
+
+// TypeSummary totals the rows of one gpu_type.
+type TypeSummary struct {
+	GPUType   string  `json:"gpu_type"`
+	GPUs      int     `json:"gpus"`
+	IdleHours float64 `json:"idle_hours"`
+	IdleCost  float64 `json:"idle_cost"`
+}
+
+// Report is the idle-GPU cost over one window.
+type Report struct {
+	Start     time.Time     `json:"start"`
+	End       time.Time     `json:"end"`
+	Step      string        `json:"step"`
+	Query     string        `json:"query"`
+	Threshold float64       `json:"threshold_percent"`
+	Currency  string        `json:"currency"`
+	IdleHours float64       `json:"idle_hours"`
+	IdleCost  float64       `json:"idle_cost"`
+	ByType    []TypeSummary `json:"by_gpu_type"`
+	Rows      []Row         `json:"gpus"`
+	// Unpriced lists the gpu_type values missing from the price table;
+	// their idle hours are reported but cost nothing.
+	Unpriced []string `json:"unpriced_gpu_types,omitempty"`
+}
+
+// Reporter builds reports from the utilization history in VictoriaMetrics.
+type Reporter struct {
+	Querier   RangeQuerier
+	Prices    Prices
+	Query     string  // GPU utilization in percent, one series per GPU
+	Threshold float64 // a GPU below this utilization is idle
+	Step      time.Duration
+}
+
+func (r *Reporter) query() string {
+	if r.Query != "" {
+		return r.Query
+	}
+	return DefaultQuery
+}
+
+func (r *Reporter) threshold() float64 {
+	if r.Threshold > 0 {
+		return r.Threshold
+	}
+	return DefaultThreshold
+}
+
+func (r *Reporter) step() time.Duration {
+	if r.Step > 0 {
+		return r.Step
+	}
+	return DefaultStep
+}
+
+// Generate reports the window from start to end. Every sample stands for
+// the step before it, so the first is taken one step after start, and gaps
+// in the history, e.g. while a preemptible worker was gone, count as
+// neither busy nor idle.
+func (r *Reporter) Generate(ctx context.Context, start, end time.Time) (*Report, error) {
+	if !end.After(start) {
+		return nil, fmt.Errorf("report window must end after it starts")
+	}
+	if err := r.Prices.Validate(); err != nil {
+		return nil, err
+	}
+
+	step := r.step()
+	matrix, err := r.Querier.QueryRange(ctx, r.query(), start.Add(step), end, step)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		Start:     start.UTC(),
+		End:       end.UTC(),
+		Step:      model.Duration(step).String(),
+		Query:     r.query(),
+		Threshold: r.threshold(),
+		Currency:  r.Prices.Currency,
+	}
+
+	hoursPerSample := step.Hours()
+	unpriced := map[string]bool{}
+	for _, series := range matrix {
+		row := Row{
+			Cluster:  series.Metric["cluster"],
+			Instance: series.Metric["instance"],
+			GPU:      gpuIndex(series.Metric),
+			GPUName:  series.Metric["gpu_name"],
+			GPUType:  series.Metric["gpu_type"],
+		}
+
+		idle := 0
+		for _, point := range series.Points {
+			if point.Value < report.Threshold {
+				idle++
+			}
+		}
+		row.ObservedHours = float64(len(series.Points)) * hoursPerSample
+		row.IdleHours = float64(idle) * hoursPerSample
+		if len(series.Points) > 0 {
+			row.IdlePercent = 100 * float64(idle) / float64(len(series.Points))
+		}
+
+		row.PricePerHour, row.Priced = r.Prices.Lookup(row.GPUType)
+		if !row.Priced {
+			unpriced[row.GPUType] = true
+		}
+		row.IdleCost = row.IdleHours * row.PricePerHour
+
+		report.Rows = append(report.Rows, row)
+	}
+
+	sort.Slice(report.Rows, func(i, j int) bool {
+		a, b := report.Rows[i], report.Rows[j]
+		if a.IdleCost != b.IdleCost {
+			return a.IdleCost > b.IdleCost
+		}
+		if a.IdleHours != b.IdleHours {
+			return a.IdleHours > b.IdleHours
+		}
+		if a.Cluster != b.Cluster {
+			return a.Cluster < b.Cluster
+		}
+		if a.Instance != b.Instance {
+			return a.Instance < b.Instance
+		}
+		return a.GPU < b.GPU
+	})
+
+	byType := map[string]*TypeSummary{}
+	for _, row := range report.Rows {
+		report.IdleHours += row.IdleHours
+		report.IdleCost += row.IdleCost
+
+		summary, ok := byType[row.GPUType]
+		if !ok {
+			summary = &TypeSummary{GPUType: row.GPUType}
+			byType[row.GPUType] = summary
+		}
+		summary.GPUs++
+		summary.IdleHours += row.IdleHours
+		summary.IdleCost += row.IdleCost
+	}
+	for _, summary := range byType {
+		report.ByType = append(report.ByType, *summary)
+	}
+	sort.Slice(report.ByType, func(i, j int) bool {
+		a, b := report.ByType[i], report.ByType[j]
+		if a.IdleCost != b.IdleCost {
+			return a.IdleCost > b.IdleCost
+		}
+		return a.GPUType < b.GPUType
+	})
+
+	for gpuType := range unpriced {
+		report.Unpriced = append(report.Unpriced, gpuType)
+	}
+	sort.Strings(report.Unpriced)
+	return report, nil
+}
+
+// gpuIndex returns the GPU of a series: gpu_index for all-smi, gpu for
+// dcgm-exporter.
+func gpuIndex(metric map[string]string) string {
+	if index, ok := metric["gpu_index"]; ok {
+		return index
+	}
+	return metric["gpu"]
+}
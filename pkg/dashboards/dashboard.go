@@ -0,0 +1,203 @@
+// Package dashboards checks the Grafana dashboards under
+// algalon_host/grafana/dashboards. Every panel query and template variable
+// query is parsed as PromQL and each metric it selects must be listed in the
+// metric catalog.
+package dashboards
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// Dashboard is the set of queries a dashboard runs.
+type Dashboard struct {
+	UID     string
+	Title   string
+	Queries []Query
+}
+
+// Query is one PromQL expression and where it appears in the dashboard.
+type Query struct {
+	// Location names the panel and refId, or the template variable.
+	Location string
+	Expr     string
+}
+
+type dashboardJSON struct {
+	UID        string      `json:"uid"`
+	Title      string      `json:"title"`
+	Panels     []panelJSON `json:"panels"`
+	Templating struct {
+		List []variableJSON `json:"list"`
+	} `json:"templating"`
+}
+
+type panelJSON struct {
+	Title   string `json:"title"`
+	Targets []struct {
+		RefID string `json:"refId"`
+		Expr  string `json:"expr"`
+	} `json:"targets"`
+	// Collapsed rows carry their panels inside the row.
+	Panels []panelJSON `json:"panels"`
+}
+
+type variableJSON struct {
+	Name  string          `json:"name"`
+	Type  string          `json:"type"`
+	Query json.RawMessage `json:"query"`
+}
+
+// Parse extracts the queries of a dashboard JSON model.
+func Parse(data []byte) (*Dashboard, error) {
+	var model dashboardJSON
+	if err := json.Unmarshal(data, &model); err != nil {
+		return nil, fmt.Errorf("failed to parse dashboard: %v", err)
+	}
+
+	d := &Dashboard{UID: model.UID, Title: model.Title}
+	d.addPanels(model.Panels)
+
+	for _, variable := range model.Templating.List {
+		if variable.Type != "query" {
+			continue
+		}
+		query, err := variableQuery(variable.Query)
+		if err != nil {
+			return nil, fmt.Errorf("variable %q: %v", variable.Name, err)
+		}
+		if query != "" {
+			d.Queries = append(d.Queries, Query{
+				Location: fmt.Sprintf("variable %q", variable.Name),
+				Expr:     query,
+			})
+		}
+	}
+
+	return d, nil
+}
+
+func (d *Dashboard) addPanels(panels []panelJSON) {
+	for _, panel := range panels {
+		for _, target := range panel.Targets {
+			if strings.TrimSpace(target.Expr) == "" {
+				continue
+			}
+			d.Queries = append(d.Queries, Query{
+				Location: fmt.Sprintf("panel %q query %s", panel.Title, target.RefID),
+				Expr:     target.Expr,
+			})
+		}
+		d.addPanels(panel.Panels)
+	}
+}
+
+// variableQuery returns the query of a template variable, which newer Grafana
+// versions store as {"query": "...", "refId": "..."} rather than a string.
+func variableQuery(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 {
+		return "", nil
+	}
+
+	var query string
+	if err := json.Unmarshal(raw, &query); err == nil {
+		return query, nil
+	}
+
+	var object struct {
+		Query string `json:"query"`
+	}
+	if err := json.Unmarshal(raw, &object); err != nil {
+		return "", fmt.Errorf("malformed query %s", raw)
+	}
+	return object.Query, nil
+}
+
+// ReadFile reads and parses the dashboard at path.
+func ReadFile(path string) (*Dashboard, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return d, nil
+}
+
+var (
+	// Grafana's variable query functions. Only label_values with a series
+	// selector and query_result select metrics.
+	labelValuesPattern = regexp.MustCompile(`^\s*label_values\(\s*(.*)\s*,\s*[a-zA-Z_][a-zA-Z0-9_]*\s*\)\s*$`)
+	queryResultPattern = regexp.MustCompile(`^\s*query_result\(\s*(.*)\s*\)\s*$`)
+	noMetricPattern    = regexp.MustCompile(`^\s*(label_values\(\s*[a-zA-Z_][a-zA-Z0-9_]*\s*\)|label_names\(.*\)|metrics\(.*\))\s*$`)
+
+	// Grafana's interval variables stand where PromQL expects a duration or
+	// a number, so they are replaced before parsing.
+	durationVariablePattern = regexp.MustCompile(`\$(__rate_interval|__interval|__range)\b|\$\{(__rate_interval|__interval|__range)\}`)
+	numberVariablePattern   = regexp.MustCompile(`\$(__interval_ms|__range_ms|__range_s)\b|\$\{(__interval_ms|__range_ms|__range_s)\}`)
+)
+
+// MetricNames parses expr, which may be a panel expression or a template
+// variable query, and returns the sorted, distinct metric names it selects.
+// Grafana variables inside label matchers are left as they are.
+func MetricNames(expr string) ([]string, error) {
+	if noMetricPattern.MatchString(expr) {
+		return nil, nil
+	}
+	if match := labelValuesPattern.FindStringSubmatch(expr); match != nil {
+		expr = match[1]
+	} else if match := queryResultPattern.FindStringSubmatch(expr); match != nil {
+		expr = match[1]
+	}
+
+	expr = durationVariablePattern.ReplaceAllString(expr, "5m")
+	expr = numberVariablePattern.ReplaceAllString(expr, "300")
+
+	parsed, err := parser.ParseExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	var names []string
+	parser.Inspect(parsed, func(node parser.Node, _ []parser.Node) error {
+		selector, ok := node.(*parser.VectorSelector)
+		if !ok {
+			return nil
+		}
+		name := selector.Name
+		if name == "" {
+			name = nameFromMatchers(selector.LabelMatchers)
+		}
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+		return nil
+	})
+
+	sort.Strings(names)
+	return names, nil
+}
+
+// nameFromMatchers returns the metric name of selectors written as
+// {__name__="metric"}. Regular expression name matchers select no single
+// metric and are not checked.
+func nameFromMatchers(matchers []*labels.Matcher) string {
+	for _, matcher := range matchers {
+		if matcher.Name == labels.MetricName && matcher.Type == labels.MatchEqual {
+			return matcher.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,128 @@
+package dashboards
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/appleparan/Algalon/pkg/catalog"
+)
+
+// Issue is a query that fails to parse or selects a metric the catalog does
+// not allow.
+type Issue struct {
+	File     string
+	Location string
+	Expr     string
+	Message  string
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("%s: %s: %s (expr: %s)", i.File, i.Location, i.Message, i.Expr)
+}
+
+// Linter checks dashboard queries against a metric catalog.
+type Linter struct {
+	Catalog *catalog.Catalog
+
+	// Exporters limits the catalog to the named exporters, e.g. all-smi and
+	// vmagent for workers without dcgm-exporter. Empty allows all of them.
+	Exporters []string
+}
+
+// NewLinter returns a linter for c that allows metrics from exporters, or
+// from every exporter in c when none are given.
+func NewLinter(c *catalog.Catalog, exporters ...string) (*Linter, error) {
+	for _, name := range exporters {
+		if _, ok := c.Exporter(name); !ok {
+			return nil, fmt.Errorf("exporter %s is not in metric catalog v%d", name, c.Version)
+		}
+	}
+	return &Linter{Catalog: c, Exporters: exporters}, nil
+}
+
+// Lint returns the issues found in d, in query order. file names the
+// dashboard in the issues.
+func (l *Linter) Lint(file string, d *Dashboard) []Issue {
+	var issues []Issue
+	for _, query := range d.Queries {
+		issue := Issue{File: file, Location: query.Location, Expr: query.Expr}
+
+		names, err := MetricNames(query.Expr)
+		if err != nil {
+			issue.Message = fmt.Sprintf("invalid PromQL: %v", err)
+			issues = append(issues, issue)
+			continue
+		}
+
+		for _, name := range names {
+			if message := l.check(name); message != "" {
+				issue.Message = message
+				issues = append(issues, issue)
+			}
+		}
+	}
+	return issues
+}
+
+func (l *Linter) check(name string) string {
+	_, exporter, ok := l.Catalog.Lookup(name)
+	if !ok {
+		return fmt.Sprintf("unknown metric %s (not in metric catalog v%d)", name, l.Catalog.Version)
+	}
+	if len(l.Exporters) == 0 {
+		return ""
+	}
+	for _, allowed := range l.Exporters {
+		if exporter.Name == allowed {
+			return ""
+		}
+	}
+	return fmt.Sprintf("metric %s is exported by %s, which is not one of %s", name, exporter.Name, strings.Join(l.Exporters, ", "))
+}
+
+// LintPaths lints every dashboard in paths, which may be JSON files or
+// directories of them, and returns the number of dashboards checked.
+func (l *Linter) LintPaths(paths ...string) (int, []Issue, error) {
+	files, err := dashboardFiles(paths)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var issues []Issue
+	for _, file := range files {
+		d, err := ReadFile(file)
+		if err != nil {
+			return 0, nil, err
+		}
+		issues = append(issues, l.Lint(file, d)...)
+	}
+	return len(files), issues, nil
+}
+
+func dashboardFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		matches, err := filepath.Glob(filepath.Join(path, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no dashboards found in %s", path)
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+	return files, nil
+}
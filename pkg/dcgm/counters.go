@@ -0,0 +1,189 @@
+// Package dcgm reads, checks and writes dcgm-exporter counter files such as
+// algalon_worker/dcgm-exporter-config.csv, and builds them from named
+// profiles. Each line of a counter file is
+//
+//	DCGM FIELD, Prometheus metric type, help message
+//
+// and lines starting with '#' are comments.
+package dcgm
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/appleparan/Algalon/pkg/catalog"
+)
+
+// Metric types dcgm-exporter accepts. A label field is not a series; its
+// value is added as a label to every other metric.
+const (
+	TypeGauge   = "gauge"
+	TypeCounter = "counter"
+	TypeLabel   = "label"
+)
+
+// Field is one enabled line of a counter file.
+type Field struct {
+	Name string
+	Type string
+	Help string
+	// Line is the 1-based line number the field was read from, 0 for
+	// fields built from a profile.
+	Line int
+}
+
+// Parse reads a counter file. Whitespace around each column is ignored, so
+// "DCGM_FI_DEV_DEC_UTIL , gauge" reads like dcgm-exporter reads it; a help
+// message may itself contain commas. Parse only checks the shape of each
+// line; call Validate to check names and types.
+func Parse(r io.Reader) ([]Field, error) {
+	var fields []Field
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		columns := strings.SplitN(text, ",", 3)
+		if len(columns) != 3 {
+			return nil, fmt.Errorf("line %d: expected \"DCGM FIELD, metric type, help message\", got %q", line, text)
+		}
+		field := Field{
+			Name: strings.TrimSpace(columns[0]),
+			Type: strings.TrimSpace(columns[1]),
+			Help: strings.TrimSpace(columns[2]),
+			Line: line,
+		}
+		if field.Name == "" {
+			return nil, fmt.Errorf("line %d: no DCGM field name", line)
+		}
+		fields = append(fields, field)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
+
+// ReadFile parses the counter file at path.
+func ReadFile(path string) ([]Field, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return fields, nil
+}
+
+// labelFields are the static DCGM fields dcgm-exporter turns into labels.
+// They are not series, so the metric catalog does not list them.
+var labelFields = map[string]string{
+	"DCGM_FI_DRIVER_VERSION":        "Driver Version",
+	"DCGM_FI_NVML_VERSION":          "NVML Version",
+	"DCGM_FI_DEV_BRAND":             "Device Brand",
+	"DCGM_FI_DEV_SERIAL":            "Device Serial Number",
+	"DCGM_FI_DEV_OEM_INFOROM_VER":   "OEM inforom version",
+	"DCGM_FI_DEV_ECC_INFOROM_VER":   "ECC inforom version",
+	"DCGM_FI_DEV_POWER_INFOROM_VER": "Power management object inforom version",
+	"DCGM_FI_DEV_INFOROM_IMAGE_VER": "Inforom image version",
+	"DCGM_FI_DEV_VBIOS_VERSION":     "VBIOS version of the device",
+}
+
+// Issue is one problem Validate found.
+type Issue struct {
+	Line    int
+	Field   string
+	Message string
+}
+
+func (i Issue) String() string {
+	if i.Line == 0 {
+		return fmt.Sprintf("%s: %s", i.Field, i.Message)
+	}
+	return fmt.Sprintf("line %d: %s: %s", i.Line, i.Field, i.Message)
+}
+
+// Validate checks every field against the dcgm-exporter metrics in c and
+// the known label fields: the name must be known, the type must be gauge,
+// counter or label and match the catalog, and no field may be listed twice.
+func Validate(fields []Field, c *catalog.Catalog) []Issue {
+	var issues []Issue
+	seen := map[string]int{}
+
+	for _, field := range fields {
+		add := func(format string, args ...interface{}) {
+			issues = append(issues, Issue{Line: field.Line, Field: field.Name, Message: fmt.Sprintf(format, args...)})
+		}
+
+		if line, ok := seen[field.Name]; ok {
+			add("already listed on line %d", line)
+			continue
+		}
+		seen[field.Name] = field.Line
+
+		switch field.Type {
+		case TypeGauge, TypeCounter, TypeLabel:
+		default:
+			add("unknown metric type %q: expected gauge, counter or label", field.Type)
+			continue
+		}
+
+		if _, ok := labelFields[field.Name]; ok {
+			if field.Type != TypeLabel {
+				add("is a static field and must have type label, not %s", field.Type)
+			}
+			continue
+		}
+
+		metric, exporter, ok := c.Lookup(field.Name)
+		if !ok || exporter.Name != catalog.DCGMExporter {
+			add("not a DCGM field in metric catalog v%d", c.Version)
+			continue
+		}
+		if field.Type != metric.Type {
+			add("has type %s in the metric catalog, not %s", metric.Type, field.Type)
+		}
+	}
+
+	return issues
+}
+
+const fileHeader = `# %s
+# dcgm-exporter counters generated by Algalon from the %s DCGM profile;
+# manual edits are overwritten by the next algalonctl dcgm generate.
+#
+# Format
+# If line starts with a '#' it is considered a comment
+# DCGM FIELD, Prometheus metric type, help message
+
+`
+
+// Format writes fields as a counter file for profile, with the field and
+// type columns aligned. name is the base name of the file the output is
+// destined for.
+func Format(w io.Writer, name, profile string, fields []Field) error {
+	nameWidth, typeWidth := 0, 0
+	for _, field := range fields {
+		nameWidth = max(nameWidth, len(field.Name)+1)
+		typeWidth = max(typeWidth, len(field.Type)+1)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, fileHeader, name, profile)
+	for _, field := range fields {
+		fmt.Fprintf(&b, "%-*s %-*s %s\n", nameWidth, field.Name+",", typeWidth, field.Type+",", field.Help)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
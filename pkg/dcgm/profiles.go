@@ -0,0 +1,190 @@
+package dcgm
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/appleparan/Algalon/pkg/catalog"
+	"github.com/appleparan/Algalon/pkg/targets"
+)
+
+// DefaultFileName is the counter file the worker mounts into dcgm-exporter.
+const DefaultFileName = "dcgm-exporter-config.csv"
+
+// Profile names.
+const (
+	ProfileMinimal        = "minimal"
+	ProfileDefault        = "default"
+	ProfileProfilingHeavy = "profiling-heavy"
+)
+
+// Profile is a named set of DCGM fields. A profile extends its Base with
+// Fields; a field ending in '*' adds every catalog field with that prefix.
+type Profile struct {
+	Name        string
+	Description string
+	Base        string
+	Fields      []string
+}
+
+var profiles = []Profile{
+	{
+		Name:        ProfileMinimal,
+		Description: "utilization, memory, temperature, power and XID errors: what the dashboards and alert rules need",
+		Fields: []string{
+			"DCGM_FI_DEV_GPU_UTIL",
+			"DCGM_FI_DEV_MEM_COPY_UTIL",
+			"DCGM_FI_DEV_FB_FREE",
+			"DCGM_FI_DEV_FB_USED",
+			"DCGM_FI_DEV_GPU_TEMP",
+			"DCGM_FI_DEV_POWER_USAGE",
+			"DCGM_FI_DEV_XID_ERRORS",
+			"DCGM_FI_DRIVER_VERSION",
+		},
+	},
+	{
+		Name:        ProfileDefault,
+		Description: "the fields enabled in dcgm-exporter's default-counters.csv",
+		Base:        ProfileMinimal,
+		Fields: []string{
+			"DCGM_FI_DEV_SM_CLOCK",
+			"DCGM_FI_DEV_MEM_CLOCK",
+			"DCGM_FI_DEV_MEMORY_TEMP",
+			"DCGM_FI_DEV_TOTAL_ENERGY_CONSUMPTION",
+			"DCGM_FI_DEV_PCIE_REPLAY_COUNTER",
+			"DCGM_FI_DEV_ENC_UTIL",
+			"DCGM_FI_DEV_DEC_UTIL",
+			"DCGM_FI_DEV_NVLINK_BANDWIDTH_TOTAL",
+			"DCGM_FI_DEV_VGPU_LICENSE_STATUS",
+			"DCGM_FI_DEV_UNCORRECTABLE_REMAPPED_ROWS",
+			"DCGM_FI_DEV_CORRECTABLE_REMAPPED_ROWS",
+			"DCGM_FI_DEV_ROW_REMAP_FAILURE",
+			"DCGM_FI_PROF_GR_ENGINE_ACTIVE",
+			"DCGM_FI_PROF_PIPE_TENSOR_ACTIVE",
+			"DCGM_FI_PROF_DRAM_ACTIVE",
+			"DCGM_FI_PROF_PCIE_TX_BYTES",
+			"DCGM_FI_PROF_PCIE_RX_BYTES",
+		},
+	},
+	{
+		Name:        ProfileProfilingHeavy,
+		Description: "the default fields plus every DCGM_FI_PROF_* profiling metric; needs a Volta or newer datacenter GPU",
+		Base:        ProfileDefault,
+		Fields:      []string{"DCGM_FI_PROF_*"},
+	},
+}
+
+// Profiles returns every profile, smallest first.
+func Profiles() []Profile {
+	return append([]Profile(nil), profiles...)
+}
+
+// ProfileNames returns the profile names, smallest first.
+func ProfileNames() []string {
+	names := make([]string, 0, len(profiles))
+	for _, profile := range profiles {
+		names = append(names, profile.Name)
+	}
+	return names
+}
+
+// LookupProfile returns the profile called name.
+func LookupProfile(name string) (Profile, error) {
+	for _, profile := range profiles {
+		if profile.Name == name {
+			return profile, nil
+		}
+	}
+	return Profile{}, fmt.Errorf("unknown DCGM profile %q: expected %s", name, strings.Join(ProfileNames(), ", "))
+}
+
+// Build returns the fields of the profile called name, base fields first,
+// with types and help messages from c. The result passes Validate.
+func Build(name string, c *catalog.Catalog) ([]Field, error) {
+	profile, err := LookupProfile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []Field
+	if profile.Base != "" {
+		if fields, err = Build(profile.Base, c); err != nil {
+			return nil, err
+		}
+	}
+	seen := map[string]bool{}
+	for _, field := range fields {
+		seen[field.Name] = true
+	}
+
+	for _, pattern := range profile.Fields {
+		names := []string{pattern}
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			names = catalogFields(c, prefix)
+		}
+		for _, fieldName := range names {
+			if seen[fieldName] {
+				continue
+			}
+			seen[fieldName] = true
+
+			field, err := lookupField(c, fieldName)
+			if err != nil {
+				return nil, fmt.Errorf("profile %s: %v", profile.Name, err)
+			}
+			fields = append(fields, field)
+		}
+	}
+
+	if issues := Validate(fields, c); len(issues) > 0 {
+		return nil, fmt.Errorf("profile %s: %s", profile.Name, issues[0])
+	}
+	return fields, nil
+}
+
+// catalogFields returns the dcgm-exporter metrics in c starting with
+// prefix, in catalog order.
+func catalogFields(c *catalog.Catalog, prefix string) []string {
+	exporter, ok := c.Exporter(catalog.DCGMExporter)
+	if !ok {
+		return nil
+	}
+	var names []string
+	for _, metric := range exporter.Metrics {
+		if strings.HasPrefix(metric.Name, prefix) {
+			names = append(names, metric.Name)
+		}
+	}
+	return names
+}
+
+func lookupField(c *catalog.Catalog, name string) (Field, error) {
+	if help, ok := labelFields[name]; ok {
+		return Field{Name: name, Type: TypeLabel, Help: help}, nil
+	}
+	metric, exporter, ok := c.Lookup(name)
+	if !ok || exporter.Name != catalog.DCGMExporter {
+		return Field{}, fmt.Errorf("%s is not a DCGM field in metric catalog v%d", name, c.Version)
+	}
+	return Field{Name: name, Type: metric.Type, Help: metric.Help}, nil
+}
+
+// WriteProfile builds the profile called name from c and atomically writes
+// it to path. It returns the fields written.
+func WriteProfile(path, name string, c *catalog.Catalog) ([]Field, error) {
+	fields, err := Build(name, c)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := Format(&buf, filepath.Base(path), name, fields); err != nil {
+		return nil, err
+	}
+	if err := targets.WriteAtomic(path, buf.Bytes()); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
@@ -0,0 +1,140 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// GCE instance states. Preempted instances go STOPPING, then TERMINATED.
+const (
+	StatusProvisioning = "PROVISIONING"
+	StatusStaging      = "STAGING"
+	StatusRunning      = "RUNNING"
+	StatusStopping     = "STOPPING"
+	StatusSuspended    = "SUSPENDED"
+	StatusTerminated   = "TERMINATED"
+)
+
+// WorkerLabels are the labels the algalon-worker Terraform module puts on
+// every worker instance.
+var WorkerLabels = map[string]string{"component": "algalon-worker"}
+
+// Instance is the part of a compute instance that discovery needs.
+type Instance struct {
+	Name        string            `json:"name"`
+	Zone        string            `json:"zone"`
+	Status      string            `json:"status"`
+	InternalIP  string            `json:"internal_ip"`
+	Preemptible bool              `json:"preemptible"`
+	Labels      map[string]string `json:"labels"`
+
+	// GPUType and GPUsPerInstance describe the first attached accelerator, e.g.
+	// nvidia-tesla-t4 and 1; both are empty for CPU-only workers.
+	GPUType         string `json:"gpu_type"`
+	GPUsPerInstance int    `json:"gpus_per_instance"`
+}
+
+// Compute lists instances carrying every label in labels. GCEClient talks to
+// the Compute Engine API; FakeCompute stands in for it offline.
+type Compute interface {
+	ListInstances(ctx context.Context, labels map[string]string) ([]Instance, error)
+}
+
+// FakeCompute is an in-memory Compute. Tests and local runs add, preempt and
+// delete instances to simulate a fleet of workers.
+type FakeCompute struct {
+	mu        sync.Mutex
+	instances map[string]Instance
+	err       error
+}
+
+// NewFakeCompute returns a FakeCompute holding instances.
+func NewFakeCompute(instances ...Instance) *FakeCompute {
+	f := &FakeCompute{instances: map[string]Instance{}}
+	for _, instance := range instances {
+		f.Put(instance)
+	}
+	return f
+}
+
+// LoadFakeCompute reads a JSON array of instances, so discovery can run
+// against a hand-written fleet without cloud credentials.
+func LoadFakeCompute(path string) (*FakeCompute, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var instances []Instance
+	if err := json.Unmarshal(data, &instances); err != nil {
+		return nil, fmt.Errorf("%s: invalid instance list: %v", path, err)
+	}
+	return NewFakeCompute(instances...), nil
+}
+
+// Put adds instance or replaces the instance with the same name.
+func (f *FakeCompute) Put(instance Instance) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.instances[instance.Name] = instance
+}
+
+// SetStatus changes the status of the named instance, e.g. to
+// StatusTerminated to simulate a preemption.
+func (f *FakeCompute) SetStatus(name, status string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if instance, ok := f.instances[name]; ok {
+		instance.Status = status
+		f.instances[name] = instance
+	}
+}
+
+// Delete removes the named instance.
+func (f *FakeCompute) Delete(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.instances, name)
+}
+
+// FailWith makes ListInstances return err until it is called with nil.
+func (f *FakeCompute) FailWith(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+// ListInstances implements Compute.
+func (f *FakeCompute) ListInstances(ctx context.Context, labels map[string]string) ([]Instance, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	var matched []Instance
+	for _, instance := range f.instances {
+		if hasLabels(instance.Labels, labels) {
+			matched = append(matched, instance)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].Name < matched[j].Name })
+	return matched, nil
+}
+
+func hasLabels(have, want map[string]string) bool {
+	for name, value := range want {
+		if have[name] != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/appleparan/Algalon/pkg/registry"
+	"github.com/appleparan/Algalon/pkg/targets"
+)
+
+// Discoverer scans a network and registers newly found all-smi workers in a
+// registry store, mirroring a single run of worker-discovery.sh.
+type Discoverer struct {
+	Scanner *Scanner
+	Network string
+	Store   *registry.Store
+	DryRun  bool
+	Logger  *log.Logger
+}
+
+// RunOnce performs one discovery run and returns the workers that were not
+// yet registered. In dry-run mode they are only logged.
+func (d *Discoverer) RunOnce(ctx context.Context) ([]targets.Target, error) {
+	d.Logger.Printf("scanning network %s for workers on port %d", d.Network, d.Scanner.Port)
+
+	started := time.Now()
+	discovered, err := d.Scanner.Scan(ctx, d.Network)
+	if err != nil {
+		return nil, err
+	}
+	d.Logger.Printf("discovered %d workers in %s", len(discovered), time.Since(started).Round(time.Millisecond))
+
+	// The registry daemon, the agent or algalonctl may have registered
+	// workers since the store was loaded.
+	if err := d.Store.Reload(); err != nil {
+		return nil, err
+	}
+	registered := map[string]bool{}
+	for _, worker := range d.Store.List() {
+		registered[worker.Target] = true
+	}
+
+	var fresh []targets.Target
+	for _, target := range discovered {
+		if !registered[target.String()] {
+			fresh = append(fresh, target)
+		}
+	}
+
+	if len(fresh) == 0 {
+		d.Logger.Print("all discovered workers are already registered")
+		return nil, nil
+	}
+
+	for _, target := range fresh {
+		if d.DryRun {
+			d.Logger.Printf("would register new worker: %s", target)
+			continue
+		}
+		if _, _, err := d.Store.Add(registry.Worker{Target: target.String()}); err != nil {
+			return fresh, err
+		}
+		d.Logger.Printf("registered new worker: %s", target)
+	}
+
+	return fresh, nil
+}
+
+// RunDaemon repeats RunOnce every interval until ctx is cancelled. A failed
+// run is logged and retried on the next tick.
+func (d *Discoverer) RunDaemon(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("discovery interval must be positive, got %s", interval)
+	}
+
+	d.Logger.Printf("starting worker discovery daemon (interval %s)", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := d.RunOnce(ctx); err != nil && ctx.Err() == nil {
+			d.Logger.Printf("discovery run failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			d.Logger.Print("shutting down")
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
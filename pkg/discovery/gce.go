@@ -0,0 +1,255 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Endpoints used by GCEClient. Tests point them at an httptest server.
+const (
+	DefaultComputeURL  = "https://compute.googleapis.com/compute/v1"
+	DefaultMetadataURL = "http://metadata.google.internal/computeMetadata/v1"
+)
+
+// GCEClient lists instances through the Compute Engine REST API, using the
+// access token of the VM's service account from the metadata server. The
+// host needs the compute.instances.list permission, which the
+// cloud-platform scope of the algalon-host module already grants.
+type GCEClient struct {
+	Project     string // defaults to the project of the VM
+	Zone        string // empty lists every zone
+	ComputeURL  string
+	MetadataURL string
+	HTTPClient  *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// NewGCEClient returns a client for project, or for the VM's own project if
+// project is empty.
+func NewGCEClient(project, zone string) *GCEClient {
+	return &GCEClient{
+		Project:     project,
+		Zone:        zone,
+		ComputeURL:  DefaultComputeURL,
+		MetadataURL: DefaultMetadataURL,
+		HTTPClient:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+type gceInstance struct {
+	Name              string            `json:"name"`
+	Zone              string            `json:"zone"`
+	Status            string            `json:"status"`
+	Labels            map[string]string `json:"labels"`
+	NetworkInterfaces []struct {
+		NetworkIP string `json:"networkIP"`
+	} `json:"networkInterfaces"`
+	Scheduling struct {
+		Preemptible       bool   `json:"preemptible"`
+		ProvisioningModel string `json:"provisioningModel"`
+	} `json:"scheduling"`
+	GuestAccelerators []struct {
+		AcceleratorType  string `json:"acceleratorType"`
+		AcceleratorCount int    `json:"acceleratorCount"`
+	} `json:"guestAccelerators"`
+}
+
+type gceInstanceList struct {
+	Items         []gceInstance `json:"items"`
+	NextPageToken string        `json:"nextPageToken"`
+}
+
+type gceAggregatedList struct {
+	Items map[string]struct {
+		Instances []gceInstance `json:"instances"`
+	} `json:"items"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+// ListInstances implements Compute.
+func (c *GCEClient) ListInstances(ctx context.Context, labels map[string]string) ([]Instance, error) {
+	project := c.Project
+	if project == "" {
+		var err error
+		if project, err = c.metadata(ctx, "project/project-id"); err != nil {
+			return nil, fmt.Errorf("cannot determine project: %v", err)
+		}
+	}
+
+	endpoint := fmt.Sprintf("%s/projects/%s/aggregated/instances", c.ComputeURL, url.PathEscape(project))
+	if c.Zone != "" {
+		endpoint = fmt.Sprintf("%s/projects/%s/zones/%s/instances", c.ComputeURL, url.PathEscape(project), url.PathEscape(c.Zone))
+	}
+
+	var instances []Instance
+	pageToken := ""
+	for {
+		query := url.Values{}
+		if filter := labelFilter(labels); filter != "" {
+			query.Set("filter", filter)
+		}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+
+		var page []gceInstance
+		var err error
+		if c.Zone != "" {
+			var list gceInstanceList
+			err = c.get(ctx, endpoint+"?"+query.Encode(), &list)
+			page, pageToken = list.Items, list.NextPageToken
+		} else {
+			var list gceAggregatedList
+			err = c.get(ctx, endpoint+"?"+query.Encode(), &list)
+			for _, scope := range list.Items {
+				page = append(page, scope.Instances...)
+			}
+			pageToken = list.NextPageToken
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, raw := range page {
+			instance := raw.instance()
+			// The API filter is authoritative, but re-check so a
+			// mistyped filter can never widen the target set.
+			if hasLabels(instance.Labels, labels) {
+				instances = append(instances, instance)
+			}
+		}
+		if pageToken == "" {
+			break
+		}
+	}
+
+	sort.Slice(instances, func(i, j int) bool { return instances[i].Name < instances[j].Name })
+	return instances, nil
+}
+
+func (g gceInstance) instance() Instance {
+	instance := Instance{
+		Name:        g.Name,
+		Zone:        path.Base(g.Zone),
+		Status:      g.Status,
+		Labels:      g.Labels,
+		Preemptible: g.Scheduling.Preemptible || g.Scheduling.ProvisioningModel == "SPOT",
+	}
+	if len(g.NetworkInterfaces) > 0 {
+		instance.InternalIP = g.NetworkInterfaces[0].NetworkIP
+	}
+	// acceleratorType is a URL ending in .../acceleratorTypes/nvidia-tesla-t4.
+	if len(g.GuestAccelerators) > 0 {
+		instance.GPUType = path.Base(g.GuestAccelerators[0].AcceleratorType)
+		instance.GPUsPerInstance = g.GuestAccelerators[0].AcceleratorCount
+	}
+	return instance
+}
+
+// labelFilter builds a Compute API filter matching every label.
+func labelFilter(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	clauses := make([]string, 0, len(names))
+	for _, name := range names {
+		clauses = append(clauses, fmt.Sprintf("labels.%s = %q", name, labels[name]))
+	}
+	return strings.Join(clauses, " AND ")
+}
+
+func (c *GCEClient) get(ctx context.Context, endpoint string, v interface{}) error {
+	token, err := c.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("compute API returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("invalid compute API response: %v", err)
+	}
+	return nil
+}
+
+// accessToken returns the cached service account token, refreshing it a
+// minute before it expires.
+func (c *GCEClient) accessToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && time.Now().Before(c.expires) {
+		return c.token, nil
+	}
+
+	raw, err := c.metadata(ctx, "instance/service-accounts/default/token")
+	if err != nil {
+		return "", fmt.Errorf("cannot fetch access token: %v", err)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal([]byte(raw), &token); err != nil || token.AccessToken == "" {
+		return "", fmt.Errorf("invalid access token response from metadata server")
+	}
+
+	c.token = token.AccessToken
+	c.expires = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return c.token, nil
+}
+
+func (c *GCEClient) metadata(ctx context.Context, key string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.MetadataURL+"/"+key, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata server returned %s for %s", resp.Status, key)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
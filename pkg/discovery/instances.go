@@ -0,0 +1,349 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/appleparan/Algalon/pkg/targets"
+)
+
+// Defaults for InstanceDiscoverer.
+const (
+	// DefaultInstanceFile sits next to all-smi-targets.yml and matches the
+	// all-smi-*.yml glob in prometheus.yml, so a target listed in both files
+	// would be scraped twice; see InstanceDiscoverer.RegistryPath.
+	DefaultInstanceFile = "all-smi-gce.yml"
+
+	// DefaultGracePeriod keeps a worker that drops out of the instance list
+	// for this long, so a flaky list call or a preempted instance that is
+	// immediately recreated does not make the target flap.
+	DefaultGracePeriod = 5 * time.Minute
+)
+
+// InstanceDiscoverer keeps a file_sd target file in sync with the worker
+// instances reported by a Compute API. Running instances are added as soon
+// as they have an internal IP. Instances reported as stopping or terminated,
+// which is how preemption shows up, are removed at once; instances that
+// merely disappear from the list are kept for GracePeriod first.
+type InstanceDiscoverer struct {
+	Compute     Compute
+	Labels      map[string]string // instance label filter; defaults to WorkerLabels
+	Port        int
+	Path        string
+	GracePeriod time.Duration
+	DryRun      bool
+	Logger      *log.Logger
+
+	// RegistryPath, if set, is the target file algalon-registry maintains.
+	// Workers registered there, e.g. by algalon-agent, are left out of Path
+	// so VMAgent does not scrape them twice.
+	RegistryPath string
+
+	// Now returns the current time; tests replace it to step through the
+	// grace period.
+	Now func() time.Time
+
+	// OnChange, if set, is called with a summary after a run that rewrote
+	// the target file, e.g. to record it in the target history.
+	OnChange func(message string)
+
+	known  map[string]knownTarget
+	loaded bool
+}
+
+type knownTarget struct {
+	instance string
+	labels   map[string]string
+	lastSeen time.Time
+}
+
+// Changes summarises one InstanceDiscoverer run.
+type Changes struct {
+	Added   []string // targets that appeared
+	Removed []string // targets that were dropped
+	Missing []string // targets absent from the list but still within the grace period
+	Targets int      // targets in the file after the run
+}
+
+func (c Changes) summary() string {
+	var parts []string
+	if len(c.Added) > 0 {
+		parts = append(parts, "add "+strings.Join(c.Added, ", "))
+	}
+	if len(c.Removed) > 0 {
+		parts = append(parts, "remove "+strings.Join(c.Removed, ", "))
+	}
+	if len(parts) == 0 {
+		return "update instance labels"
+	}
+	return strings.Join(parts, "; ")
+}
+
+// RunOnce lists the worker instances and rewrites the target file if the
+// target set changed. If the list call fails, the file is left untouched so
+// an API outage does not drop every worker.
+func (d *InstanceDiscoverer) RunOnce(ctx context.Context) (Changes, error) {
+	if err := d.load(); err != nil {
+		return Changes{}, err
+	}
+
+	filter := d.Labels
+	if filter == nil {
+		filter = WorkerLabels
+	}
+	instances, err := d.Compute.ListInstances(ctx, filter)
+	if err != nil {
+		return Changes{}, fmt.Errorf("failed to list instances: %v", err)
+	}
+
+	now := d.now()
+	var changes Changes
+	next := map[string]knownTarget{}
+	gone := map[string]bool{}
+
+	for _, instance := range instances {
+		target := d.target(instance)
+		switch {
+		case instance.Status == StatusRunning && target != "":
+			if _, ok := d.known[target]; !ok {
+				changes.Added = append(changes.Added, target)
+				d.Logger.Printf("adding %s (%s in %s, preemptible=%t)", target, instance.Name, instance.Zone, instance.Preemptible)
+			}
+			next[target] = knownTarget{instance: instance.Name, labels: instanceLabels(instance), lastSeen: now}
+		case instance.Status == StatusStopping || instance.Status == StatusTerminated || instance.Status == StatusSuspended:
+			if target != "" {
+				gone[target] = true
+			}
+			for known, state := range d.known {
+				if state.instance == instance.Name {
+					gone[known] = true
+				}
+			}
+		}
+	}
+
+	for target, state := range d.known {
+		if _, ok := next[target]; ok {
+			continue
+		}
+		switch {
+		case gone[target]:
+			d.Logger.Printf("removing %s (%s stopped or was preempted)", target, instanceName(state))
+		case now.Sub(state.lastSeen) >= d.gracePeriod():
+			d.Logger.Printf("removing %s (%s missing for %s)", target, instanceName(state), now.Sub(state.lastSeen).Round(time.Second))
+		default:
+			changes.Missing = append(changes.Missing, target)
+			next[target] = state
+			continue
+		}
+		changes.Removed = append(changes.Removed, target)
+	}
+
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Removed)
+	sort.Strings(changes.Missing)
+	// The registry file is read and the discovery file written under the
+	// lock the other target writers hold.
+	unlock, err := targets.LockDir(filepath.Dir(d.Path))
+	if err != nil {
+		return changes, err
+	}
+	defer unlock()
+
+	file, err := d.unregistered(next)
+	if err != nil {
+		return changes, err
+	}
+	changes.Targets = len(file)
+
+	if d.DryRun {
+		d.Logger.Printf("dry run: would write %d targets to %s", len(file), d.Path)
+		d.known = next
+		return changes, nil
+	}
+
+	written, err := d.write(file)
+	if err != nil {
+		return changes, err
+	}
+	if written {
+		d.Logger.Printf("wrote %d targets to %s", len(file), d.Path)
+		if d.OnChange != nil {
+			d.OnChange(changes.summary())
+		}
+	}
+	d.known = next
+	return changes, nil
+}
+
+// RunDaemon repeats RunOnce every interval until ctx is cancelled. A failed
+// run is logged and retried on the next tick.
+func (d *InstanceDiscoverer) RunDaemon(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("discovery interval must be positive, got %s", interval)
+	}
+
+	d.Logger.Printf("starting instance discovery daemon (interval %s, grace period %s)", interval, d.gracePeriod())
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := d.RunOnce(ctx); err != nil && ctx.Err() == nil {
+			d.Logger.Printf("discovery run failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			d.Logger.Print("shutting down")
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// load seeds the known targets from an existing file, so a restarted
+// discoverer applies the grace period instead of starting from scratch.
+func (d *InstanceDiscoverer) load() error {
+	if d.loaded {
+		return nil
+	}
+	d.known = map[string]knownTarget{}
+	d.loaded = true
+
+	groups, err := targets.ReadFile(d.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	now := d.now()
+	for _, group := range groups {
+		for _, target := range group.Targets {
+			d.known[target] = knownTarget{labels: group.Labels, lastSeen: now}
+		}
+	}
+	return nil
+}
+
+// unregistered returns the known targets that are not in RegistryPath. They
+// stay known, so a worker that deregisters is picked up again at once.
+func (d *InstanceDiscoverer) unregistered(known map[string]knownTarget) (map[string]knownTarget, error) {
+	if d.RegistryPath == "" {
+		return known, nil
+	}
+	groups, err := targets.ReadFile(d.RegistryPath)
+	if os.IsNotExist(err) {
+		return known, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read registered targets: %v", err)
+	}
+
+	registered := map[string]bool{}
+	for _, group := range groups {
+		for _, target := range group.Targets {
+			registered[target] = true
+		}
+	}
+	file := make(map[string]knownTarget, len(known))
+	for target, state := range known {
+		if !registered[target] {
+			file[target] = state
+		}
+	}
+	return file, nil
+}
+
+// write renders the targets grouped by label set and replaces the file only
+// if its content changed.
+func (d *InstanceDiscoverer) write(known map[string]knownTarget) (bool, error) {
+	labels := make(map[string]map[string]string, len(known))
+	for target, state := range known {
+		labels[target] = state.labels
+	}
+	groups := targets.GroupByLabels(labels)
+
+	data, err := targets.Render(filepath.Base(d.Path), groups)
+	if err != nil {
+		return false, err
+	}
+	if current, err := os.ReadFile(d.Path); err == nil && bytes.Equal(current, data) {
+		return false, nil
+	}
+	if err := targets.WriteFile(d.Path, groups); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (d *InstanceDiscoverer) target(instance Instance) string {
+	if instance.InternalIP == "" {
+		return ""
+	}
+	port := d.Port
+	if port == 0 {
+		port = DefaultPort
+	}
+	return net.JoinHostPort(instance.InternalIP, strconv.Itoa(port))
+}
+
+func (d *InstanceDiscoverer) gracePeriod() time.Duration {
+	if d.GracePeriod > 0 {
+		return d.GracePeriod
+	}
+	return DefaultGracePeriod
+}
+
+func (d *InstanceDiscoverer) now() time.Time {
+	if d.Now != nil {
+		return d.Now()
+	}
+	return time.Now()
+}
+
+// instanceLabels maps the cluster and environment instance labels set by
+// the algalon-worker module onto the standard all-smi target labels, and
+// adds the GPU, zone, name and preemptibility of the instance as metadata
+// labels. Each instance therefore gets a target group of its own.
+func instanceLabels(instance Instance) map[string]string {
+	cluster := instance.Labels["cluster"]
+	if cluster == "" {
+		cluster = targets.DefaultCluster
+	}
+	environment := instance.Labels["environment"]
+	if environment == "" {
+		environment = targets.DefaultEnvironment
+	}
+	labels := targets.DefaultLabels(cluster, environment)
+
+	metadata := targets.Metadata{
+		GPUType:         instance.GPUType,
+		GPUsPerInstance: instance.GPUsPerInstance,
+		Zone:            instance.Zone,
+		InstanceName:    instance.Name,
+		Preemptible:     strconv.FormatBool(instance.Preemptible),
+	}
+	for name, value := range metadata.Labels() {
+		labels[name] = value
+	}
+	return labels
+}
+
+func instanceName(state knownTarget) string {
+	if state.instance == "" {
+		return "instance"
+	}
+	return state.instance
+}
@@ -0,0 +1,76 @@
+package discovery
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// MaxHosts bounds the size of a single scan. A /12 is already far larger than
+// any Algalon worker subnet.
+const MaxHosts = 1 << 20
+
+// Hosts returns every usable IPv4 host address in cidr. The network and
+// broadcast addresses are skipped for prefixes shorter than /31.
+func Hosts(cidr string) ([]net.IP, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid network range %q: %v", cidr, err)
+	}
+
+	base := network.IP.To4()
+	if base == nil {
+		return nil, fmt.Errorf("invalid network range %q: only IPv4 ranges can be scanned", cidr)
+	}
+
+	ones, bits := network.Mask.Size()
+	size := uint64(1) << uint(bits-ones)
+	if size > MaxHosts {
+		return nil, fmt.Errorf("network range %q has %d addresses, more than the %d allowed per scan", cidr, size, MaxHosts)
+	}
+
+	first, last := uint64(0), size-1
+	if bits-ones >= 2 {
+		first, last = 1, size-2
+	}
+
+	start := uint64(binary.BigEndian.Uint32(base))
+	hosts := make([]net.IP, 0, last-first+1)
+	for offset := first; offset <= last; offset++ {
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, uint32(start+offset))
+		hosts = append(hosts, ip)
+	}
+
+	return hosts, nil
+}
+
+// DetectNetwork returns the subnet of the first non-loopback IPv4 interface
+// that is up, standing in for the `ip route` lookup in worker-discovery.sh.
+func DetectNetwork() (string, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.To4() == nil {
+				continue
+			}
+			network := &net.IPNet{IP: ipNet.IP.Mask(ipNet.Mask), Mask: ipNet.Mask}
+			return network.String(), nil
+		}
+	}
+
+	return "", fmt.Errorf("network range not specified and could not be auto-detected")
+}
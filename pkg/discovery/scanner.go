@@ -0,0 +1,177 @@
+// Package discovery finds all-smi workers on a network and registers them in
+// the file_sd target file. It replaces the sequential nmap/curl loop in
+// algalon_host/scripts/worker-discovery.sh with a bounded pool of concurrent
+// probes.
+package discovery
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appleparan/Algalon/pkg/targets"
+)
+
+// Defaults matching worker-discovery.sh.
+const (
+	DefaultPort        = 9090
+	DefaultInterval    = 300 * time.Second
+	DefaultConcurrency = 256
+	DefaultTimeout     = 2 * time.Second
+)
+
+// infoMetric is exported by every all-smi instance. Its presence is what
+// distinguishes all-smi from any other service listening on the port.
+const infoMetric = "all_smi_info"
+
+// maxMetricsSize caps how much of a /metrics page is read while probing.
+const maxMetricsSize = 16 << 20
+
+// Scanner probes hosts for an all-smi /metrics endpoint.
+type Scanner struct {
+	Port        int
+	Concurrency int
+	Timeout     time.Duration
+}
+
+// NewScanner returns a scanner for port using the default pool size and
+// per-probe timeout.
+func NewScanner(port int) *Scanner {
+	return &Scanner{
+		Port:        port,
+		Concurrency: DefaultConcurrency,
+		Timeout:     DefaultTimeout,
+	}
+}
+
+// Scan probes every host in cidr and returns the all-smi targets found,
+// sorted by address. It stops early if ctx is cancelled.
+func (s *Scanner) Scan(ctx context.Context, cidr string) ([]targets.Target, error) {
+	if err := targets.ValidatePort(s.Port); err != nil {
+		return nil, err
+	}
+
+	hosts, err := Hosts(cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	concurrency := s.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	client := s.newClient()
+
+	candidates := make(chan net.IP)
+	var (
+		mu    sync.Mutex
+		found []net.IP
+		wg    sync.WaitGroup
+	)
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ip := range candidates {
+				ok, _ := s.probe(ctx, client, net.JoinHostPort(ip.String(), strconv.Itoa(s.Port)))
+				if ok {
+					mu.Lock()
+					found = append(found, ip)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, ip := range hosts {
+		select {
+		case <-ctx.Done():
+			break feed
+		case candidates <- ip:
+		}
+	}
+	close(candidates)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		return string(found[i].To4()) < string(found[j].To4())
+	})
+
+	discovered := make([]targets.Target, 0, len(found))
+	for _, ip := range found {
+		discovered = append(discovered, targets.Target{Host: ip.String(), Port: s.Port})
+	}
+
+	return discovered, nil
+}
+
+// Probe fetches http://address/metrics and reports whether it is served by
+// all-smi. An error is returned when the endpoint cannot be reached.
+func (s *Scanner) Probe(ctx context.Context, address string) (bool, error) {
+	return s.probe(ctx, s.newClient(), address)
+}
+
+// newClient returns a client that gives up on unreachable hosts after
+// s.Timeout and does not keep idle connections to the hosts it probes.
+func (s *Scanner) newClient() *http.Client {
+	return &http.Client{
+		Timeout: s.Timeout,
+		Transport: &http.Transport{
+			DialContext:       (&net.Dialer{Timeout: s.Timeout}).DialContext,
+			DisableKeepAlives: true,
+		},
+	}
+}
+
+func (s *Scanner) probe(ctx context.Context, client *http.Client, address string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://%s/metrics", address), nil)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, nil
+	}
+
+	return HasAllSmiInfo(io.LimitReader(resp.Body, maxMetricsSize))
+}
+
+// HasAllSmiInfo reports whether a Prometheus text exposition contains an
+// all_smi_info sample.
+func HasAllSmiInfo(r io.Reader) (bool, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, infoMetric) {
+			continue
+		}
+		rest := line[len(infoMetric):]
+		if strings.HasPrefix(rest, "{") || strings.HasPrefix(rest, " ") {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
@@ -0,0 +1,161 @@
+// Package envfile reads the KEY=VALUE files under examples/host-configs and
+// examples/worker-configs, which are copied to algalon_host/.env and
+// algalon_worker/.env. It follows the docker compose .env rules the files
+// are written for: comments and blank lines are ignored, values may be
+// quoted, and ${VAR} references expand to earlier keys or the environment.
+package envfile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Env is the set of variables defined by an env file.
+type Env map[string]string
+
+var (
+	keyPattern       = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	referencePattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}|\$([A-Za-z_][A-Za-z0-9_]*)`)
+)
+
+// Parse reads an env file from r. References to variables the file has not
+// defined yet are resolved through lookup, which may be nil; unresolved
+// references expand to the empty string, as in docker compose. Command
+// substitutions such as $(hostname) are kept verbatim.
+func Parse(r io.Reader, lookup func(string) (string, bool)) (Env, error) {
+	env := Env{}
+	scanner := bufio.NewScanner(r)
+
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE, got %q", n, line)
+		}
+		key = strings.TrimSpace(key)
+		if !keyPattern.MatchString(key) {
+			return nil, fmt.Errorf("line %d: invalid variable name %q", n, key)
+		}
+
+		raw := strings.TrimSpace(value)
+		value, err := parseValue(raw)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %v", n, key, err)
+		}
+		// Single-quoted values are literal
+		if !strings.HasPrefix(raw, "'") {
+			value = env.expand(value, lookup)
+		}
+		env[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return env, nil
+}
+
+// parseValue strips matching quotes. Unquoted values end at an inline
+// comment, which must be preceded by whitespace.
+func parseValue(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	quote := value[0]
+	if quote == '"' || quote == '\'' {
+		end := strings.IndexByte(value[1:], quote)
+		if end < 0 {
+			return "", fmt.Errorf("unterminated %c quote", quote)
+		}
+		rest := strings.TrimSpace(value[end+2:])
+		if rest != "" && !strings.HasPrefix(rest, "#") {
+			return "", fmt.Errorf("unexpected text after closing quote: %q", rest)
+		}
+		return value[1 : end+1], nil
+	}
+
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(value), nil
+}
+
+func (e Env) expand(value string, lookup func(string) (string, bool)) string {
+	return referencePattern.ReplaceAllStringFunc(value, func(ref string) string {
+		match := referencePattern.FindStringSubmatch(ref)
+		name := match[1]
+		if name == "" {
+			name = match[2]
+		}
+		if v, ok := e[name]; ok {
+			return v
+		}
+		if lookup != nil {
+			if v, ok := lookup(name); ok {
+				return v
+			}
+		}
+		return ""
+	})
+}
+
+// ReadFile reads and parses the env file at path, resolving references
+// through the process environment.
+func ReadFile(path string) (Env, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	env, err := Parse(f, os.LookupEnv)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return env, nil
+}
+
+// Lookup has the signature of os.LookupEnv, so an Env can stand in for the
+// process environment.
+func (e Env) Lookup(key string) (string, bool) {
+	value, ok := e[key]
+	return value, ok
+}
+
+// Environ returns the variables as sorted KEY=VALUE pairs for exec.Cmd.Env.
+func (e Env) Environ() []string {
+	pairs := make([]string, 0, len(e))
+	for key, value := range e {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+// Chain returns a lookup that tries each of lookups in order, e.g. the
+// process environment before an env file so exported variables win, as they
+// do for docker compose.
+func Chain(lookups ...func(string) (string, bool)) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		for _, lookup := range lookups {
+			if lookup == nil {
+				continue
+			}
+			if value, ok := lookup(key); ok {
+				return value, true
+			}
+		}
+		return "", false
+	}
+}
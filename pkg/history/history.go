@@ -0,0 +1,434 @@
+// Package history versions the file_sd target files on the monitoring host.
+// Every recorded change is a numbered snapshot of the target directory with
+// a timestamp, an author and a message, so a bad registration or a generator
+// run can be inspected with Diff and undone with Rollback. It replaces the
+// timestamped copies register-worker.sh --backup leaves in
+// /opt/Algalon/algalon_host/backups, which could not be listed or restored.
+package history
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/appleparan/Algalon/pkg/targets"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// DefaultDir is the history directory next to register-worker.sh's
+// BACKUP_DIR.
+const DefaultDir = "/opt/Algalon/algalon_host/backups/history"
+
+// Current refers to the live target directory in Diff.
+const Current = 0
+
+// DefaultPatterns select the target files VMAgent reads. The reaper's
+// quarantine file and the *.template files are not file_sd and are left out.
+var DefaultPatterns = []string{"all-smi-*.yml", "dcgm-*.yml"}
+
+// ErrNoChange is returned by Record, along with the latest version, when the
+// target files match it.
+var ErrNoChange = errors.New("target files unchanged since the latest version")
+
+const metaFile = "version.json"
+
+// Version describes one snapshot.
+type Version struct {
+	Number  int       `json:"version"`
+	Time    time.Time `json:"time"`
+	Author  string    `json:"author"`
+	Message string    `json:"message"`
+	Files   []string  `json:"files"`
+}
+
+// History stores versions of the files in TargetsDir under Dir, one
+// subdirectory per version.
+type History struct {
+	Dir        string
+	TargetsDir string
+	Patterns   []string // file name globs to track; defaults to DefaultPatterns
+
+	// Now returns the current time; tests replace it for stable timestamps.
+	Now func() time.Time
+}
+
+// New returns a History keeping versions of targetsDir in dir.
+func New(dir, targetsDir string) *History {
+	return &History{Dir: dir, TargetsDir: targetsDir}
+}
+
+// DefaultAuthor names the user running the process, preferring the user
+// behind sudo.
+func DefaultAuthor() string {
+	if name := os.Getenv("SUDO_USER"); name != "" {
+		return name
+	}
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return "unknown"
+}
+
+// Recorder returns an OnChange hook for the target writers that records
+// every change of targetsDir in dir as author. A failure is logged and does
+// not fail the change, which is already written. It returns nil, i.e. no
+// hook, if dir is empty.
+func Recorder(dir, targetsDir, author string, logger *log.Logger) func(message string) {
+	if dir == "" {
+		return nil
+	}
+	h := New(dir, targetsDir)
+	return func(message string) {
+		if _, err := h.Record(author, message); err != nil && !errors.Is(err, ErrNoChange) {
+			logger.Printf("failed to record target history: %v", err)
+		}
+	}
+}
+
+// Versions returns every version, oldest first.
+func (h *History) Versions() ([]Version, error) {
+	entries, err := os.ReadDir(h.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []Version
+	for _, entry := range entries {
+		number, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		version, err := h.Version(number)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Number < versions[j].Number })
+	return versions, nil
+}
+
+// Version returns the metadata of one version.
+func (h *History) Version(number int) (Version, error) {
+	data, err := os.ReadFile(filepath.Join(h.versionDir(number), metaFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return Version{}, fmt.Errorf("version %d does not exist in %s", number, h.Dir)
+	}
+	if err != nil {
+		return Version{}, err
+	}
+
+	var version Version
+	if err := json.Unmarshal(data, &version); err != nil {
+		return Version{}, fmt.Errorf("version %d: malformed %s: %v", number, metaFile, err)
+	}
+	return version, nil
+}
+
+// Record snapshots the tracked files in TargetsDir as a new version. It
+// returns ErrNoChange, and records nothing, if they match the latest
+// version.
+func (h *History) Record(author, message string) (Version, error) {
+	current, err := h.Files(Current)
+	if err != nil {
+		return Version{}, err
+	}
+
+	versions, err := h.Versions()
+	if err != nil {
+		return Version{}, err
+	}
+	next := 1
+	if len(versions) > 0 {
+		latest := versions[len(versions)-1]
+		previous, err := h.Files(latest.Number)
+		if err != nil {
+			return Version{}, err
+		}
+		if sameFiles(current, previous) {
+			return latest, ErrNoChange
+		}
+		next = latest.Number + 1
+	}
+
+	version := Version{
+		Time:    h.now().UTC(),
+		Author:  author,
+		Message: message,
+		Files:   sortedNames(current),
+	}
+	return h.write(next, version, current)
+}
+
+// write stores a version in a temporary directory and renames it into place.
+// rename(2) fails with EEXIST or ENOTEMPTY if another process claimed the
+// number first, in which case the next free one is used.
+func (h *History) write(number int, version Version, files map[string][]byte) (Version, error) {
+	if err := os.MkdirAll(h.Dir, 0o755); err != nil {
+		return Version{}, err
+	}
+	tmp, err := os.MkdirTemp(h.Dir, ".tmp-")
+	if err != nil {
+		return Version{}, err
+	}
+	defer os.RemoveAll(tmp)
+
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(tmp, name), data, 0o644); err != nil {
+			return Version{}, err
+		}
+	}
+
+	for attempt := 0; attempt < 100; attempt++ {
+		version.Number = number + attempt
+		meta, err := json.MarshalIndent(version, "", "  ")
+		if err != nil {
+			return Version{}, err
+		}
+		if err := os.WriteFile(filepath.Join(tmp, metaFile), append(meta, '\n'), 0o644); err != nil {
+			return Version{}, err
+		}
+		if err := os.Chmod(tmp, 0o755); err != nil {
+			return Version{}, err
+		}
+		if err := os.Rename(tmp, h.versionDir(version.Number)); err == nil {
+			return version, nil
+		} else if !errors.Is(err, os.ErrExist) && !errors.Is(err, syscall.ENOTEMPTY) {
+			return Version{}, err
+		}
+	}
+	return Version{}, fmt.Errorf("no free version number after %d in %s", number, h.Dir)
+}
+
+// Files returns the tracked files of a version by name, or those in
+// TargetsDir for Current.
+func (h *History) Files(number int) (map[string][]byte, error) {
+	dir := h.TargetsDir
+	if number != Current {
+		version, err := h.Version(number)
+		if err != nil {
+			return nil, err
+		}
+		files := map[string][]byte{}
+		for _, name := range version.Files {
+			if !h.tracked(name) {
+				return nil, fmt.Errorf("version %d: invalid file name %q in %s", number, name, metaFile)
+			}
+			data, err := os.ReadFile(filepath.Join(h.versionDir(number), name))
+			if err != nil {
+				return nil, fmt.Errorf("version %d: %v", number, err)
+			}
+			files[name] = data
+		}
+		return files, nil
+	}
+
+	files := map[string][]byte{}
+	for _, pattern := range h.patterns() {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range matches {
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+			if !info.Mode().IsRegular() {
+				continue
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			files[filepath.Base(path)] = data
+		}
+	}
+	return files, nil
+}
+
+// Diff returns a unified diff from version a to version b. Either may be
+// Current. An empty string means they are identical.
+func (h *History) Diff(a, b int) (string, error) {
+	from, err := h.Files(a)
+	if err != nil {
+		return "", err
+	}
+	to, err := h.Files(b)
+	if err != nil {
+		return "", err
+	}
+
+	names := map[string]bool{}
+	for name := range from {
+		names[name] = true
+	}
+	for name := range to {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	var out strings.Builder
+	for _, name := range sorted {
+		if bytes.Equal(from[name], to[name]) {
+			continue
+		}
+		fromFile, toFile := label(a, name), label(b, name)
+		if _, ok := from[name]; !ok {
+			fromFile = "/dev/null"
+		}
+		if _, ok := to[name]; !ok {
+			toFile = "/dev/null"
+		}
+		diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(string(from[name])),
+			B:        difflib.SplitLines(string(to[name])),
+			FromFile: fromFile,
+			ToFile:   toFile,
+			Context:  3,
+		})
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(diff)
+	}
+	return out.String(), nil
+}
+
+// Rollback restores the target files of a version into TargetsDir. Every
+// file must parse as file_sd YAML before anything is written, and is read
+// back afterwards to verify it. Tracked files the version does not contain
+// are removed. The state before and after the rollback are both recorded,
+// so a rollback can itself be undone.
+func (h *History) Rollback(number int, author string) (Version, error) {
+	files, err := h.Files(number)
+	if err != nil {
+		return Version{}, err
+	}
+	for _, name := range sortedNames(files) {
+		if _, err := targets.Parse(files[name]); err != nil {
+			return Version{}, fmt.Errorf("version %d: %s is not valid file_sd: %v", number, name, err)
+		}
+	}
+
+	// Hold the lock the target writers share until the rollback is recorded,
+	// so a registration cannot overwrite it or be lost by it.
+	unlock, err := targets.LockDir(h.TargetsDir)
+	if err != nil {
+		return Version{}, err
+	}
+	defer unlock()
+
+	if _, err := h.Record(author, fmt.Sprintf("before rollback to version %d", number)); err != nil && !errors.Is(err, ErrNoChange) {
+		return Version{}, err
+	}
+
+	current, err := h.Files(Current)
+	if err != nil {
+		return Version{}, err
+	}
+	for _, name := range sortedNames(files) {
+		path := filepath.Join(h.TargetsDir, name)
+		if err := targets.WriteAtomic(path, files[name]); err != nil {
+			return Version{}, err
+		}
+		if _, err := targets.ReadFile(path); err != nil {
+			return Version{}, fmt.Errorf("restored file failed verification: %v", err)
+		}
+	}
+	for name := range current {
+		if _, ok := files[name]; !ok {
+			if err := os.Remove(filepath.Join(h.TargetsDir, name)); err != nil {
+				return Version{}, err
+			}
+		}
+	}
+
+	version, err := h.Record(author, fmt.Sprintf("rollback to version %d", number))
+	if errors.Is(err, ErrNoChange) {
+		return version, nil
+	}
+	return version, err
+}
+
+func (h *History) versionDir(number int) string {
+	return filepath.Join(h.Dir, fmt.Sprintf("%06d", number))
+}
+
+func (h *History) patterns() []string {
+	if len(h.Patterns) > 0 {
+		return h.Patterns
+	}
+	return DefaultPatterns
+}
+
+// tracked reports whether name is a plain file name matching one of the
+// patterns. Names read back from version.json are checked with it, so an
+// edited or crafted version cannot make Rollback write outside TargetsDir.
+func (h *History) tracked(name string) bool {
+	if name != filepath.Base(name) || name == "." || name == ".." || strings.ContainsRune(name, os.PathSeparator) {
+		return false
+	}
+	for _, pattern := range h.patterns() {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *History) now() time.Time {
+	if h.Now != nil {
+		return h.Now()
+	}
+	return time.Now()
+}
+
+func label(number int, name string) string {
+	if number == Current {
+		return "current/" + name
+	}
+	return fmt.Sprintf("v%d/%s", number, name)
+}
+
+func sameFiles(a, b map[string][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, data := range a {
+		other, ok := b[name]
+		if !ok || !bytes.Equal(data, other) {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedNames(files map[string][]byte) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
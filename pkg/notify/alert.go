@@ -0,0 +1,212 @@
+// Package notify is the alert notification gateway of the monitoring host.
+// It accepts alerts in the Alertmanager webhook format or straight from
+// vmalert, groups them by cluster and instance, drops repeats and fans each
+// group out to webhook, email and chat sinks.
+package notify
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// Alert statuses.
+const (
+	StatusFiring   = "firing"
+	StatusResolved = "resolved"
+)
+
+// Alert is one alert as Alertmanager sends it to webhook receivers.
+type Alert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+	Fingerprint  string            `json:"fingerprint,omitempty"`
+}
+
+// Name returns the alertname label.
+func (a Alert) Name() string {
+	return a.Labels[model.AlertNameLabel]
+}
+
+// Message is the Alertmanager webhook payload (version 4). The gateway
+// accepts it and sends it on to webhook sinks, so anything written for
+// Alertmanager webhooks can sit behind either.
+type Message struct {
+	Version           string            `json:"version"`
+	GroupKey          string            `json:"groupKey"`
+	Status            string            `json:"status"`
+	Receiver          string            `json:"receiver"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	Alerts            []Alert           `json:"alerts"`
+}
+
+// Key identifies an alert group. Alerts without an instance label, e.g. a
+// cluster-wide alert, share the group of their cluster.
+type Key struct {
+	Cluster  string
+	Instance string
+}
+
+// KeyOf returns the group of an alert with labels.
+func KeyOf(labels map[string]string) Key {
+	return Key{Cluster: labels["cluster"], Instance: labels["instance"]}
+}
+
+func (k Key) String() string {
+	cluster := k.Cluster
+	if cluster == "" {
+		cluster = "-"
+	}
+	if k.Instance == "" {
+		return cluster
+	}
+	return cluster + "/" + k.Instance
+}
+
+// fingerprint identifies an alert by its label set, like Alertmanager does.
+func fingerprint(labels map[string]string) string {
+	set := make(model.LabelSet, len(labels))
+	for name, value := range labels {
+		set[model.LabelName(name)] = model.LabelValue(value)
+	}
+	return set.Fingerprint().String()
+}
+
+// normalize fills in the fingerprint and, for alerts posted by vmalert
+// without a status, derives the status from EndsAt.
+func normalize(a Alert, now time.Time) Alert {
+	a.Fingerprint = fingerprint(a.Labels)
+	if a.Status == "" {
+		a.Status = StatusFiring
+		if !a.EndsAt.IsZero() && !a.EndsAt.After(now) {
+			a.Status = StatusResolved
+		}
+	}
+	return a
+}
+
+// Notification is one alert group as it is delivered to a sink.
+type Notification struct {
+	Key    Key
+	Alerts []Alert // firing alerts first, then by name
+}
+
+// Status is firing if any alert of the group is.
+func (n Notification) Status() string {
+	if n.count(StatusFiring) > 0 {
+		return StatusFiring
+	}
+	return StatusResolved
+}
+
+func (n Notification) count(status string) int {
+	count := 0
+	for _, alert := range n.Alerts {
+		if alert.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+// Title summarises the group in one line, e.g.
+// "[FIRING:2] production/10.128.0.2:9090".
+func (n Notification) Title() string {
+	status := n.Status()
+	return fmt.Sprintf("[%s:%d] %s", strings.ToUpper(status), n.count(status), n.Key)
+}
+
+// Text lists the alerts of the group, one per line.
+func (n Notification) Text() string {
+	var b strings.Builder
+	for _, alert := range n.Alerts {
+		fmt.Fprintf(&b, "- [%s] %s", alert.Status, alert.Name())
+		if severity := alert.Labels["severity"]; severity != "" {
+			fmt.Fprintf(&b, " (%s)", severity)
+		}
+		if summary := alert.Annotations["summary"]; summary != "" {
+			fmt.Fprintf(&b, ": %s", summary)
+		}
+		b.WriteString("\n")
+		if description := alert.Annotations["description"]; description != "" {
+			fmt.Fprintf(&b, "  %s\n", description)
+		}
+	}
+	return b.String()
+}
+
+// Message converts n to the Alertmanager webhook payload.
+func (n Notification) Message(receiver string) Message {
+	groupLabels := map[string]string{}
+	if n.Key.Cluster != "" {
+		groupLabels["cluster"] = n.Key.Cluster
+	}
+	if n.Key.Instance != "" {
+		groupLabels["instance"] = n.Key.Instance
+	}
+
+	return Message{
+		Version:           "4",
+		GroupKey:          n.Key.String(),
+		Status:            n.Status(),
+		Receiver:          receiver,
+		GroupLabels:       groupLabels,
+		CommonLabels:      common(n.Alerts, func(a Alert) map[string]string { return a.Labels }),
+		CommonAnnotations: common(n.Alerts, func(a Alert) map[string]string { return a.Annotations }),
+		Alerts:            n.Alerts,
+	}
+}
+
+// common returns the pairs every alert shares.
+func common(alerts []Alert, pairs func(Alert) map[string]string) map[string]string {
+	shared := map[string]string{}
+	if len(alerts) == 0 {
+		return shared
+	}
+	for name, value := range pairs(alerts[0]) {
+		shared[name] = value
+	}
+	for _, alert := range alerts[1:] {
+		for name, value := range shared {
+			if pairs(alert)[name] != value {
+				delete(shared, name)
+			}
+		}
+	}
+	return shared
+}
+
+// sortAlerts orders firing alerts before resolved ones, then by name and
+// fingerprint, so notifications are stable.
+func sortAlerts(alerts []Alert) {
+	sort.Slice(alerts, func(i, j int) bool {
+		a, b := alerts[i], alerts[j]
+		if a.Status != b.Status {
+			return a.Status == StatusFiring
+		}
+		if a.Name() != b.Name() {
+			return a.Name() < b.Name()
+		}
+		return a.Fingerprint < b.Fingerprint
+	})
+}
+
+func sortNotifications(notifications []Notification) {
+	sort.Slice(notifications, func(i, j int) bool {
+		a, b := notifications[i].Key, notifications[j].Key
+		if a.Cluster != b.Cluster {
+			return a.Cluster < b.Cluster
+		}
+		return a.Instance < b.Instance
+	})
+}
@@ -0,0 +1,247 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// Gateway defaults.
+const (
+	DefaultGroupWait      = 30 * time.Second
+	DefaultRepeatInterval = 4 * time.Hour
+)
+
+// Gateway collects alerts into groups by cluster and instance and delivers
+// each group to every sink. A group is sent to a sink when an alert in it
+// starts firing or resolves, and again every RepeatInterval while anything
+// in it still fires. Alerts received again unchanged are not sent again.
+type Gateway struct {
+	Sinks          []Sink
+	RepeatInterval time.Duration
+	Logger         *log.Logger
+	Now            func() time.Time
+
+	mu     sync.Mutex
+	groups map[Key]*group
+}
+
+type group struct {
+	alerts  map[string]Alert     // by fingerprint
+	changed time.Time            // last time an alert started firing or resolved
+	sent    map[string]time.Time // last delivery by sink name
+}
+
+// New returns a Gateway delivering to sinks.
+func New(sinks ...Sink) *Gateway {
+	return &Gateway{Sinks: sinks, RepeatInterval: DefaultRepeatInterval}
+}
+
+func (g *Gateway) now() time.Time {
+	if g.Now != nil {
+		return g.Now()
+	}
+	return time.Now()
+}
+
+func (g *Gateway) logger() *log.Logger {
+	if g.Logger != nil {
+		return g.Logger
+	}
+	return log.New(io.Discard, "", 0)
+}
+
+// Receive adds alerts to their groups and returns how many of them started
+// firing or resolved. A resolved alert the gateway never saw firing is
+// dropped.
+func (g *Gateway) Receive(alerts []Alert) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.groups == nil {
+		g.groups = map[Key]*group{}
+	}
+
+	now := g.now()
+	changed := 0
+	for _, alert := range alerts {
+		alert = normalize(alert, now)
+		key := KeyOf(alert.Labels)
+
+		grp, ok := g.groups[key]
+		if !ok {
+			if alert.Status == StatusResolved {
+				continue
+			}
+			grp = &group{alerts: map[string]Alert{}, sent: map[string]time.Time{}}
+			g.groups[key] = grp
+		}
+
+		previous, seen := grp.alerts[alert.Fingerprint]
+		if !seen && alert.Status == StatusResolved {
+			continue
+		}
+		if !seen || previous.Status != alert.Status {
+			grp.changed = now
+			changed++
+		}
+		grp.alerts[alert.Fingerprint] = alert
+	}
+	return changed
+}
+
+// delivery is one notification due at a set of sinks.
+type delivery struct {
+	notification Notification
+	sinks        []Sink
+}
+
+// Flush sends every group that is due to the sinks it is due at. A failed
+// sink is retried on the next Flush; the others are not sent the group
+// again. Resolved alerts are forgotten once every sink has been told.
+func (g *Gateway) Flush(ctx context.Context) error {
+	now := g.now()
+	deliveries := g.due(now)
+
+	var errs []error
+	delivered := map[Key][]string{}
+	for _, d := range deliveries {
+		for _, sink := range d.sinks {
+			if err := sink.Send(ctx, d.notification); err != nil {
+				g.logger().Printf("failed to send %s to %s: %v", d.notification.Key, sink.Name(), err)
+				errs = append(errs, fmt.Errorf("%s: %s: %v", sink.Name(), d.notification.Key, err))
+				continue
+			}
+			g.logger().Printf("sent %s to %s", d.notification.Title(), sink.Name())
+			delivered[d.notification.Key] = append(delivered[d.notification.Key], sink.Name())
+		}
+	}
+
+	g.markSent(now, delivered)
+	return errors.Join(errs...)
+}
+
+// due expires alerts whose EndsAt has passed and returns the deliveries
+// that are due at now.
+func (g *Gateway) due(now time.Time) []delivery {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var deliveries []delivery
+	for key, grp := range g.groups {
+		firing := false
+		for fp, alert := range grp.alerts {
+			// vmalert keeps pushing EndsAt forward while an alert fires; an
+			// alert that stopped being sent has resolved.
+			if alert.Status == StatusFiring && !alert.EndsAt.IsZero() && !alert.EndsAt.After(now) {
+				alert.Status = StatusResolved
+				grp.alerts[fp] = alert
+				grp.changed = now
+			}
+			firing = firing || alert.Status == StatusFiring
+		}
+
+		var sinks []Sink
+		for _, sink := range g.Sinks {
+			sent := grp.sent[sink.Name()]
+			if sent.Before(grp.changed) || (firing && g.RepeatInterval > 0 && now.Sub(sent) >= g.RepeatInterval) {
+				sinks = append(sinks, sink)
+			}
+		}
+		if len(sinks) == 0 {
+			continue
+		}
+
+		notification := Notification{Key: key}
+		for _, alert := range grp.alerts {
+			notification.Alerts = append(notification.Alerts, alert)
+		}
+		sortAlerts(notification.Alerts)
+		deliveries = append(deliveries, delivery{notification: notification, sinks: sinks})
+	}
+	return deliveries
+}
+
+// markSent records the deliveries made at now and drops resolved alerts,
+// and then empty groups, once every sink has been sent them.
+func (g *Gateway) markSent(now time.Time, delivered map[Key][]string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for key, grp := range g.groups {
+		for _, name := range delivered[key] {
+			grp.sent[name] = now
+		}
+
+		allSent := true
+		for _, sink := range g.Sinks {
+			if grp.sent[sink.Name()].Before(grp.changed) {
+				allSent = false
+			}
+		}
+		if !allSent {
+			continue
+		}
+		for fp, alert := range grp.alerts {
+			if alert.Status == StatusResolved {
+				delete(grp.alerts, fp)
+			}
+		}
+		if len(grp.alerts) == 0 {
+			delete(g.groups, key)
+		}
+	}
+}
+
+// Groups returns the current alert groups, e.g. for the status endpoint.
+func (g *Gateway) Groups() []Notification {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	groups := make([]Notification, 0, len(g.groups))
+	for key, grp := range g.groups {
+		notification := Notification{Key: key}
+		for _, alert := range grp.alerts {
+			notification.Alerts = append(notification.Alerts, alert)
+		}
+		sortAlerts(notification.Alerts)
+		groups = append(groups, notification)
+	}
+	sortNotifications(groups)
+	return groups
+}
+
+// RunDaemon flushes every groupWait until ctx is cancelled, so alerts that
+// arrive together are sent together.
+func (g *Gateway) RunDaemon(ctx context.Context, groupWait time.Duration) error {
+	if groupWait <= 0 {
+		return fmt.Errorf("group wait must be positive, got %s", groupWait)
+	}
+
+	names := make([]string, 0, len(g.Sinks))
+	for _, sink := range g.Sinks {
+		names = append(names, sink.Name())
+	}
+	g.logger().Printf("delivering to %v (group wait %s, repeat interval %s)", names, groupWait, g.RepeatInterval)
+
+	ticker := time.NewTicker(groupWait)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Deliver what arrived since the last tick before exiting.
+			flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			g.Flush(flushCtx)
+			cancel()
+			g.logger().Print("shutting down")
+			return nil
+		case <-ticker.C:
+			g.Flush(ctx)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package notify
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// NewHandler exposes g over HTTP:
+//
+//	POST /webhook        receive an Alertmanager webhook payload
+//	POST /api/v2/alerts  receive alerts from vmalert (--notifier.url)
+//	GET  /alerts         list the current alert groups
+//
+// Alerts are only accepted here; they are delivered by g.RunDaemon.
+func NewHandler(g *Gateway, logger *log.Logger) http.Handler {
+	h := &handler{gateway: g, logger: logger}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /webhook", h.webhook)
+	mux.HandleFunc("POST /api/v2/alerts", h.alerts)
+	mux.HandleFunc("GET /alerts", h.list)
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+
+	return mux
+}
+
+type handler struct {
+	gateway *Gateway
+	logger  *log.Logger
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func (h *handler) webhook(w http.ResponseWriter, r *http.Request) {
+	var message Message
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&message); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid webhook payload: " + err.Error()})
+		return
+	}
+	h.receive(w, message.Alerts)
+}
+
+func (h *handler) alerts(w http.ResponseWriter, r *http.Request) {
+	var alerts []Alert
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&alerts); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid alert list: " + err.Error()})
+		return
+	}
+	h.receive(w, alerts)
+}
+
+func (h *handler) receive(w http.ResponseWriter, alerts []Alert) {
+	for _, alert := range alerts {
+		if alert.Name() == "" {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "alert without alertname label"})
+			return
+		}
+	}
+
+	if changed := h.gateway.Receive(alerts); changed > 0 {
+		h.logger.Printf("received %d alerts, %d started firing or resolved", len(alerts), changed)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *handler) list(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.gateway.Groups())
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,185 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Receiver is the receiver name put in the webhook payloads the gateway
+// sends.
+const Receiver = "algalon-notify"
+
+// Sink delivers notifications. Name identifies the sink in logs and in the
+// gateway's delivery state, so it must be unique among a gateway's sinks.
+type Sink interface {
+	Name() string
+	Send(ctx context.Context, n Notification) error
+}
+
+// WebhookSink posts the Alertmanager webhook payload of each notification
+// to URL.
+type WebhookSink struct {
+	URL    string
+	Client *http.Client
+}
+
+// Name implements Sink.
+func (s *WebhookSink) Name() string { return "webhook" }
+
+// Send implements Sink.
+func (s *WebhookSink) Send(ctx context.Context, n Notification) error {
+	return postJSON(ctx, s.Client, s.URL, n.Message(Receiver))
+}
+
+// ChatSink posts each notification as text to a Slack incoming webhook.
+// Mattermost and Rocket.Chat accept the same payload.
+type ChatSink struct {
+	URL    string
+	Client *http.Client
+}
+
+type chatMessage struct {
+	Text string `json:"text"`
+}
+
+// Name implements Sink.
+func (s *ChatSink) Name() string { return "chat" }
+
+// Send implements Sink.
+func (s *ChatSink) Send(ctx context.Context, n Notification) error {
+	return postJSON(ctx, s.Client, s.URL, chatMessage{Text: "*" + n.Title() + "*\n" + n.Text()})
+}
+
+func postJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s answered %s: %s", url, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// SMTPSink emails each notification. It upgrades to TLS when the server
+// offers STARTTLS; net/smtp refuses to send credentials over a plain
+// connection to anything but localhost.
+type SMTPSink struct {
+	Addr     string // host:port
+	From     string
+	To       []string
+	Username string
+	Password string
+}
+
+// Name implements Sink.
+func (s *SMTPSink) Name() string { return "smtp" }
+
+// Send implements Sink.
+func (s *SMTPSink) Send(ctx context.Context, n Notification) error {
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return fmt.Errorf("invalid SMTP address %q: %v", s.Addr, err)
+	}
+
+	conn, err := (&net.Dialer{Timeout: 10 * time.Second}).DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(30 * time.Second)
+	}
+	conn.SetDeadline(deadline)
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if s.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.Username, s.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.From); err != nil {
+		return err
+	}
+	for _, to := range s.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(s.message(n)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func (s *SMTPSink) message(n Notification) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", s.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", n.Title())
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(n.Text(), "\n", "\r\n"))
+	return b.Bytes()
+}
+
+// LogSink writes notifications to Logger instead of delivering them, for
+// dry runs.
+type LogSink struct {
+	Logger *log.Logger
+}
+
+// Name implements Sink.
+func (s *LogSink) Name() string { return "log" }
+
+// Send implements Sink.
+func (s *LogSink) Send(ctx context.Context, n Notification) error {
+	s.Logger.Printf("%s\n%s", n.Title(), strings.TrimRight(n.Text(), "\n"))
+	return nil
+}
@@ -0,0 +1,87 @@
+package reaper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/appleparan/Algalon/pkg/targets"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultQuarantineFile sits next to all-smi-targets.yml but does not match
+// the all-smi-*.yml glob in prometheus.yml, so quarantined targets are no
+// longer scraped.
+const DefaultQuarantineFile = "quarantined-targets.yml"
+
+// Quarantined is a target the reaper took out of the target file. Its labels
+// are kept so it can be restored as it was.
+type Quarantined struct {
+	Target        string            `yaml:"target"`
+	Labels        map[string]string `yaml:"labels,omitempty"`
+	QuarantinedAt time.Time         `yaml:"quarantined_at"`
+}
+
+const quarantineHeader = `# Targets quarantined by algalon-reaper after they stopped answering scrapes.
+# They are not scraped. A target that answers again is moved back to the
+# target file; the rest are deleted once their retention expires.
+
+`
+
+// ReadQuarantine reads the quarantine file at path. A missing file is an
+// empty quarantine.
+func ReadQuarantine(path string) ([]Quarantined, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Quarantined
+	if err := yaml.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("%s: invalid quarantine file: %v", path, err)
+	}
+	for i, entry := range entries {
+		if _, err := targets.ParseTarget(entry.Target, 0); err != nil {
+			return nil, fmt.Errorf("%s: entry %d: %v", path, i, err)
+		}
+	}
+	return entries, nil
+}
+
+// RenderQuarantine encodes entries sorted by target.
+func RenderQuarantine(entries []Quarantined) ([]byte, error) {
+	sorted := append([]Quarantined(nil), entries...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Target < sorted[j].Target })
+
+	var buf bytes.Buffer
+	buf.WriteString(quarantineHeader)
+	if len(sorted) == 0 {
+		buf.WriteString("[]\n")
+		return buf.Bytes(), nil
+	}
+
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(sorted); err != nil {
+		return nil, fmt.Errorf("failed to encode quarantine: %v", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode quarantine: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteQuarantine atomically replaces path with entries.
+func WriteQuarantine(path string, entries []Quarantined) error {
+	data, err := RenderQuarantine(entries)
+	if err != nil {
+		return err
+	}
+	return targets.WriteAtomic(path, data)
+}
@@ -0,0 +1,314 @@
+// Package reaper removes all-smi targets that stopped answering scrapes, such
+// as preemptible workers that were deleted without deregistering. Targets
+// VictoriaMetrics has seen down for a whole grace period are moved from the
+// file_sd target file into a quarantine file, where they are no longer
+// scraped. A quarantined target that answers again is restored; the rest are
+// deleted once their retention expires.
+package reaper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/appleparan/Algalon/pkg/targets"
+	"github.com/appleparan/Algalon/pkg/vmclient"
+)
+
+// Defaults for Reaper.
+const (
+	// DefaultSelector matches the up series of the all-smi scrape job in
+	// prometheus.yml.
+	DefaultSelector = `up{job="all-smi"}`
+
+	// DefaultGracePeriod is how long a target must have been down before it
+	// is quarantined. It is long enough to ride out a worker reboot.
+	DefaultGracePeriod = 15 * time.Minute
+
+	// DefaultRetention is how long a target stays in quarantine before it
+	// is deleted.
+	DefaultRetention = 24 * time.Hour
+)
+
+// Querier runs instant queries; *vmclient.Client implements it.
+type Querier interface {
+	Query(ctx context.Context, query string) (vmclient.Vector, error)
+}
+
+// Prober checks whether a quarantined target serves all-smi metrics again;
+// *discovery.Scanner implements it.
+type Prober interface {
+	Probe(ctx context.Context, address string) (bool, error)
+}
+
+// Reaper reconciles one file_sd target file with the scrape health recorded
+// in VictoriaMetrics.
+type Reaper struct {
+	Querier        Querier
+	Prober         Prober // optional; without it quarantined targets are never restored
+	Selector       string // up series to check; defaults to DefaultSelector
+	Path           string
+	QuarantinePath string
+	GracePeriod    time.Duration
+	Retention      time.Duration
+	DryRun         bool
+	Logger         *log.Logger
+
+	// Now returns the current time; tests replace it to step through the
+	// retention period.
+	Now func() time.Time
+
+	// OnChange, if set, is called with a summary after a run that rewrote
+	// the target file, e.g. to record it in the target history.
+	OnChange func(message string)
+
+	// restored remembers when targets were restored. Their up series still
+	// reads 0 for the grace period, which must not quarantine them again.
+	restored map[string]time.Time
+}
+
+// Changes summarises one Reaper run.
+type Changes struct {
+	Quarantined  []string // targets moved to the quarantine file
+	Restored     []string // quarantined targets that answered again
+	Released     []string // quarantined targets that were registered again
+	Removed      []string // quarantined targets whose retention expired
+	Targets      int      // targets in the target file after the run
+	InQuarantine int      // targets in the quarantine file after the run
+}
+
+func (c Changes) summary() string {
+	var parts []string
+	if len(c.Quarantined) > 0 {
+		parts = append(parts, "quarantine "+strings.Join(c.Quarantined, ", "))
+	}
+	if len(c.Restored) > 0 {
+		parts = append(parts, "restore "+strings.Join(c.Restored, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Query returns the PromQL query for targets that were down for the whole
+// grace period. A target must also have been scraped in the grace period
+// before that, i.e. registered at least one grace period ago: a worker that
+// is still booting has been down since it registered, but only for as long
+// as it has existed.
+func (r *Reaper) Query() string {
+	selector := r.Selector
+	if selector == "" {
+		selector = DefaultSelector
+	}
+	grace := int(r.gracePeriod().Seconds())
+	return fmt.Sprintf("max_over_time(%s[%ds]) == 0 and count_over_time(%s[%ds] offset %ds)",
+		selector, grace, selector, grace, grace)
+}
+
+// RunOnce queries VictoriaMetrics for stale targets and updates the target
+// and quarantine files. If the query fails, nothing is changed. The target
+// file is read and rewritten under targets.LockDir, so registrations made
+// meanwhile by algalon-registry are kept.
+func (r *Reaper) RunOnce(ctx context.Context) (Changes, error) {
+	vector, err := r.Querier.Query(ctx, r.Query())
+	if err != nil {
+		return Changes{}, fmt.Errorf("failed to query stale targets: %v", err)
+	}
+	stale := map[string]bool{}
+	for _, sample := range vector {
+		if instance := sample.Metric["instance"]; instance != "" {
+			stale[instance] = true
+		}
+	}
+
+	// Only the reaper writes the quarantine file, so it can be read and
+	// probed before taking the lock other writers wait on.
+	quarantine, err := ReadQuarantine(r.QuarantinePath)
+	if err != nil {
+		return Changes{}, err
+	}
+	answers := map[string]bool{}
+	for _, entry := range quarantine {
+		answers[entry.Target] = r.answers(ctx, entry.Target)
+	}
+
+	unlock, err := targets.LockDir(filepath.Dir(r.Path))
+	if err != nil {
+		return Changes{}, err
+	}
+	defer unlock()
+
+	groups, err := targets.ReadFile(r.Path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Changes{}, err
+	}
+
+	// restored is only kept once the run has written its changes, so a dry
+	// run leaves the state the next run reads untouched.
+	now := r.now()
+	restored := map[string]time.Time{}
+	for target, at := range r.restored {
+		if now.Sub(at) < r.gracePeriod() {
+			restored[target] = at
+		}
+	}
+
+	inFile := map[string]bool{}
+	for _, group := range groups {
+		for _, target := range group.Targets {
+			inFile[target] = true
+		}
+	}
+
+	var changes Changes
+	var kept []Quarantined
+	for _, entry := range quarantine {
+		switch {
+		case inFile[entry.Target]:
+			r.Logger.Printf("releasing %s from quarantine; it was registered again", entry.Target)
+			changes.Released = append(changes.Released, entry.Target)
+		case answers[entry.Target]:
+			r.Logger.Printf("restoring %s; it answers again after %s in quarantine", entry.Target, now.Sub(entry.QuarantinedAt).Round(time.Second))
+			changes.Restored = append(changes.Restored, entry.Target)
+			groups = addTarget(groups, entry.Target, entry.Labels)
+			restored[entry.Target] = now
+		case now.Sub(entry.QuarantinedAt) >= r.retention():
+			r.Logger.Printf("removing %s (quarantined for %s)", entry.Target, now.Sub(entry.QuarantinedAt).Round(time.Second))
+			changes.Removed = append(changes.Removed, entry.Target)
+		default:
+			kept = append(kept, entry)
+		}
+	}
+
+	var next []targets.Group
+	for _, group := range groups {
+		var remaining []string
+		for _, target := range group.Targets {
+			if _, ok := restored[target]; stale[target] && !ok {
+				r.Logger.Printf("quarantining %s %v (down for at least %s)", target, group.Labels, r.gracePeriod())
+				changes.Quarantined = append(changes.Quarantined, target)
+				kept = append(kept, Quarantined{Target: target, Labels: group.Labels, QuarantinedAt: now})
+				continue
+			}
+			remaining = append(remaining, target)
+		}
+		if len(remaining) > 0 {
+			group.Targets = remaining
+			next = append(next, group)
+		}
+	}
+
+	sort.Strings(changes.Quarantined)
+	sort.Strings(changes.Restored)
+	sort.Strings(changes.Released)
+	sort.Strings(changes.Removed)
+	for _, group := range next {
+		changes.Targets += len(group.Targets)
+	}
+	changes.InQuarantine = len(kept)
+
+	targetsChanged := len(changes.Quarantined) > 0 || len(changes.Restored) > 0
+	quarantineChanged := targetsChanged || len(changes.Released) > 0 || len(changes.Removed) > 0
+
+	if r.DryRun {
+		if quarantineChanged {
+			r.Logger.Printf("dry run: would write %d targets to %s and %d to %s", changes.Targets, r.Path, changes.InQuarantine, r.QuarantinePath)
+		}
+		return changes, nil
+	}
+
+	// Write the quarantine first: if the second write fails, a target is at
+	// worst in both files, never in neither.
+	if quarantineChanged {
+		if err := WriteQuarantine(r.QuarantinePath, kept); err != nil {
+			return changes, err
+		}
+		r.Logger.Printf("wrote %d quarantined targets to %s", changes.InQuarantine, r.QuarantinePath)
+	}
+	if targetsChanged {
+		if next == nil {
+			next = []targets.Group{}
+		}
+		if err := targets.WriteFile(r.Path, next); err != nil {
+			return changes, err
+		}
+		r.Logger.Printf("wrote %d targets to %s", changes.Targets, r.Path)
+		if r.OnChange != nil {
+			r.OnChange(changes.summary())
+		}
+	}
+	r.restored = restored
+	return changes, nil
+}
+
+// RunDaemon repeats RunOnce every interval until ctx is cancelled. A failed
+// run is logged and retried on the next tick.
+func (r *Reaper) RunDaemon(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("reaper interval must be positive, got %s", interval)
+	}
+
+	r.Logger.Printf("starting reaper daemon (interval %s, grace period %s, retention %s)", interval, r.gracePeriod(), r.retention())
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.RunOnce(ctx); err != nil && ctx.Err() == nil {
+			r.Logger.Printf("reaper run failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			r.Logger.Print("shutting down")
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Reaper) answers(ctx context.Context, target string) bool {
+	if r.Prober == nil {
+		return false
+	}
+	ok, _ := r.Prober.Probe(ctx, target)
+	return ok
+}
+
+func (r *Reaper) gracePeriod() time.Duration {
+	if r.GracePeriod > 0 {
+		return r.GracePeriod
+	}
+	return DefaultGracePeriod
+}
+
+func (r *Reaper) retention() time.Duration {
+	if r.Retention > 0 {
+		return r.Retention
+	}
+	return DefaultRetention
+}
+
+func (r *Reaper) now() time.Time {
+	if r.Now != nil {
+		return r.Now()
+	}
+	return time.Now()
+}
+
+// addTarget appends target to the group carrying exactly labels, or to a new
+// group if there is none.
+func addTarget(groups []targets.Group, target string, labels map[string]string) []targets.Group {
+	for i, group := range groups {
+		if targets.SameLabels(group.Labels, labels) {
+			groups[i].Targets = append(append([]string(nil), group.Targets...), target)
+			sort.Strings(groups[i].Targets)
+			return groups
+		}
+	}
+	return append(groups, targets.Group{Targets: []string{target}, Labels: labels})
+}
@@ -0,0 +1,96 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// NewHandler exposes store over HTTP:
+//
+//	GET    /workers          list registered workers
+//	POST   /workers          register a worker ({"target": "host:port", "labels": {...}})
+//	DELETE /workers/{target} deregister a worker
+//
+// Every change is logged to logger.
+func NewHandler(store *Store, logger *log.Logger) http.Handler {
+	h := &handler{store: store, logger: logger}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /workers", h.list)
+	mux.HandleFunc("POST /workers", h.register)
+	mux.HandleFunc("DELETE /workers/{target}", h.deregister)
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+
+	return mux
+}
+
+type handler struct {
+	store  *Store
+	logger *log.Logger
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func (h *handler) list(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.store.List())
+}
+
+func (h *handler) register(w http.ResponseWriter, r *http.Request) {
+	var req Worker
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid request body: " + err.Error()})
+		return
+	}
+
+	worker, created, err := h.store.Add(req)
+	if err != nil {
+		if errors.Is(err, errWrite) || errors.Is(err, errRead) {
+			h.logger.Printf("failed to register %s: %v", req.Target, err)
+			writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+
+	if created {
+		h.logger.Printf("registered worker %s %v", worker.Target, worker.Labels)
+		writeJSON(w, http.StatusCreated, worker)
+		return
+	}
+
+	h.logger.Printf("worker %s already registered", worker.Target)
+	writeJSON(w, http.StatusOK, worker)
+}
+
+func (h *handler) deregister(w http.ResponseWriter, r *http.Request) {
+	target := r.PathValue("target")
+
+	err := h.store.Remove(target)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+	case errors.Is(err, errWrite), errors.Is(err, errRead):
+		h.logger.Printf("failed to deregister %s: %v", target, err)
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+	case err != nil:
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+	default:
+		h.logger.Printf("deregistered worker %s", target)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,240 @@
+// Package registry keeps the set of registered all-smi workers and mirrors it
+// into a file_sd target file. It replaces the in-place edits done by
+// algalon_host/scripts/register-worker.sh: every change is serialized and the
+// file is rewritten atomically, so VMAgent picks it up on its next
+// fileSDCheckInterval without a restart.
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/appleparan/Algalon/pkg/targets"
+)
+
+// DefaultTargetsFile is the path register-worker.sh edits on the host.
+const DefaultTargetsFile = "/opt/Algalon/algalon_host/node/targets/all-smi-targets.yml"
+
+// Worker is a registered scrape target and the labels attached to it.
+type Worker struct {
+	Target string            `json:"target"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// ErrNotFound is returned when removing a target that is not registered.
+var ErrNotFound = errors.New("worker not registered")
+
+// errWrite and errRead mark failures to persist or reload the target file,
+// as opposed to invalid requests.
+var (
+	errWrite = errors.New("failed to write target file")
+	errRead  = errors.New("failed to read target file")
+)
+
+// Store is the registered worker set backed by a file_sd target file.
+// It is safe for concurrent use.
+type Store struct {
+	mu            sync.Mutex
+	path          string
+	defaultLabels map[string]string
+	workers       map[string]Worker
+
+	// OnChange, if set, is called with a short description after every
+	// change is written, e.g. to record it in the target history. It runs
+	// with the store locked, so the file cannot change underneath it.
+	OnChange func(message string)
+}
+
+// NewStore loads the workers already listed in path, if it exists.
+// defaultLabels are attached to every newly registered worker; labels sent
+// with a registration are merged on top of them.
+func NewStore(path string, defaultLabels map[string]string) (*Store, error) {
+	s := &Store{
+		path:          path,
+		defaultLabels: targets.CopyLabels(defaultLabels),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// load replaces the in-memory worker set with the content of the target
+// file. Add and Remove call it first, holding targets.LockDir until they have
+// written the file, so edits made by other tools, such as algalon-reaper
+// quarantining a target, are not overwritten. The caller must hold s.mu or
+// own s exclusively.
+func (s *Store) load() error {
+	workers := map[string]Worker{}
+
+	groups, err := targets.ReadFile(s.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for _, group := range groups {
+		for _, target := range group.Targets {
+			workers[target] = Worker{Target: target, Labels: targets.CopyLabels(group.Labels)}
+		}
+	}
+
+	s.workers = workers
+	return nil
+}
+
+// Reload rereads the target file under targets.LockDir, picking up workers
+// that other processes registered or removed since the store was loaded.
+func (s *Store) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unlock, err := targets.LockDir(filepath.Dir(s.path))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errRead, err)
+	}
+	defer unlock()
+
+	if err := s.load(); err != nil {
+		return fmt.Errorf("%w: %v", errRead, err)
+	}
+	return nil
+}
+
+// Path returns the target file the store writes to.
+func (s *Store) Path() string {
+	return s.path
+}
+
+// List returns the registered workers ordered by target.
+func (s *Store) List() []Worker {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	workers := make([]Worker, 0, len(s.workers))
+	for _, worker := range s.workers {
+		workers = append(workers, copyWorker(worker))
+	}
+	sort.Slice(workers, func(i, j int) bool { return workers[i].Target < workers[j].Target })
+
+	return workers
+}
+
+// Add registers w, or updates its labels if the target is already known.
+// It reports whether the target was newly added. The target file is only
+// rewritten when something changed.
+func (s *Store) Add(w Worker) (Worker, bool, error) {
+	target, err := targets.ParseTarget(w.Target, 0)
+	if err != nil {
+		return Worker{}, false, err
+	}
+
+	labels := targets.CopyLabels(s.defaultLabels)
+	for name, value := range w.Labels {
+		if err := targets.ValidateGroupLabelName(name); err != nil {
+			return Worker{}, false, err
+		}
+		if name == "job" {
+			return Worker{}, false, fmt.Errorf("label %q cannot be overridden", name)
+		}
+		labels[name] = value
+	}
+
+	worker := Worker{Target: target.String(), Labels: labels}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unlock, err := targets.LockDir(filepath.Dir(s.path))
+	if err != nil {
+		return Worker{}, false, fmt.Errorf("%w: %v", errWrite, err)
+	}
+	defer unlock()
+
+	if err := s.load(); err != nil {
+		return Worker{}, false, fmt.Errorf("%w: %v", errRead, err)
+	}
+
+	previous, exists := s.workers[worker.Target]
+	if exists && targets.SameLabels(previous.Labels, worker.Labels) {
+		return copyWorker(previous), false, nil
+	}
+
+	s.workers[worker.Target] = worker
+	if err := s.flush(); err != nil {
+		if exists {
+			s.workers[worker.Target] = previous
+		} else {
+			delete(s.workers, worker.Target)
+		}
+		return Worker{}, false, err
+	}
+	if exists {
+		s.changed(fmt.Sprintf("update labels of %s", worker.Target))
+	} else {
+		s.changed(fmt.Sprintf("register %s", worker.Target))
+	}
+
+	return copyWorker(worker), !exists, nil
+}
+
+// Remove deregisters target and rewrites the target file.
+func (s *Store) Remove(target string) error {
+	parsed, err := targets.ParseTarget(target, 0)
+	if err != nil {
+		return err
+	}
+	key := parsed.String()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unlock, err := targets.LockDir(filepath.Dir(s.path))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errWrite, err)
+	}
+	defer unlock()
+
+	if err := s.load(); err != nil {
+		return fmt.Errorf("%w: %v", errRead, err)
+	}
+
+	previous, exists := s.workers[key]
+	if !exists {
+		return ErrNotFound
+	}
+
+	delete(s.workers, key)
+	if err := s.flush(); err != nil {
+		s.workers[key] = previous
+		return err
+	}
+	s.changed(fmt.Sprintf("deregister %s", key))
+
+	return nil
+}
+
+func (s *Store) changed(message string) {
+	if s.OnChange != nil {
+		s.OnChange(message)
+	}
+}
+
+// flush writes the current worker set, one file_sd group per distinct label
+// set. The caller must hold s.mu.
+func (s *Store) flush() error {
+	labels := make(map[string]map[string]string, len(s.workers))
+	for _, worker := range s.workers {
+		labels[worker.Target] = worker.Labels
+	}
+	groups := targets.GroupByLabels(labels)
+
+	if err := targets.WriteFile(s.path, groups); err != nil {
+		return fmt.Errorf("%w %s: %v", errWrite, s.path, err)
+	}
+	return nil
+}
+
+func copyWorker(w Worker) Worker {
+	return Worker{Target: w.Target, Labels: targets.CopyLabels(w.Labels)}
+}
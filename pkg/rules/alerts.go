@@ -0,0 +1,142 @@
+package rules
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultAlertsFile is the rule file vmalert loads from algalon_host/rules.
+const DefaultAlertsFile = "algalon-alerts.yml"
+
+// Severity label values.
+const (
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Thresholds parameterise the alert pack. The host config reads them from
+// the ALERT_* settings.
+type Thresholds struct {
+	TargetDownFor          time.Duration // how long a target must fail scrapes
+	GPUTemperatureWarning  float64       // degrees Celsius
+	GPUTemperatureCritical float64       // degrees Celsius
+	GPUMemoryPercent       float64       // used share of GPU memory, 0-100
+	For                    time.Duration // pending period of the temperature and memory alerts
+}
+
+// DefaultThresholds returns the thresholds used when the host config sets
+// none. 80°C and 90°C are below the slowdown temperature of current NVIDIA
+// data-center GPUs.
+func DefaultThresholds() Thresholds {
+	return Thresholds{
+		TargetDownFor:          5 * time.Minute,
+		GPUTemperatureWarning:  80,
+		GPUTemperatureCritical: 90,
+		GPUMemoryPercent:       95,
+		For:                    5 * time.Minute,
+	}
+}
+
+// Validate checks the thresholds are in range and ordered.
+func (t Thresholds) Validate() error {
+	if t.TargetDownFor < 0 || t.For < 0 {
+		return fmt.Errorf("alert durations must not be negative")
+	}
+	if t.GPUTemperatureWarning <= 0 {
+		return fmt.Errorf("GPU temperature warning threshold %g must be positive", t.GPUTemperatureWarning)
+	}
+	if t.GPUTemperatureWarning >= t.GPUTemperatureCritical {
+		return fmt.Errorf("GPU temperature warning threshold %g must be below the critical threshold %g", t.GPUTemperatureWarning, t.GPUTemperatureCritical)
+	}
+	if t.GPUMemoryPercent <= 0 || t.GPUMemoryPercent > 100 {
+		return fmt.Errorf("GPU memory threshold %g must be between 0 and 100 percent", t.GPUMemoryPercent)
+	}
+	return nil
+}
+
+// Alert names in the pack.
+const (
+	AlertTargetDown             = "AlgalonTargetDown"
+	AlertGPUTemperatureHigh     = "AlgalonGPUTemperatureHigh"
+	AlertGPUTemperatureCritical = "AlgalonGPUTemperatureCritical"
+	AlertGPUMemorySaturated     = "AlgalonGPUMemorySaturated"
+	AlertGPUXIDError            = "AlgalonGPUXIDError"
+)
+
+// AlertGroups returns the GPU health alert pack for t. Each GPU condition
+// matches both the all-smi and the dcgm-exporter metric.
+func AlertGroups(t Thresholds) []Group {
+	temperature := func(threshold float64) string {
+		return anyOf(
+			fmt.Sprintf("all_smi_gpu_temperature_celsius > %g", threshold),
+			fmt.Sprintf("DCGM_FI_DEV_GPU_TEMP > %g", threshold),
+		)
+	}
+
+	return []Group{
+		{
+			Name: "algalon-targets",
+			Rules: []Rule{
+				{
+					Alert:  AlertTargetDown,
+					Expr:   "up == 0",
+					For:    duration(t.TargetDownFor),
+					Labels: map[string]string{"severity": SeverityCritical},
+					Annotations: map[string]string{
+						"summary":     "Target {{ $labels.instance }} is down",
+						"description": fmt.Sprintf("{{ $labels.job }} target {{ $labels.instance }} in cluster {{ $labels.cluster }} has failed every scrape for %s.", duration(t.TargetDownFor)),
+					},
+				},
+			},
+		},
+		{
+			Name: "algalon-gpu-health",
+			Rules: []Rule{
+				{
+					Alert:  AlertGPUTemperatureHigh,
+					Expr:   temperature(t.GPUTemperatureWarning),
+					For:    duration(t.For),
+					Labels: map[string]string{"severity": SeverityWarning},
+					Annotations: map[string]string{
+						"summary":     "GPU on {{ $labels.instance }} is running hot",
+						"description": fmt.Sprintf("GPU temperature on {{ $labels.instance }} is {{ $value }}°C, above %g°C.", t.GPUTemperatureWarning),
+					},
+				},
+				{
+					Alert:  AlertGPUTemperatureCritical,
+					Expr:   temperature(t.GPUTemperatureCritical),
+					For:    duration(t.For),
+					Labels: map[string]string{"severity": SeverityCritical},
+					Annotations: map[string]string{
+						"summary":     "GPU on {{ $labels.instance }} is overheating",
+						"description": fmt.Sprintf("GPU temperature on {{ $labels.instance }} is {{ $value }}°C, above %g°C. Expect thermal throttling.", t.GPUTemperatureCritical),
+					},
+				},
+				{
+					Alert: AlertGPUMemorySaturated,
+					Expr: anyOf(
+						fmt.Sprintf("100 * all_smi_gpu_memory_used_bytes / (all_smi_gpu_memory_total_bytes > 0) > %g", t.GPUMemoryPercent),
+						fmt.Sprintf("100 * DCGM_FI_DEV_FB_USED / ((DCGM_FI_DEV_FB_USED + DCGM_FI_DEV_FB_FREE) > 0) > %g", t.GPUMemoryPercent),
+					),
+					For:    duration(t.For),
+					Labels: map[string]string{"severity": SeverityWarning},
+					Annotations: map[string]string{
+						"summary":     "GPU memory on {{ $labels.instance }} is nearly full",
+						"description": fmt.Sprintf("GPU memory on {{ $labels.instance }} is {{ $value | printf \"%%.1f\" }}%% used, above %g%%.", t.GPUMemoryPercent),
+					},
+				},
+				{
+					// dcgm-exporter reports the last XID seen; any non-zero
+					// value is a driver-reported GPU fault.
+					Alert:  AlertGPUXIDError,
+					Expr:   "DCGM_FI_DEV_XID_ERRORS > 0",
+					Labels: map[string]string{"severity": SeverityCritical},
+					Annotations: map[string]string{
+						"summary":     "GPU {{ $labels.gpu }} on {{ $labels.instance }} reported an XID error",
+						"description": "GPU {{ $labels.gpu }} on {{ $labels.instance }} reported XID {{ $value }}. Check the driver logs; XIDs 48, 63, 64, 74 and 79 usually need a reset or replacement.",
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,147 @@
+package rules
+
+import "fmt"
+
+// DefaultRecordingFile is the recording rule file vmalert loads from
+// algalon_host/rules, next to DefaultAlertsFile.
+const DefaultRecordingFile = "algalon-recording.yml"
+
+// Recorded series in the pack, named level:metric:operation. The gpu level
+// merges all-smi and dcgm-exporter into one series per GPU, with memory in
+// bytes, so the instance and cluster levels cover workers running either or
+// both.
+const (
+	RecordGPUUtilization     = "gpu:utilization:percent"
+	RecordGPUMemoryUsed      = "gpu:memory_used:bytes"
+	RecordGPUMemoryTotal     = "gpu:memory_total:bytes"
+	RecordGPUMemoryUsedRatio = "gpu:memory_used:ratio"
+
+	RecordInstanceUtilizationAvg     = "instance:gpu_utilization:avg"
+	RecordInstanceUtilizationP95     = "instance:gpu_utilization:p95_1h"
+	RecordInstanceMemoryUsedRatio    = "instance:gpu_memory_used:ratio"
+	RecordInstanceMemoryUsedMaxRatio = "instance:gpu_memory_used:max_ratio"
+
+	RecordClusterUtilizationAvg     = "cluster:gpu_utilization:avg"
+	RecordClusterUtilizationP95     = "cluster:gpu_utilization:p95"
+	RecordClusterMemoryUsedRatio    = "cluster:gpu_memory_used:ratio"
+	RecordClusterMemoryUsedMaxRatio = "cluster:gpu_memory_used:max_ratio"
+	RecordClusterGPUs               = "cluster:gpus:count"
+)
+
+// mebibyte converts the dcgm-exporter framebuffer fields, reported in MiB.
+const mebibyte = 1 << 20
+
+// LabelHost is the instance without its port, added to the gpu-level series
+// so the exporters of one worker can be matched.
+const LabelHost = "host"
+
+// preferAllSmi merges the all-smi and dcgm-exporter expressions of one GPU
+// metric. A worker running both exporters reports each GPU twice, so the
+// dcgm-exporter series of a host are only used when all-smi reports none.
+func preferAllSmi(allSmi, dcgm string) string {
+	host := func(expr string) string {
+		return fmt.Sprintf(`label_replace(%s, "%s", "$1", "instance", "(.+?)(?::[0-9]+)?")`, expr, LabelHost)
+	}
+	return fmt.Sprintf("%s or on (cluster, %s) %s", host(allSmi), LabelHost, host(dcgm))
+}
+
+// RecordingGroups returns the recording rule pack. Dashboards and alerts
+// can query the instance and cluster aggregates instead of recomputing them
+// across every GPU series over the whole retention period.
+//
+// Each group reads the series recorded by the groups before it, so the
+// aggregates lag the raw series by at most one evaluation interval.
+func RecordingGroups() []Group {
+	// aggregate applies op by the given labels to a gpu-level series.
+	aggregate := func(op, by, series string) string {
+		return fmt.Sprintf("%s by (%s) (%s)", op, by, series)
+	}
+	// memoryRatio divides the used by the total GPU memory of each label set.
+	memoryRatio := func(by string) string {
+		return fmt.Sprintf("%s / (%s > 0)",
+			aggregate("sum", by, RecordGPUMemoryUsed),
+			aggregate("sum", by, RecordGPUMemoryTotal))
+	}
+
+	const instance, cluster = "cluster, instance", "cluster"
+
+	return []Group{
+		{
+			Name: "algalon-gpu-recording",
+			Rules: []Rule{
+				{
+					Record: RecordGPUUtilization,
+					Expr:   preferAllSmi("all_smi_gpu_utilization", "DCGM_FI_DEV_GPU_UTIL"),
+				},
+				{
+					Record: RecordGPUMemoryUsed,
+					Expr:   preferAllSmi("all_smi_gpu_memory_used_bytes", fmt.Sprintf("DCGM_FI_DEV_FB_USED * %d", mebibyte)),
+				},
+				{
+					Record: RecordGPUMemoryTotal,
+					Expr:   preferAllSmi("all_smi_gpu_memory_total_bytes", fmt.Sprintf("(DCGM_FI_DEV_FB_USED + DCGM_FI_DEV_FB_FREE) * %d", mebibyte)),
+				},
+				{
+					// A GPU reporting no total memory has no ratio rather
+					// than a division by zero.
+					Record: RecordGPUMemoryUsedRatio,
+					Expr: preferAllSmi(
+						"all_smi_gpu_memory_used_bytes / (all_smi_gpu_memory_total_bytes > 0)",
+						"DCGM_FI_DEV_FB_USED / ((DCGM_FI_DEV_FB_USED + DCGM_FI_DEV_FB_FREE) > 0)",
+					),
+				},
+			},
+		},
+		{
+			Name: "algalon-instance-recording",
+			Rules: []Rule{
+				{
+					Record: RecordInstanceUtilizationAvg,
+					Expr:   aggregate("avg", instance, RecordGPUUtilization),
+				},
+				{
+					// The hourly p95 of each GPU, averaged over the
+					// instance's GPUs.
+					Record: RecordInstanceUtilizationP95,
+					Expr:   aggregate("avg", instance, fmt.Sprintf("quantile_over_time(0.95, %s[1h])", RecordGPUUtilization)),
+				},
+				{
+					Record: RecordInstanceMemoryUsedRatio,
+					Expr:   memoryRatio(instance),
+				},
+				{
+					// Memory pressure of the fullest GPU, which is what
+					// runs out first.
+					Record: RecordInstanceMemoryUsedMaxRatio,
+					Expr:   aggregate("max", instance, RecordGPUMemoryUsedRatio),
+				},
+			},
+		},
+		{
+			Name: "algalon-cluster-recording",
+			Rules: []Rule{
+				{
+					Record: RecordClusterUtilizationAvg,
+					Expr:   aggregate("avg", cluster, RecordGPUUtilization),
+				},
+				{
+					// The utilization 95% of the cluster's GPUs stay below.
+					Record: RecordClusterUtilizationP95,
+					Expr:   fmt.Sprintf("quantile by (%s) (0.95, %s)", cluster, RecordGPUUtilization),
+				},
+				{
+					Record: RecordClusterMemoryUsedRatio,
+					Expr:   memoryRatio(cluster),
+				},
+				{
+					Record: RecordClusterMemoryUsedMaxRatio,
+					Expr:   aggregate("max", cluster, RecordGPUMemoryUsedRatio),
+				},
+				{
+					Record: RecordClusterGPUs,
+					Expr:   aggregate("count", cluster, RecordGPUUtilization),
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,191 @@
+// Package rules generates the vmalert rule files for the host stack. Rule
+// expressions are parsed as PromQL and the metrics they select are checked
+// against the metric catalog before anything is written, so a renamed
+// exporter metric fails generation instead of silently never alerting.
+package rules
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/appleparan/Algalon/pkg/catalog"
+	"github.com/appleparan/Algalon/pkg/dashboards"
+	"github.com/appleparan/Algalon/pkg/targets"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v3"
+)
+
+// Group is one vmalert rule group.
+type Group struct {
+	Name     string `yaml:"name"`
+	Interval string `yaml:"interval,omitempty"`
+	Rules    []Rule `yaml:"rules"`
+}
+
+// Rule is an alerting rule (Alert set) or a recording rule (Record set).
+type Rule struct {
+	Alert       string            `yaml:"alert,omitempty"`
+	Record      string            `yaml:"record,omitempty"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// Name returns the alert or recorded metric name.
+func (r Rule) Name() string {
+	if r.Alert != "" {
+		return r.Alert
+	}
+	return r.Record
+}
+
+// ForDuration returns the parsed For field, or 0 if it is empty.
+func (r Rule) ForDuration() (time.Duration, error) {
+	if r.For == "" {
+		return 0, nil
+	}
+	d, err := model.ParseDuration(r.For)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(d), nil
+}
+
+type file struct {
+	Groups []Group `yaml:"groups"`
+}
+
+const fileHeader = `# %s
+# vmalert rules generated by Algalon from the host configuration; manual
+# edits are overwritten by the next algalonctl rules generate.
+
+`
+
+// duration formats d the way rule files write durations, e.g. 5m.
+func duration(d time.Duration) string {
+	return model.Duration(d).String()
+}
+
+// Validate checks that every rule has exactly one of alert and record, a
+// unique name within its group, valid label names and a PromQL expression
+// whose metrics are in c or recorded by another rule in groups.
+func Validate(groups []Group, c *catalog.Catalog) error {
+	recorded := map[string]bool{}
+	for _, group := range groups {
+		for _, rule := range group.Rules {
+			if rule.Record != "" {
+				recorded[rule.Record] = true
+			}
+		}
+	}
+
+	groupNames := map[string]bool{}
+	for _, group := range groups {
+		if group.Name == "" {
+			return fmt.Errorf("rule group has no name")
+		}
+		if groupNames[group.Name] {
+			return fmt.Errorf("duplicate rule group %s", group.Name)
+		}
+		groupNames[group.Name] = true
+		if len(group.Rules) == 0 {
+			return fmt.Errorf("rule group %s has no rules", group.Name)
+		}
+
+		seen := map[string]bool{}
+		for i, rule := range group.Rules {
+			if (rule.Alert == "") == (rule.Record == "") {
+				return fmt.Errorf("rule group %s: rule %d must set exactly one of alert and record", group.Name, i)
+			}
+			name := rule.Name()
+			if rule.Alert != "" && seen[name] {
+				return fmt.Errorf("rule group %s: duplicate alert %s", group.Name, name)
+			}
+			seen[name] = true
+
+			if err := validateRule(rule, c, recorded); err != nil {
+				return fmt.Errorf("rule group %s: %s: %v", group.Name, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func validateRule(rule Rule, c *catalog.Catalog, recorded map[string]bool) error {
+	if rule.Record != "" && !model.IsValidMetricName(model.LabelValue(rule.Record)) {
+		return fmt.Errorf("invalid metric name")
+	}
+	if rule.Record != "" && (rule.For != "" || len(rule.Annotations) > 0) {
+		return fmt.Errorf("recording rules take no for or annotations")
+	}
+	if _, err := rule.ForDuration(); err != nil {
+		return fmt.Errorf("invalid for %q: %v", rule.For, err)
+	}
+	for name := range rule.Labels {
+		if err := targets.ValidateLabelName(name); err != nil {
+			return err
+		}
+	}
+
+	names, err := dashboards.MetricNames(rule.Expr)
+	if err != nil {
+		return fmt.Errorf("invalid PromQL %q: %v", rule.Expr, err)
+	}
+	for _, name := range names {
+		if _, _, ok := c.Lookup(name); !ok && !recorded[name] {
+			return fmt.Errorf("unknown metric %s (not in metric catalog v%d)", name, c.Version)
+		}
+	}
+	return nil
+}
+
+// Render validates groups against the default catalog and encodes them as
+// a vmalert rule file. name is the base name of the destination file.
+func Render(name string, groups []Group) ([]byte, error) {
+	if err := Validate(groups, catalog.Default()); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, fileHeader, name)
+
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(file{Groups: groups}); err != nil {
+		return nil, fmt.Errorf("failed to encode rule groups: %v", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode rule groups: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Parse decodes a rule file.
+func Parse(data []byte) ([]Group, error) {
+	var f file
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&f); err != nil {
+		return nil, fmt.Errorf("invalid rule file: %v", err)
+	}
+	return f.Groups, nil
+}
+
+// WriteFile renders groups and atomically replaces path with the result.
+func WriteFile(path string, groups []Group) error {
+	data, err := Render(filepath.Base(path), groups)
+	if err != nil {
+		return err
+	}
+	return targets.WriteAtomic(path, data)
+}
+
+// anyOf joins alternative expressions, e.g. the all-smi and dcgm-exporter
+// form of the same condition, so one rule covers workers running either.
+func anyOf(exprs ...string) string {
+	return strings.Join(exprs, " or ")
+}
@@ -0,0 +1,174 @@
+// Package scrape renders the vmagent scrape configuration, prometheus.yml,
+// with one job per exporter kind. Each job reads the file_sd target files
+// of its exporter, so adding dcgm-exporter workers only needs a dcgm-*.yml
+// next to the all-smi-*.yml files.
+package scrape
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/appleparan/Algalon/pkg/targets"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v3"
+)
+
+// Defaults matching algalon_host/docker-compose.yml. DefaultScrapeInterval
+// is the VMAGENT_SCRAPE_INTERVAL default, the all-smi job's interval;
+// DefaultGlobalScrapeInterval is what jobs without their own interval use.
+const (
+	DefaultFileName             = "prometheus.yml"
+	DefaultTargetsDir           = "/etc/prometheus/targets"
+	DefaultScrapeInterval       = 5 * time.Second
+	DefaultGlobalScrapeInterval = 15 * time.Second
+)
+
+// Config is the part of the Prometheus scrape configuration Algalon uses.
+type Config struct {
+	Global        Global         `yaml:"global"`
+	ScrapeConfigs []ScrapeConfig `yaml:"scrape_configs"`
+}
+
+// Global holds the settings every job inherits.
+type Global struct {
+	ScrapeInterval model.Duration `yaml:"scrape_interval"`
+	ScrapeTimeout  model.Duration `yaml:"scrape_timeout,omitempty"`
+}
+
+// ScrapeConfig is one scrape job.
+type ScrapeConfig struct {
+	JobName        string         `yaml:"job_name"`
+	FileSDConfigs  []FileSDConfig `yaml:"file_sd_configs"`
+	ScrapeInterval model.Duration `yaml:"scrape_interval,omitempty"`
+	ScrapeTimeout  model.Duration `yaml:"scrape_timeout,omitempty"`
+	MetricsPath    string         `yaml:"metrics_path,omitempty"`
+	// RelabelConfigs rewrite target labels before the scrape,
+	// MetricRelabelConfigs rewrite or drop scraped series.
+	RelabelConfigs       []RelabelConfig `yaml:"relabel_configs,omitempty"`
+	MetricRelabelConfigs []RelabelConfig `yaml:"metric_relabel_configs,omitempty"`
+}
+
+// FileSDConfig lists the target files of a job; globs are allowed.
+type FileSDConfig struct {
+	Files []string `yaml:"files"`
+}
+
+// MetadataRelabelConfig maps the __meta_algalon_* labels of the target
+// files, e.g. __meta_algalon_gpu_type, onto series labels such as gpu_type.
+// Every rendered job starts with it; a later labeldrop rule removes
+// metadata a job should not carry.
+func MetadataRelabelConfig() RelabelConfig {
+	return RelabelConfig{
+		Regex:  regexp.QuoteMeta(targets.MetaLabelPrefix) + "(" + strings.Join(targets.MetadataLabelNames(), "|") + ")",
+		Action: "labelmap",
+	}
+}
+
+// Validate checks that there is at least one job, that job names are
+// unique, that every job reads some target files, that no scrape timeout
+// exceeds its interval and that every relabel rule is well formed.
+func (c Config) Validate() error {
+	if len(c.ScrapeConfigs) == 0 {
+		return fmt.Errorf("scrape configuration has no jobs")
+	}
+	global := c.Global.ScrapeInterval
+	if global == 0 {
+		global = model.Duration(time.Minute) // the Prometheus default
+	}
+	if c.Global.ScrapeTimeout > global {
+		return fmt.Errorf("global scrape_timeout %s exceeds scrape_interval %s", c.Global.ScrapeTimeout, global)
+	}
+
+	jobs := map[string]bool{}
+	for i, job := range c.ScrapeConfigs {
+		if job.JobName == "" {
+			return fmt.Errorf("scrape job %d has no job_name", i)
+		}
+		if jobs[job.JobName] {
+			return fmt.Errorf("scrape job %q is defined twice", job.JobName)
+		}
+		jobs[job.JobName] = true
+
+		files := 0
+		for _, sd := range job.FileSDConfigs {
+			files += len(sd.Files)
+		}
+		if files == 0 {
+			return fmt.Errorf("scrape job %q reads no target files", job.JobName)
+		}
+
+		// vmagent refuses a timeout longer than the interval; an unset
+		// timeout is the global one, capped at the job's interval.
+		interval := job.ScrapeInterval
+		if interval == 0 {
+			interval = global
+		}
+		if job.ScrapeTimeout > interval {
+			return fmt.Errorf("scrape job %q: scrape_timeout %s exceeds scrape_interval %s", job.JobName, job.ScrapeTimeout, interval)
+		}
+
+		for j, rc := range job.RelabelConfigs {
+			if err := rc.Validate(); err != nil {
+				return fmt.Errorf("scrape job %q: relabel_configs[%d]: %v", job.JobName, j, err)
+			}
+		}
+		for j, rc := range job.MetricRelabelConfigs {
+			if err := rc.Validate(); err != nil {
+				return fmt.Errorf("scrape job %q: metric_relabel_configs[%d]: %v", job.JobName, j, err)
+			}
+		}
+	}
+	return nil
+}
+
+const fileHeader = `# prometheus.yml
+# vmagent scrape configuration generated by Algalon, one job per exporter;
+# manual edits are overwritten by the next algalonctl scrape generate.
+
+`
+
+// Render validates cfg and encodes it as YAML.
+func Render(cfg Config) ([]byte, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(fileHeader)
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(cfg); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Parse decodes a scrape configuration written by Render. Unknown fields
+// are rejected.
+func Parse(data []byte) (Config, error) {
+	var cfg Config
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&cfg); err != nil {
+		return Config{}, fmt.Errorf("invalid scrape configuration: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// WriteFile renders cfg and atomically writes it to path.
+func WriteFile(path string, cfg Config) error {
+	data, err := Render(cfg)
+	if err != nil {
+		return err
+	}
+	return targets.WriteAtomic(path, data)
+}
@@ -0,0 +1,200 @@
+package scrape
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+
+	"github.com/appleparan/Algalon/pkg/targets"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v3"
+)
+
+// Settings are the typed inputs the scrape configuration is rendered from,
+// normally built from the host env file by config.HostFromEnv. They can
+// also be read from YAML, e.g. examples/scrape/relabel-settings.yml.
+type Settings struct {
+	// ScrapeInterval is the global interval; zero is
+	// DefaultGlobalScrapeInterval.
+	ScrapeInterval model.Duration `yaml:"scrape_interval"`
+	// TargetsDir is where vmagent sees the target files; empty is
+	// DefaultTargetsDir.
+	TargetsDir string        `yaml:"targets_dir"`
+	Jobs       []JobSettings `yaml:"jobs"`
+}
+
+// JobSettings configure the scrape job of one exporter. Zero values use
+// the exporter's defaults.
+type JobSettings struct {
+	Exporter       string         `yaml:"exporter"`
+	ScrapeInterval model.Duration `yaml:"scrape_interval"`
+	// ScrapeTimeout defaults to the exporter's timeout, capped at the
+	// interval. An explicit timeout longer than the interval is an error.
+	ScrapeTimeout model.Duration `yaml:"scrape_timeout"`
+	// MinInterval is how often the exporter collects, e.g. ALL_SMI_INTERVAL;
+	// scraping faster only returns the same samples again.
+	MinInterval model.Duration `yaml:"min_interval"`
+	MetricsPath string         `yaml:"metrics_path"`
+	// RelabelConfigs run after MetadataRelabelConfig.
+	RelabelConfigs       []RelabelConfig `yaml:"relabel_configs"`
+	MetricRelabelConfigs []RelabelConfig `yaml:"metric_relabel_configs"`
+}
+
+// DefaultSettings scrape every exporter with its defaults.
+func DefaultSettings() Settings {
+	var s Settings
+	for _, exporter := range targets.Exporters() {
+		s.Jobs = append(s.Jobs, JobSettings{Exporter: exporter.Name})
+	}
+	return s
+}
+
+// Config validates the settings and returns the scrape configuration.
+func (s Settings) Config() (Config, error) {
+	global := s.ScrapeInterval
+	if global == 0 {
+		global = model.Duration(DefaultGlobalScrapeInterval)
+	}
+	targetsDir := s.TargetsDir
+	if targetsDir == "" {
+		targetsDir = DefaultTargetsDir
+	}
+	if !path.IsAbs(targetsDir) {
+		return Config{}, fmt.Errorf("targets_dir %q must be an absolute path inside the vmagent container", targetsDir)
+	}
+
+	cfg := Config{Global: Global{ScrapeInterval: global}}
+	for _, js := range s.Jobs {
+		job, err := js.config(targetsDir)
+		if err != nil {
+			return Config{}, fmt.Errorf("scrape job %s: %v", js.Exporter, err)
+		}
+		cfg.ScrapeConfigs = append(cfg.ScrapeConfigs, job)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+func (js JobSettings) config(targetsDir string) (ScrapeConfig, error) {
+	if js.Exporter == "" {
+		return ScrapeConfig{}, fmt.Errorf("no exporter")
+	}
+	exporter, err := targets.LookupExporter(js.Exporter)
+	if err != nil {
+		return ScrapeConfig{}, err
+	}
+
+	interval := js.ScrapeInterval
+	if interval == 0 {
+		interval = model.Duration(exporter.ScrapeInterval)
+	}
+	if interval < js.MinInterval {
+		return ScrapeConfig{}, fmt.Errorf("scrape_interval %s is shorter than the exporter's collection interval %s; every other scrape would return the same samples", interval, js.MinInterval)
+	}
+
+	timeout := js.ScrapeTimeout
+	if timeout == 0 {
+		timeout = min(model.Duration(exporter.ScrapeTimeout), interval)
+	}
+
+	metricsPath := js.MetricsPath
+	if metricsPath == "" {
+		metricsPath = exporter.MetricsPath
+	}
+
+	job := ScrapeConfig{
+		JobName:              exporter.Name,
+		FileSDConfigs:        []FileSDConfig{{Files: []string{path.Join(targetsDir, exporter.Glob())}}},
+		ScrapeInterval:       interval,
+		ScrapeTimeout:        timeout,
+		MetricsPath:          metricsPath,
+		RelabelConfigs:       append([]RelabelConfig{MetadataRelabelConfig()}, js.RelabelConfigs...),
+		MetricRelabelConfigs: js.MetricRelabelConfigs,
+	}
+	return job, nil
+}
+
+// LoadSettings reads YAML settings. Unknown fields are rejected and the
+// settings are validated.
+func LoadSettings(path string) (Settings, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Settings{}, err
+	}
+
+	var s Settings
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&s); err != nil {
+		return Settings{}, fmt.Errorf("%s: invalid scrape settings: %v", path, err)
+	}
+	if _, err := s.Config(); err != nil {
+		return Settings{}, fmt.Errorf("%s: %v", path, err)
+	}
+	return s, nil
+}
+
+// RelabelConfig is one relabel_configs or metric_relabel_configs rule.
+type RelabelConfig struct {
+	SourceLabels []string `yaml:"source_labels,flow,omitempty"`
+	Separator    string   `yaml:"separator,omitempty"`
+	Regex        string   `yaml:"regex,omitempty"`
+	Modulus      uint64   `yaml:"modulus,omitempty"`
+	TargetLabel  string   `yaml:"target_label,omitempty"`
+	Replacement  string   `yaml:"replacement,omitempty"`
+	Action       string   `yaml:"action,omitempty"`
+}
+
+// relabelActions are the Prometheus relabel actions; vmagent supports all
+// of them.
+var relabelActions = map[string]bool{
+	"replace": true, "keep": true, "drop": true, "keepequal": true, "dropequal": true,
+	"hashmod": true, "labelmap": true, "labeldrop": true, "labelkeep": true,
+	"lowercase": true, "uppercase": true,
+}
+
+// Validate checks the action, the regex and the fields the action needs.
+func (r RelabelConfig) Validate() error {
+	action := r.Action
+	if action == "" {
+		action = "replace"
+	}
+	if !relabelActions[action] {
+		return fmt.Errorf("unknown relabel action %q", r.Action)
+	}
+	// Prometheus anchors the regex at both ends.
+	if _, err := regexp.Compile("^(?:" + r.Regex + ")$"); err != nil {
+		return fmt.Errorf("invalid relabel regex %q: %v", r.Regex, err)
+	}
+	for _, name := range r.SourceLabels {
+		if !model.LabelName(name).IsValid() {
+			return fmt.Errorf("invalid source label %q", name)
+		}
+	}
+
+	switch action {
+	case "replace", "hashmod", "lowercase", "uppercase", "keepequal", "dropequal":
+		if r.TargetLabel == "" {
+			return fmt.Errorf("relabel action %s needs a target_label", action)
+		}
+	case "labelmap", "labeldrop", "labelkeep":
+		if r.Regex == "" {
+			return fmt.Errorf("relabel action %s needs a regex", action)
+		}
+	}
+	switch action {
+	case "keep", "drop", "hashmod", "lowercase", "uppercase", "keepequal", "dropequal":
+		if len(r.SourceLabels) == 0 {
+			return fmt.Errorf("relabel action %s needs source_labels", action)
+		}
+	}
+	if action == "hashmod" && r.Modulus == 0 {
+		return fmt.Errorf("relabel action hashmod needs a modulus")
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package targets
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Defaults shared with generate-targets.sh and algalon_host/setup.sh.
+const (
+	DefaultCluster     = "production"
+	DefaultEnvironment = "gpu-cluster"
+	DefaultPort        = 9090
+
+	// DefaultFileName is the file_sd file generate-targets.sh writes. It
+	// matches the /etc/prometheus/targets/all-smi-*.yml glob in prometheus.yml.
+	DefaultFileName = "all-smi-targets.yml"
+)
+
+// ErrNoTargets is returned when ALGALON_TARGETS is empty.
+var ErrNoTargets = errors.New("no targets specified; use ALGALON_TARGETS or --targets")
+
+// Config holds the generator settings normally supplied through the
+// ALGALON_* environment variables.
+type Config struct {
+	Targets     string
+	Cluster     string
+	Environment string
+	DefaultPort int
+	// Exporter names the exporter the targets run; empty means all-smi.
+	Exporter string
+
+	// Metadata labels every target. With InstancePrefix the targets are
+	// labelled <prefix>-1, <prefix>-2, ... in order, the way the
+	// algalon-worker module names its instances.
+	Metadata       Metadata
+	InstancePrefix string
+}
+
+// ConfigFromEnv reads ALGALON_TARGETS, ALGALON_CLUSTER, ALGALON_ENVIRONMENT,
+// ALGALON_EXPORTER and ALGALON_DEFAULT_PORT through lookup, applying the
+// script defaults for anything unset. The default port is the exporter's.
+// The metadata comes from ALGALON_GPU_TYPE, ALGALON_GPUS_PER_INSTANCE,
+// ALGALON_ZONE, ALGALON_PREEMPTIBLE and ALGALON_INSTANCE_PREFIX.
+// Pass os.LookupEnv to read the process environment.
+func ConfigFromEnv(lookup func(string) (string, bool)) (Config, error) {
+	if lookup == nil {
+		lookup = os.LookupEnv
+	}
+
+	cfg := Config{
+		Cluster:     DefaultCluster,
+		Environment: DefaultEnvironment,
+		DefaultPort: DefaultPort,
+	}
+
+	if value, ok := lookup("ALGALON_TARGETS"); ok {
+		cfg.Targets = value
+	}
+	if value, ok := lookup("ALGALON_CLUSTER"); ok && strings.TrimSpace(value) != "" {
+		cfg.Cluster = strings.TrimSpace(value)
+	}
+	if value, ok := lookup("ALGALON_ENVIRONMENT"); ok && strings.TrimSpace(value) != "" {
+		cfg.Environment = strings.TrimSpace(value)
+	}
+	if value, ok := lookup("ALGALON_EXPORTER"); ok && strings.TrimSpace(value) != "" {
+		exporter, err := LookupExporter(strings.TrimSpace(value))
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid ALGALON_EXPORTER: %v", err)
+		}
+		cfg.Exporter = exporter.Name
+		cfg.DefaultPort = exporter.Port
+	}
+	if value, ok := lookup("ALGALON_DEFAULT_PORT"); ok && strings.TrimSpace(value) != "" {
+		port, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid ALGALON_DEFAULT_PORT %q: not a number", value)
+		}
+		cfg.DefaultPort = port
+	}
+
+	if value, ok := lookup("ALGALON_GPU_TYPE"); ok {
+		cfg.Metadata.GPUType = strings.TrimSpace(value)
+	}
+	if value, ok := lookup("ALGALON_GPUS_PER_INSTANCE"); ok && strings.TrimSpace(value) != "" {
+		count, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid ALGALON_GPUS_PER_INSTANCE %q: not a number", value)
+		}
+		cfg.Metadata.GPUsPerInstance = count
+	}
+	if value, ok := lookup("ALGALON_ZONE"); ok {
+		cfg.Metadata.Zone = strings.TrimSpace(value)
+	}
+	if value, ok := lookup("ALGALON_PREEMPTIBLE"); ok && strings.TrimSpace(value) != "" {
+		preemptible, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid ALGALON_PREEMPTIBLE %q: expected true or false", value)
+		}
+		cfg.Metadata.Preemptible = strconv.FormatBool(preemptible)
+	}
+	if value, ok := lookup("ALGALON_INSTANCE_PREFIX"); ok {
+		cfg.InstancePrefix = strings.TrimSpace(value)
+	}
+
+	return cfg, nil
+}
+
+// Groups validates the configuration and returns the single target group
+// generate-targets.sh would have produced, labelled for the exporter and
+// with the metadata labels. With an instance prefix every target gets a
+// group of its own, since each carries its own instance_name.
+func (c Config) Groups() ([]Group, error) {
+	exporter, err := LookupExporter(c.Exporter)
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidatePort(c.DefaultPort); err != nil {
+		return nil, fmt.Errorf("invalid default port: %v", err)
+	}
+	if c.Cluster == "" {
+		return nil, fmt.Errorf("cluster name must not be empty")
+	}
+	if c.Environment == "" {
+		return nil, fmt.Errorf("environment name must not be empty")
+	}
+
+	if err := c.Metadata.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid target metadata: %v", err)
+	}
+
+	parsed, err := ParseTargets(c.Targets, c.DefaultPort)
+	if err != nil {
+		return nil, err
+	}
+	if c.Metadata.InstanceName != "" && (len(parsed) > 1 || c.InstancePrefix != "") {
+		return nil, fmt.Errorf("instance name %q can only label a single target; use an instance prefix", c.Metadata.InstanceName)
+	}
+
+	labels := func(metadata Metadata) map[string]string {
+		labels := exporter.Labels(c.Cluster, c.Environment)
+		for name, value := range metadata.Labels() {
+			labels[name] = value
+		}
+		return labels
+	}
+
+	if c.InstancePrefix == "" {
+		group := Group{Labels: labels(c.Metadata)}
+		for _, target := range parsed {
+			group.Targets = append(group.Targets, target.String())
+		}
+		return []Group{group}, nil
+	}
+
+	groups := make([]Group, 0, len(parsed))
+	for i, target := range parsed {
+		metadata := c.Metadata
+		metadata.InstanceName = fmt.Sprintf("%s-%d", c.InstancePrefix, i+1)
+		if err := metadata.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid instance prefix: %v", err)
+		}
+		groups = append(groups, Group{Targets: []string{target.String()}, Labels: labels(metadata)})
+	}
+	return groups, nil
+}
+
+// FileName returns the target file the configuration is written to,
+// all-smi-targets.yml or dcgm-targets.yml, so each exporter's scrape job
+// finds it.
+func (c Config) FileName() string {
+	exporter, err := LookupExporter(c.Exporter)
+	if err != nil {
+		return DefaultFileName
+	}
+	return exporter.DefaultFileName()
+}
+
+// DefaultLabels returns the labels every all-smi target group carries.
+func DefaultLabels(cluster, environment string) map[string]string {
+	return AllSmi.Labels(cluster, environment)
+}
+
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// ValidateLabelName reports whether name is a usable Prometheus label name.
+// Names starting with "__" are reserved for internal use and rejected.
+func ValidateLabelName(name string) error {
+	if !labelNamePattern.MatchString(name) || strings.HasPrefix(name, "__") {
+		return fmt.Errorf("invalid label name %q", name)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package targets
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Exporter is a kind of metrics exporter running on the workers. Each kind
+// is scraped by its own vmagent job, which reads the <FilePrefix>-*.yml
+// target files, so all-smi and dcgm-exporter targets never mix.
+type Exporter struct {
+	// Name is the job name and the job label of every target group.
+	Name string
+	// FilePrefix starts the name of every target file of this exporter.
+	FilePrefix     string
+	Port           int
+	ScrapeInterval time.Duration
+	ScrapeTimeout  time.Duration
+	MetricsPath    string
+	// MonitoringType is the monitoring_type label of every target group.
+	MonitoringType string
+	// Description names what the exporter monitors in file headers.
+	Description string
+}
+
+// The supported exporters.
+var (
+	// AllSmi is the default exporter; its interval matches the default
+	// ALL_SMI_INTERVAL. The timeout is capped at the interval when rendered.
+	AllSmi = Exporter{
+		Name:           "all-smi",
+		FilePrefix:     "all-smi",
+		Port:           DefaultPort,
+		ScrapeInterval: 5 * time.Second,
+		ScrapeTimeout:  10 * time.Second,
+		MetricsPath:    "/metrics",
+		MonitoringType: "comprehensive", // all-smi provides GPU+CPU+Memory
+		Description:    "all-smi GPU/CPU",
+	}
+
+	// DCGMExporter is NVIDIA's dcgm-exporter on its standard port. DCGM
+	// collects fields every 30s by default, so scraping faster gains nothing.
+	DCGMExporter = Exporter{
+		Name:           "dcgm-exporter",
+		FilePrefix:     "dcgm",
+		Port:           9400,
+		ScrapeInterval: 15 * time.Second,
+		ScrapeTimeout:  10 * time.Second,
+		MetricsPath:    "/metrics",
+		MonitoringType: "gpu",
+		Description:    "dcgm-exporter GPU",
+	}
+)
+
+// Exporters returns every supported exporter, all-smi first.
+func Exporters() []Exporter {
+	return []Exporter{AllSmi, DCGMExporter}
+}
+
+// LookupExporter returns the exporter called name. An empty name is all-smi.
+func LookupExporter(name string) (Exporter, error) {
+	if name == "" {
+		return AllSmi, nil
+	}
+	names := make([]string, 0, len(Exporters()))
+	for _, exporter := range Exporters() {
+		if exporter.Name == name {
+			return exporter, nil
+		}
+		names = append(names, exporter.Name)
+	}
+	return Exporter{}, fmt.Errorf("unknown exporter %q: expected %s", name, strings.Join(names, " or "))
+}
+
+// exporterOf returns the exporter whose target files are named like name,
+// falling back to all-smi.
+func exporterOf(name string) Exporter {
+	for _, exporter := range Exporters() {
+		if strings.HasPrefix(name, exporter.FilePrefix+"-") {
+			return exporter
+		}
+	}
+	return AllSmi
+}
+
+// Glob matches the target files of the exporter, e.g. all-smi-*.yml.
+func (e Exporter) Glob() string {
+	return e.FilePrefix + "-*.yml"
+}
+
+// FileName returns the target file of cluster, e.g. dcgm-training.yml.
+func (e Exporter) FileName(cluster string) string {
+	return e.FilePrefix + "-" + cluster + ".yml"
+}
+
+// DefaultFileName returns the single target file written without a spec,
+// e.g. all-smi-targets.yml.
+func (e Exporter) DefaultFileName() string {
+	return e.FileName("targets")
+}
+
+// Labels returns the labels every target group of the exporter carries.
+func (e Exporter) Labels(cluster, environment string) map[string]string {
+	return map[string]string{
+		"job":             e.Name,
+		"cluster":         cluster,
+		"environment":     environment,
+		"monitoring_type": e.MonitoringType,
+	}
+}
@@ -0,0 +1,161 @@
+package targets
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Group is one entry of a Prometheus file_sd target file.
+type Group struct {
+	Targets []string          `yaml:"targets"`
+	Labels  map[string]string `yaml:"labels,omitempty"`
+}
+
+const fileHeader = `# targets/%s
+# Configuration for %s monitoring worker nodes
+# This file is auto-generated by Algalon; manual edits may be overwritten
+
+`
+
+// Render encodes groups as file_sd YAML, prefixed with a header comment like
+// the one generate-targets.sh copied from the template. name is the base name
+// of the file the output is destined for.
+func Render(name string, groups []Group) ([]byte, error) {
+	if err := ValidateGroups(groups); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, fileHeader, name, exporterOf(name).Description)
+
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(groups); err != nil {
+		return nil, fmt.Errorf("failed to encode target groups: %v", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode target groups: %v", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Parse decodes file_sd YAML and validates every group in it.
+func Parse(data []byte) ([]Group, error) {
+	var groups []Group
+	if err := yaml.Unmarshal(data, &groups); err != nil {
+		return nil, fmt.Errorf("invalid file_sd YAML: %v", err)
+	}
+
+	if err := ValidateGroups(groups); err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+// ReadFile reads and parses a file_sd target file.
+func ReadFile(path string) ([]Group, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return groups, nil
+}
+
+// WriteFile renders groups and atomically replaces path with the result, so
+// VMAgent never reads a half-written file during its fileSDCheckInterval.
+func WriteFile(path string, groups []Group) error {
+	data, err := Render(filepath.Base(path), groups)
+	if err != nil {
+		return err
+	}
+
+	return WriteAtomic(path, data)
+}
+
+// WriteAtomic replaces path with data through a temporary file in the same
+// directory, creating the directory if needed.
+func WriteAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// ValidateGroups checks that every group has at least one valid target and
+// valid label names or __meta_algalon_* metadata labels, and that no target
+// appears twice across groups. A group may not set a metadata label both
+// directly and through its __meta_algalon_* label, e.g. gpu_type and
+// __meta_algalon_gpu_type, since the labelmap rule would silently pick one.
+func ValidateGroups(groups []Group) error {
+	seen := map[string]bool{}
+
+	for i, group := range groups {
+		if len(group.Targets) == 0 {
+			return fmt.Errorf("target group %d has no targets", i)
+		}
+
+		for name := range group.Labels {
+			if err := ValidateGroupLabelName(name); err != nil {
+				return fmt.Errorf("target group %d: %v", i, err)
+			}
+		}
+		for _, name := range MetadataLabelNames() {
+			_, plain := group.Labels[name]
+			_, meta := group.Labels[MetaLabel(name)]
+			if plain && meta {
+				return fmt.Errorf("target group %d: label %s is set both directly and as %s", i, name, MetaLabel(name))
+			}
+		}
+
+		for _, raw := range group.Targets {
+			// An explicit port is required inside a target file.
+			target, err := ParseTarget(raw, 0)
+			if err != nil {
+				return fmt.Errorf("target group %d: %v", i, err)
+			}
+
+			key := target.String()
+			if seen[key] {
+				return fmt.Errorf("target group %d: duplicate target %q", i, key)
+			}
+			seen[key] = true
+		}
+	}
+
+	return nil
+}
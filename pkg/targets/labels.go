@@ -0,0 +1,64 @@
+package targets
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// LabelsKey returns a string identifying a label set, e.g. to merge targets
+// with the same labels into one group.
+func LabelsKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s=%q,", name, labels[name])
+	}
+	return b.String()
+}
+
+// SameLabels reports whether a and b hold the same labels.
+func SameLabels(a, b map[string]string) bool {
+	return LabelsKey(a) == LabelsKey(b)
+}
+
+// CopyLabels returns a copy of labels that is never nil.
+func CopyLabels(labels map[string]string) map[string]string {
+	copied := make(map[string]string, len(labels))
+	for name, value := range labels {
+		copied[name] = value
+	}
+	return copied
+}
+
+// GroupByLabels builds one group per distinct label set from the labels of
+// each target. Groups are ordered by label set and their targets sorted, so
+// the same targets always render the same file.
+func GroupByLabels(labels map[string]map[string]string) []Group {
+	byLabels := map[string]*Group{}
+	var keys []string
+	for target, set := range labels {
+		key := LabelsKey(set)
+		group, ok := byLabels[key]
+		if !ok {
+			group = &Group{Labels: set}
+			byLabels[key] = group
+			keys = append(keys, key)
+		}
+		group.Targets = append(group.Targets, target)
+	}
+
+	sort.Strings(keys)
+	groups := make([]Group, 0, len(keys))
+	for _, key := range keys {
+		group := byLabels[key]
+		sort.Strings(group.Targets)
+		groups = append(groups, *group)
+	}
+	return groups
+}
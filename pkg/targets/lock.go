@@ -0,0 +1,33 @@
+package targets
+
+import (
+	"fmt"
+	"os"
+)
+
+// LockDir takes an exclusive advisory lock on a target directory. Every
+// Algalon process that writes target files in it, such as algalon-registry,
+// algalon-reaper, the generators, GCE discovery and history rollbacks, holds
+// it for the whole update, so one cannot overwrite the other's change. It
+// blocks until the lock is free and returns the function releasing it. The
+// lock is not reentrant: the functions writing files do not take it.
+//
+// The directory itself is locked rather than a file in it: WriteFile
+// replaces the target files, and a lock file would sit next to them.
+func LockDir(dir string) (unlock func(), err error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock %s: %v", dir, err)
+	}
+
+	// Closing the directory releases the lock.
+	return func() { f.Close() }, nil
+}
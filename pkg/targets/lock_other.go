@@ -0,0 +1,10 @@
+//go:build !unix
+
+package targets
+
+import "os"
+
+// lockFile is a no-op where flock is unavailable; the host runs Linux.
+func lockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,111 @@
+package targets
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MetaLabelPrefix starts the metadata labels a target file may carry, e.g.
+// __meta_algalon_gpu_type. Labels starting with "__" are dropped after
+// relabeling, so metadata only reaches the series through the labelmap rule
+// the scrape package adds to every job.
+const MetaLabelPrefix = "__meta_algalon_"
+
+// Metadata label names as they appear on the series.
+const (
+	LabelGPUType         = "gpu_type"
+	LabelGPUsPerInstance = "gpus_per_instance"
+	LabelZone            = "zone"
+	LabelInstanceName    = "instance_name"
+	LabelPreemptible     = "preemptible"
+)
+
+// MetadataLabelNames returns the metadata label names, in the order the
+// algalon-worker Terraform module knows them.
+func MetadataLabelNames() []string {
+	return []string{LabelGPUType, LabelGPUsPerInstance, LabelZone, LabelInstanceName, LabelPreemptible}
+}
+
+// MetaLabel returns the target file label carrying the metadata label name.
+func MetaLabel(name string) string {
+	return MetaLabelPrefix + name
+}
+
+// Metadata describes the instance behind a target. Zero values are unknown
+// and left out of the labels.
+type Metadata struct {
+	GPUType         string // e.g. nvidia-tesla-t4
+	GPUsPerInstance int
+	Zone            string
+	InstanceName    string
+	Preemptible     string // "true", "false" or empty
+}
+
+var gcePattern = regexp.MustCompile(`^[a-z]([-a-z0-9]*[a-z0-9])?$`)
+
+// Validate checks the values against the GCE naming rules the Terraform
+// modules follow.
+func (m Metadata) Validate() error {
+	for _, field := range []struct{ name, value string }{
+		{"GPU type", m.GPUType},
+		{"zone", m.Zone},
+		{"instance name", m.InstanceName},
+	} {
+		if field.value != "" && !gcePattern.MatchString(field.value) {
+			return fmt.Errorf("invalid %s %q: expected lowercase letters, digits and hyphens", field.name, field.value)
+		}
+	}
+	if m.GPUsPerInstance < 0 {
+		return fmt.Errorf("invalid GPUs per instance %d: must not be negative", m.GPUsPerInstance)
+	}
+	if m.Preemptible != "" && m.Preemptible != "true" && m.Preemptible != "false" {
+		return fmt.Errorf("invalid preemptible %q: expected true or false", m.Preemptible)
+	}
+	return nil
+}
+
+// Labels returns the known metadata as __meta_algalon_* labels.
+func (m Metadata) Labels() map[string]string {
+	labels := map[string]string{}
+	set := func(name, value string) {
+		if value != "" {
+			labels[MetaLabel(name)] = value
+		}
+	}
+	set(LabelGPUType, m.GPUType)
+	if m.GPUsPerInstance > 0 {
+		set(LabelGPUsPerInstance, strconv.Itoa(m.GPUsPerInstance))
+	}
+	set(LabelZone, m.Zone)
+	set(LabelInstanceName, m.InstanceName)
+	set(LabelPreemptible, m.Preemptible)
+	return labels
+}
+
+// MetadataSummary lists the metadata labels the groups of c carry as
+// "name: value" lines, in MetadataLabelNames order, for the generators to
+// print. instances is the number of groups, i.e. of numbered instance names.
+func (c Config) MetadataSummary(instances int) []string {
+	labels := c.Metadata.Labels()
+	if c.InstancePrefix != "" {
+		labels[MetaLabel(LabelInstanceName)] = fmt.Sprintf("%s-1 .. %s-%d", c.InstancePrefix, c.InstancePrefix, instances)
+	}
+	var lines []string
+	for _, name := range MetadataLabelNames() {
+		if value, ok := labels[MetaLabel(name)]; ok {
+			lines = append(lines, name+": "+value)
+		}
+	}
+	return lines
+}
+
+// ValidateGroupLabelName accepts the label names of a target group: usable
+// Prometheus label names and the __meta_algalon_* metadata labels.
+func ValidateGroupLabelName(name string) error {
+	if meta, ok := strings.CutPrefix(name, MetaLabelPrefix); ok && labelNamePattern.MatchString(meta) {
+		return nil
+	}
+	return ValidateLabelName(name)
+}
@@ -0,0 +1,397 @@
+package targets
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Spec describes several clusters of workers declaratively. Each cluster
+// becomes its own all-smi-<name>.yml, picked up by the
+// /etc/prometheus/targets/all-smi-*.yml glob in prometheus.yml, and a
+// dcgm-<name>.yml when the cluster also runs dcgm-exporter. YAML and JSON
+// are both accepted.
+//
+//	defaults:
+//	  port: 9090
+//	  environment: production
+//	clusters:
+//	  - name: training
+//	    datacenter: us-central1
+//	    exporters: [all-smi, dcgm-exporter]
+//	    ports: {dcgm-exporter: 9400}
+//	    groups:
+//	      - platform: nvidia
+//	        gpu_type: a100
+//	        targets: ["10.0.1.100", "10.0.1.101"]
+type Spec struct {
+	Defaults SpecDefaults  `yaml:"defaults"`
+	Clusters []ClusterSpec `yaml:"clusters"`
+}
+
+// SpecDefaults applies to every cluster unless overridden.
+type SpecDefaults struct {
+	Port          int `yaml:"port"`
+	SpecExporters `yaml:",inline"`
+	SpecLabels    `yaml:",inline"`
+}
+
+// ClusterSpec is one cluster and the target groups it is split into.
+type ClusterSpec struct {
+	Name          string      `yaml:"name"`
+	Port          int         `yaml:"port"`
+	Groups        []GroupSpec `yaml:"groups"`
+	SpecExporters `yaml:",inline"`
+	SpecLabels    `yaml:",inline"`
+}
+
+// SpecExporters selects the exporters a cluster runs and their ports. Port
+// on the same level is the all-smi port. Without exporters a cluster runs
+// all-smi only; ports default to each exporter's standard port.
+type SpecExporters struct {
+	Exporters []string       `yaml:"exporters"`
+	Ports     map[string]int `yaml:"ports"`
+}
+
+// GroupSpec is one file_sd group, usually the workers sharing a platform
+// and GPU type.
+type GroupSpec struct {
+	Targets    []string `yaml:"targets"`
+	SpecLabels `yaml:",inline"`
+}
+
+// SpecLabels are the labels that may be set at any level of a Spec. The
+// innermost non-empty value wins; Labels maps are merged the same way.
+type SpecLabels struct {
+	Environment string            `yaml:"environment"`
+	Datacenter  string            `yaml:"datacenter"`
+	Platform    string            `yaml:"platform"`
+	GPUType     string            `yaml:"gpu_type"`
+	Labels      map[string]string `yaml:"labels"`
+}
+
+// File is one target file generated from a Spec.
+type File struct {
+	Name string
+	// Exporter is the exporter the targets run; empty means all-smi.
+	Exporter string
+	Groups   []Group
+}
+
+// reservedLabels are set from dedicated Spec fields and may not appear in a
+// Labels map.
+var reservedLabels = map[string]string{
+	"job":             "",
+	"monitoring_type": "",
+	"cluster":         "name",
+	"environment":     "environment",
+	"datacenter":      "datacenter",
+	"platform":        "platform",
+	"gpu_type":        "gpu_type",
+}
+
+var clusterNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// SpecFileName returns the all-smi target file name for cluster.
+func SpecFileName(cluster string) string {
+	return AllSmi.FileName(cluster)
+}
+
+// ParseSpec decodes a YAML or JSON spec. Unknown fields are rejected so a
+// misspelt gpu_type does not silently drop the label.
+func ParseSpec(data []byte) (*Spec, error) {
+	var spec Spec
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&spec); err != nil {
+		return nil, fmt.Errorf("invalid cluster spec: %v", err)
+	}
+
+	if _, err := spec.Files(); err != nil {
+		return nil, err
+	}
+
+	return &spec, nil
+}
+
+// ReadSpec reads and parses the spec at path.
+func ReadSpec(path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	spec, err := ParseSpec(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return spec, nil
+}
+
+// Files validates the spec and returns one target file per cluster and
+// exporter, in spec order. Every group carries the job, cluster,
+// environment, datacenter, platform, gpu_type and monitoring_type labels.
+func (s *Spec) Files() ([]File, error) {
+	if len(s.Clusters) == 0 {
+		return nil, fmt.Errorf("cluster spec has no clusters")
+	}
+
+	defaultPorts := map[string]int{}
+	for _, exporter := range Exporters() {
+		defaultPorts[exporter.Name] = exporter.Port
+	}
+	defaultPorts, err := s.Defaults.ports(defaultPorts, s.Defaults.Port)
+	if err != nil {
+		return nil, fmt.Errorf("invalid default port: %v", err)
+	}
+	defaultExporters, err := s.Defaults.exporters([]Exporter{AllSmi})
+	if err != nil {
+		return nil, fmt.Errorf("defaults: %v", err)
+	}
+	if err := s.Defaults.validate(); err != nil {
+		return nil, fmt.Errorf("defaults: %v", err)
+	}
+
+	var (
+		files     []File
+		allGroups []Group
+		names     = map[string]bool{}
+	)
+
+	for i, cluster := range s.Clusters {
+		if !clusterNamePattern.MatchString(cluster.Name) {
+			return nil, fmt.Errorf("cluster %d: invalid name %q: use lowercase letters, digits, '-' and '_'", i, cluster.Name)
+		}
+		if names[cluster.Name] {
+			return nil, fmt.Errorf("cluster %q is defined twice", cluster.Name)
+		}
+		names[cluster.Name] = true
+
+		ports, err := cluster.ports(defaultPorts, cluster.Port)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %q: invalid port: %v", cluster.Name, err)
+		}
+		exporters, err := cluster.exporters(defaultExporters)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %q: %v", cluster.Name, err)
+		}
+		if err := cluster.validate(); err != nil {
+			return nil, fmt.Errorf("cluster %q: %v", cluster.Name, err)
+		}
+		if len(cluster.Groups) == 0 {
+			return nil, fmt.Errorf("cluster %q has no groups", cluster.Name)
+		}
+
+		for _, exporter := range exporters {
+			file := File{Name: exporter.FileName(cluster.Name), Exporter: exporter.Name}
+			for j, spec := range cluster.Groups {
+				if len(exporters) > 1 {
+					// A port on a target cannot belong to every exporter.
+					if err := spec.requireDefaultPorts(); err != nil {
+						return nil, fmt.Errorf("cluster %q group %d: %v", cluster.Name, j, err)
+					}
+				}
+				group, err := spec.group(exporter, cluster.Name, ports[exporter.Name], s.Defaults.SpecLabels, cluster.SpecLabels)
+				if err != nil {
+					return nil, fmt.Errorf("cluster %q group %d: %v", cluster.Name, j, err)
+				}
+				file.Groups = append(file.Groups, group)
+			}
+
+			files = append(files, file)
+			allGroups = append(allGroups, file.Groups...)
+		}
+	}
+
+	// Catch a worker listed under two clusters, which would be scraped twice.
+	if err := ValidateGroups(allGroups); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// exporters returns the exporters selected on this level, or inherited.
+func (e SpecExporters) exporters(inherited []Exporter) ([]Exporter, error) {
+	if len(e.Exporters) == 0 {
+		return inherited, nil
+	}
+
+	var exporters []Exporter
+	seen := map[string]bool{}
+	for _, name := range e.Exporters {
+		exporter, err := LookupExporter(name)
+		if err != nil || name == "" {
+			return nil, fmt.Errorf("unknown exporter %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("exporter %q is listed twice", name)
+		}
+		seen[name] = true
+		exporters = append(exporters, exporter)
+	}
+	return exporters, nil
+}
+
+// ports returns the inherited ports with the all-smi port and the ports
+// map of this level applied.
+func (e SpecExporters) ports(inherited map[string]int, allSmiPort int) (map[string]int, error) {
+	ports := make(map[string]int, len(inherited))
+	for name, port := range inherited {
+		ports[name] = port
+	}
+	if allSmiPort != 0 {
+		ports[AllSmi.Name] = allSmiPort
+	}
+	for name, port := range e.Ports {
+		if _, err := LookupExporter(name); err != nil || name == "" {
+			return nil, fmt.Errorf("unknown exporter %q", name)
+		}
+		ports[name] = port
+	}
+
+	for _, exporter := range Exporters() {
+		if err := ValidatePort(ports[exporter.Name]); err != nil {
+			return nil, fmt.Errorf("%s: %v", exporter.Name, err)
+		}
+	}
+	return ports, nil
+}
+
+// requireDefaultPorts rejects targets with their own port.
+func (g GroupSpec) requireDefaultPorts() error {
+	for _, raw := range g.Targets {
+		if _, err := ParseTarget(raw, 0); err == nil {
+			return fmt.Errorf("target %q sets a port, but the cluster runs several exporters: set them with ports instead", raw)
+		}
+	}
+	return nil
+}
+
+func (g GroupSpec) group(exporter Exporter, cluster string, port int, levels ...SpecLabels) (Group, error) {
+	if err := g.validate(); err != nil {
+		return Group{}, err
+	}
+	if len(g.Targets) == 0 {
+		return Group{}, fmt.Errorf("no targets")
+	}
+
+	merged := SpecLabels{Labels: map[string]string{}}
+	for _, level := range append(levels, g.SpecLabels) {
+		merged.merge(level)
+	}
+
+	labels := exporter.Labels(cluster, merged.Environment)
+	for name, value := range merged.Labels {
+		labels[name] = value
+	}
+	for name, value := range map[string]string{
+		"environment": merged.Environment,
+		"datacenter":  merged.Datacenter,
+		"platform":    merged.Platform,
+		"gpu_type":    merged.GPUType,
+	} {
+		if value == "" {
+			return Group{}, fmt.Errorf("%s is not set at group, cluster or defaults level", name)
+		}
+		labels[name] = value
+	}
+
+	group := Group{Labels: labels}
+	for _, raw := range g.Targets {
+		target, err := ParseTarget(raw, port)
+		if err != nil {
+			return Group{}, err
+		}
+		group.Targets = append(group.Targets, target.String())
+	}
+
+	return group, nil
+}
+
+func (l *SpecLabels) merge(other SpecLabels) {
+	if other.Environment != "" {
+		l.Environment = other.Environment
+	}
+	if other.Datacenter != "" {
+		l.Datacenter = other.Datacenter
+	}
+	if other.Platform != "" {
+		l.Platform = other.Platform
+	}
+	if other.GPUType != "" {
+		l.GPUType = other.GPUType
+	}
+	for name, value := range other.Labels {
+		l.Labels[name] = value
+	}
+}
+
+func (l SpecLabels) validate() error {
+	for name := range l.Labels {
+		if field, ok := reservedLabels[name]; ok {
+			if field == "" {
+				return fmt.Errorf("label %q is set by the generator", name)
+			}
+			return fmt.Errorf("label %q must be set with the %s field", name, field)
+		}
+		if err := ValidateLabelName(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteFiles atomically writes each file into dir. With prune, any other
+// target file of the same exporters, e.g. all-smi-*.yml, is removed from
+// dir so clusters dropped from the spec stop being scraped; files of
+// exporters the spec does not use are left alone. It returns the paths it
+// removed.
+func WriteFiles(dir string, files []File, prune bool) ([]string, error) {
+	keep := map[string]bool{}
+	globs := map[string]bool{}
+	for _, file := range files {
+		exporter, err := LookupExporter(file.Exporter)
+		if err != nil {
+			return nil, err
+		}
+		if err := WriteFile(filepath.Join(dir, file.Name), file.Groups); err != nil {
+			return nil, err
+		}
+		keep[file.Name] = true
+		globs[exporter.Glob()] = true
+	}
+
+	if !prune {
+		return nil, nil
+	}
+
+	var stale []string
+	for glob := range globs {
+		matches, err := filepath.Glob(filepath.Join(dir, glob))
+		if err != nil {
+			return nil, err
+		}
+		stale = append(stale, matches...)
+	}
+	sort.Strings(stale)
+
+	var removed []string
+	for _, path := range stale {
+		if keep[filepath.Base(path)] {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return removed, err
+		}
+		removed = append(removed, path)
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,141 @@
+// Package targets builds the Prometheus file_sd target files that VMAgent
+// reads from algalon_host/node/targets. It is the typed replacement for the
+// string concatenation done by generate-targets.sh.
+package targets
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Target is a single worker scrape endpoint in host:port form.
+type Target struct {
+	Host string
+	Port int
+}
+
+// String returns the target in the host:port form used by file_sd.
+func (t Target) String() string {
+	return net.JoinHostPort(t.Host, strconv.Itoa(t.Port))
+}
+
+var hostnameLabelPattern = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+
+// ParseTarget parses a single "host" or "host:port" entry. When the port is
+// omitted defaultPort is used, matching generate-targets.sh; a defaultPort of
+// zero makes the port mandatory. IPv6 addresses must be bracketed, e.g.
+// "[fd00::1]:9090".
+func ParseTarget(raw string, defaultPort int) (Target, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return Target{}, fmt.Errorf("empty target")
+	}
+
+	host, portStr, hasPort := raw, "", false
+	if strings.HasPrefix(raw, "[") || strings.Count(raw, ":") == 1 {
+		var err error
+		host, portStr, err = net.SplitHostPort(raw)
+		hasPort = err == nil
+		if err != nil {
+			// A bracketed IPv6 address without a port is still valid.
+			if strings.HasPrefix(raw, "[") && strings.HasSuffix(raw, "]") {
+				host, portStr = strings.Trim(raw, "[]"), ""
+			} else {
+				return Target{}, fmt.Errorf("invalid target %q: %v", raw, err)
+			}
+		}
+	} else if strings.Contains(raw, ":") {
+		return Target{}, fmt.Errorf("invalid target %q: IPv6 addresses must be enclosed in brackets", raw)
+	}
+
+	port := defaultPort
+	if !hasPort && defaultPort == 0 {
+		return Target{}, fmt.Errorf("invalid target %q: missing port", raw)
+	}
+	if hasPort {
+		p, err := strconv.Atoi(portStr)
+		if err != nil {
+			return Target{}, fmt.Errorf("invalid target %q: port %q is not a number", raw, portStr)
+		}
+		port = p
+	}
+
+	if err := ValidatePort(port); err != nil {
+		return Target{}, fmt.Errorf("invalid target %q: %v", raw, err)
+	}
+	if err := validateHost(host); err != nil {
+		return Target{}, fmt.Errorf("invalid target %q: %v", raw, err)
+	}
+
+	return Target{Host: host, Port: port}, nil
+}
+
+// ParseTargets parses the comma-separated ALGALON_TARGETS format. Blank
+// entries are skipped and duplicate targets are rejected.
+func ParseTargets(list string, defaultPort int) ([]Target, error) {
+	var parsed []Target
+	seen := map[string]bool{}
+
+	for _, raw := range strings.Split(list, ",") {
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+
+		target, err := ParseTarget(raw, defaultPort)
+		if err != nil {
+			return nil, err
+		}
+
+		key := target.String()
+		if seen[key] {
+			return nil, fmt.Errorf("duplicate target %q", key)
+		}
+		seen[key] = true
+
+		parsed = append(parsed, target)
+	}
+
+	if len(parsed) == 0 {
+		return nil, ErrNoTargets
+	}
+
+	return parsed, nil
+}
+
+// ValidatePort reports whether port is a usable TCP port.
+func ValidatePort(port int) error {
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("port %d is out of range 1-65535", port)
+	}
+	return nil
+}
+
+func validateHost(host string) error {
+	if host == "" {
+		return fmt.Errorf("host is empty")
+	}
+
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+
+	// Dotted-quad strings that failed ParseIP are malformed IPv4 addresses,
+	// not hostnames.
+	if strings.Trim(host, "0123456789.") == "" {
+		return fmt.Errorf("host %q is not a valid IPv4 address", host)
+	}
+
+	if len(host) > 253 {
+		return fmt.Errorf("hostname %q is longer than 253 characters", host)
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if !hostnameLabelPattern.MatchString(label) {
+			return fmt.Errorf("host %q is not a valid hostname", host)
+		}
+	}
+
+	return nil
+}
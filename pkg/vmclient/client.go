@@ -0,0 +1,208 @@
+// Package vmclient queries the Prometheus-compatible HTTP API that
+// VictoriaMetrics serves under /api/v1.
+package vmclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultURL is VictoriaMetrics as seen from the monitoring host.
+const DefaultURL = "http://localhost:8428"
+
+// Sample is one series of an instant vector.
+type Sample struct {
+	Metric    map[string]string
+	Value     float64
+	Timestamp time.Time
+}
+
+// Vector is the result of an instant query.
+type Vector []Sample
+
+// Point is one sample of a range vector series.
+type Point struct {
+	Timestamp time.Time
+	Value     float64
+}
+
+// Series is one series of a range vector.
+type Series struct {
+	Metric map[string]string
+	Points []Point
+}
+
+// Matrix is the result of a range query.
+type Matrix []Series
+
+// Client queries a VictoriaMetrics (or Prometheus) server.
+type Client struct {
+	URL        string
+	HTTPClient *http.Client
+}
+
+// NewClient returns a client for the server at baseURL, e.g.
+// http://localhost:8428.
+func NewClient(baseURL string) *Client {
+	return &Client{
+		URL:        strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+type apiResponse struct {
+	Status    string  `json:"status"`
+	ErrorType string  `json:"errorType"`
+	Error     string  `json:"error"`
+	Data      apiData `json:"data"`
+}
+
+type apiData struct {
+	ResultType string          `json:"resultType"`
+	Result     json.RawMessage `json:"result"`
+}
+
+type apiSample struct {
+	Metric map[string]string  `json:"metric"`
+	Value  [2]json.RawMessage `json:"value"`
+}
+
+// Query runs an instant query evaluated at the server's current time and
+// fails unless it returns a vector.
+func (c *Client) Query(ctx context.Context, query string) (Vector, error) {
+	data, err := c.do(ctx, "/api/v1/query", url.Values{"query": {query}})
+	if err != nil {
+		return nil, err
+	}
+	if data.ResultType != "vector" {
+		return nil, fmt.Errorf("query %s returned a %s, want a vector", query, data.ResultType)
+	}
+
+	var raw []apiSample
+	if err := json.Unmarshal(data.Result, &raw); err != nil {
+		return nil, fmt.Errorf("malformed vector result: %v", err)
+	}
+
+	vector := make(Vector, 0, len(raw))
+	for _, s := range raw {
+		timestamp, value, err := decodePoint(s.Value)
+		if err != nil {
+			return nil, err
+		}
+		vector = append(vector, Sample{Metric: s.Metric, Value: value, Timestamp: timestamp})
+	}
+	return vector, nil
+}
+
+type apiSeries struct {
+	Metric map[string]string    `json:"metric"`
+	Values [][2]json.RawMessage `json:"values"`
+}
+
+// QueryRange evaluates query at every step from start to end and fails
+// unless it returns a matrix. VictoriaMetrics aligns start to a multiple of
+// step.
+func (c *Client) QueryRange(ctx context.Context, query string, start, end time.Time, step time.Duration) (Matrix, error) {
+	if step <= 0 {
+		return nil, fmt.Errorf("query step must be positive, got %s", step)
+	}
+	if end.Before(start) {
+		return nil, fmt.Errorf("query range ends at %s before it starts at %s", end.Format(time.RFC3339), start.Format(time.RFC3339))
+	}
+
+	data, err := c.do(ctx, "/api/v1/query_range", url.Values{
+		"query": {query},
+		"start": {formatTime(start)},
+		"end":   {formatTime(end)},
+		"step":  {strconv.FormatFloat(step.Seconds(), 'f', -1, 64)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if data.ResultType != "matrix" {
+		return nil, fmt.Errorf("range query %s returned a %s, want a matrix", query, data.ResultType)
+	}
+
+	var raw []apiSeries
+	if err := json.Unmarshal(data.Result, &raw); err != nil {
+		return nil, fmt.Errorf("malformed matrix result: %v", err)
+	}
+
+	matrix := make(Matrix, 0, len(raw))
+	for _, s := range raw {
+		series := Series{Metric: s.Metric, Points: make([]Point, 0, len(s.Values))}
+		for _, value := range s.Values {
+			timestamp, v, err := decodePoint(value)
+			if err != nil {
+				return nil, err
+			}
+			series.Points = append(series.Points, Point{Timestamp: timestamp, Value: v})
+		}
+		matrix = append(matrix, series)
+	}
+	return matrix, nil
+}
+
+// formatTime formats t as the Unix seconds the HTTP API takes.
+func formatTime(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixNano())/1e9, 'f', -1, 64)
+}
+
+func (c *Client) do(ctx context.Context, path string, params url.Values) (*apiData, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL+path, strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s: %v", c.URL, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response from %s: %v", c.URL, err)
+	}
+
+	var decoded apiResponse
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		return nil, fmt.Errorf("query returned status %d with a malformed body: %v", resp.StatusCode, err)
+	}
+	if decoded.Status != "success" {
+		return nil, fmt.Errorf("query failed with status %d (%s): %s", resp.StatusCode, decoded.ErrorType, decoded.Error)
+	}
+	return &decoded.Data, nil
+}
+
+// decodePoint decodes the [<unix seconds>, "<value>"] pairs the HTTP API
+// uses for sample values.
+func decodePoint(raw [2]json.RawMessage) (time.Time, float64, error) {
+	var seconds float64
+	if err := json.Unmarshal(raw[0], &seconds); err != nil {
+		return time.Time{}, 0, fmt.Errorf("malformed sample timestamp %s: %v", raw[0], err)
+	}
+
+	var value string
+	if err := json.Unmarshal(raw[1], &value); err != nil {
+		return time.Time{}, 0, fmt.Errorf("malformed sample value %s: %v", raw[1], err)
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("malformed sample value %q: %v", value, err)
+	}
+
+	return time.Unix(0, int64(seconds*1e9)), v, nil
+}
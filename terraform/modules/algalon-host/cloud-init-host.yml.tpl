@@ -110,15 +110,7 @@ write_files:
       # Run main function
       main "$@"
 
-runcmd:
-  # Wait for Docker to be ready
-  - timeout 300 bash -c 'until docker info; do sleep 5; done'
-
-  # Run Algalon setup
-  - /tmp/algalon-setup.sh 2>&1 | tee /var/log/algalon-setup.log
-
-# Configure systemd service for monitoring setup logs
-write_files:
+  # Status check, run once setup has had time to finish
   - path: /tmp/setup-complete-check.sh
     permissions: 0755
     content: |
@@ -158,6 +150,14 @@ write_files:
       sleep 60
       check_services
 
-# Enable monitoring and start status check
+# cloud-init keeps only the last occurrence of a top-level key, so every
+# file and command must live in a single write_files and runcmd list
 runcmd:
-  - nohup /tmp/setup-complete-check.sh > /var/log/algalon-status.log 2>&1 &
\ No newline at end of file
+  # Wait for Docker to be ready
+  - timeout 300 bash -c 'until docker info; do sleep 5; done'
+
+  # Run Algalon setup
+  - /tmp/algalon-setup.sh 2>&1 | tee /var/log/algalon-setup.log
+
+  # Enable monitoring and start status check
+  - nohup /tmp/setup-complete-check.sh > /var/log/algalon-status.log 2>&1 &
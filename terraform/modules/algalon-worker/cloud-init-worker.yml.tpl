@@ -53,19 +53,22 @@ write_files:
           local version="${all_smi_version}"
           local port="${all_smi_port}"
           local interval="${all_smi_interval}"
+          local registry="${registry_url}"
 
           log "Worker configuration:"
           log "  Version: $version"
           log "  Port: $port"
           log "  Interval: $${interval}s"
+          log "  Registry: $${registry:-none, register targets on the host}"
 
           # Clone repository
           cd /opt
           git clone https://github.com/appleparan/Algalon.git
           cd Algalon/algalon_worker
 
-          # Setup worker
-          ./setup.sh --version "$version" --port "$port" --interval "$interval"
+          # Setup worker; with a registry URL, setup.sh also starts
+          # algalon-agent, which registers the worker once all-smi is ready
+          ./setup.sh --version "$version" --port "$port" --interval "$interval" --registry "$registry"
 
           success "Algalon Worker setup complete!"
 
@@ -90,15 +93,7 @@ write_files:
       # Run main function
       main "$@"
 
-runcmd:
-  # Wait for Docker to be ready
-  - timeout 300 bash -c 'until docker info; do sleep 5; done'
-
-  # Run Algalon setup
-  - /tmp/algalon-setup.sh 2>&1 | tee /var/log/algalon-setup.log
-
-# Configure systemd service for monitoring setup logs
-write_files:
+  # Status check, run once setup has had time to finish
   - path: /tmp/setup-complete-check.sh
     permissions: 0755
     content: |
@@ -133,6 +128,14 @@ write_files:
       sleep 60
       check_services
 
-# Enable monitoring and start status check
+# cloud-init keeps only the last occurrence of a top-level key, so every
+# file and command must live in a single write_files and runcmd list
 runcmd:
-  - nohup /tmp/setup-complete-check.sh > /var/log/algalon-status.log 2>&1 &
\ No newline at end of file
+  # Wait for Docker to be ready
+  - timeout 300 bash -c 'until docker info; do sleep 5; done'
+
+  # Run Algalon setup
+  - /tmp/algalon-setup.sh 2>&1 | tee /var/log/algalon-setup.log
+
+  # Enable monitoring and start status check
+  - nohup /tmp/setup-complete-check.sh > /var/log/algalon-status.log 2>&1 &
@@ -26,6 +26,7 @@ locals {
     all_smi_version  = var.all_smi_version
     all_smi_port     = var.all_smi_port
     all_smi_interval = var.all_smi_interval
+    registry_url     = var.registry_url
   })
 
   common_metadata = {
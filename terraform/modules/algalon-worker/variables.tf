@@ -62,6 +62,17 @@ variable "all_smi_interval" {
   default     = 5
 }
 
+variable "registry_url" {
+  description = "URL of the host's algalon-registry service; when set, workers run algalon-agent to register themselves and deregister on preemption"
+  type        = string
+  default     = ""
+
+  validation {
+    condition     = var.registry_url == "" || can(regex("^https?://[^/]+", var.registry_url))
+    error_message = "registry_url must be empty or an http(s) URL, e.g. http://10.128.0.10:8430."
+  }
+}
+
 variable "boot_disk_size" {
   description = "Size of the boot disk in GB"
   type        = number
@@ -2,87 +2,29 @@ package test
 
 import (
 	"fmt"
-	"net/http"
-	"os"
+	"reflect"
+	"sort"
 	"strings"
 	"testing"
 	"time"
 
-	"github.com/gruntwork-io/terratest/modules/random"
 	"github.com/gruntwork-io/terratest/modules/retry"
 	"github.com/gruntwork-io/terratest/modules/terraform"
-	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"algalon-terraform-testsupport"
 )
 
 func TestFullAlgalonDeploymentE2E(t *testing.T) {
-	// Skip if running in CI without GCP credentials
-	if os.Getenv("TF_VAR_project_id") == "" {
-		t.Skip("Skipping E2E test: TF_VAR_project_id not set")
-	}
-
-	projectID := os.Getenv("TF_VAR_project_id")
-	region := getEnvOrDefault("TF_VAR_region", "us-central1")
-
-	// Determine deployment type based on environment variable
-	deploymentType := getEnvOrDefault("TEST_DEPLOYMENT_TYPE", "training-cluster")
-
-	// Generate unique deployment name
-	uniqueID := random.UniqueId()
-	deploymentName := getEnvOrDefault("TF_VAR_deployment_name", fmt.Sprintf("algalon-e2e-%s-%s", deploymentType, uniqueID))
-
-	// Set terraform directory based on deployment type
-	var terraformDir string
-	var vars map[string]interface{}
-
-	if deploymentType == "host-only" {
-		terraformDir = "../../terraform/examples/host-only"
-		vars = map[string]interface{}{
-			"project_id":        projectID,
-			"region":            region,
-			"deployment_name":   deploymentName,
-			"cluster_name":      "e2e-test",
-			"environment_name":  "testing",
-			"enable_host_external_ip":  true,
-			"reserve_static_ip":        false, // Cost optimization
-			"grafana_allowed_ips": []string{"0.0.0.0/0"}, // Allow all for testing
-			"ssh_allowed_ips":     []string{"0.0.0.0/0"}, // Allow all for testing
-			"host_machine_type":   "n1-standard-2", // Smaller for testing
-		}
-	} else {
-		terraformDir = "../../terraform/examples/training-cluster"
-		vars = map[string]interface{}{
-			"project_id":        projectID,
-			"region":            region,
-			"deployment_name":   deploymentName,
-			"worker_count":      2,
-			"gpu_type":          "nvidia-tesla-t4",
-			"cluster_name":      "e2e-test",
-			"environment_name":  "testing",
-			"use_preemptible_workers": true, // Cost optimization
-			"enable_host_external_ip":  true,
-			"enable_worker_external_ip": true, // Enable for direct testing
-			"grafana_allowed_ips": []string{"0.0.0.0/0"}, // Allow all for testing
-			"ssh_allowed_ips":     []string{"0.0.0.0/0"}, // Allow all for testing
-			"all_smi_interval":    3, // Faster collection for testing
-			"host_machine_type":     "n1-standard-2", // Smaller for testing
-			"worker_machine_type":   "n1-standard-1", // Smaller for testing
-		}
-	}
+	deployment := testsupport.NewDeploymentFromEnv(t, "e2e")
+	deployment.Name = testsupport.GetEnvOrDefault("TF_VAR_deployment_name", deployment.Name)
+	deployment.WorkerCount = 2
+	deployment.EnableWorkerExternalIP = true // Enable for direct testing
+	deployment.AllSmiInterval = 3            // Faster collection for testing
 
-	t.Logf("Running E2E test for deployment type: %s", deploymentType)
+	t.Logf("Running E2E test for deployment type: %s", deployment.Type)
 
-	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: terraformDir,
-		NoColor:      true,
-		Vars:         vars,
-		RetryableTerraformErrors: map[string]string{
-			".*timeout.*":                    "Timeout occurred",
-			".*Error waiting for operation.*": "GCP operation timeout",
-		},
-		MaxRetries:         3,
-		TimeBetweenRetries: 30 * time.Second,
-	})
+	terraformOptions := deployment.TerraformOptions(t)
 
 	// Clean up resources with "terraform destroy" at the end of the test
 	defer terraform.Destroy(t, terraformOptions)
@@ -100,12 +42,12 @@ func TestFullAlgalonDeploymentE2E(t *testing.T) {
 	// Test VictoriaMetrics accessibility
 	testVictoriaMetricsAccessibility(t, terraformOptions)
 
-	if deploymentType == "training-cluster" {
+	if deployment.Type == testsupport.TrainingCluster {
 		// Test worker metrics endpoints (only for training cluster)
 		testWorkerMetricsEndpoints(t, terraformOptions)
 
 		// Test metrics collection pipeline (more comprehensive for training cluster)
-		testMetricsCollectionPipeline(t, terraformOptions, deploymentType)
+		testMetricsCollectionPipeline(t, terraformOptions, deployment)
 	} else {
 		// Test basic monitoring setup for host-only
 		testHostOnlyMonitoring(t, terraformOptions)
@@ -120,24 +62,7 @@ func testGrafanaAccessibility(t *testing.T, terraformOptions *terraform.Options)
 
 	// Test Grafana health endpoint
 	healthURL := fmt.Sprintf("%s/api/health", grafanaURL)
-
-	maxRetries := 20
-	timeBetweenRetries := 30 * time.Second
-
-	retry.DoWithRetry(t, "Check Grafana health", maxRetries, timeBetweenRetries, func() (string, error) {
-		resp, err := http.Get(healthURL)
-		if err != nil {
-			return "", fmt.Errorf("failed to connect to Grafana: %v", err)
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			return "", fmt.Errorf("Grafana health check failed with status: %d", resp.StatusCode)
-		}
-
-		t.Logf("✅ Grafana is accessible at %s", grafanaURL)
-		return "Grafana is healthy", nil
-	})
+	testsupport.WaitForHTTPOK(t, "Grafana", healthURL, 20, 30*time.Second)
 }
 
 func testVictoriaMetricsAccessibility(t *testing.T, terraformOptions *terraform.Options) {
@@ -146,26 +71,15 @@ func testVictoriaMetricsAccessibility(t *testing.T, terraformOptions *terraform.
 	victoriaMetricsURL := terraform.Output(t, terraformOptions, "victoria_metrics_url")
 	require.NotEmpty(t, victoriaMetricsURL)
 
+	checkVictoriaMetricsHealth(t, victoriaMetricsURL, 20, 30*time.Second)
+}
+
+// checkVictoriaMetricsHealth polls the /health endpoint of victoriaMetricsURL
+// until it answers 200 OK.
+func checkVictoriaMetricsHealth(t *testing.T, victoriaMetricsURL string, maxRetries int, timeBetweenRetries time.Duration) {
 	// Test VictoriaMetrics health endpoint
 	healthURL := fmt.Sprintf("%s/health", victoriaMetricsURL)
-
-	maxRetries := 20
-	timeBetweenRetries := 30 * time.Second
-
-	retry.DoWithRetry(t, "Check VictoriaMetrics health", maxRetries, timeBetweenRetries, func() (string, error) {
-		resp, err := http.Get(healthURL)
-		if err != nil {
-			return "", fmt.Errorf("failed to connect to VictoriaMetrics: %v", err)
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			return "", fmt.Errorf("VictoriaMetrics health check failed with status: %d", resp.StatusCode)
-		}
-
-		t.Logf("✅ VictoriaMetrics is accessible at %s", victoriaMetricsURL)
-		return "VictoriaMetrics is healthy", nil
-	})
+	testsupport.WaitForHTTPOK(t, "VictoriaMetrics", healthURL, maxRetries, timeBetweenRetries)
 }
 
 func testWorkerMetricsEndpoints(t *testing.T, terraformOptions *terraform.Options) {
@@ -178,21 +92,7 @@ func testWorkerMetricsEndpoints(t *testing.T, terraformOptions *terraform.Option
 	timeBetweenRetries := 30 * time.Second
 
 	for i, endpoint := range workerEndpoints {
-		endpoint := endpoint // capture for closure
-		retry.DoWithRetry(t, fmt.Sprintf("Check worker %d metrics", i+1), maxRetries, timeBetweenRetries, func() (string, error) {
-			resp, err := http.Get(endpoint)
-			if err != nil {
-				return "", fmt.Errorf("failed to connect to worker endpoint %s: %v", endpoint, err)
-			}
-			defer resp.Body.Close()
-
-			if resp.StatusCode != http.StatusOK {
-				return "", fmt.Errorf("worker metrics endpoint %s returned status: %d", endpoint, resp.StatusCode)
-			}
-
-			t.Logf("✅ Worker %d metrics accessible at %s", i+1, endpoint)
-			return fmt.Sprintf("Worker %d is healthy", i+1), nil
-		})
+		testsupport.WaitForHTTPOK(t, fmt.Sprintf("Worker %d metrics", i+1), endpoint, maxRetries, timeBetweenRetries)
 	}
 }
 
@@ -202,129 +102,176 @@ func testHostOnlyMonitoring(t *testing.T, terraformOptions *terraform.Options) {
 	victoriaMetricsURL := terraform.Output(t, terraformOptions, "victoria_metrics_url")
 	require.NotEmpty(t, victoriaMetricsURL)
 
-	// Query VictoriaMetrics for basic metrics (should have host metrics)
-	queryURL := fmt.Sprintf("%s/api/v1/query", victoriaMetricsURL)
+	client := testsupport.NewPrometheusClient(victoriaMetricsURL)
 
 	maxRetries := 20 // Give time for basic metrics to be collected
 	timeBetweenRetries := 30 * time.Second
 
-	// Test for basic host metrics that should always be present
-	expectedMetrics := []string{
-		"up", // Should show VictoriaMetrics itself
-	}
-
-	for _, metricName := range expectedMetrics {
-		metricName := metricName // capture for closure
-		retry.DoWithRetry(t, fmt.Sprintf("Check basic metric %s", metricName), maxRetries, timeBetweenRetries, func() (string, error) {
-			// Query for the specific metric
-			fullQueryURL := fmt.Sprintf("%s?query=%s", queryURL, metricName)
-
-			resp, err := http.Get(fullQueryURL)
-			if err != nil {
-				return "", fmt.Errorf("failed to query metric %s: %v", metricName, err)
-			}
-			defer resp.Body.Close()
-
-			if resp.StatusCode != http.StatusOK {
-				return "", fmt.Errorf("metric query for %s failed with status: %d", metricName, resp.StatusCode)
-			}
+	// Should show VictoriaMetrics itself
+	retry.DoWithRetry(t, "Check basic metric up", maxRetries, timeBetweenRetries, func() (string, error) {
+		vector, err := client.QueryVector("up")
+		if err != nil {
+			return "", err
+		}
+		if len(vector) == 0 {
+			return "", fmt.Errorf("no series found for metric up")
+		}
+		if err := vector.CheckRange(0, 1); err != nil {
+			return "", err
+		}
 
-			t.Logf("✅ Basic metric %s is available for host-only monitoring", metricName)
-			return fmt.Sprintf("Metric %s found", metricName), nil
-		})
-	}
+		t.Logf("✅ Basic metric up is available for host-only monitoring (%d series)", len(vector))
+		return fmt.Sprintf("%d up series", len(vector)), nil
+	})
 
 	t.Log("✅ Host-only monitoring setup is working")
 }
 
-func testMetricsCollectionPipeline(t *testing.T, terraformOptions *terraform.Options, deploymentType string) {
+func testMetricsCollectionPipeline(t *testing.T, terraformOptions *terraform.Options, deployment *testsupport.Deployment) {
 	t.Log("Testing metrics collection pipeline...")
 
 	victoriaMetricsURL := terraform.Output(t, terraformOptions, "victoria_metrics_url")
 	require.NotEmpty(t, victoriaMetricsURL)
 
-	// Query VictoriaMetrics for all-smi metrics
-	queryURL := fmt.Sprintf("%s/api/v1/query", victoriaMetricsURL)
+	// vmagent scrapes the internal addresses in worker_targets, one per
+	// entry in worker_metrics_endpoints
+	workerEndpoints := terraform.OutputList(t, terraformOptions, "worker_metrics_endpoints")
+	workerTargets := strings.Split(terraform.Output(t, terraformOptions, "worker_targets"), ",")
+	require.Len(t, workerTargets, len(workerEndpoints))
+
+	expected := pipelineExpectation{
+		Instances: workerTargets,
+		Labels: testsupport.Labels{
+			"job":         "all-smi",
+			"cluster":     deployment.ClusterName,
+			"environment": deployment.EnvironmentName,
+		},
+	}
+
+	// Give time for metrics to be collected
+	checkMetricsCollectionPipeline(t, victoriaMetricsURL, expected, 30, 30*time.Second)
+}
 
-	maxRetries := 30 // Give time for metrics to be collected
-	timeBetweenRetries := 30 * time.Second
+// pipelineExpectation describes the series a healthy pipeline reports.
+type pipelineExpectation struct {
+	// Instances holds the host:port of every worker vmagent scrapes.
+	Instances []string
+
+	// Labels must be carried by every all-smi series, including up.
+	Labels testsupport.Labels
+}
+
+// checkMetricsCollectionPipeline asserts that all-smi metrics scraped from the
+// workers are queryable from the VictoriaMetrics instance at victoriaMetricsURL.
+// It only needs the URL, so it runs against a real deployment or the offline
+// stand-in alike.
+func checkMetricsCollectionPipeline(t *testing.T, victoriaMetricsURL string, expected pipelineExpectation, maxRetries int, timeBetweenRetries time.Duration) {
+	client := testsupport.NewPrometheusClient(victoriaMetricsURL)
+
+	instances := append([]string(nil), expected.Instances...)
+	sort.Strings(instances)
+
+	// Exactly one up series per worker, all of them up
+	retry.DoWithRetry(t, "Check worker target metrics", maxRetries, timeBetweenRetries, func() (string, error) {
+		vector, err := client.QueryVector(fmt.Sprintf(`up{job=%q}`, expected.Labels["job"]))
+		if err != nil {
+			return "", err
+		}
+		if err := checkOneSeriesPerInstance(vector, expected); err != nil {
+			return "", fmt.Errorf("up: %v", err)
+		}
+		if err := vector.CheckRange(1, 1); err != nil {
+			return "", fmt.Errorf("worker target is down: %v", err)
+		}
+
+		t.Logf("✅ %d all-smi targets are up", len(vector))
+		return "Targets are up", nil
+	})
 
 	// Test for specific all-smi metrics
-	expectedMetrics := []string{
-		"all_smi_info",
-		"all_smi_gpu_utilization",
-		"all_smi_memory_utilization",
-		"all_smi_cpu_utilization",
+	testCases := []struct {
+		metric     string
+		perWorker  bool // exactly one series per worker
+		min, max   float64
+		withLabels []string
+	}{
+		{metric: "all_smi_info", perWorker: true, min: 1, max: 1, withLabels: []string{"hostname", "version"}},
+		{metric: "all_smi_gpu_utilization", min: 0, max: 100, withLabels: []string{"gpu", "index"}},
+		{metric: "all_smi_memory_utilization", min: 0, max: 100},
+		{metric: "all_smi_cpu_utilization", min: 0, max: 100},
 	}
 
-	for _, metricName := range expectedMetrics {
-		metricName := metricName // capture for closure
-		retry.DoWithRetry(t, fmt.Sprintf("Check metric %s", metricName), maxRetries, timeBetweenRetries, func() (string, error) {
-			// Query for the specific metric
-			fullQueryURL := fmt.Sprintf("%s?query=%s", queryURL, metricName)
-
-			resp, err := http.Get(fullQueryURL)
+	for _, tc := range testCases {
+		tc := tc // capture for closure
+		retry.DoWithRetry(t, fmt.Sprintf("Check metric %s", tc.metric), maxRetries, timeBetweenRetries, func() (string, error) {
+			vector, err := client.QueryVector(fmt.Sprintf(`%s{job=%q}`, tc.metric, expected.Labels["job"]))
 			if err != nil {
-				return "", fmt.Errorf("failed to query metric %s: %v", metricName, err)
+				return "", err
 			}
-			defer resp.Body.Close()
 
-			if resp.StatusCode != http.StatusOK {
-				return "", fmt.Errorf("metric query for %s failed with status: %d", metricName, resp.StatusCode)
+			if tc.perWorker {
+				err = checkOneSeriesPerInstance(vector, expected)
+			} else {
+				err = checkEveryInstanceReports(vector, instances)
+			}
+			if err != nil {
+				return "", fmt.Errorf("%s: %v", tc.metric, err)
 			}
 
-			// Read response body to check if we got data
-			body := make([]byte, 1024)
-			n, _ := resp.Body.Read(body)
-			responseBody := string(body[:n])
-
-			// Check if response contains data (not just empty result)
-			if strings.Contains(responseBody, `"result":[]`) {
-				return "", fmt.Errorf("no data found for metric %s", metricName)
+			if err := vector.CheckRange(tc.min, tc.max); err != nil {
+				return "", err
+			}
+			if err := checkLabels(vector, expected.Labels, tc.withLabels); err != nil {
+				return "", err
 			}
 
-			t.Logf("✅ Metric %s is being collected", metricName)
-			return fmt.Sprintf("Metric %s found", metricName), nil
+			t.Logf("✅ Metric %s is being collected (%d series)", tc.metric, len(vector))
+			return fmt.Sprintf("Metric %s found", tc.metric), nil
 		})
 	}
 
-	// Test that we can query for worker-specific metrics
-	retry.DoWithRetry(t, "Check worker target metrics", maxRetries, timeBetweenRetries, func() (string, error) {
-		// Query for up metric which should show targets
-		fullQueryURL := fmt.Sprintf("%s?query=up", queryURL)
-
-		resp, err := http.Get(fullQueryURL)
-		if err != nil {
-			return "", fmt.Errorf("failed to query up metric: %v", err)
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			return "", fmt.Errorf("up metric query failed with status: %d", resp.StatusCode)
-		}
-
-		body := make([]byte, 2048)
-		n, _ := resp.Body.Read(body)
-		responseBody := string(body[:n])
-
-		// Check if we have targets reporting
-		if strings.Contains(responseBody, `"result":[]`) {
-			return "", fmt.Errorf("no targets found in up metric")
-		}
+	t.Log("✅ Metrics collection pipeline is working")
+}
 
-		// Look for job="all-smi" in the response
-		if !strings.Contains(responseBody, `job":"all-smi"`) {
-			return "", fmt.Errorf("all-smi job not found in targets")
+// checkOneSeriesPerInstance verifies that vector holds exactly one series for
+// each expected instance and no others.
+func checkOneSeriesPerInstance(vector testsupport.Vector, expected pipelineExpectation) error {
+	if len(vector) != len(expected.Instances) {
+		return fmt.Errorf("got %d series, want %d (one per worker)", len(vector), len(expected.Instances))
+	}
+	counts := vector.CountBy("instance")
+	for _, instance := range expected.Instances {
+		if counts[instance] != 1 {
+			return fmt.Errorf("got %d series for instance %s, want 1 (instances: %v)", counts[instance], instance, vector.LabelValues("instance"))
 		}
+	}
+	return checkLabels(vector, expected.Labels, nil)
+}
 
-		t.Log("✅ Metrics collection pipeline is working")
-		return "Pipeline is functional", nil
-	})
+// checkEveryInstanceReports verifies that the set of instances in vector is
+// exactly instances, which must be sorted.
+func checkEveryInstanceReports(vector testsupport.Vector, instances []string) error {
+	if len(vector) == 0 {
+		return fmt.Errorf("no data found")
+	}
+	if got := vector.LabelValues("instance"); !reflect.DeepEqual(got, instances) {
+		return fmt.Errorf("got series for instances %v, want %v", got, instances)
+	}
+	return nil
 }
 
-func getEnvOrDefault(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// checkLabels verifies that every series carries labels and has a non-empty
+// value for each of names.
+func checkLabels(vector testsupport.Vector, labels testsupport.Labels, names []string) error {
+	if filtered := vector.Filter(labels); len(filtered) != len(vector) {
+		return fmt.Errorf("%d of %d series lack labels %s", len(vector)-len(filtered), len(vector), labels)
 	}
-	return defaultValue
-}
\ No newline at end of file
+	for _, sample := range vector {
+		for _, name := range names {
+			if sample.Metric[name] == "" {
+				return fmt.Errorf("%s has no %s label", sample.Metric, name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package test
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"algalon-terraform-testsupport"
+)
+
+// TestMetricsCollectionPipelineOffline runs the same pipeline assertions as
+// the GCP deployment against a fake all-smi exporter and an in-process
+// VictoriaMetrics stand-in. It needs no cloud credentials.
+func TestMetricsCollectionPipelineOffline(t *testing.T) {
+	t.Parallel()
+
+	workers := []*testsupport.FakeAllSmiExporter{
+		testsupport.NewFakeAllSmiExporter(t, "algalon-worker-1"),
+		testsupport.NewFakeAllSmiExporter(t, "algalon-worker-2",
+			testsupport.FakeGPU{Name: "NVIDIA Tesla V100", UUID: "GPU-11111111-1111-1111-1111-111111111111", Utilization: 87, MemoryUtilization: 64},
+			testsupport.FakeGPU{Name: "NVIDIA Tesla V100", UUID: "GPU-22222222-2222-2222-2222-222222222222", Utilization: 3, MemoryUtilization: 5},
+		),
+	}
+
+	var targets []testsupport.FakeScrapeTarget
+	var instances []string
+	for _, worker := range workers {
+		instances = append(instances, worker.Address())
+		targets = append(targets, testsupport.FakeScrapeTarget{
+			Job:     "all-smi",
+			Address: worker.Address(),
+			Labels: map[string]string{
+				"cluster":     "offline-test",
+				"environment": "testing",
+			},
+		})
+	}
+
+	victoriaMetrics := testsupport.NewFakeVictoriaMetrics(t, 100*time.Millisecond, targets...)
+
+	checkVictoriaMetricsHealth(t, victoriaMetrics.URL, 5, time.Second)
+	checkMetricsCollectionPipeline(t, victoriaMetrics.URL, pipelineExpectation{
+		Instances: instances,
+		Labels: testsupport.Labels{
+			"job":         "all-smi",
+			"cluster":     "offline-test",
+			"environment": "testing",
+		},
+	}, 5, time.Second)
+}
+
+func TestPipelineAssertionsDetectMissingWorkers(t *testing.T) {
+	t.Parallel()
+
+	worker := testsupport.NewFakeAllSmiExporter(t, "algalon-worker-1")
+	stopped := testsupport.NewFakeAllSmiExporter(t, "algalon-worker-2")
+	stopped.Close()
+
+	labels := map[string]string{"cluster": "offline-test", "environment": "testing"}
+	victoriaMetrics := testsupport.NewFakeVictoriaMetrics(t, time.Hour,
+		testsupport.FakeScrapeTarget{Job: "all-smi", Address: worker.Address(), Labels: labels},
+		testsupport.FakeScrapeTarget{Job: "all-smi", Address: stopped.Address(), Labels: labels},
+	)
+	client := testsupport.NewPrometheusClient(victoriaMetrics.URL)
+
+	expected := pipelineExpectation{
+		Instances: []string{worker.Address(), stopped.Address()},
+		Labels: testsupport.Labels{
+			"job":         "all-smi",
+			"cluster":     "offline-test",
+			"environment": "testing",
+		},
+	}
+
+	// Both targets have an up series, but the stopped one reports 0
+	up, err := client.QueryVector(`up{job="all-smi"}`)
+	require.NoError(t, err)
+	assert.NoError(t, checkOneSeriesPerInstance(up, expected))
+	assert.Error(t, up.CheckRange(1, 1))
+
+	var down []string
+	for _, sample := range up {
+		if sample.Point.Value == 0 {
+			down = append(down, sample.Metric["instance"])
+		}
+	}
+	assert.Equal(t, []string{stopped.Address()}, down)
+
+	// all_smi_info is only reported by the running worker
+	info, err := client.QueryVector(`all_smi_info{job="all-smi"}`)
+	require.NoError(t, err)
+	assert.Len(t, info, 1)
+	assert.Error(t, checkOneSeriesPerInstance(info, expected))
+	instances := append([]string(nil), expected.Instances...)
+	sort.Strings(instances)
+	assert.Error(t, checkEveryInstanceReports(info, instances))
+
+	// A label the targets do not carry is reported
+	expected.Labels["cluster"] = "other-cluster"
+	info, err = client.QueryVector(`all_smi_info{job="all-smi"}`)
+	require.NoError(t, err)
+	assert.Error(t, checkLabels(info, expected.Labels, nil))
+}
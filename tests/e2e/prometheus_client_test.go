@@ -0,0 +1,141 @@
+package test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"algalon-terraform-testsupport"
+)
+
+func TestPrometheusClientDecodesResults(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		body        string
+		status      int
+		expectType  string
+		expectError bool
+		check       func(t *testing.T, result *testsupport.QueryResult)
+	}{
+		{
+			name:       "Vector",
+			body:       `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"__name__":"up","job":"all-smi","instance":"10.1.0.2:9090"},"value":[1700000000.5,"1"]}]}}`,
+			expectType: testsupport.ResultTypeVector,
+			check: func(t *testing.T, result *testsupport.QueryResult) {
+				require.Len(t, result.Vector, 1)
+				sample := result.Vector[0]
+				assert.Equal(t, testsupport.Labels{"__name__": "up", "job": "all-smi", "instance": "10.1.0.2:9090"}, sample.Metric)
+				assert.Equal(t, 1.0, sample.Point.Value)
+				assert.Equal(t, time.Unix(1700000000, 5e8), sample.Point.Timestamp)
+				assert.Equal(t, `up{instance="10.1.0.2:9090",job="all-smi"}`, sample.Metric.String())
+			},
+		},
+		{
+			name:       "Empty Vector",
+			body:       `{"status":"success","data":{"resultType":"vector","result":[]}}`,
+			expectType: testsupport.ResultTypeVector,
+			check: func(t *testing.T, result *testsupport.QueryResult) {
+				assert.NotNil(t, result.Vector)
+				assert.Empty(t, result.Vector)
+			},
+		},
+		{
+			name:       "Matrix",
+			body:       `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"gpu":"NVIDIA Tesla T4"},"values":[[1700000000,"42"],[1700000015,"43.5"]]}]}}`,
+			expectType: testsupport.ResultTypeMatrix,
+			check: func(t *testing.T, result *testsupport.QueryResult) {
+				require.Len(t, result.Matrix, 1)
+				require.Len(t, result.Matrix[0].Points, 2)
+				assert.Equal(t, 43.5, result.Matrix[0].Points[1].Value)
+				assert.Equal(t, time.Unix(1700000015, 0), result.Matrix[0].Points[1].Timestamp)
+			},
+		},
+		{
+			name:       "Scalar",
+			body:       `{"status":"success","data":{"resultType":"scalar","result":[1700000000,"NaN"]}}`,
+			expectType: testsupport.ResultTypeScalar,
+			check: func(t *testing.T, result *testsupport.QueryResult) {
+				require.NotNil(t, result.Scalar)
+				assert.NotEqual(t, result.Scalar.Value, result.Scalar.Value) // NaN
+			},
+		},
+		{
+			name:        "API Error",
+			body:        `{"status":"error","errorType":"bad_data","error":"unsupported query"}`,
+			status:      http.StatusBadRequest,
+			expectError: true,
+		},
+		{
+			name:        "Malformed Value",
+			body:        `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1700000000,"high"]}]}}`,
+			expectError: true,
+		},
+		{
+			name:        "Not JSON",
+			body:        `<html>502 Bad Gateway</html>`,
+			status:      http.StatusBadGateway,
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tc.status != 0 {
+					w.WriteHeader(tc.status)
+				}
+				fmt.Fprint(w, tc.body)
+			}))
+			defer server.Close()
+
+			result, err := testsupport.NewPrometheusClient(server.URL).Query("up")
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectType, result.Type)
+			tc.check(t, result)
+		})
+	}
+}
+
+func TestPrometheusClientSendsRangeParameters(t *testing.T) {
+	t.Parallel()
+
+	var paths []string
+	var form map[string][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		r.ParseForm()
+		form = r.Form
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"matrix","result":[]}}`)
+	}))
+	defer server.Close()
+
+	start := time.Unix(1700000000, 0)
+	matrix, err := testsupport.NewPrometheusClient(server.URL+"/").QueryMatrix("all_smi_gpu_utilization", start, start.Add(time.Hour), 15*time.Second)
+	require.NoError(t, err)
+	assert.Empty(t, matrix)
+	assert.Equal(t, []string{"/api/v1/query_range"}, paths)
+
+	assert.Equal(t, []string{"all_smi_gpu_utilization"}, form["query"])
+	assert.Equal(t, []string{"1700000000"}, form["start"])
+	assert.Equal(t, []string{"1700003600"}, form["end"])
+	assert.Equal(t, []string{"15"}, form["step"])
+
+	// A result of the wrong type is reported rather than decoded as empty
+	_, err = testsupport.NewPrometheusClient(server.URL).QueryVector("up")
+	assert.Error(t, err)
+}
@@ -1,87 +1,26 @@
 package test
 
 import (
-	"fmt"
-	"os"
 	"testing"
-	"time"
 
-	"github.com/gruntwork-io/terratest/modules/gcp"
-	"github.com/gruntwork-io/terratest/modules/random"
 	"github.com/gruntwork-io/terratest/modules/terraform"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/api/compute/v1"
+
+	"algalon-terraform-testsupport"
 )
 
 func TestBasicDeploymentIntegration(t *testing.T) {
 	t.Parallel()
 
 	// Skip if running in CI without GCP credentials
-	if os.Getenv("TF_VAR_project_id") == "" {
-		t.Skip("Skipping integration test: TF_VAR_project_id not set")
-	}
+	deployment := testsupport.NewDeploymentFromEnv(t, "integration")
+	deployment.WorkerCount = 1 // Keep minimal for integration test
 
-	projectID := os.Getenv("TF_VAR_project_id")
-	region := getEnvOrDefault("TF_VAR_region", "us-central1")
-
-	// Determine deployment type based on environment variable
-	deploymentType := getEnvOrDefault("TEST_DEPLOYMENT_TYPE", "training-cluster")
-
-	// Generate unique deployment name
-	uniqueID := random.UniqueId()
-	deploymentName := fmt.Sprintf("algalon-integration-%s-%s", deploymentType, uniqueID)
-
-	// Set terraform directory based on deployment type
-	var terraformDir string
-	var vars map[string]interface{}
-
-	if deploymentType == "host-only" {
-		terraformDir = "../../terraform/examples/host-only"
-		vars = map[string]interface{}{
-			"project_id":        projectID,
-			"region":            region,
-			"deployment_name":   deploymentName,
-			"cluster_name":      "integration-test",
-			"environment_name":  "testing",
-			"enable_host_external_ip":  true,
-			"reserve_static_ip":        false, // Cost optimization
-			"grafana_allowed_ips": []string{"0.0.0.0/0"}, // Allow all for testing
-			"ssh_allowed_ips":     []string{"0.0.0.0/0"}, // Allow all for testing
-			"host_machine_type":   "n1-standard-2", // Smaller for testing
-		}
-	} else {
-		terraformDir = "../../terraform/examples/training-cluster"
-		vars = map[string]interface{}{
-			"project_id":        projectID,
-			"region":            region,
-			"deployment_name":   deploymentName,
-			"worker_count":      1, // Keep minimal for integration test
-			"gpu_type":          "nvidia-tesla-t4",
-			"cluster_name":      "integration-test",
-			"environment_name":  "testing",
-			"use_preemptible_workers": true, // Cost optimization
-			"enable_host_external_ip":  true,
-			"enable_worker_external_ip": false,
-			"grafana_allowed_ips": []string{"0.0.0.0/0"}, // Allow all for testing
-			"ssh_allowed_ips":     []string{"0.0.0.0/0"}, // Allow all for testing
-			"host_machine_type":     "n1-standard-2", // Smaller for testing
-			"worker_machine_type":   "n1-standard-1", // Smaller for testing
-		}
-	}
-
-	t.Logf("Running integration test for deployment type: %s", deploymentType)
+	t.Logf("Running integration test for deployment type: %s", deployment.Type)
 
-	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: terraformDir,
-		NoColor:      true,
-		Vars:         vars,
-		RetryableTerraformErrors: map[string]string{
-			".*timeout.*":                    "Timeout occurred",
-			".*Error waiting for operation.*": "GCP operation timeout",
-		},
-		MaxRetries:         3,
-		TimeBetweenRetries: 30 * time.Second,
-	})
+	terraformOptions := deployment.TerraformOptions(t)
 
 	// Clean up resources with "terraform destroy" at the end of the test
 	defer terraform.Destroy(t, terraformOptions)
@@ -90,23 +29,23 @@ func TestBasicDeploymentIntegration(t *testing.T) {
 	terraform.InitAndApply(t, terraformOptions)
 
 	// Test that infrastructure was created correctly
-	testInfrastructureCreation(t, terraformOptions, projectID, region, deploymentType)
+	testInfrastructureCreation(t, terraformOptions, deployment)
 
 	// Test network connectivity
-	testNetworkConnectivity(t, terraformOptions, deploymentType)
+	testNetworkConnectivity(t, terraformOptions, deployment.Type)
 
 	// Test monitoring endpoints
-	testMonitoringEndpoints(t, terraformOptions, deploymentType)
+	testMonitoringEndpoints(t, terraformOptions, deployment.Type)
 }
 
-func testInfrastructureCreation(t *testing.T, terraformOptions *terraform.Options, projectID, region, deploymentType string) {
+func testInfrastructureCreation(t *testing.T, terraformOptions *terraform.Options, deployment *testsupport.Deployment) {
 	// Get outputs
 	networkName := terraform.Output(t, terraformOptions, "network_name")
 	monitoringHostIP := terraform.Output(t, terraformOptions, "monitoring_host_external_ip")
 
 	// Verify network was created
 	assert.NotEmpty(t, networkName)
-	network := gcp.GetNetwork(t, projectID, networkName)
+	network := testsupport.GetNetwork(t, deployment.ProjectID, networkName)
 	assert.NotNil(t, network)
 
 	// Verify monitoring host was created
@@ -114,10 +53,10 @@ func testInfrastructureCreation(t *testing.T, terraformOptions *terraform.Option
 	assert.Regexp(t, `^\d+\.\d+\.\d+\.\d+$`, monitoringHostIP)
 
 	// Verify instances exist in GCP
-	instances := gcp.GetInstancesInRegion(t, projectID, region)
+	instances := testsupport.GetInstancesInRegion(t, deployment.ProjectID, deployment.Region)
 
-	var monitoringInstance *gcp.Instance
-	var workerInstances []*gcp.Instance
+	var monitoringInstance *compute.Instance
+	var workerInstances []*compute.Instance
 
 	for _, instance := range instances {
 		if instance.Labels["component"] == "algalon-host" {
@@ -131,23 +70,23 @@ func testInfrastructureCreation(t *testing.T, terraformOptions *terraform.Option
 	require.NotNil(t, monitoringInstance, "Monitoring instance should exist")
 
 	// Verify instance labels for monitoring host
-	assert.Equal(t, "integration-test", monitoringInstance.Labels["cluster"])
-	assert.Equal(t, "testing", monitoringInstance.Labels["environment"])
+	assert.Equal(t, deployment.ClusterName, monitoringInstance.Labels["cluster"])
+	assert.Equal(t, deployment.EnvironmentName, monitoringInstance.Labels["environment"])
 	assert.Equal(t, "algalon-host", monitoringInstance.Labels["component"])
 
-	if deploymentType == "training-cluster" {
+	if deployment.Type == testsupport.TrainingCluster {
 		// For training cluster, verify workers were created
 		workerInternalIPs := terraform.OutputList(t, terraformOptions, "worker_internal_ips")
-		assert.Len(t, workerInternalIPs, 1)
+		assert.Len(t, workerInternalIPs, deployment.WorkerCount)
 		for _, ip := range workerInternalIPs {
 			assert.Regexp(t, `^\d+\.\d+\.\d+\.\d+$`, ip)
 		}
 
-		require.Len(t, workerInstances, 1, "Worker instances should exist for training cluster")
+		require.Len(t, workerInstances, deployment.WorkerCount, "Worker instances should exist for training cluster")
 
 		for _, workerInstance := range workerInstances {
-			assert.Equal(t, "integration-test", workerInstance.Labels["cluster"])
-			assert.Equal(t, "testing", workerInstance.Labels["environment"])
+			assert.Equal(t, deployment.ClusterName, workerInstance.Labels["cluster"])
+			assert.Equal(t, deployment.EnvironmentName, workerInstance.Labels["environment"])
 			assert.Equal(t, "algalon-worker", workerInstance.Labels["component"])
 		}
 	} else {
@@ -162,7 +101,7 @@ func testNetworkConnectivity(t *testing.T, terraformOptions *terraform.Options,
 
 	assert.NotEmpty(t, monitoringHostIP)
 
-	if deploymentType == "training-cluster" {
+	if deploymentType == testsupport.TrainingCluster {
 		// Verify IPs are in the expected subnet range (10.1.x.x for training cluster)
 		assert.Regexp(t, `^10\.1\.\d+\.\d+$`, monitoringHostIP)
 
@@ -187,7 +126,7 @@ func testMonitoringEndpoints(t *testing.T, terraformOptions *terraform.Options,
 	assert.Regexp(t, `^http://\d+\.\d+\.\d+\.\d+:3000$`, grafanaURL)
 	assert.Regexp(t, `^http://\d+\.\d+\.\d+\.\d+:8428$`, victoriaMetricsURL)
 
-	if deploymentType == "training-cluster" {
+	if deploymentType == testsupport.TrainingCluster {
 		workerEndpoints := terraform.OutputList(t, terraformOptions, "worker_metrics_endpoints")
 		assert.NotEmpty(t, workerEndpoints, "Worker endpoints should exist for training cluster")
 
@@ -201,10 +140,3 @@ func testMonitoringEndpoints(t *testing.T, terraformOptions *terraform.Options,
 	// 2. This would require more complex networking setup in CI
 	// 3. End-to-end tests will cover actual service availability
 }
-
-func getEnvOrDefault(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
-}
\ No newline at end of file
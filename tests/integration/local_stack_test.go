@@ -0,0 +1,327 @@
+package test
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appleparan/Algalon/pkg/targets"
+	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+
+	"algalon-terraform-testsupport"
+)
+
+const (
+	hostStackDir = "../../algalon_host"
+
+	// Published ports of the host stack; vmagent's is added by the override.
+	localVictoriaMetricsURL = "http://localhost:8428"
+	localVMAgentURL         = "http://localhost:8429"
+	localGrafanaURL         = "http://localhost:3000"
+)
+
+// localStackOverride lets vmagent reach exporters on the machine running the
+// test and exposes its HTTP API, which serves the active target list.
+const localStackOverride = `services:
+  vmagent:
+    ports:
+      - "8429:8429"
+    extra_hosts:
+      - "host.docker.internal:host-gateway"
+`
+
+var fileSDCheckIntervalPattern = regexp.MustCompile(`--promscrape\.fileSDCheckInterval=([0-9a-z]+)`)
+
+// TestHostStackLocal brings up algalon_host/docker-compose.yml with Docker and
+// checks provisioning and file_sd pickup against a fake all-smi exporter. It
+// needs no cloud credentials, but uses the stack's fixed container names and
+// ports, so a running local Algalon host must be stopped first.
+func TestHostStackLocal(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping local docker-compose stack test in short mode")
+	}
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("Skipping local docker-compose stack test: docker is not installed")
+	}
+	if out, err := exec.Command("docker", "compose", "version").CombinedOutput(); err != nil {
+		t.Skipf("Skipping local docker-compose stack test: docker compose is unavailable: %s", strings.TrimSpace(string(out)))
+	}
+
+	stackDir := copyHostStack(t)
+	fileSDCheckInterval := readFileSDCheckInterval(t, filepath.Join(stackDir, "docker-compose.yml"))
+	scrapeInterval := readScrapeInterval(t, filepath.Join(stackDir, "prometheus.yml"), "all-smi")
+
+	compose := newComposeProject(t, stackDir)
+	compose.up(t)
+
+	testsupport.WaitForHTTPOK(t, "VictoriaMetrics", localVictoriaMetricsURL+"/health", 30, 5*time.Second)
+	testsupport.WaitForHTTPOK(t, "VMAgent", localVMAgentURL+"/health", 30, 5*time.Second)
+	testsupport.WaitForHTTPOK(t, "Grafana", localGrafanaURL+"/api/health", 60, 5*time.Second)
+
+	testGrafanaProvisioning(t)
+	testFileSDPickup(t, stackDir, fileSDCheckInterval, scrapeInterval)
+}
+
+func testGrafanaProvisioning(t *testing.T) {
+	grafana := testsupport.NewGrafanaClient(localGrafanaURL, "admin", "admin")
+
+	// Provisioning runs after the health endpoint comes up, so allow a few
+	// retries before treating a missing object as a failure
+	retry.DoWithRetry(t, "Check VictoriaMetrics datasource", 12, 5*time.Second, func() (string, error) {
+		datasource, err := grafana.Datasource("vm-gpu")
+		if err != nil {
+			return "", err
+		}
+		if datasource.Type != "victoriametrics-metrics-datasource" {
+			return "", fmt.Errorf("datasource vm-gpu has type %s", datasource.Type)
+		}
+		if datasource.URL != "http://victoriametrics:8428" {
+			return "", fmt.Errorf("datasource vm-gpu points at %s", datasource.URL)
+		}
+		if !datasource.IsDefault {
+			return "", fmt.Errorf("datasource vm-gpu is not the default datasource")
+		}
+
+		t.Log("✅ VictoriaMetrics datasource is provisioned")
+		return datasource.Name, nil
+	})
+
+	dashboards := map[string]string{
+		"all-smi-monitoring": "All-SMI Hardware Monitoring",
+		"gpu-monitoring":     "GPU Monitoring Dashboard",
+		"all-smi-system":     "All-SMI System Monitoring",
+	}
+
+	for uid, title := range dashboards {
+		uid, title := uid, title // capture for closure
+		retry.DoWithRetry(t, fmt.Sprintf("Check dashboard %s", uid), 12, 5*time.Second, func() (string, error) {
+			dashboard, err := grafana.Dashboard(uid)
+			if err != nil {
+				return "", err
+			}
+			if dashboard.Title != title {
+				return "", fmt.Errorf("dashboard %s has title %q, want %q", uid, dashboard.Title, title)
+			}
+			if !dashboard.Provisioned {
+				return "", fmt.Errorf("dashboard %s was not provisioned from file", uid)
+			}
+
+			t.Logf("✅ Dashboard %s is provisioned", uid)
+			return dashboard.Title, nil
+		})
+	}
+}
+
+// testFileSDPickup writes a new all-smi-*.yml target file and requires vmagent
+// to scrape it within one file_sd check interval plus one scrape interval.
+func testFileSDPickup(t *testing.T, stackDir string, fileSDCheckInterval, scrapeInterval time.Duration) {
+	exporter := testsupport.ListenFakeAllSmiExporter(t, ":0", "smoke-worker-1")
+	target := fmt.Sprintf("host.docker.internal:%d", exporter.Port())
+
+	labels := targets.DefaultLabels("smoke-test", "local")
+	targetFile := filepath.Join(stackDir, "node", "targets", "all-smi-smoke.yml")
+	require.NoError(t, targets.WriteFile(targetFile, []targets.Group{{
+		Targets: []string{target},
+		Labels:  labels,
+	}}))
+	writtenAt := time.Now()
+
+	// Allow for the first scrape landing anywhere in the scrape interval,
+	// plus a little slack for the scrape itself
+	deadline := writtenAt.Add(fileSDCheckInterval + scrapeInterval + 10*time.Second)
+	vmagent := testsupport.NewPrometheusClient(localVMAgentURL)
+
+	var lastState string
+	for {
+		active, err := vmagent.Targets()
+		if err != nil {
+			lastState = err.Error()
+		} else {
+			lastState = describeTarget(active, target)
+			if lastState == "up" {
+				break
+			}
+		}
+
+		if time.Now().After(deadline) {
+			require.FailNowf(t, "target file was not picked up",
+				"vmagent did not scrape %s within %s of writing %s (fileSDCheckInterval=%s, scrape_interval=%s): %s",
+				target, deadline.Sub(writtenAt), filepath.Base(targetFile), fileSDCheckInterval, scrapeInterval, lastState)
+		}
+		time.Sleep(time.Second)
+	}
+	t.Logf("✅ vmagent scraped %s %s after the target file was written", target, time.Since(writtenAt).Round(time.Second))
+
+	// VictoriaMetrics hides samples younger than its 30s search latency
+	// offset, so the stored series show up some time after the scrape
+	victoriaMetrics := testsupport.NewPrometheusClient(localVictoriaMetricsURL)
+	retry.DoWithRetry(t, "Check scraped series", 24, 5*time.Second, func() (string, error) {
+		vector, err := victoriaMetrics.QueryVector(fmt.Sprintf(`all_smi_gpu_utilization{job="all-smi",instance=%q}`, target))
+		if err != nil {
+			return "", err
+		}
+		if len(vector) != 1 {
+			return "", fmt.Errorf("got %d all_smi_gpu_utilization series for %s, want 1", len(vector), target)
+		}
+		if err := checkSeriesLabels(vector, labels); err != nil {
+			return "", err
+		}
+
+		t.Logf("✅ all-smi metrics from %s are stored in VictoriaMetrics", target)
+		return "Series found", nil
+	})
+}
+
+// describeTarget returns "up" when target is active and healthy, and a
+// description of its state otherwise.
+func describeTarget(active []testsupport.ScrapeTarget, target string) string {
+	for _, scrapeTarget := range active {
+		if scrapeTarget.Labels["instance"] != target {
+			continue
+		}
+		if scrapeTarget.Health == "up" {
+			return "up"
+		}
+		return fmt.Sprintf("target is %s: %s", scrapeTarget.Health, scrapeTarget.LastError)
+	}
+	return fmt.Sprintf("target is not among the %d active targets", len(active))
+}
+
+func checkSeriesLabels(vector testsupport.Vector, labels map[string]string) error {
+	if filtered := vector.Filter(labels); len(filtered) != len(vector) {
+		return fmt.Errorf("%d of %d series lack labels %s", len(vector)-len(filtered), len(vector), testsupport.Labels(labels))
+	}
+	return nil
+}
+
+// copyHostStack copies the compose file, scrape config and Grafana
+// provisioning into a temporary directory with an empty targets directory,
+// so the test never touches the checked-in node/targets.
+func copyHostStack(t *testing.T) string {
+	t.Helper()
+
+	stackDir := t.TempDir()
+	for _, name := range []string{"docker-compose.yml", "prometheus.yml", "grafana"} {
+		require.NoError(t, copyPath(filepath.Join(hostStackDir, name), filepath.Join(stackDir, name)))
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(stackDir, "node", "targets"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(stackDir, "docker-compose.smoke.yml"), []byte(localStackOverride), 0644))
+
+	return stackDir
+}
+
+func copyPath(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		// The containers run as non-root users and must be able to read the
+		// mounted files
+		return os.WriteFile(target, data, 0644)
+	})
+}
+
+func readFileSDCheckInterval(t *testing.T, composeFile string) time.Duration {
+	t.Helper()
+
+	data, err := os.ReadFile(composeFile)
+	require.NoError(t, err)
+
+	match := fileSDCheckIntervalPattern.FindSubmatch(data)
+	require.NotNil(t, match, "%s does not set --promscrape.fileSDCheckInterval", composeFile)
+
+	interval, err := time.ParseDuration(string(match[1]))
+	require.NoError(t, err)
+	return interval
+}
+
+func readScrapeInterval(t *testing.T, configFile, job string) time.Duration {
+	t.Helper()
+
+	data, err := os.ReadFile(configFile)
+	require.NoError(t, err)
+
+	var config struct {
+		Global struct {
+			ScrapeInterval string `yaml:"scrape_interval"`
+		} `yaml:"global"`
+		ScrapeConfigs []struct {
+			JobName        string `yaml:"job_name"`
+			ScrapeInterval string `yaml:"scrape_interval"`
+		} `yaml:"scrape_configs"`
+	}
+	require.NoError(t, yaml.Unmarshal(data, &config))
+
+	raw := config.Global.ScrapeInterval
+	for _, scrapeConfig := range config.ScrapeConfigs {
+		if scrapeConfig.JobName == job && scrapeConfig.ScrapeInterval != "" {
+			raw = scrapeConfig.ScrapeInterval
+		}
+	}
+	require.NotEmpty(t, raw, "%s sets no scrape_interval for job %s", configFile, job)
+
+	interval, err := time.ParseDuration(raw)
+	require.NoError(t, err)
+	return interval
+}
+
+// composeProject runs docker compose for the copied stack under a unique
+// project name, so its volumes start empty and are removed afterwards.
+type composeProject struct {
+	dir  string
+	name string
+}
+
+func newComposeProject(t *testing.T, dir string) *composeProject {
+	return &composeProject{
+		dir:  dir,
+		name: fmt.Sprintf("algalon-smoke-%s", strings.ToLower(random.UniqueId())),
+	}
+}
+
+func (p *composeProject) run(args ...string) (string, error) {
+	args = append([]string{"compose", "-p", p.name, "-f", "docker-compose.yml", "-f", "docker-compose.smoke.yml"}, args...)
+	cmd := exec.Command("docker", args...)
+	cmd.Dir = p.dir
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+func (p *composeProject) up(t *testing.T) {
+	t.Helper()
+
+	t.Cleanup(func() {
+		if t.Failed() {
+			if logs, err := p.run("logs", "--no-color", "--tail", "50"); err == nil {
+				t.Logf("docker compose logs:\n%s", logs)
+			}
+		}
+		if out, err := p.run("down", "-v", "--remove-orphans"); err != nil {
+			t.Logf("docker compose down failed: %v\n%s", err, out)
+		}
+	})
+
+	out, err := p.run("up", "-d")
+	require.NoError(t, err, "docker compose up failed:\n%s", out)
+}
@@ -2,50 +2,36 @@ package test
 
 import (
 	"fmt"
-	"os"
 	"testing"
-	"time"
 
-	"github.com/gruntwork-io/terratest/modules/gcp"
 	"github.com/gruntwork-io/terratest/modules/random"
 	"github.com/gruntwork-io/terratest/modules/terraform"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/api/compute/v1"
+
+	"algalon-terraform-testsupport"
 )
 
 func TestNetworkModuleIntegration(t *testing.T) {
 	t.Parallel()
 
 	// Skip if running in CI without GCP credentials
-	if os.Getenv("TF_VAR_project_id") == "" {
-		t.Skip("Skipping integration test: TF_VAR_project_id not set")
-	}
-
-	projectID := os.Getenv("TF_VAR_project_id")
-	region := getEnvOrDefault("TF_VAR_region", "us-central1")
+	projectID := testsupport.RequireProjectID(t, "integration")
+	region := testsupport.GetEnvOrDefault("TF_VAR_region", "us-central1")
 
 	// Generate unique network name
 	uniqueID := random.UniqueId()
 	networkName := fmt.Sprintf("algalon-network-test-%s", uniqueID)
 
-	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "../../terraform/modules/network",
-		NoColor:      true,
-		Vars: map[string]interface{}{
-			"network_name":           networkName,
-			"region":                 region,
-			"subnet_cidr":            "10.2.0.0/16",
-			"grafana_allowed_ips":    []string{"10.0.0.0/8"},
-			"ssh_allowed_ips":        []string{"10.0.0.0/8"},
-			"enable_ssh_access":      true,
-			"enable_external_victoria_metrics": true,
-		},
-		RetryableTerraformErrors: map[string]string{
-			".*timeout.*":                    "Timeout occurred",
-			".*Error waiting for operation.*": "GCP operation timeout",
-		},
-		MaxRetries:         3,
-		TimeBetweenRetries: 30 * time.Second,
+	terraformOptions := testsupport.ApplyOptions(t, testsupport.NetworkModuleDir, map[string]interface{}{
+		"network_name":                     networkName,
+		"region":                           region,
+		"subnet_cidr":                      "10.2.0.0/16",
+		"grafana_allowed_ips":              []string{"10.0.0.0/8"},
+		"ssh_allowed_ips":                  []string{"10.0.0.0/8"},
+		"enable_ssh_access":                true,
+		"enable_external_victoria_metrics": true,
 	})
 
 	// Clean up resources with "terraform destroy" at the end of the test
@@ -70,14 +56,14 @@ func testNetworkCreation(t *testing.T, terraformOptions *terraform.Options, proj
 	assert.Equal(t, expectedNetworkName, actualNetworkName)
 
 	// Get network details from GCP
-	network := gcp.GetNetwork(t, projectID, expectedNetworkName)
+	network := testsupport.GetNetwork(t, projectID, expectedNetworkName)
 	require.NotNil(t, network)
 	assert.Equal(t, expectedNetworkName, network.Name)
 	assert.False(t, network.AutoCreateSubnetworks)
 
 	// Verify subnet was created
 	subnetName := terraform.Output(t, terraformOptions, "subnet_name")
-	subnet := gcp.GetSubnetwork(t, projectID, region, subnetName)
+	subnet := testsupport.GetSubnetwork(t, projectID, region, subnetName)
 	require.NotNil(t, subnet)
 	assert.Equal(t, "10.2.0.0/16", subnet.IpCidrRange)
 	assert.Equal(t, region, subnet.Region)
@@ -85,10 +71,10 @@ func testNetworkCreation(t *testing.T, terraformOptions *terraform.Options, proj
 
 func testFirewallRules(t *testing.T, terraformOptions *terraform.Options, projectID, networkName string) {
 	// Get all firewall rules for the project
-	firewallRules := gcp.GetFirewallRulesForNetwork(t, projectID, networkName)
+	firewallRules := testsupport.GetFirewallRulesForNetwork(t, projectID, networkName)
 
 	// Create a map for easier lookup
-	ruleMap := make(map[string]*gcp.FirewallRule)
+	ruleMap := make(map[string]*compute.Firewall)
 	for _, rule := range firewallRules {
 		ruleMap[rule.Name] = rule
 	}
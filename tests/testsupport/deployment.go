@@ -0,0 +1,158 @@
+package testsupport
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+)
+
+// Terraform directories, relative to a suite directory such as tests/unit.
+const (
+	NetworkModuleDir       = "../../terraform/modules/network"
+	HostModuleDir          = "../../terraform/modules/algalon-host"
+	WorkerModuleDir        = "../../terraform/modules/algalon-worker"
+	HostOnlyExampleDir     = "../../terraform/examples/host-only"
+	TrainingClusterExample = "../../terraform/examples/training-cluster"
+)
+
+// Deployment types selected through TEST_DEPLOYMENT_TYPE.
+const (
+	HostOnly        = "host-only"
+	TrainingCluster = "training-cluster"
+)
+
+// retryableErrors are the GCP failures worth retrying on apply and destroy.
+var retryableErrors = map[string]string{
+	".*timeout.*":                     "Timeout occurred",
+	".*Error waiting for operation.*": "GCP operation timeout",
+}
+
+// Deployment describes one of the example deployments under
+// terraform/examples, with the test-sized defaults the integration and e2e
+// suites share.
+type Deployment struct {
+	Type            string
+	ProjectID       string
+	Region          string
+	Name            string
+	ClusterName     string
+	EnvironmentName string
+	HostMachineType string
+
+	// Training-cluster settings; ignored for host-only deployments.
+	WorkerCount            int
+	GPUType                string
+	WorkerMachineType      string
+	UsePreemptibleWorkers  bool
+	EnableWorkerExternalIP bool
+	AllSmiInterval         int
+}
+
+// NewDeployment returns a deployment fixture for deploymentType with a unique
+// name derived from suite, e.g. "algalon-e2e-training-cluster-ab12cd".
+func NewDeployment(deploymentType, projectID, suite string) *Deployment {
+	return &Deployment{
+		Type:                  deploymentType,
+		ProjectID:             projectID,
+		Region:                GetEnvOrDefault("TF_VAR_region", "us-central1"),
+		Name:                  fmt.Sprintf("algalon-%s-%s-%s", suite, deploymentType, random.UniqueId()),
+		ClusterName:           fmt.Sprintf("%s-test", suite),
+		EnvironmentName:       "testing",
+		HostMachineType:       "n1-standard-2", // Smaller for testing
+		WorkerCount:           1,
+		GPUType:               "nvidia-tesla-t4",
+		WorkerMachineType:     "n1-standard-1", // Smaller for testing
+		UsePreemptibleWorkers: true,            // Cost optimization
+	}
+}
+
+// NewDeploymentFromEnv is NewDeployment with the deployment type taken from
+// TEST_DEPLOYMENT_TYPE (default training-cluster). It skips the test when
+// TF_VAR_project_id is not set.
+func NewDeploymentFromEnv(t *testing.T, suite string) *Deployment {
+	t.Helper()
+
+	projectID := RequireProjectID(t, suite)
+	deploymentType := GetEnvOrDefault("TEST_DEPLOYMENT_TYPE", TrainingCluster)
+
+	return NewDeployment(deploymentType, projectID, suite)
+}
+
+// TerraformDir returns the example directory for the deployment type.
+func (d *Deployment) TerraformDir() string {
+	if d.Type == HostOnly {
+		return HostOnlyExampleDir
+	}
+	return TrainingClusterExample
+}
+
+// Vars returns the Terraform variables for the deployment.
+func (d *Deployment) Vars() map[string]interface{} {
+	vars := map[string]interface{}{
+		"project_id":              d.ProjectID,
+		"region":                  d.Region,
+		"deployment_name":         d.Name,
+		"cluster_name":            d.ClusterName,
+		"environment_name":        d.EnvironmentName,
+		"enable_host_external_ip": true,
+		"grafana_allowed_ips":     []string{"0.0.0.0/0"}, // Allow all for testing
+		"ssh_allowed_ips":         []string{"0.0.0.0/0"}, // Allow all for testing
+		"host_machine_type":       d.HostMachineType,
+	}
+
+	if d.Type == HostOnly {
+		vars["reserve_static_ip"] = false // Cost optimization
+		return vars
+	}
+
+	vars["worker_count"] = d.WorkerCount
+	vars["gpu_type"] = d.GPUType
+	vars["worker_machine_type"] = d.WorkerMachineType
+	vars["use_preemptible_workers"] = d.UsePreemptibleWorkers
+	vars["enable_worker_external_ip"] = d.EnableWorkerExternalIP
+	if d.AllSmiInterval > 0 {
+		vars["all_smi_interval"] = d.AllSmiInterval
+	}
+
+	return vars
+}
+
+// TerraformOptions returns apply/destroy options for the deployment.
+func (d *Deployment) TerraformOptions(t *testing.T) *terraform.Options {
+	return ApplyOptions(t, d.TerraformDir(), d.Vars())
+}
+
+// ApplyOptions returns options for suites that create real infrastructure,
+// retrying the GCP errors that are known to be transient.
+func ApplyOptions(t *testing.T, terraformDir string, vars map[string]interface{}) *terraform.Options {
+	errors := make(map[string]string, len(retryableErrors))
+	for pattern, message := range retryableErrors {
+		errors[pattern] = message
+	}
+
+	options := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
+		TerraformDir:             terraformDir,
+		NoColor:                  true,
+		Vars:                     vars,
+		RetryableTerraformErrors: errors,
+	})
+
+	// WithDefaultRetryableErrors resets the retry settings, so GCP's slower
+	// operations need theirs applied afterwards.
+	options.MaxRetries = 3
+	options.TimeBetweenRetries = 30 * time.Second
+
+	return options
+}
+
+// PlanOptions returns options for plan-only unit tests.
+func PlanOptions(t *testing.T, terraformDir string, vars map[string]interface{}) *terraform.Options {
+	return terraform.WithDefaultRetryableErrors(t, &terraform.Options{
+		TerraformDir: terraformDir,
+		NoColor:      true,
+		Vars:         vars,
+	})
+}
@@ -0,0 +1,31 @@
+// Package testsupport holds the fixtures, probes and clients shared by the
+// unit, integration and e2e terratest suites under tests/. Paths are relative
+// to a suite directory such as tests/unit, so every suite can use them as-is.
+package testsupport
+
+import (
+	"os"
+	"testing"
+)
+
+// GetEnvOrDefault returns the value of key, or defaultValue when it is unset
+// or empty.
+func GetEnvOrDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// RequireProjectID returns TF_VAR_project_id, skipping the test when it is not
+// set so suites that deploy real infrastructure stay green without GCP
+// credentials.
+func RequireProjectID(t *testing.T, suite string) string {
+	t.Helper()
+
+	projectID := os.Getenv("TF_VAR_project_id")
+	if projectID == "" {
+		t.Skipf("Skipping %s test: TF_VAR_project_id not set", suite)
+	}
+	return projectID
+}
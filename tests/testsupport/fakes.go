@@ -0,0 +1,636 @@
+package testsupport
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// FakeGPU describes a single GPU reported by the fake all-smi exporter.
+type FakeGPU struct {
+	Name              string
+	UUID              string
+	Utilization       float64
+	MemoryUtilization float64
+}
+
+// FakeAllSmiExporter serves a /metrics page shaped like the one all-smi
+// exposes on a worker, so the scrape path can be exercised without GPUs.
+type FakeAllSmiExporter struct {
+	*httptest.Server
+
+	mu             sync.Mutex
+	hostname       string
+	version        string
+	cpuUtilization float64
+	memUtilization float64
+	gpus           []FakeGPU
+}
+
+// NewFakeAllSmiExporter starts an exporter for hostname reporting gpus, or a
+// single Tesla T4 when none are given. It is closed when the test ends.
+func NewFakeAllSmiExporter(t *testing.T, hostname string, gpus ...FakeGPU) *FakeAllSmiExporter {
+	t.Helper()
+
+	return ListenFakeAllSmiExporter(t, "127.0.0.1:0", hostname, gpus...)
+}
+
+// ListenFakeAllSmiExporter is NewFakeAllSmiExporter listening on address,
+// e.g. ":0" so that containers can reach it through the Docker host gateway.
+func ListenFakeAllSmiExporter(t *testing.T, address, hostname string, gpus ...FakeGPU) *FakeAllSmiExporter {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", address)
+	require.NoError(t, err, "failed to listen on %s", address)
+
+	if len(gpus) == 0 {
+		gpus = []FakeGPU{{Name: "NVIDIA Tesla T4", UUID: "GPU-00000000-0000-0000-0000-000000000000", Utilization: 42, MemoryUtilization: 35}}
+	}
+
+	exporter := &FakeAllSmiExporter{
+		hostname:       hostname,
+		version:        "v0.9.0",
+		cpuUtilization: 12.5,
+		memUtilization: 48.0,
+		gpus:           gpus,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", exporter.handleMetrics)
+	exporter.Server = httptest.NewUnstartedServer(mux)
+	exporter.Listener.Close()
+	exporter.Listener = listener
+	exporter.Start()
+	t.Cleanup(exporter.Close)
+
+	return exporter
+}
+
+// Address returns the host:port pair the exporter listens on, in the same
+// format used by ALGALON_TARGETS and the file_sd target files.
+func (e *FakeAllSmiExporter) Address() string {
+	return strings.TrimPrefix(e.URL, "http://")
+}
+
+// Port returns the port the exporter listens on.
+func (e *FakeAllSmiExporter) Port() int {
+	return e.Listener.Addr().(*net.TCPAddr).Port
+}
+
+func (e *FakeAllSmiExporter) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP all_smi_info all-smi build and host information")
+	fmt.Fprintln(w, "# TYPE all_smi_info gauge")
+	fmt.Fprintf(w, "all_smi_info{hostname=%q,version=%q} 1\n", e.hostname, e.version)
+
+	fmt.Fprintln(w, "# HELP all_smi_gpu_utilization GPU utilization percentage")
+	fmt.Fprintln(w, "# TYPE all_smi_gpu_utilization gauge")
+	for i, gpu := range e.gpus {
+		fmt.Fprintf(w, "all_smi_gpu_utilization{gpu=%q,uuid=%q,index=\"%d\"} %g\n", gpu.Name, gpu.UUID, i, gpu.Utilization)
+	}
+
+	fmt.Fprintln(w, "# HELP all_smi_gpu_memory_utilization GPU memory utilization percentage")
+	fmt.Fprintln(w, "# TYPE all_smi_gpu_memory_utilization gauge")
+	for i, gpu := range e.gpus {
+		fmt.Fprintf(w, "all_smi_gpu_memory_utilization{gpu=%q,uuid=%q,index=\"%d\"} %g\n", gpu.Name, gpu.UUID, i, gpu.MemoryUtilization)
+	}
+
+	fmt.Fprintln(w, "# HELP all_smi_cpu_utilization CPU utilization percentage")
+	fmt.Fprintln(w, "# TYPE all_smi_cpu_utilization gauge")
+	fmt.Fprintf(w, "all_smi_cpu_utilization{hostname=%q} %g\n", e.hostname, e.cpuUtilization)
+
+	fmt.Fprintln(w, "# HELP all_smi_memory_utilization System memory utilization percentage")
+	fmt.Fprintln(w, "# TYPE all_smi_memory_utilization gauge")
+	fmt.Fprintf(w, "all_smi_memory_utilization{hostname=%q} %g\n", e.hostname, e.memUtilization)
+}
+
+// FakeScrapeTarget is a single entry the VictoriaMetrics stand-in scrapes,
+// mirroring one target of a file_sd group.
+type FakeScrapeTarget struct {
+	Job     string
+	Address string
+	Labels  map[string]string
+}
+
+type fakeSeries struct {
+	labels map[string]string
+	value  float64
+}
+
+// FakeVictoriaMetrics plays the role of vmagent and VictoriaMetrics together:
+// it scrapes the configured targets on an interval and answers instant
+// queries on /api/v1/query plus the /health probe.
+type FakeVictoriaMetrics struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	targets []FakeScrapeTarget
+	series  []fakeSeries
+	client  *http.Client
+}
+
+// NewFakeVictoriaMetrics scrapes targets once, then again every
+// scrapeInterval until the test ends.
+func NewFakeVictoriaMetrics(t *testing.T, scrapeInterval time.Duration, targets ...FakeScrapeTarget) *FakeVictoriaMetrics {
+	t.Helper()
+
+	vm := &FakeVictoriaMetrics{
+		targets: targets,
+		client:  &http.Client{Timeout: 5 * time.Second},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "OK")
+	})
+	mux.HandleFunc("/api/v1/query", vm.handleQuery)
+	vm.Server = httptest.NewServer(mux)
+
+	vm.scrapeAll()
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(scrapeInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				vm.scrapeAll()
+			}
+		}
+	}()
+
+	t.Cleanup(func() {
+		close(stop)
+		<-done
+		vm.Close()
+	})
+
+	return vm
+}
+
+func (vm *FakeVictoriaMetrics) scrapeAll() {
+	vm.mu.Lock()
+	targets := append([]FakeScrapeTarget(nil), vm.targets...)
+	vm.mu.Unlock()
+
+	var series []fakeSeries
+	for _, target := range targets {
+		targetLabels := map[string]string{
+			"job":      target.Job,
+			"instance": target.Address,
+		}
+		for name, value := range target.Labels {
+			targetLabels[name] = value
+		}
+
+		scraped, err := vm.scrape(target.Address)
+		up := 1.0
+		if err != nil {
+			up = 0
+			scraped = nil
+		}
+
+		for _, s := range scraped {
+			for name, value := range targetLabels {
+				s.labels[name] = value
+			}
+			series = append(series, s)
+		}
+
+		upLabels := map[string]string{"__name__": "up"}
+		for name, value := range targetLabels {
+			upLabels[name] = value
+		}
+		series = append(series, fakeSeries{labels: upLabels, value: up})
+	}
+
+	vm.mu.Lock()
+	vm.series = series
+	vm.mu.Unlock()
+}
+
+func (vm *FakeVictoriaMetrics) scrape(address string) ([]fakeSeries, error) {
+	resp, err := vm.client.Get(fmt.Sprintf("http://%s/metrics", address))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("scrape of %s returned status: %d", address, resp.StatusCode)
+	}
+
+	return parseExposition(resp.Body)
+}
+
+var sampleLinePattern = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(\{(.*)\})?\s+(\S+)(\s+\d+)?$`)
+var labelPairPattern = regexp.MustCompile(`\s*([a-zA-Z_][a-zA-Z0-9_]*)="((?:[^"\\]|\\.)*)"\s*,?`)
+
+// parseExposition reads the subset of the Prometheus text format that
+// all-smi emits: one sample per line, optional labels and no timestamps.
+func parseExposition(r io.Reader) ([]fakeSeries, error) {
+	var series []fakeSeries
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		match := sampleLinePattern.FindStringSubmatch(line)
+		if match == nil {
+			return nil, fmt.Errorf("malformed sample line: %q", line)
+		}
+
+		value, err := strconv.ParseFloat(match[4], 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed sample value in %q: %v", line, err)
+		}
+
+		labels := map[string]string{"__name__": match[1]}
+		for _, pair := range labelPairPattern.FindAllStringSubmatch(match[3], -1) {
+			unquoted, err := strconv.Unquote(`"` + pair[2] + `"`)
+			if err != nil {
+				return nil, fmt.Errorf("malformed label value in %q: %v", line, err)
+			}
+			labels[pair[1]] = unquoted
+		}
+
+		series = append(series, fakeSeries{labels: labels, value: value})
+	}
+
+	return series, scanner.Err()
+}
+
+var selectorPattern = regexp.MustCompile(`^\s*([a-zA-Z_:][a-zA-Z0-9_:]*)?\s*(\{(.*)\})?\s*$`)
+
+// parseSelector understands plain metric names and equality matchers such
+// as up{job="all-smi"}, which is all the pipeline assertions send.
+func parseSelector(query string) (map[string]string, error) {
+	match := selectorPattern.FindStringSubmatch(query)
+	if match == nil || (match[1] == "" && match[3] == "") {
+		return nil, fmt.Errorf("unsupported query: %q", query)
+	}
+
+	matchers := map[string]string{}
+	if match[1] != "" {
+		matchers["__name__"] = match[1]
+	}
+
+	body := strings.TrimSpace(match[3])
+	pairs := labelPairPattern.FindAllStringSubmatch(body, -1)
+	consumed := 0
+	for _, pair := range pairs {
+		consumed += len(pair[0])
+		matchers[pair[1]] = pair[2]
+	}
+	if consumed != len(body) {
+		return nil, fmt.Errorf("unsupported label matchers in query: %q", query)
+	}
+
+	return matchers, nil
+}
+
+type queryResponse struct {
+	Status    string     `json:"status"`
+	Data      *queryData `json:"data,omitempty"`
+	ErrorType string     `json:"errorType,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+type queryData struct {
+	ResultType string         `json:"resultType"`
+	Result     []vectorSample `json:"result"`
+}
+
+type vectorSample struct {
+	Metric map[string]string `json:"metric"`
+	Value  [2]interface{}    `json:"value"`
+}
+
+func (vm *FakeVictoriaMetrics) handleQuery(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := r.ParseForm(); err != nil {
+		writeQueryError(w, err)
+		return
+	}
+
+	matchers, err := parseSelector(r.Form.Get("query"))
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	now := float64(time.Now().UnixNano()) / 1e9
+	result := []vectorSample{}
+	for _, s := range vm.series {
+		if !labelsMatch(s.labels, matchers) {
+			continue
+		}
+		result = append(result, vectorSample{
+			Metric: s.labels,
+			Value:  [2]interface{}{now, strconv.FormatFloat(s.value, 'f', -1, 64)},
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return fmt.Sprint(result[i].Metric) < fmt.Sprint(result[j].Metric)
+	})
+
+	json.NewEncoder(w).Encode(queryResponse{
+		Status: "success",
+		Data:   &queryData{ResultType: "vector", Result: result},
+	})
+}
+
+func labelsMatch(labels, matchers map[string]string) bool {
+	for name, value := range matchers {
+		if labels[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func writeQueryError(w http.ResponseWriter, err error) {
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(queryResponse{
+		Status:    "error",
+		ErrorType: "bad_data",
+		Error:     err.Error(),
+	})
+}
+
+// FakeWebhook stands in for an Alertmanager webhook receiver. It records the
+// JSON body of every notification posted to it.
+type FakeWebhook struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	bodies []json.RawMessage
+	status int
+}
+
+// NewFakeWebhook starts a webhook receiver. It is closed when the test ends.
+func NewFakeWebhook(t *testing.T) *FakeWebhook {
+	t.Helper()
+
+	f := &FakeWebhook{}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *FakeWebhook) handle(w http.ResponseWriter, r *http.Request) {
+	var body json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.status != 0 {
+		http.Error(w, "fake webhook failure", f.status)
+		return
+	}
+	f.bodies = append(f.bodies, body)
+}
+
+// FailWith makes the fake answer status until it is called with 0.
+func (f *FakeWebhook) FailWith(status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status = status
+}
+
+// Bodies returns the JSON bodies received so far.
+func (f *FakeWebhook) Bodies() []json.RawMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]json.RawMessage(nil), f.bodies...)
+}
+
+// FakeChat stands in for a Slack incoming webhook. It records the text of
+// every message posted to it.
+type FakeChat struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	texts  []string
+	status int
+}
+
+// NewFakeChat starts a chat webhook. It is closed when the test ends.
+func NewFakeChat(t *testing.T) *FakeChat {
+	t.Helper()
+
+	f := &FakeChat{}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *FakeChat) handle(w http.ResponseWriter, r *http.Request) {
+	var message struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&message); err != nil || message.Text == "" {
+		// Slack answers invalid payloads with 400 no_text.
+		http.Error(w, "no_text", http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.status != 0 {
+		http.Error(w, "fake chat failure", f.status)
+		return
+	}
+	f.texts = append(f.texts, message.Text)
+	io.WriteString(w, "ok")
+}
+
+// FailWith makes the fake answer status until it is called with 0.
+func (f *FakeChat) FailWith(status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status = status
+}
+
+// Texts returns the texts received so far.
+func (f *FakeChat) Texts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.texts...)
+}
+
+// FakeMail is one message received by FakeSMTP.
+type FakeMail struct {
+	From string
+	To   []string
+	Data string
+}
+
+// FakeSMTP is a mail server on a local port that speaks just enough SMTP
+// for net/smtp: EHLO, AUTH PLAIN, MAIL, RCPT, DATA and QUIT. It accepts any
+// credentials and keeps every message in memory.
+type FakeSMTP struct {
+	listener net.Listener
+
+	mu     sync.Mutex
+	mails  []FakeMail
+	reject bool
+	wg     sync.WaitGroup
+}
+
+// NewFakeSMTP starts a FakeSMTP on 127.0.0.1. It is closed when the test
+// ends.
+func NewFakeSMTP(t *testing.T) *FakeSMTP {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "failed to start the fake SMTP server")
+
+	f := &FakeSMTP{listener: listener}
+	f.wg.Add(1)
+	go f.serve()
+	t.Cleanup(func() {
+		f.listener.Close()
+		f.wg.Wait()
+	})
+	return f
+}
+
+// Addr returns the host:port the fake listens on.
+func (f *FakeSMTP) Addr() string {
+	return f.listener.Addr().String()
+}
+
+// Reject makes the fake refuse every sender with a temporary failure until
+// it is called with false.
+func (f *FakeSMTP) Reject(reject bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reject = reject
+}
+
+// Mails returns the messages received so far.
+func (f *FakeSMTP) Mails() []FakeMail {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]FakeMail(nil), f.mails...)
+}
+
+func (f *FakeSMTP) serve() {
+	defer f.wg.Done()
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			defer conn.Close()
+			f.session(textproto.NewConn(conn))
+		}()
+	}
+}
+
+func (f *FakeSMTP) session(conn *textproto.Conn) {
+	var mail FakeMail
+	reply := func(format string, args ...interface{}) bool {
+		return conn.PrintfLine(format, args...) == nil
+	}
+
+	if !reply("220 localhost fake SMTP ready") {
+		return
+	}
+	for {
+		line, err := conn.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			if !reply("250-localhost") || !reply("250 AUTH PLAIN") {
+				return
+			}
+		case "HELO", "NOOP":
+			reply("250 OK")
+		case "AUTH":
+			reply("235 2.7.0 Authentication successful")
+		case "MAIL":
+			f.mu.Lock()
+			reject := f.reject
+			f.mu.Unlock()
+			if reject {
+				reply("451 4.3.0 fake SMTP rejects mail")
+				continue
+			}
+			mail = FakeMail{From: mailAddress(arg)}
+			reply("250 OK")
+		case "RCPT":
+			mail.To = append(mail.To, mailAddress(arg))
+			reply("250 OK")
+		case "DATA":
+			if !reply("354 End data with <CR><LF>.<CR><LF>") {
+				return
+			}
+			data, err := io.ReadAll(conn.DotReader())
+			if err != nil {
+				return
+			}
+			mail.Data = string(data)
+			f.mu.Lock()
+			f.mails = append(f.mails, mail)
+			f.mu.Unlock()
+			reply("250 OK: queued")
+		case "RSET":
+			mail = FakeMail{}
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 5.5.2 command %s not implemented", verb)
+		}
+	}
+}
+
+// mailAddress extracts the address from "FROM:<a@b>" or "TO:<a@b>".
+func mailAddress(arg string) string {
+	_, value, _ := strings.Cut(arg, ":")
+	value, _, _ = strings.Cut(value, " ")
+	return strings.Trim(value, "<>")
+}
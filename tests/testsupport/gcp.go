@@ -0,0 +1,72 @@
+package testsupport
+
+import (
+	"context"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/gcp"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/compute/v1"
+)
+
+// GetNetwork returns the VPC network name in projectID.
+func GetNetwork(t *testing.T, projectID, name string) *compute.Network {
+	t.Helper()
+
+	network, err := gcp.NewComputeService(t).Networks.Get(projectID, name).Do()
+	require.NoError(t, err, "failed to get network %s", name)
+	return network
+}
+
+// GetSubnetwork returns the subnetwork name in projectID and region.
+func GetSubnetwork(t *testing.T, projectID, region, name string) *compute.Subnetwork {
+	t.Helper()
+
+	subnet, err := gcp.NewComputeService(t).Subnetworks.Get(projectID, region, name).Do()
+	require.NoError(t, err, "failed to get subnetwork %s", name)
+
+	// The API reports the region as a URL; the suites compare against names.
+	subnet.Region = path.Base(subnet.Region)
+	return subnet
+}
+
+// GetFirewallRulesForNetwork returns every firewall rule in projectID that is
+// attached to the VPC network networkName.
+func GetFirewallRulesForNetwork(t *testing.T, projectID, networkName string) []*compute.Firewall {
+	t.Helper()
+
+	var rules []*compute.Firewall
+	err := gcp.NewComputeService(t).Firewalls.List(projectID).Pages(context.Background(), func(page *compute.FirewallList) error {
+		for _, rule := range page.Items {
+			if path.Base(rule.Network) == networkName {
+				rules = append(rules, rule)
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err, "failed to list firewall rules for network %s", networkName)
+	return rules
+}
+
+// GetInstancesInRegion returns every instance in projectID whose zone
+// belongs to region.
+func GetInstancesInRegion(t *testing.T, projectID, region string) []*compute.Instance {
+	t.Helper()
+
+	var instances []*compute.Instance
+	err := gcp.NewComputeService(t).Instances.AggregatedList(projectID).Pages(context.Background(), func(page *compute.InstanceAggregatedList) error {
+		for scope, scoped := range page.Items {
+			// Scopes are named "zones/<region>-<letter>".
+			zone := strings.TrimPrefix(scope, "zones/")
+			if !strings.HasPrefix(zone, region+"-") {
+				continue
+			}
+			instances = append(instances, scoped.Instances...)
+		}
+		return nil
+	})
+	require.NoError(t, err, "failed to list instances in region %s", region)
+	return instances
+}
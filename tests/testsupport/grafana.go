@@ -0,0 +1,105 @@
+package testsupport
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// GrafanaClient reads provisioning state from the Grafana HTTP API.
+type GrafanaClient struct {
+	URL        string
+	User       string
+	Password   string
+	HTTPClient *http.Client
+}
+
+// NewGrafanaClient returns a client for the Grafana server at baseURL that
+// authenticates with basic auth, e.g. admin/admin in docker-compose.yml.
+func NewGrafanaClient(baseURL, user, password string) *GrafanaClient {
+	return &GrafanaClient{
+		URL:        strings.TrimSuffix(baseURL, "/"),
+		User:       user,
+		Password:   password,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// GrafanaDatasource is the subset of a datasource the provisioning files set.
+type GrafanaDatasource struct {
+	UID       string `json:"uid"`
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	URL       string `json:"url"`
+	Access    string `json:"access"`
+	IsDefault bool   `json:"isDefault"`
+}
+
+// GrafanaDashboard is the subset of a dashboard and its metadata the
+// provisioning files set.
+type GrafanaDashboard struct {
+	UID         string
+	Title       string
+	Provisioned bool
+}
+
+// Datasource returns the datasource with the given uid.
+func (c *GrafanaClient) Datasource(uid string) (*GrafanaDatasource, error) {
+	var datasource GrafanaDatasource
+	if err := c.get("/api/datasources/uid/"+uid, &datasource); err != nil {
+		return nil, err
+	}
+	return &datasource, nil
+}
+
+// Dashboard returns the dashboard with the given uid.
+func (c *GrafanaClient) Dashboard(uid string) (*GrafanaDashboard, error) {
+	var response struct {
+		Dashboard struct {
+			UID   string `json:"uid"`
+			Title string `json:"title"`
+		} `json:"dashboard"`
+		Meta struct {
+			Provisioned bool `json:"provisioned"`
+		} `json:"meta"`
+	}
+	if err := c.get("/api/dashboards/uid/"+uid, &response); err != nil {
+		return nil, err
+	}
+
+	return &GrafanaDashboard{
+		UID:         response.Dashboard.UID,
+		Title:       response.Dashboard.Title,
+		Provisioned: response.Meta.Provisioned,
+	}, nil
+}
+
+func (c *GrafanaClient) get(path string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, c.URL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.User, c.Password)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to get %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d: %s", path, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("malformed response from %s: %v", path, err)
+	}
+	return nil
+}
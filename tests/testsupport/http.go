@@ -0,0 +1,37 @@
+package testsupport
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/retry"
+)
+
+// WaitForHTTPOK polls url until it answers 200 OK and returns the response
+// body. It fails the test once maxRetries attempts have been used up.
+func WaitForHTTPOK(t *testing.T, description, url string, maxRetries int, timeBetweenRetries time.Duration) string {
+	t.Helper()
+
+	return retry.DoWithRetry(t, fmt.Sprintf("Check %s", description), maxRetries, timeBetweenRetries, func() (string, error) {
+		resp, err := http.Get(url)
+		if err != nil {
+			return "", fmt.Errorf("failed to connect to %s: %v", description, err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("%s returned status: %d", description, resp.StatusCode)
+		}
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return "", fmt.Errorf("failed to read response from %s: %v", description, err)
+		}
+
+		t.Logf("✅ %s is accessible at %s", description, url)
+		return string(body), nil
+	})
+}
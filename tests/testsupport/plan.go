@@ -0,0 +1,88 @@
+package testsupport
+
+import (
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	tfjson "github.com/hashicorp/terraform-json"
+)
+
+// PlanAndShow runs init and plan for terraformDir and returns the parsed
+// `terraform show -json` output of the saved plan.
+func PlanAndShow(t *testing.T, terraformDir string, vars map[string]interface{}) *terraform.PlanStruct {
+	t.Helper()
+
+	options := PlanOptions(t, terraformDir, vars)
+	options.PlanFilePath = filepath.Join(t.TempDir(), "plan.out")
+
+	return terraform.InitAndPlanAndShowWithStruct(t, options)
+}
+
+// PlannedResources returns the planned resources of resourceType from every
+// module in the plan, sorted by address.
+func PlannedResources(plan *terraform.PlanStruct, resourceType string) []*tfjson.StateResource {
+	var resources []*tfjson.StateResource
+	for _, resource := range plan.ResourcePlannedValuesMap {
+		if resource.Type == resourceType {
+			resources = append(resources, resource)
+		}
+	}
+
+	sort.Slice(resources, func(i, j int) bool {
+		return resources[i].Address < resources[j].Address
+	})
+	return resources
+}
+
+// PlannedNames returns the "name" attribute of each resource.
+func PlannedNames(resources []*tfjson.StateResource) []string {
+	names := make([]string, 0, len(resources))
+	for _, resource := range resources {
+		name, _ := resource.AttributeValues["name"].(string)
+		names = append(names, name)
+	}
+	return names
+}
+
+// NestedBlocks returns the instances of the nested block name, e.g. the
+// guest_accelerator blocks of a google_compute_instance.
+func NestedBlocks(resource *tfjson.StateResource, name string) []map[string]interface{} {
+	raw, _ := resource.AttributeValues[name].([]interface{})
+
+	blocks := make([]map[string]interface{}, 0, len(raw))
+	for _, item := range raw {
+		if block, ok := item.(map[string]interface{}); ok {
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks
+}
+
+// StringMap converts a map attribute value, such as labels, to strings.
+func StringMap(value interface{}) map[string]string {
+	raw, _ := value.(map[string]interface{})
+
+	values := make(map[string]string, len(raw))
+	for key, value := range raw {
+		if s, ok := value.(string); ok {
+			values[key] = s
+		}
+	}
+	return values
+}
+
+// StringList converts a list attribute value, such as source_ranges, to
+// strings.
+func StringList(value interface{}) []string {
+	raw, _ := value.([]interface{})
+
+	values := make([]string, 0, len(raw))
+	for _, item := range raw {
+		if s, ok := item.(string); ok {
+			values = append(values, s)
+		}
+	}
+	return values
+}
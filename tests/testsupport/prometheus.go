@@ -0,0 +1,345 @@
+package testsupport
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Result types reported in the data.resultType field of a query response.
+const (
+	ResultTypeVector = "vector"
+	ResultTypeMatrix = "matrix"
+	ResultTypeScalar = "scalar"
+	ResultTypeString = "string"
+)
+
+// Labels is the label set of a series, including __name__ when present.
+type Labels map[string]string
+
+// String formats the label set as a PromQL series selector.
+func (l Labels) String() string {
+	names := make([]string, 0, len(l))
+	for name := range l {
+		if name != "__name__" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", name, l[name]))
+	}
+	return fmt.Sprintf("%s{%s}", l["__name__"], strings.Join(pairs, ","))
+}
+
+// Point is a single timestamped value.
+type Point struct {
+	Timestamp time.Time
+	Value     float64
+}
+
+// UnmarshalJSON decodes the [<unix seconds>, "<value>"] pairs the HTTP API
+// uses for sample values.
+func (p *Point) UnmarshalJSON(data []byte) error {
+	var raw [2]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("malformed sample %s: %v", data, err)
+	}
+
+	var seconds float64
+	if err := json.Unmarshal(raw[0], &seconds); err != nil {
+		return fmt.Errorf("malformed sample timestamp %s: %v", raw[0], err)
+	}
+
+	var value string
+	if err := json.Unmarshal(raw[1], &value); err != nil {
+		return fmt.Errorf("malformed sample value %s: %v", raw[1], err)
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("malformed sample value %q: %v", value, err)
+	}
+
+	p.Timestamp = time.Unix(0, int64(seconds*1e9))
+	p.Value = v
+	return nil
+}
+
+// Sample is one series of an instant vector.
+type Sample struct {
+	Metric Labels `json:"metric"`
+	Point  Point  `json:"value"`
+}
+
+// Vector is the result of an instant query.
+type Vector []Sample
+
+// Series is one series of a range query result.
+type Series struct {
+	Metric Labels  `json:"metric"`
+	Points []Point `json:"values"`
+}
+
+// Matrix is the result of a range query.
+type Matrix []Series
+
+// Filter returns the samples whose labels include every pair in labels.
+func (v Vector) Filter(labels Labels) Vector {
+	var filtered Vector
+	for _, sample := range v {
+		if hasLabels(sample.Metric, labels) {
+			filtered = append(filtered, sample)
+		}
+	}
+	return filtered
+}
+
+// LabelValues returns the sorted, distinct values of label across v.
+func (v Vector) LabelValues(label string) []string {
+	seen := map[string]bool{}
+	var values []string
+	for _, sample := range v {
+		value, ok := sample.Metric[label]
+		if ok && !seen[value] {
+			seen[value] = true
+			values = append(values, value)
+		}
+	}
+	sort.Strings(values)
+	return values
+}
+
+// CountBy returns how many samples carry each value of label.
+func (v Vector) CountBy(label string) map[string]int {
+	counts := map[string]int{}
+	for _, sample := range v {
+		counts[sample.Metric[label]]++
+	}
+	return counts
+}
+
+// CheckRange returns an error naming the first sample whose value lies
+// outside [min, max].
+func (v Vector) CheckRange(min, max float64) error {
+	for _, sample := range v {
+		if sample.Point.Value < min || sample.Point.Value > max {
+			return fmt.Errorf("%s = %g is outside [%g, %g]", sample.Metric, sample.Point.Value, min, max)
+		}
+	}
+	return nil
+}
+
+func hasLabels(metric, labels Labels) bool {
+	for name, value := range labels {
+		if metric[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// QueryResult is the decoded data section of a query response. Exactly one
+// of Vector, Matrix or Scalar is set, according to Type.
+type QueryResult struct {
+	Type   string
+	Vector Vector
+	Matrix Matrix
+	Scalar *Point
+	String string
+}
+
+type apiResponse struct {
+	Status    string          `json:"status"`
+	Data      json.RawMessage `json:"data"`
+	ErrorType string          `json:"errorType"`
+	Error     string          `json:"error"`
+	Warnings  []string        `json:"warnings"`
+}
+
+type apiData struct {
+	ResultType string          `json:"resultType"`
+	Result     json.RawMessage `json:"result"`
+}
+
+// APIError is returned when the server answers with status "error".
+type APIError struct {
+	StatusCode int
+	Type       string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("query failed with status %d (%s): %s", e.StatusCode, e.Type, e.Message)
+}
+
+// PrometheusClient queries the Prometheus-compatible HTTP API that
+// VictoriaMetrics serves under /api/v1.
+type PrometheusClient struct {
+	URL        string
+	HTTPClient *http.Client
+}
+
+// NewPrometheusClient returns a client for the server at baseURL, e.g. the
+// victoria_metrics_url Terraform output.
+func NewPrometheusClient(baseURL string) *PrometheusClient {
+	return &PrometheusClient{
+		URL:        strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Query runs an instant query evaluated at the server's current time.
+func (c *PrometheusClient) Query(query string) (*QueryResult, error) {
+	return c.do("/api/v1/query", url.Values{"query": {query}})
+}
+
+// QueryRange runs a range query over [start, end] at the given step.
+func (c *PrometheusClient) QueryRange(query string, start, end time.Time, step time.Duration) (*QueryResult, error) {
+	return c.do("/api/v1/query_range", url.Values{
+		"query": {query},
+		"start": {formatTime(start)},
+		"end":   {formatTime(end)},
+		"step":  {strconv.FormatFloat(step.Seconds(), 'f', -1, 64)},
+	})
+}
+
+// QueryVector runs an instant query and fails unless it returns a vector.
+func (c *PrometheusClient) QueryVector(query string) (Vector, error) {
+	result, err := c.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	if result.Type != ResultTypeVector {
+		return nil, fmt.Errorf("query %s returned a %s, want a vector", query, result.Type)
+	}
+	return result.Vector, nil
+}
+
+// QueryMatrix runs a range query and fails unless it returns a matrix.
+func (c *PrometheusClient) QueryMatrix(query string, start, end time.Time, step time.Duration) (Matrix, error) {
+	result, err := c.QueryRange(query, start, end, step)
+	if err != nil {
+		return nil, err
+	}
+	if result.Type != ResultTypeMatrix {
+		return nil, fmt.Errorf("query %s returned a %s, want a matrix", query, result.Type)
+	}
+	return result.Matrix, nil
+}
+
+// ScrapeTarget is one active target reported by /api/v1/targets.
+type ScrapeTarget struct {
+	DiscoveredLabels Labels    `json:"discoveredLabels"`
+	Labels           Labels    `json:"labels"`
+	ScrapePool       string    `json:"scrapePool"`
+	ScrapeURL        string    `json:"scrapeUrl"`
+	LastError        string    `json:"lastError"`
+	LastScrape       time.Time `json:"lastScrape"`
+	Health           string    `json:"health"`
+}
+
+// Targets returns the active scrape targets. VictoriaMetrics serves the list
+// from vmagent rather than the storage node, so c must point at vmagent's
+// HTTP listener (port 8429 by default).
+func (c *PrometheusClient) Targets() ([]ScrapeTarget, error) {
+	resp, err := c.HTTPClient.Get(c.URL + "/api/v1/targets?state=active")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list targets on %s: %v", c.URL, err)
+	}
+
+	data, err := c.decode(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets struct {
+		ActiveTargets []ScrapeTarget `json:"activeTargets"`
+	}
+	if err := json.Unmarshal(data, &targets); err != nil {
+		return nil, fmt.Errorf("malformed targets response: %v", err)
+	}
+	return targets.ActiveTargets, nil
+}
+
+func (c *PrometheusClient) do(path string, params url.Values) (*QueryResult, error) {
+	resp, err := c.HTTPClient.PostForm(c.URL+path, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s: %v", c.URL, err)
+	}
+
+	data, err := c.decode(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var result apiData
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("malformed query data: %v", err)
+	}
+	return decodeResult(&result)
+}
+
+// decode reads resp and returns the data section of a successful response.
+func (c *PrometheusClient) decode(resp *http.Response) (json.RawMessage, error) {
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response from %s: %v", c.URL, err)
+	}
+
+	var decoded apiResponse
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		return nil, fmt.Errorf("query returned status %d with a malformed body: %v", resp.StatusCode, err)
+	}
+
+	if decoded.Status != "success" {
+		return nil, &APIError{StatusCode: resp.StatusCode, Type: decoded.ErrorType, Message: decoded.Error}
+	}
+	if len(decoded.Data) == 0 || string(decoded.Data) == "null" {
+		return nil, fmt.Errorf("query response has no data section")
+	}
+
+	return decoded.Data, nil
+}
+
+func decodeResult(data *apiData) (*QueryResult, error) {
+	result := &QueryResult{Type: data.ResultType}
+
+	var err error
+	switch data.ResultType {
+	case ResultTypeVector:
+		result.Vector = Vector{}
+		err = json.Unmarshal(data.Result, &result.Vector)
+	case ResultTypeMatrix:
+		result.Matrix = Matrix{}
+		err = json.Unmarshal(data.Result, &result.Matrix)
+	case ResultTypeScalar:
+		result.Scalar = &Point{}
+		err = json.Unmarshal(data.Result, result.Scalar)
+	case ResultTypeString:
+		var raw [2]json.RawMessage
+		if err = json.Unmarshal(data.Result, &raw); err == nil {
+			err = json.Unmarshal(raw[1], &result.String)
+		}
+	default:
+		return nil, fmt.Errorf("unknown result type %q", data.ResultType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("malformed %s result: %v", data.ResultType, err)
+	}
+
+	return result, nil
+}
+
+func formatTime(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixNano())/1e9, 'f', -1, 64)
+}
@@ -0,0 +1,62 @@
+package testsupport
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/gocty"
+)
+
+// Cloud-init templates, relative to a suite directory such as tests/unit.
+const (
+	HostCloudInitTemplate   = HostModuleDir + "/cloud-init-host.yml.tpl"
+	WorkerCloudInitTemplate = WorkerModuleDir + "/cloud-init-worker.yml.tpl"
+)
+
+// RenderTemplate renders the Terraform template at path the way
+// templatefile(path, vars) does, without needing a Terraform binary. Values
+// must be strings, numbers or bools. Template functions are not available.
+func RenderTemplate(path string, vars map[string]interface{}) (string, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	expr, diags := hclsyntax.ParseTemplate(src, path, hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return "", fmt.Errorf("failed to parse %s: %s", path, diags.Error())
+	}
+
+	variables := make(map[string]cty.Value, len(vars))
+	for name, value := range vars {
+		ty, err := gocty.ImpliedType(value)
+		if err != nil {
+			return "", fmt.Errorf("template variable %s: %v", name, err)
+		}
+		variables[name], err = gocty.ToCtyValue(value, ty)
+		if err != nil {
+			return "", fmt.Errorf("template variable %s: %v", name, err)
+		}
+	}
+
+	// Like templatefile(), referencing a variable that was not passed is an
+	// error rather than an empty string.
+	for _, traversal := range expr.Variables() {
+		if _, ok := variables[traversal.RootName()]; !ok {
+			return "", fmt.Errorf("%s: vars map does not contain key %q", traversal.SourceRange(), traversal.RootName())
+		}
+	}
+
+	result, diags := expr.Value(&hcl.EvalContext{Variables: variables})
+	if diags.HasErrors() {
+		return "", fmt.Errorf("failed to render %s: %s", path, diags.Error())
+	}
+	if result.Type() != cty.String {
+		return "", fmt.Errorf("%s rendered to a %s, want a string", path, result.Type().FriendlyName())
+	}
+
+	return result.AsString(), nil
+}
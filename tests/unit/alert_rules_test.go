@@ -0,0 +1,293 @@
+package test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/appleparan/Algalon/pkg/catalog"
+	"github.com/appleparan/Algalon/pkg/config"
+	"github.com/appleparan/Algalon/pkg/envfile"
+	"github.com/appleparan/Algalon/pkg/rules"
+	"github.com/prometheus/common/promslog"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/promql/promqltest"
+	promrules "github.com/prometheus/prometheus/rules"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// findRule returns the rule named name in groups.
+func findRule(t *testing.T, groups []rules.Group, name string) rules.Rule {
+	t.Helper()
+	for _, group := range groups {
+		for _, rule := range group.Rules {
+			if rule.Name() == name {
+				return rule
+			}
+		}
+	}
+	t.Fatalf("rule %s not found", name)
+	return rules.Rule{}
+}
+
+// alertStates evaluates rule once a minute over the synthetic series in
+// load, the way vmalert does, and returns the state of each active alert by
+// instance after the given number of minutes.
+func alertStates(t *testing.T, rule rules.Rule, load string, minutes int) map[string]string {
+	t.Helper()
+
+	storage := promqltest.LoadedStorage(t, "load 1m\n"+load)
+	t.Cleanup(func() { storage.Close() })
+	engine := promqltest.NewTestEngine(t, false, 0, promqltest.DefaultMaxSamplesPerQuery)
+
+	expr, err := parser.ParseExpr(rule.Expr)
+	require.NoError(t, err)
+	hold, err := rule.ForDuration()
+	require.NoError(t, err)
+
+	alerting := promrules.NewAlertingRule(rule.Alert, expr, hold, 0,
+		labels.FromMap(rule.Labels), labels.FromMap(rule.Annotations), labels.EmptyLabels(), "", true, promslog.NewNopLogger())
+	query := promrules.EngineQueryFunc(engine, storage)
+
+	for minute := 0; minute <= minutes; minute++ {
+		ts := time.Unix(0, 0).Add(time.Duration(minute) * time.Minute)
+		_, err := alerting.Eval(context.Background(), 0, ts, query, nil, 0)
+		require.NoError(t, err)
+	}
+
+	states := map[string]string{}
+	for _, alert := range alerting.ActiveAlerts() {
+		states[alert.Labels.Get("instance")] = alert.State.String()
+	}
+	return states
+}
+
+func TestAlertRulesOnSyntheticSeries(t *testing.T) {
+	t.Parallel()
+
+	groups := rules.AlertGroups(rules.DefaultThresholds())
+
+	testCases := []struct {
+		name    string
+		alert   string
+		load    string
+		minutes int
+		want    map[string]string
+	}{
+		{
+			name:    "Target Down Fires After For",
+			alert:   rules.AlertTargetDown,
+			load:    `up{job="all-smi", instance="w1:9090"} 0x10` + "\n" + `up{job="all-smi", instance="w2:9090"} 1x10`,
+			minutes: 6,
+			want:    map[string]string{"w1:9090": "firing"},
+		},
+		{
+			name:    "Target Down Pending Within For",
+			alert:   rules.AlertTargetDown,
+			load:    `up{job="all-smi", instance="w1:9090"} 1 1 0 0 0`,
+			minutes: 4,
+			want:    map[string]string{"w1:9090": "pending"},
+		},
+		{
+			name:    "Target Recovers",
+			alert:   rules.AlertTargetDown,
+			load:    `up{job="all-smi", instance="w1:9090"} 0 0 0 0 1 1 1 1 1`,
+			minutes: 8,
+			want:    map[string]string{},
+		},
+		{
+			name:    "All-SMI Temperature Above Warning",
+			alert:   rules.AlertGPUTemperatureHigh,
+			load:    `all_smi_gpu_temperature_celsius{instance="w1:9090", gpu_index="0"} 85x10` + "\n" + `all_smi_gpu_temperature_celsius{instance="w2:9090", gpu_index="0"} 70x10`,
+			minutes: 6,
+			want:    map[string]string{"w1:9090": "firing"},
+		},
+		{
+			name:    "DCGM Temperature Above Warning",
+			alert:   rules.AlertGPUTemperatureHigh,
+			load:    `DCGM_FI_DEV_GPU_TEMP{instance="w3:9400", gpu="1"} 82x10`,
+			minutes: 6,
+			want:    map[string]string{"w3:9400": "firing"},
+		},
+		{
+			name:    "Temperature At Warning Does Not Fire",
+			alert:   rules.AlertGPUTemperatureHigh,
+			load:    `all_smi_gpu_temperature_celsius{instance="w1:9090"} 80x10`,
+			minutes: 6,
+			want:    map[string]string{},
+		},
+		{
+			name:    "Brief Temperature Spike Stays Pending",
+			alert:   rules.AlertGPUTemperatureHigh,
+			load:    `all_smi_gpu_temperature_celsius{instance="w1:9090"} 70 70 70 88 88 88`,
+			minutes: 5,
+			want:    map[string]string{"w1:9090": "pending"},
+		},
+		{
+			name:    "Temperature Above Critical",
+			alert:   rules.AlertGPUTemperatureCritical,
+			load:    `all_smi_gpu_temperature_celsius{instance="w1:9090"} 92x10` + "\n" + `DCGM_FI_DEV_GPU_TEMP{instance="w3:9400"} 85x10`,
+			minutes: 6,
+			want:    map[string]string{"w1:9090": "firing"},
+		},
+		{
+			name:  "All-SMI Memory Saturated",
+			alert: rules.AlertGPUMemorySaturated,
+			load: `all_smi_gpu_memory_used_bytes{instance="w1:9090"} 78x10` + "\n" +
+				`all_smi_gpu_memory_total_bytes{instance="w1:9090"} 80x10` + "\n" +
+				`all_smi_gpu_memory_used_bytes{instance="w2:9090"} 40x10` + "\n" +
+				`all_smi_gpu_memory_total_bytes{instance="w2:9090"} 80x10`,
+			minutes: 6,
+			want:    map[string]string{"w1:9090": "firing"},
+		},
+		{
+			name:  "DCGM Memory Saturated",
+			alert: rules.AlertGPUMemorySaturated,
+			load: `DCGM_FI_DEV_FB_USED{instance="w3:9400"} 39000x10` + "\n" +
+				`DCGM_FI_DEV_FB_FREE{instance="w3:9400"} 1000x10`,
+			minutes: 6,
+			want:    map[string]string{"w3:9400": "firing"},
+		},
+		{
+			name:    "Memory With Zero Total Is Ignored",
+			alert:   rules.AlertGPUMemorySaturated,
+			load:    `all_smi_gpu_memory_used_bytes{instance="w1:9090"} 0x10` + "\n" + `all_smi_gpu_memory_total_bytes{instance="w1:9090"} 0x10`,
+			minutes: 6,
+			want:    map[string]string{},
+		},
+		{
+			name:    "XID Error Fires Immediately",
+			alert:   rules.AlertGPUXIDError,
+			load:    `DCGM_FI_DEV_XID_ERRORS{instance="w3:9400", gpu="0"} 0 0 79` + "\n" + `DCGM_FI_DEV_XID_ERRORS{instance="w4:9400", gpu="0"} 0 0 0`,
+			minutes: 2,
+			want:    map[string]string{"w3:9400": "firing"},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			rule := findRule(t, groups, tc.alert)
+			assert.Equal(t, tc.want, alertStates(t, rule, tc.load, tc.minutes))
+		})
+	}
+}
+
+func TestAlertRulesUseHostThresholds(t *testing.T) {
+	t.Parallel()
+
+	env := envfile.Env{
+		"ALERT_GPU_TEMPERATURE_WARNING":  "75",
+		"ALERT_GPU_TEMPERATURE_CRITICAL": "85",
+		"ALERT_GPU_MEMORY_PERCENT":       "90",
+		"ALERT_FOR":                      "2m",
+		"ALERT_TARGET_DOWN_FOR":          "1m",
+	}
+	host, err := config.HostFromEnv(env.Lookup)
+	require.NoError(t, err)
+	require.NoError(t, host.Validate())
+	assert.Equal(t, 2*time.Minute, host.Alerts.For)
+
+	groups := rules.AlertGroups(host.Alerts)
+
+	temperature := findRule(t, groups, rules.AlertGPUTemperatureHigh)
+	assert.Equal(t, "2m", temperature.For)
+	assert.Equal(t, map[string]string{"w1:9090": "firing"},
+		alertStates(t, temperature, `all_smi_gpu_temperature_celsius{instance="w1:9090"} 78x5`, 2),
+		"78°C is above the configured 75°C and fires after the configured 2m")
+
+	memory := findRule(t, groups, rules.AlertGPUMemorySaturated)
+	assert.Equal(t, map[string]string{"w1:9090": "firing"},
+		alertStates(t, memory, `all_smi_gpu_memory_used_bytes{instance="w1:9090"} 74x5`+"\n"+`all_smi_gpu_memory_total_bytes{instance="w1:9090"} 80x5`, 3),
+		"92.5% is above the configured 90%")
+
+	down := findRule(t, groups, rules.AlertTargetDown)
+	assert.Equal(t, map[string]string{"w1:9090": "firing"},
+		alertStates(t, down, `up{instance="w1:9090"} 0x5`, 1))
+}
+
+func TestAlertRulesValidate(t *testing.T) {
+	t.Parallel()
+
+	c := catalog.Default()
+	require.NoError(t, rules.Validate(rules.AlertGroups(rules.DefaultThresholds()), c))
+
+	testCases := []struct {
+		name   string
+		groups []rules.Group
+		errMsg string
+	}{
+		{
+			name:   "Unknown Metric",
+			groups: []rules.Group{{Name: "g", Rules: []rules.Rule{{Alert: "A", Expr: "all_smi_gpu_temp > 80"}}}},
+			errMsg: "unknown metric all_smi_gpu_temp",
+		},
+		{
+			name:   "Invalid PromQL",
+			groups: []rules.Group{{Name: "g", Rules: []rules.Rule{{Alert: "A", Expr: "up ==="}}}},
+			errMsg: "invalid PromQL",
+		},
+		{
+			name:   "Alert And Record",
+			groups: []rules.Group{{Name: "g", Rules: []rules.Rule{{Alert: "A", Record: "a:up", Expr: "up"}}}},
+			errMsg: "exactly one of alert and record",
+		},
+		{
+			name:   "Duplicate Alert",
+			groups: []rules.Group{{Name: "g", Rules: []rules.Rule{{Alert: "A", Expr: "up == 0"}, {Alert: "A", Expr: "up == 0"}}}},
+			errMsg: "duplicate alert A",
+		},
+		{
+			name:   "Bad For",
+			groups: []rules.Group{{Name: "g", Rules: []rules.Rule{{Alert: "A", Expr: "up == 0", For: "5 minutes"}}}},
+			errMsg: "invalid for",
+		},
+		{
+			name:   "Bad Label Name",
+			groups: []rules.Group{{Name: "g", Rules: []rules.Rule{{Alert: "A", Expr: "up == 0", Labels: map[string]string{"team-name": "gpu"}}}}},
+			errMsg: "team-name",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := rules.Validate(tc.groups, c)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.errMsg)
+		})
+	}
+}
+
+func TestAlertRulesRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "rules", rules.DefaultAlertsFile)
+	groups := rules.AlertGroups(rules.DefaultThresholds())
+	require.NoError(t, rules.WriteFile(path, groups))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	parsed, err := rules.Parse(data)
+	require.NoError(t, err)
+	assert.Equal(t, groups, parsed)
+}
+
+func TestShippedAlertRulesMatchDefaults(t *testing.T) {
+	t.Parallel()
+
+	shipped, err := os.ReadFile(filepath.Join("..", "..", "algalon_host", "rules", rules.DefaultAlertsFile))
+	require.NoError(t, err)
+
+	want, err := rules.Render(rules.DefaultAlertsFile, rules.AlertGroups(rules.DefaultThresholds()))
+	require.NoError(t, err)
+	assert.Equal(t, string(want), string(shipped), "Regenerate with: algalonctl rules generate")
+}
@@ -5,19 +5,18 @@ import (
 
 	"github.com/gruntwork-io/terratest/modules/terraform"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"algalon-terraform-testsupport"
 )
 
 func TestAlgalonHostModule(t *testing.T) {
 	t.Parallel()
 
-	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "../../terraform/modules/algalon-host",
-		NoColor:      true,
-		Vars: map[string]interface{}{
-			"network_name":   "test-network",
-			"subnet_name":    "test-subnet",
-			"worker_targets": "worker1:9090,worker2:9090",
-		},
+	terraformOptions := testsupport.PlanOptions(t, testsupport.HostModuleDir, map[string]interface{}{
+		"network_name":   "test-network",
+		"subnet_name":    "test-subnet",
+		"worker_targets": "worker1:9090,worker2:9090",
 	})
 
 	// Test initialization and planning (includes validation)
@@ -76,25 +75,49 @@ func TestAlgalonHostConfiguration(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
 
-			terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-				TerraformDir: "../../terraform/modules/algalon-host",
-				NoColor:      true,
-				Vars: map[string]interface{}{
-					"instance_name":      tc.instanceName,
-					"machine_type":       tc.machineType,
-					"network_name":       "test-network",
-					"subnet_name":        "test-subnet",
-					"worker_targets":     tc.workerTargets,
-					"cluster_name":       tc.clusterName,
-					"environment_name":   tc.environment,
-					"enable_external_ip": tc.enableExtIP,
-					"reserve_static_ip":  tc.reserveStaticIP,
-				},
+			plan := testsupport.PlanAndShow(t, testsupport.HostModuleDir, map[string]interface{}{
+				"instance_name":      tc.instanceName,
+				"machine_type":       tc.machineType,
+				"network_name":       "test-network",
+				"subnet_name":        "test-subnet",
+				"worker_targets":     tc.workerTargets,
+				"cluster_name":       tc.clusterName,
+				"environment_name":   tc.environment,
+				"enable_external_ip": tc.enableExtIP,
+				"reserve_static_ip":  tc.reserveStaticIP,
 			})
 
-			terraform.Init(t, terraformOptions)
-			_, planErr := terraform.PlanE(t, terraformOptions)
-			assert.NoError(t, planErr, "Algalon host configuration '%s' should plan successfully", tc.name)
+			instances := testsupport.PlannedResources(plan, "google_compute_instance")
+			require.Len(t, instances, 1, "Algalon host configuration '%s' should plan one host", tc.name)
+			host := instances[0]
+
+			assert.Equal(t, tc.instanceName, host.AttributeValues["name"])
+			assert.Equal(t, tc.machineType, host.AttributeValues["machine_type"])
+
+			labels := testsupport.StringMap(host.AttributeValues["labels"])
+			assert.Equal(t, "algalon-host", labels["component"])
+			assert.Equal(t, tc.clusterName, labels["cluster"])
+			assert.Equal(t, tc.environment, labels["environment"])
+
+			tags := testsupport.StringList(host.AttributeValues["tags"])
+			assert.Contains(t, tags, "algalon-monitoring")
+
+			addresses := testsupport.PlannedResources(plan, "google_compute_address")
+			if tc.reserveStaticIP {
+				assert.Equal(t, []string{tc.instanceName + "-ip"}, testsupport.PlannedNames(addresses))
+				assert.Contains(t, tags, "reserved-ip")
+			} else {
+				assert.Empty(t, addresses)
+				assert.Contains(t, tags, "auto-ip")
+			}
+
+			interfaces := testsupport.NestedBlocks(host, "network_interface")
+			require.Len(t, interfaces, 1)
+			if tc.enableExtIP {
+				assert.NotEmpty(t, interfaces[0]["access_config"])
+			} else {
+				assert.Empty(t, interfaces[0]["access_config"])
+			}
 		})
 	}
 }
@@ -102,16 +125,12 @@ func TestAlgalonHostConfiguration(t *testing.T) {
 func TestAlgalonHostBasicPlan(t *testing.T) {
 	t.Parallel()
 
-	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "../../terraform/modules/algalon-host",
-		NoColor:      true,
-		Vars: map[string]interface{}{
-			"network_name":     "test-network",
-			"subnet_name":      "test-subnet",
-			"worker_targets":   "worker1:9090,worker2:9090",
-			"cluster_name":     "test-cluster",
-			"environment_name": "test-env",
-		},
+	terraformOptions := testsupport.PlanOptions(t, testsupport.HostModuleDir, map[string]interface{}{
+		"network_name":     "test-network",
+		"subnet_name":      "test-subnet",
+		"worker_targets":   "worker1:9090,worker2:9090",
+		"cluster_name":     "test-cluster",
+		"environment_name": "test-env",
 	})
 
 	terraform.Init(t, terraformOptions)
@@ -146,15 +165,11 @@ func TestAlgalonHostDiskConfiguration(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
 
-			terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-				TerraformDir: "../../terraform/modules/algalon-host",
-				NoColor:      true,
-				Vars: map[string]interface{}{
-					"network_name":   "test-network",
-					"subnet_name":    "test-subnet",
-					"boot_disk_size": tc.bootDiskSize,
-					"boot_disk_type": tc.bootDiskType,
-				},
+			terraformOptions := testsupport.PlanOptions(t, testsupport.HostModuleDir, map[string]interface{}{
+				"network_name":   "test-network",
+				"subnet_name":    "test-subnet",
+				"boot_disk_size": tc.bootDiskSize,
+				"boot_disk_type": tc.bootDiskType,
 			})
 
 			terraform.Init(t, terraformOptions)
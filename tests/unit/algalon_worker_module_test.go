@@ -1,22 +1,23 @@
 package test
 
 import (
+	"fmt"
+	"math"
 	"testing"
 
 	"github.com/gruntwork-io/terratest/modules/terraform"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"algalon-terraform-testsupport"
 )
 
 func TestAlgalonWorkerModule(t *testing.T) {
 	t.Parallel()
 
-	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "../../terraform/modules/algalon-worker",
-		NoColor:      true,
-		Vars: map[string]interface{}{
-			"network_name": "test-network",
-			"subnet_name":  "test-subnet",
-		},
+	terraformOptions := testsupport.PlanOptions(t, testsupport.WorkerModuleDir, map[string]interface{}{
+		"network_name": "test-network",
+		"subnet_name":  "test-subnet",
 	})
 
 	// Test initialization and planning (includes validation)
@@ -64,6 +65,18 @@ func TestAlgalonWorkerConfiguration(t *testing.T) {
 			preemptible:    false,
 			enableExtIP:    true,
 		},
+		{
+			name:           "Preemptible Configuration",
+			instanceCount:  2,
+			machineType:    "n1-standard-4",
+			gpuType:        "nvidia-tesla-t4",
+			gpuCount:       4,
+			allSmiVersion:  "v0.9.0",
+			allSmiPort:     9090,
+			allSmiInterval: 5,
+			preemptible:    true,
+			enableExtIP:    false,
+		},
 		{
 			name:           "CPU-Only Configuration",
 			instanceCount:  1,
@@ -83,10 +96,17 @@ func TestAlgalonWorkerConfiguration(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
 
+			// CPU-only workers still take one slot of total_gpu_count each,
+			// since the module sizes the pool from it
+			totalGPUs := tc.instanceCount * tc.gpuCount
+			if tc.gpuType == "" {
+				totalGPUs = tc.instanceCount
+			}
+
 			vars := map[string]interface{}{
 				"network_name":       "test-network",
 				"subnet_name":        "test-subnet",
-				"total_gpu_count":    tc.instanceCount * tc.gpuCount, // Calculate total GPUs
+				"total_gpu_count":    totalGPUs,
 				"machine_type":       tc.machineType,
 				"all_smi_version":    tc.allSmiVersion,
 				"all_smi_port":       tc.allSmiPort,
@@ -96,20 +116,51 @@ func TestAlgalonWorkerConfiguration(t *testing.T) {
 			}
 
 			// Only add GPU config if GPU type is specified
+			gpusPerInstance := 1
 			if tc.gpuType != "" {
 				vars["gpu_type"] = tc.gpuType
 				vars["gpus_per_instance"] = tc.gpuCount
+				gpusPerInstance = tc.gpuCount
 			}
 
-			terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-				TerraformDir: "../../terraform/modules/algalon-worker",
-				NoColor:      true,
-				Vars:         vars,
-			})
+			plan := testsupport.PlanAndShow(t, testsupport.WorkerModuleDir, vars)
+
+			instances := testsupport.PlannedResources(plan, "google_compute_instance")
+			require.Len(t, instances, int(math.Ceil(float64(totalGPUs)/float64(gpusPerInstance))))
 
-			terraform.Init(t, terraformOptions)
-			_, planErr := terraform.PlanE(t, terraformOptions)
-			assert.NoError(t, planErr, "Algalon worker configuration '%s' should plan successfully", tc.name)
+			for i, instance := range instances {
+				assert.Equal(t, fmt.Sprintf("algalon-worker-%d", i+1), instance.AttributeValues["name"])
+				assert.Equal(t, tc.machineType, instance.AttributeValues["machine_type"])
+
+				accelerators := testsupport.NestedBlocks(instance, "guest_accelerator")
+				scheduling := testsupport.NestedBlocks(instance, "scheduling")
+				require.Len(t, scheduling, 1)
+				assert.Equal(t, tc.preemptible, scheduling[0]["preemptible"])
+
+				if tc.gpuType != "" {
+					require.Len(t, accelerators, 1)
+					assert.Equal(t, tc.gpuType, accelerators[0]["type"])
+					assert.EqualValues(t, tc.gpuCount, accelerators[0]["count"])
+					assert.Equal(t, "TERMINATE", scheduling[0]["on_host_maintenance"], "GPU instances cannot live-migrate")
+				} else {
+					assert.Empty(t, accelerators)
+					assert.Equal(t, "MIGRATE", scheduling[0]["on_host_maintenance"])
+				}
+
+				interfaces := testsupport.NestedBlocks(instance, "network_interface")
+				require.Len(t, interfaces, 1)
+				if tc.enableExtIP {
+					assert.NotEmpty(t, interfaces[0]["access_config"])
+				} else {
+					assert.Empty(t, interfaces[0]["access_config"])
+				}
+
+				labels := testsupport.StringMap(instance.AttributeValues["labels"])
+				assert.Equal(t, "algalon-worker", labels["component"])
+				assert.Equal(t, "production", labels["cluster"])
+				assert.Equal(t, "gpu-cluster", labels["environment"])
+				assert.Equal(t, fmt.Sprint(i+1), labels["worker_index"])
+			}
 		})
 	}
 }
@@ -117,16 +168,12 @@ func TestAlgalonWorkerConfiguration(t *testing.T) {
 func TestAlgalonWorkerBasicPlan(t *testing.T) {
 	t.Parallel()
 
-	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "../../terraform/modules/algalon-worker",
-		NoColor:      true,
-		Vars: map[string]interface{}{
-			"network_name":     "test-network",
-			"subnet_name":      "test-subnet",
-			"all_smi_version":  "v0.9.0",
-			"all_smi_port":     9091,
-			"all_smi_interval": 3,
-		},
+	terraformOptions := testsupport.PlanOptions(t, testsupport.WorkerModuleDir, map[string]interface{}{
+		"network_name":     "test-network",
+		"subnet_name":      "test-subnet",
+		"all_smi_version":  "v0.9.0",
+		"all_smi_port":     9091,
+		"all_smi_interval": 3,
 	})
 
 	terraform.Init(t, terraformOptions)
@@ -170,23 +217,23 @@ func TestAlgalonWorkerGPUAllocation(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
 
-			terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-				TerraformDir: "../../terraform/modules/algalon-worker",
-				NoColor:      true,
-				Vars: map[string]interface{}{
-					"network_name":       "test-network",
-					"subnet_name":        "test-subnet",
-					"total_gpu_count":    tc.totalGPUs,
-					"gpus_per_instance":  tc.gpusPerInstance,
-					"gpu_type":           "nvidia-tesla-t4",
-				},
+			plan := testsupport.PlanAndShow(t, testsupport.WorkerModuleDir, map[string]interface{}{
+				"network_name":      "test-network",
+				"subnet_name":       "test-subnet",
+				"total_gpu_count":   tc.totalGPUs,
+				"gpus_per_instance": tc.gpusPerInstance,
+				"gpu_type":          "nvidia-tesla-t4",
 			})
 
-			terraform.Init(t, terraformOptions)
+			instances := testsupport.PlannedResources(plan, "google_compute_instance")
+			require.Len(t, instances, tc.expectedInstances, "GPU allocation test case '%s' should plan ceil(total/per-instance) workers", tc.name)
 
-			// Test planning (includes validation)
-			_, planErr := terraform.PlanE(t, terraformOptions)
-			assert.NoError(t, planErr, "GPU allocation test case '%s' should plan successfully", tc.name)
+			for _, instance := range instances {
+				accelerators := testsupport.NestedBlocks(instance, "guest_accelerator")
+				require.Len(t, accelerators, 1)
+				assert.Equal(t, "nvidia-tesla-t4", accelerators[0]["type"])
+				assert.EqualValues(t, tc.gpusPerInstance, accelerators[0]["count"])
+			}
 		})
 	}
 }
@@ -194,24 +241,26 @@ func TestAlgalonWorkerGPUAllocation(t *testing.T) {
 func TestAlgalonWorkerLabels(t *testing.T) {
 	t.Parallel()
 
-	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "../../terraform/modules/algalon-worker",
-		NoColor:      true,
-		Vars: map[string]interface{}{
-			"network_name":     "test-network",
-			"subnet_name":      "test-subnet",
-			"cluster_name":     "test-cluster",
-			"environment_name": "test-environment",
-			"labels": map[string]interface{}{
-				"team":        "ml-ops",
-				"cost_center": "research",
-			},
+	plan := testsupport.PlanAndShow(t, testsupport.WorkerModuleDir, map[string]interface{}{
+		"network_name":     "test-network",
+		"subnet_name":      "test-subnet",
+		"cluster_name":     "test-cluster",
+		"environment_name": "test-environment",
+		"labels": map[string]interface{}{
+			"team":        "ml-ops",
+			"cost_center": "research",
+			"component":   "overridden", // Module labels take precedence
 		},
 	})
 
-	terraform.Init(t, terraformOptions)
-
-	// Test planning (includes validation)
-	_, planErr := terraform.PlanE(t, terraformOptions)
-	assert.NoError(t, planErr, "Algalon worker labels test should plan successfully")
-}
\ No newline at end of file
+	instances := testsupport.PlannedResources(plan, "google_compute_instance")
+	require.Len(t, instances, 1)
+	assert.Equal(t, map[string]string{
+		"team":         "ml-ops",
+		"cost_center":  "research",
+		"component":    "algalon-worker",
+		"cluster":      "test-cluster",
+		"environment":  "test-environment",
+		"worker_index": "1",
+	}, testsupport.StringMap(instances[0].AttributeValues["labels"]))
+}
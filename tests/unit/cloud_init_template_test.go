@@ -0,0 +1,213 @@
+package test
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+
+	"algalon-terraform-testsupport"
+)
+
+const (
+	setupScriptPath  = "/tmp/algalon-setup.sh"
+	statusScriptPath = "/tmp/setup-complete-check.sh"
+
+	waitForDockerCmd = "timeout 300 bash -c 'until docker info; do sleep 5; done'"
+	runSetupCmd      = "/tmp/algalon-setup.sh 2>&1 | tee /var/log/algalon-setup.log"
+	statusCheckCmd   = "nohup /tmp/setup-complete-check.sh > /var/log/algalon-status.log 2>&1 &"
+)
+
+// cloudConfig is the part of the cloud-config schema the templates use.
+type cloudConfig struct {
+	WriteFiles []cloudConfigFile `yaml:"write_files"`
+	RunCmd     []string          `yaml:"runcmd"`
+}
+
+type cloudConfigFile struct {
+	Path        string `yaml:"path"`
+	Permissions string `yaml:"permissions"`
+	Content     string `yaml:"content"`
+}
+
+// renderCloudConfig renders a cloud-init template and decodes it strictly:
+// unknown or duplicate top-level keys fail the test, since cloud-init would
+// silently keep only the last of the duplicates.
+func renderCloudConfig(t *testing.T, template string, vars map[string]interface{}) cloudConfig {
+	t.Helper()
+
+	rendered, err := testsupport.RenderTemplate(template, vars)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(rendered, "#cloud-config\n"), "cloud-init ignores user-data without the #cloud-config header")
+
+	var config cloudConfig
+	decoder := yaml.NewDecoder(strings.NewReader(rendered))
+	decoder.KnownFields(true)
+	require.NoError(t, decoder.Decode(&config))
+
+	return config
+}
+
+// file returns the write_files entry for path, failing the test if it is
+// missing.
+func (c cloudConfig) file(t *testing.T, path string) cloudConfigFile {
+	t.Helper()
+
+	for _, f := range c.WriteFiles {
+		if f.Path == path {
+			return f
+		}
+	}
+	require.Failf(t, "missing write_files entry", "no write_files entry for %s", path)
+	return cloudConfigFile{}
+}
+
+// assertRunOrder checks that runcmd runs the setup script after Docker is up
+// and the status check last.
+func assertRunOrder(t *testing.T, config cloudConfig) {
+	t.Helper()
+
+	assert.Equal(t, []string{waitForDockerCmd, runSetupCmd, statusCheckCmd}, config.RunCmd)
+
+	var paths []string
+	for _, f := range config.WriteFiles {
+		paths = append(paths, f.Path)
+		assert.Equal(t, "0755", f.Permissions, "%s must be executable", f.Path)
+		assertValidBash(t, f.Path, f.Content)
+	}
+	assert.Equal(t, []string{setupScriptPath, statusScriptPath}, paths)
+}
+
+// assertValidBash checks the script with bash -n, when bash is available.
+func assertValidBash(t *testing.T, name, script string) {
+	t.Helper()
+
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		return
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(bash, "-n")
+	cmd.Stdin = strings.NewReader(script)
+	cmd.Stderr = &stderr
+	assert.NoError(t, cmd.Run(), "%s is not valid bash: %s", name, stderr.String())
+}
+
+func TestHostCloudInitTemplate(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		workerTargets string
+		clusterName   string
+		environment   string
+	}{
+		{
+			name:          "Default Configuration",
+			workerTargets: "localhost:9090",
+			clusterName:   "production",
+			environment:   "gpu-cluster",
+		},
+		{
+			name:          "Training Cluster",
+			workerTargets: "10.1.0.2:9090,10.1.0.3:9090,10.1.0.4:9091",
+			clusterName:   "training",
+			environment:   "ml-training",
+		},
+		{
+			name:          "Host-Only Deployment",
+			workerTargets: "",
+			clusterName:   "development",
+			environment:   "testing",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			// Same mapping as the templatefile() call in the algalon-host module
+			config := renderCloudConfig(t, testsupport.HostCloudInitTemplate, map[string]interface{}{
+				"algalon_targets":     tc.workerTargets,
+				"algalon_cluster":     tc.clusterName,
+				"algalon_environment": tc.environment,
+			})
+
+			assertRunOrder(t, config)
+
+			setup := config.file(t, setupScriptPath).Content
+			assert.Contains(t, setup, fmt.Sprintf("local targets=%q\n", tc.workerTargets))
+			assert.Contains(t, setup, fmt.Sprintf("local cluster=%q\n", tc.clusterName))
+			assert.Contains(t, setup, fmt.Sprintf("local environment=%q\n", tc.environment))
+			assert.Contains(t, setup, `export ALGALON_TARGETS="$targets"`)
+			assert.Contains(t, setup, "./generate-targets.sh")
+
+			// Escaped shell variables must survive rendering untouched
+			assert.Contains(t, setup, "${COMPOSE_VERSION}")
+			assert.Contains(t, setup, "http://${EXTERNAL_IP}:3000")
+		})
+	}
+}
+
+func TestWorkerCloudInitTemplate(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name           string
+		allSmiVersion  string
+		allSmiPort     int
+		allSmiInterval int
+		registryURL    string
+	}{
+		{name: "Default Configuration", allSmiVersion: "v0.9.0", allSmiPort: 9090, allSmiInterval: 5},
+		{name: "Custom Port", allSmiVersion: "v0.9.0", allSmiPort: 9091, allSmiInterval: 3},
+		{name: "Pinned Older Release", allSmiVersion: "v0.8.1", allSmiPort: 19090, allSmiInterval: 15},
+		{name: "Self-Registration", allSmiVersion: "v0.9.0", allSmiPort: 9090, allSmiInterval: 5, registryURL: "http://10.128.0.10:8430"},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			// Same mapping as the templatefile() call in the algalon-worker module
+			config := renderCloudConfig(t, testsupport.WorkerCloudInitTemplate, map[string]interface{}{
+				"all_smi_version":  tc.allSmiVersion,
+				"all_smi_port":     tc.allSmiPort,
+				"all_smi_interval": tc.allSmiInterval,
+				"registry_url":     tc.registryURL,
+			})
+
+			assertRunOrder(t, config)
+
+			setup := config.file(t, setupScriptPath).Content
+			assert.Contains(t, setup, fmt.Sprintf("local version=%q\n", tc.allSmiVersion))
+			assert.Contains(t, setup, fmt.Sprintf("local port=\"%d\"\n", tc.allSmiPort))
+			assert.Contains(t, setup, fmt.Sprintf("local interval=\"%d\"\n", tc.allSmiInterval))
+			assert.Contains(t, setup, fmt.Sprintf("local registry=%q\n", tc.registryURL))
+			assert.Contains(t, setup, `./setup.sh --version "$version" --port "$port" --interval "$interval" --registry "$registry"`)
+			assert.Contains(t, setup, `log "  Interval: ${interval}s"`)
+
+			status := config.file(t, statusScriptPath).Content
+			assert.Contains(t, status, fmt.Sprintf("grep -o '[0-9]*:%d'", tc.allSmiPort))
+			assert.Contains(t, status, fmt.Sprintf("http://$EXTERNAL_IP:${port:-%d}/metrics", tc.allSmiPort))
+		})
+	}
+}
+
+func TestRenderTemplateRequiresEveryVariable(t *testing.T) {
+	t.Parallel()
+
+	_, err := testsupport.RenderTemplate(testsupport.WorkerCloudInitTemplate, map[string]interface{}{
+		"all_smi_version": "v0.9.0",
+		"all_smi_port":    9090,
+	})
+	assert.ErrorContains(t, err, "all_smi_interval")
+}
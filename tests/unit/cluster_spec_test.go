@@ -0,0 +1,247 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/appleparan/Algalon/pkg/targets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShippedClusterSpec(t *testing.T) {
+	t.Parallel()
+
+	spec, err := targets.ReadSpec(filepath.Join("..", "..", "examples", "target-specs", "multi-cluster.yml"))
+	require.NoError(t, err)
+
+	files, err := spec.Files()
+	require.NoError(t, err)
+
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		names = append(names, file.Name)
+
+		// Every file must match the glob in prometheus.yml.
+		matched, err := filepath.Match("all-smi-*.yml", file.Name)
+		require.NoError(t, err)
+		assert.True(t, matched, "%s should match all-smi-*.yml", file.Name)
+
+		for _, group := range file.Groups {
+			for _, label := range []string{"job", "cluster", "environment", "datacenter", "platform", "gpu_type", "monitoring_type"} {
+				assert.NotEmpty(t, group.Labels[label], "%s: every group should carry %s", file.Name, label)
+			}
+		}
+	}
+	assert.Equal(t, []string{"all-smi-production.yml", "all-smi-staging.yml", "all-smi-development.yml", "all-smi-edge.yml"}, names)
+
+	edge := files[3]
+	require.Len(t, edge.Groups, 2)
+	assert.Equal(t, []string{"192.168.1.51:9090"}, edge.Groups[1].Targets)
+	assert.Equal(t, map[string]string{
+		"job":             "all-smi",
+		"cluster":         "edge",
+		"environment":     "prod",
+		"datacenter":      "edge-locations",
+		"platform":        "apple",
+		"gpu_type":        "metal",
+		"monitoring_type": "comprehensive",
+		"region":          "edge",
+	}, edge.Groups[1].Labels)
+}
+
+func TestClusterSpecJSON(t *testing.T) {
+	t.Parallel()
+
+	spec, err := targets.ParseSpec([]byte(`{
+		"defaults": {"port": 9091, "environment": "research", "datacenter": "dc1"},
+		"clusters": [{
+			"name": "lab",
+			"groups": [{"platform": "nvidia", "gpu_type": "rtx4090", "targets": ["gpu-node-1.example.com", "10.0.0.5:9090"]}]
+		}]
+	}`))
+	require.NoError(t, err)
+
+	files, err := spec.Files()
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, "all-smi-lab.yml", files[0].Name)
+	assert.Equal(t, []string{"gpu-node-1.example.com:9091", "10.0.0.5:9090"}, files[0].Groups[0].Targets)
+	assert.Equal(t, "research", files[0].Groups[0].Labels["environment"])
+}
+
+func TestClusterSpecValidation(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name string
+		spec string
+	}{
+		{name: "No Clusters", spec: "clusters: []"},
+		{name: "Unknown Field", spec: `
+clusters:
+  - name: a
+    datacenter: dc1
+    groups:
+      - {platform: nvidia, gputype: a100, targets: [10.0.0.1]}`},
+		{name: "Invalid Cluster Name", spec: `
+clusters:
+  - name: Prod/East
+    groups:
+      - {environment: prod, datacenter: dc1, platform: nvidia, gpu_type: a100, targets: [10.0.0.1]}`},
+		{name: "Duplicate Cluster", spec: `
+defaults: {environment: prod, datacenter: dc1, platform: nvidia, gpu_type: a100}
+clusters:
+  - {name: a, groups: [{targets: [10.0.0.1]}]}
+  - {name: a, groups: [{targets: [10.0.0.2]}]}`},
+		{name: "Cluster Without Groups", spec: `
+defaults: {environment: prod, datacenter: dc1, platform: nvidia, gpu_type: a100}
+clusters:
+  - {name: a}`},
+		{name: "Group Without Targets", spec: `
+defaults: {environment: prod, datacenter: dc1, platform: nvidia, gpu_type: a100}
+clusters:
+  - {name: a, groups: [{targets: []}]}`},
+		{name: "Missing GPU Type", spec: `
+defaults: {environment: prod, datacenter: dc1, platform: nvidia}
+clusters:
+  - {name: a, groups: [{targets: [10.0.0.1]}]}`},
+		{name: "Malformed Target", spec: `
+defaults: {environment: prod, datacenter: dc1, platform: nvidia, gpu_type: a100}
+clusters:
+  - {name: a, groups: [{targets: ["worker_1:9090"]}]}`},
+		{name: "Target In Two Clusters", spec: `
+defaults: {environment: prod, datacenter: dc1, platform: nvidia, gpu_type: a100}
+clusters:
+  - {name: a, groups: [{targets: [10.0.0.1]}]}
+  - {name: b, groups: [{targets: ["10.0.0.1:9090"]}]}`},
+		{name: "Bad Port", spec: `
+defaults: {port: 70000, environment: prod, datacenter: dc1, platform: nvidia, gpu_type: a100}
+clusters:
+  - {name: a, groups: [{targets: [10.0.0.1]}]}`},
+		{name: "Reserved Label", spec: `
+defaults: {environment: prod, datacenter: dc1, platform: nvidia, gpu_type: a100}
+clusters:
+  - {name: a, labels: {cluster: b}, groups: [{targets: [10.0.0.1]}]}`},
+		{name: "Invalid Label Name", spec: `
+defaults: {environment: prod, datacenter: dc1, platform: nvidia, gpu_type: a100, labels: {gpu-count: "8"}}
+clusters:
+  - {name: a, groups: [{targets: [10.0.0.1]}]}`},
+		{name: "Unknown Exporter", spec: `
+defaults: {environment: prod, datacenter: dc1, platform: nvidia, gpu_type: a100}
+clusters:
+  - {name: a, exporters: [node-exporter], groups: [{targets: [10.0.0.1]}]}`},
+		{name: "Duplicate Exporter", spec: `
+defaults: {environment: prod, datacenter: dc1, platform: nvidia, gpu_type: a100}
+clusters:
+  - {name: a, exporters: [all-smi, all-smi], groups: [{targets: [10.0.0.1]}]}`},
+		{name: "Port Of Unknown Exporter", spec: `
+defaults: {environment: prod, datacenter: dc1, platform: nvidia, gpu_type: a100, ports: {node-exporter: 9100}}
+clusters:
+  - {name: a, groups: [{targets: [10.0.0.1]}]}`},
+		{name: "Target Port With Several Exporters", spec: `
+defaults: {environment: prod, datacenter: dc1, platform: nvidia, gpu_type: a100}
+clusters:
+  - {name: a, exporters: [all-smi, dcgm-exporter], groups: [{targets: ["10.0.0.1:9090"]}]}`},
+		{name: "Exporters Sharing A Port", spec: `
+defaults: {environment: prod, datacenter: dc1, platform: nvidia, gpu_type: a100, ports: {dcgm-exporter: 9090}}
+clusters:
+  - {name: a, exporters: [all-smi, dcgm-exporter], groups: [{targets: [10.0.0.1]}]}`},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := targets.ParseSpec([]byte(tc.spec))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestClusterSpecExporters(t *testing.T) {
+	t.Parallel()
+
+	spec, err := targets.ParseSpec([]byte(`
+defaults: {environment: prod, datacenter: dc1, platform: nvidia, gpu_type: a100, port: 9091}
+clusters:
+  - {name: a, groups: [{targets: [10.0.0.1, "10.0.0.2:9092"]}]}
+  - name: b
+    exporters: [all-smi, dcgm-exporter]
+    groups: [{targets: [10.0.1.1]}]
+  - name: c
+    exporters: [dcgm-exporter]
+    ports: {dcgm-exporter: 9401}
+    groups: [{targets: [10.0.2.1, "10.0.2.2:9402"]}]
+`))
+	require.NoError(t, err)
+	files, err := spec.Files()
+	require.NoError(t, err)
+
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		names = append(names, file.Name)
+	}
+	assert.Equal(t, []string{"all-smi-a.yml", "all-smi-b.yml", "dcgm-b.yml", "dcgm-c.yml"}, names)
+
+	assert.Equal(t, []string{"10.0.0.1:9091", "10.0.0.2:9092"}, files[0].Groups[0].Targets)
+	assert.Equal(t, []string{"10.0.1.1:9091"}, files[1].Groups[0].Targets, "defaults.port is the all-smi port")
+	assert.Equal(t, []string{"10.0.1.1:9400"}, files[2].Groups[0].Targets, "dcgm-exporter uses its standard port")
+	assert.Equal(t, []string{"10.0.2.1:9401", "10.0.2.2:9402"}, files[3].Groups[0].Targets, "A single exporter keeps target ports")
+
+	dcgm := files[2]
+	assert.Equal(t, targets.DCGMExporter.Name, dcgm.Exporter)
+	assert.Equal(t, "dcgm-exporter", dcgm.Groups[0].Labels["job"])
+	assert.Equal(t, "gpu", dcgm.Groups[0].Labels["monitoring_type"])
+	assert.Equal(t, "a100", dcgm.Groups[0].Labels["gpu_type"])
+
+	dir := t.TempDir()
+	stale := filepath.Join(dir, "dcgm-retired.yml")
+	require.NoError(t, os.WriteFile(stale, []byte("[]\n"), 0o644))
+	removed, err := targets.WriteFiles(dir, files, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{stale}, removed, "Files of exporters the spec uses are pruned")
+
+	data, err := os.ReadFile(filepath.Join(dir, "dcgm-b.yml"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "# Configuration for dcgm-exporter GPU monitoring worker nodes")
+}
+
+func TestClusterSpecWriteFiles(t *testing.T) {
+	t.Parallel()
+
+	spec, err := targets.ParseSpec([]byte(`
+defaults: {environment: prod, datacenter: dc1, platform: nvidia, gpu_type: a100}
+clusters:
+  - {name: a, groups: [{targets: [10.0.0.1]}]}
+  - {name: b, groups: [{targets: [10.0.0.2]}]}
+`))
+	require.NoError(t, err)
+	files, err := spec.Files()
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	stale := filepath.Join(dir, "all-smi-retired.yml")
+	unrelated := filepath.Join(dir, "dcgm-targets.yml")
+	require.NoError(t, os.WriteFile(stale, []byte("[]\n"), 0o644))
+	require.NoError(t, os.WriteFile(unrelated, []byte("[]\n"), 0o644))
+
+	removed, err := targets.WriteFiles(dir, files, false)
+	require.NoError(t, err)
+	assert.Empty(t, removed)
+	assert.FileExists(t, stale, "Stale files should be kept without prune")
+
+	removed, err = targets.WriteFiles(dir, files, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{stale}, removed)
+	assert.NoFileExists(t, stale)
+	assert.FileExists(t, unrelated, "Only all-smi-*.yml files should be pruned")
+
+	groups, err := targets.ReadFile(filepath.Join(dir, "all-smi-b.yml"))
+	require.NoError(t, err)
+	require.Len(t, groups, 1)
+	assert.Equal(t, []string{"10.0.0.2:9090"}, groups[0].Targets)
+	assert.Equal(t, "b", groups[0].Labels["cluster"])
+}
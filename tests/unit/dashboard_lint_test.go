@@ -0,0 +1,201 @@
+package test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/appleparan/Algalon/pkg/catalog"
+	"github.com/appleparan/Algalon/pkg/dashboards"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const dashboardsDir = "../../algalon_host/grafana/dashboards"
+
+func TestDashboardMetricNames(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		expr        string
+		expected    []string
+		expectError bool
+	}{
+		{name: "Bare Metric", expr: "all_smi_gpu_utilization", expected: []string{"all_smi_gpu_utilization"}},
+		{name: "Variable In Matcher", expr: `all_smi_gpu_utilization{instance=~"$instance", gpu_index=~"$gpu"}`, expected: []string{"all_smi_gpu_utilization"}},
+		{name: "Binary Expression", expr: "(all_smi_gpu_memory_used_bytes / all_smi_gpu_memory_total_bytes) * 100", expected: []string{"all_smi_gpu_memory_total_bytes", "all_smi_gpu_memory_used_bytes"}},
+		{name: "Repeated Metric", expr: "(all_smi_disk_total_bytes - all_smi_disk_available_bytes) / all_smi_disk_total_bytes", expected: []string{"all_smi_disk_available_bytes", "all_smi_disk_total_bytes"}},
+		{name: "Rate Interval", expr: "rate(DCGM_FI_DEV_XID_ERRORS[$__rate_interval])", expected: []string{"DCGM_FI_DEV_XID_ERRORS"}},
+		{name: "Braced Interval", expr: "avg_over_time(up[${__interval}])", expected: []string{"up"}},
+		{name: "Name Matcher", expr: `{__name__="all_smi_cpu_utilization"}`, expected: []string{"all_smi_cpu_utilization"}},
+		{name: "Regex Name Matcher", expr: `{__name__=~"all_smi_.*"}`, expected: nil},
+		{name: "Label Values With Metric", expr: "label_values(all_smi_gpu_temperature_celsius, instance)", expected: []string{"all_smi_gpu_temperature_celsius"}},
+		{name: "Label Values With Selector", expr: `label_values(all_smi_gpu_utilization{instance=~"$instance"}, gpu_index)`, expected: []string{"all_smi_gpu_utilization"}},
+		{name: "Label Values Without Metric", expr: "label_values(instance)", expected: nil},
+		{name: "Query Result", expr: "query_result(topk(5, all_smi_gpu_utilization))", expected: []string{"all_smi_gpu_utilization"}},
+		{name: "Metrics Function", expr: "metrics(all_smi_.*)", expected: nil},
+		{name: "Unbalanced Parenthesis", expr: "sum(all_smi_gpu_utilization", expectError: true},
+		{name: "Unsubstituted Variable", expr: "topk($count, all_smi_gpu_utilization)", expectError: true},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			names, err := dashboards.MetricNames(tc.expr)
+			if tc.expectError {
+				assert.Error(t, err, "Expression %q should be rejected", tc.expr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, names)
+		})
+	}
+}
+
+func TestDashboardQueryExtraction(t *testing.T) {
+	t.Parallel()
+
+	dashboard, err := dashboards.Parse([]byte(`{
+  "uid": "example",
+  "title": "Example",
+  "panels": [
+    {"title": "GPU", "targets": [{"refId": "A", "expr": "all_smi_gpu_utilization"}, {"refId": "B", "expr": ""}]},
+    {"title": "Notes", "type": "text"},
+    {"title": "Row", "type": "row", "panels": [
+      {"title": "Nested", "targets": [{"refId": "A", "expr": "all_smi_cpu_utilization"}]}
+    ]}
+  ],
+  "templating": {"list": [
+    {"name": "instance", "type": "query", "query": "label_values(up, instance)"},
+    {"name": "gpu", "type": "query", "query": {"query": "label_values(all_smi_gpu_utilization, gpu_index)", "refId": "A"}},
+    {"name": "interval", "type": "interval", "query": "1m,5m"}
+  ]}
+}`))
+	require.NoError(t, err)
+
+	assert.Equal(t, "example", dashboard.UID)
+	assert.Equal(t, []dashboards.Query{
+		{Location: `panel "GPU" query A`, Expr: "all_smi_gpu_utilization"},
+		{Location: `panel "Nested" query A`, Expr: "all_smi_cpu_utilization"},
+		{Location: `variable "instance"`, Expr: "label_values(up, instance)"},
+		{Location: `variable "gpu"`, Expr: "label_values(all_smi_gpu_utilization, gpu_index)"},
+	}, dashboard.Queries)
+}
+
+func TestDashboardLinterReportsUnknownMetrics(t *testing.T) {
+	t.Parallel()
+
+	linter, err := dashboards.NewLinter(catalog.Default())
+	require.NoError(t, err)
+
+	dashboard := &dashboards.Dashboard{Queries: []dashboards.Query{
+		{Location: "known", Expr: "all_smi_gpu_temperature_celsius"},
+		{Location: "renamed", Expr: "all_smi_gpu_temperature"},
+		{Location: "invalid", Expr: "sum(up"},
+		{Location: "dcgm", Expr: "DCGM_FI_PROF_PIPE_TENSOR_ACTIVE"},
+	}}
+
+	issues := linter.Lint("example.json", dashboard)
+	require.Len(t, issues, 2, "Issues: %v", issues)
+	assert.Equal(t, "renamed", issues[0].Location)
+	assert.Contains(t, issues[0].Message, "unknown metric all_smi_gpu_temperature")
+	assert.Equal(t, "invalid", issues[1].Location)
+	assert.Contains(t, issues[1].Message, "invalid PromQL")
+}
+
+func TestDashboardLinterRestrictsExporters(t *testing.T) {
+	t.Parallel()
+
+	_, err := dashboards.NewLinter(catalog.Default(), "node-exporter")
+	assert.Error(t, err, "Exporters missing from the catalog should be rejected")
+
+	linter, err := dashboards.NewLinter(catalog.Default(), catalog.AllSmi, catalog.VMAgent)
+	require.NoError(t, err)
+
+	issues := linter.Lint("example.json", &dashboards.Dashboard{Queries: []dashboards.Query{
+		{Location: "all-smi", Expr: `up == 0 or all_smi_gpu_utilization`},
+		{Location: "dcgm", Expr: "DCGM_FI_DEV_GPU_UTIL"},
+	}})
+	require.Len(t, issues, 1, "Issues: %v", issues)
+	assert.Equal(t, "dcgm", issues[0].Location)
+	assert.Contains(t, issues[0].Message, "exported by dcgm-exporter")
+}
+
+func TestMetricCatalog(t *testing.T) {
+	t.Parallel()
+
+	metrics := catalog.Default()
+	assert.GreaterOrEqual(t, metrics.Version, 1)
+
+	for _, name := range []string{catalog.AllSmi, catalog.DCGMExporter, catalog.VMAgent} {
+		exporter, ok := metrics.Exporter(name)
+		require.True(t, ok, "Catalog should describe %s", name)
+		assert.NotEmpty(t, exporter.Version, "%s should be pinned to a version", name)
+		assert.NotEmpty(t, exporter.Metrics)
+	}
+
+	metric, exporter, ok := metrics.Lookup("DCGM_FI_DEV_XID_ERRORS")
+	require.True(t, ok)
+	assert.Equal(t, catalog.DCGMExporter, exporter.Name)
+	assert.Equal(t, "gauge", metric.Type)
+
+	_, _, ok = metrics.Lookup("DCGM_FI_DRIVER_VERSION")
+	assert.False(t, ok, "Label-only DCGM fields are not series")
+
+	testCases := []struct {
+		name    string
+		catalog string
+	}{
+		{name: "Missing Version", catalog: "exporters: []"},
+		{name: "Duplicate Exporter", catalog: "catalog_version: 1\nexporters: [{name: a}, {name: a}]"},
+		{name: "Duplicate Metric", catalog: "catalog_version: 1\nexporters: [{name: a, metrics: [{name: m, type: gauge}]}, {name: b, metrics: [{name: m, type: gauge}]}]"},
+		{name: "Unknown Type", catalog: "catalog_version: 1\nexporters: [{name: a, metrics: [{name: m, type: label}]}]"},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := catalog.Parse([]byte(tc.catalog))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestShippedDashboardsQueryKnownMetrics(t *testing.T) {
+	t.Parallel()
+
+	linter, err := dashboards.NewLinter(catalog.Default())
+	require.NoError(t, err)
+
+	count, issues, err := linter.LintPaths(dashboardsDir)
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	var problems []string
+	for _, issue := range issues {
+		problems = append(problems, issue.String())
+	}
+	assert.Empty(t, issues, "Dashboards query metrics missing from the catalog:\n%s", strings.Join(problems, "\n"))
+}
+
+func TestShippedDashboardsWithoutDCGM(t *testing.T) {
+	t.Parallel()
+
+	// Workers run all-smi only unless dcgm-exporter is deployed alongside,
+	// so only the tensor core panel may depend on it
+	linter, err := dashboards.NewLinter(catalog.Default(), catalog.AllSmi, catalog.VMAgent)
+	require.NoError(t, err)
+
+	_, issues, err := linter.LintPaths(dashboardsDir)
+	require.NoError(t, err)
+
+	require.Len(t, issues, 1, "Issues: %v", issues)
+	assert.Equal(t, "gpu-monitoring.json", filepath.Base(issues[0].File))
+	assert.Equal(t, `panel "Tensor Core Utilization" query A`, issues[0].Location)
+}
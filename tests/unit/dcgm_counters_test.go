@@ -0,0 +1,157 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/appleparan/Algalon/pkg/catalog"
+	"github.com/appleparan/Algalon/pkg/dcgm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fieldNames(fields []dcgm.Field) []string {
+	names := make([]string, 0, len(fields))
+	for _, field := range fields {
+		names = append(names, field.Name)
+	}
+	return names
+}
+
+func TestDCGMParseCounters(t *testing.T) {
+	t.Parallel()
+
+	fields, err := dcgm.Parse(strings.NewReader(`# Format
+# DCGM FIELD, Prometheus metric type, help message
+
+DCGM_FI_DEV_GPU_UTIL,      gauge, GPU utilization (in %).
+  # DCGM_FI_DEV_ENC_UTIL,    gauge, Encoder utilization (in %).
+DCGM_FI_DEV_DEC_UTIL ,     gauge, Decoder utilization (in %).
+DCGM_FI_PROF_PCIE_TX_BYTES, gauge, The rate of data transmitted, in bytes per second.
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, []dcgm.Field{
+		{Name: "DCGM_FI_DEV_GPU_UTIL", Type: "gauge", Help: "GPU utilization (in %).", Line: 4},
+		{Name: "DCGM_FI_DEV_DEC_UTIL", Type: "gauge", Help: "Decoder utilization (in %).", Line: 6},
+		{Name: "DCGM_FI_PROF_PCIE_TX_BYTES", Type: "gauge", Help: "The rate of data transmitted, in bytes per second.", Line: 7},
+	}, fields, "Whitespace around columns is ignored and help may contain commas")
+
+	_, err = dcgm.Parse(strings.NewReader("DCGM_FI_DEV_GPU_UTIL, gauge\n"))
+	assert.ErrorContains(t, err, "line 1: expected")
+	_, err = dcgm.Parse(strings.NewReader("\n , gauge, help\n"))
+	assert.ErrorContains(t, err, "line 2: no DCGM field name")
+}
+
+func TestDCGMValidateCounters(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name   string
+		line   string
+		errMsg string
+	}{
+		{name: "Gauge", line: "DCGM_FI_DEV_GPU_TEMP, gauge, GPU temperature (in C)."},
+		{name: "Counter", line: "DCGM_FI_DEV_PCIE_REPLAY_COUNTER, counter, Total number of PCIe retries."},
+		{name: "Label", line: "DCGM_FI_DRIVER_VERSION, label, Driver Version"},
+		{name: "Unknown Field", line: "DCGM_FI_DEV_GPU_TEMPERATURE, gauge, GPU temperature.", errMsg: "not a DCGM field"},
+		{name: "Metric Of Another Exporter", line: "all_smi_gpu_utilization, gauge, GPU utilization.", errMsg: "not a DCGM field"},
+		{name: "Unknown Type", line: "DCGM_FI_DEV_GPU_TEMP, histogram, GPU temperature.", errMsg: `unknown metric type "histogram"`},
+		{name: "Type Differs From Catalog", line: "DCGM_FI_PROF_PCIE_TX_BYTES, counter, PCIe TX bytes.", errMsg: "has type gauge in the metric catalog, not counter"},
+		{name: "Label Field As Gauge", line: "DCGM_FI_DEV_SERIAL, gauge, Device Serial Number", errMsg: "must have type label"},
+		{name: "Listed Twice", line: "DCGM_FI_DEV_FB_USED, gauge, a\nDCGM_FI_DEV_FB_USED, gauge, b", errMsg: "line 2: DCGM_FI_DEV_FB_USED: already listed on line 1"},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			fields, err := dcgm.Parse(strings.NewReader(tc.line))
+			require.NoError(t, err)
+
+			issues := dcgm.Validate(fields, catalog.Default())
+			if tc.errMsg == "" {
+				assert.Empty(t, issues)
+				return
+			}
+			require.Len(t, issues, 1)
+			assert.Contains(t, issues[0].String(), tc.errMsg)
+		})
+	}
+}
+
+func TestShippedDCGMCounters(t *testing.T) {
+	t.Parallel()
+
+	def, err := dcgm.Build(dcgm.ProfileDefault, catalog.Default())
+	require.NoError(t, err)
+
+	for _, name := range []string{dcgm.DefaultFileName, "default-counters.csv"} {
+		path := filepath.Join("..", "..", "algalon_worker", name)
+		fields, err := dcgm.ReadFile(path)
+		require.NoError(t, err)
+		assert.Empty(t, dcgm.Validate(fields, catalog.Default()), "%s should only list catalog fields", name)
+		assert.ElementsMatch(t, fieldNames(fields), fieldNames(def), "The default profile should enable the same fields as %s", name)
+
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.NotContains(t, string(data), " ,", "%s has whitespace before a comma", name)
+	}
+}
+
+func TestDCGMProfiles(t *testing.T) {
+	t.Parallel()
+
+	metrics := catalog.Default()
+	assert.Equal(t, []string{"minimal", "default", "profiling-heavy"}, dcgm.ProfileNames())
+
+	minimal, err := dcgm.Build(dcgm.ProfileMinimal, metrics)
+	require.NoError(t, err)
+	def, err := dcgm.Build(dcgm.ProfileDefault, metrics)
+	require.NoError(t, err)
+	heavy, err := dcgm.Build(dcgm.ProfileProfilingHeavy, metrics)
+	require.NoError(t, err)
+
+	assert.Equal(t, fieldNames(minimal), fieldNames(def)[:len(minimal)], "A profile starts with the fields of its base")
+	assert.Equal(t, fieldNames(def), fieldNames(heavy)[:len(def)])
+	for _, name := range []string{"DCGM_FI_DEV_GPU_TEMP", "DCGM_FI_DEV_FB_USED", "DCGM_FI_DEV_FB_FREE", "DCGM_FI_DEV_XID_ERRORS"} {
+		assert.Contains(t, fieldNames(minimal), name, "The alert rules need %s", name)
+	}
+
+	exporter, ok := metrics.Exporter(catalog.DCGMExporter)
+	require.True(t, ok)
+	for _, metric := range exporter.Metrics {
+		if strings.HasPrefix(metric.Name, "DCGM_FI_PROF_") {
+			assert.Contains(t, fieldNames(heavy), metric.Name, "profiling-heavy should enable every profiling field")
+		}
+	}
+	assert.NotContains(t, fieldNames(def), "DCGM_FI_PROF_SM_OCCUPANCY")
+
+	_, err = dcgm.Build("everything", metrics)
+	assert.ErrorContains(t, err, "expected minimal, default, profiling-heavy")
+}
+
+func TestDCGMWriteProfileRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), dcgm.DefaultFileName)
+	written, err := dcgm.WriteProfile(path, dcgm.ProfileProfilingHeavy, catalog.Default())
+	require.NoError(t, err)
+
+	read, err := dcgm.ReadFile(path)
+	require.NoError(t, err)
+	require.Len(t, read, len(written))
+	for i := range read {
+		assert.Equal(t, written[i].Name, read[i].Name)
+		assert.Equal(t, written[i].Type, read[i].Type)
+		assert.Equal(t, written[i].Help, read[i].Help)
+	}
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(data), "# dcgm-exporter-config.csv\n"))
+	assert.Contains(t, string(data), "\nDCGM_FI_DEV_GPU_UTIL,                    gauge,   GPU utilization (in %).\n", "Columns are aligned")
+}
@@ -0,0 +1,261 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appleparan/Algalon/pkg/discovery"
+	"github.com/appleparan/Algalon/pkg/registry"
+	"github.com/appleparan/Algalon/pkg/targets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const allSmiMetricsPage = `# HELP all_smi_info all-smi build and host information
+# TYPE all_smi_info gauge
+all_smi_info{hostname="worker",version="v0.9.0"} 1
+all_smi_gpu_utilization{gpu="NVIDIA Tesla T4",index="0"} 42
+`
+
+const otherMetricsPage = `# HELP node_cpu_seconds_total Seconds the CPUs spent in each mode.
+node_cpu_seconds_total{cpu="0",mode="idle"} 1234
+# all_smi_info appears only in this comment
+gpu_utilization 12
+`
+
+func TestDiscoveryHosts(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		cidr        string
+		expectCount int
+		expectFirst string
+		expectLast  string
+		expectError bool
+	}{
+		{name: "Slash 24", cidr: "192.168.1.0/24", expectCount: 254, expectFirst: "192.168.1.1", expectLast: "192.168.1.254"},
+		{name: "Slash 30", cidr: "10.0.0.4/30", expectCount: 2, expectFirst: "10.0.0.5", expectLast: "10.0.0.6"},
+		{name: "Slash 31", cidr: "10.0.0.4/31", expectCount: 2, expectFirst: "10.0.0.4", expectLast: "10.0.0.5"},
+		{name: "Single Host", cidr: "10.0.0.7/32", expectCount: 1, expectFirst: "10.0.0.7", expectLast: "10.0.0.7"},
+		{name: "Host Bits Set", cidr: "10.128.0.9/16", expectCount: 65534, expectFirst: "10.128.0.1", expectLast: "10.128.255.254"},
+		{name: "Too Large", cidr: "10.0.0.0/8", expectError: true},
+		{name: "IPv6", cidr: "fd00::/120", expectError: true},
+		{name: "Malformed", cidr: "192.168.1.0", expectError: true},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			hosts, err := discovery.Hosts(tc.cidr)
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Len(t, hosts, tc.expectCount)
+			assert.Equal(t, tc.expectFirst, hosts[0].String())
+			assert.Equal(t, tc.expectLast, hosts[len(hosts)-1].String())
+		})
+	}
+}
+
+func TestHasAllSmiInfo(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		page     string
+		expected bool
+	}{
+		{name: "all-smi Page", page: allSmiMetricsPage, expected: true},
+		{name: "Unlabelled Info Sample", page: "all_smi_info 1\n", expected: true},
+		{name: "Other Exporter", page: otherMetricsPage, expected: false},
+		{name: "Metric With Info Prefix", page: "all_smi_info_total 3\n", expected: false},
+		{name: "Empty Page", page: "", expected: false},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ok, err := discovery.HasAllSmiInfo(strings.NewReader(tc.page))
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, ok)
+		})
+	}
+}
+
+// listenOnLoopbackHosts starts a metrics server on 127.0.0.<host>:port for
+// every entry in pages, all sharing one port so a single scan can find them.
+func listenOnLoopbackHosts(t *testing.T, pages map[int]string) int {
+	t.Helper()
+
+	for attempt := 0; attempt < 10; attempt++ {
+		var listeners []net.Listener
+		port := 0
+		ok := true
+
+		for host := range pages {
+			l, err := net.Listen("tcp", net.JoinHostPort(fmt.Sprintf("127.0.0.%d", host), strconv.Itoa(port)))
+			if err != nil {
+				ok = false
+				break
+			}
+			port = l.Addr().(*net.TCPAddr).Port
+			listeners = append(listeners, l)
+		}
+
+		if !ok {
+			for _, l := range listeners {
+				l.Close()
+			}
+			continue
+		}
+
+		for _, l := range listeners {
+			host := int(l.Addr().(*net.TCPAddr).IP.To4()[3])
+			page := pages[host]
+			server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/metrics" {
+					http.NotFound(w, r)
+					return
+				}
+				io.WriteString(w, page)
+			})}
+			go server.Serve(l)
+			t.Cleanup(func() { server.Close() })
+		}
+
+		return port
+	}
+
+	t.Skip("Skipping: could not bind several 127.0.0.x addresses to one port")
+	return 0
+}
+
+func TestDiscoveryScanFindsOnlyAllSmiWorkers(t *testing.T) {
+	t.Parallel()
+
+	port := listenOnLoopbackHosts(t, map[int]string{
+		2: allSmiMetricsPage,
+		3: otherMetricsPage,
+		5: allSmiMetricsPage,
+	})
+
+	scanner := discovery.NewScanner(port)
+	scanner.Concurrency = 4
+	scanner.Timeout = time.Second
+
+	found, err := scanner.Scan(context.Background(), "127.0.0.0/29")
+	require.NoError(t, err)
+
+	var addresses []string
+	for _, target := range found {
+		addresses = append(addresses, target.String())
+	}
+	assert.Equal(t, []string{
+		net.JoinHostPort("127.0.0.2", strconv.Itoa(port)),
+		net.JoinHostPort("127.0.0.5", strconv.Itoa(port)),
+	}, addresses)
+}
+
+func TestDiscoveryRunOnceRegistersNewWorkers(t *testing.T) {
+	t.Parallel()
+
+	port := listenOnLoopbackHosts(t, map[int]string{
+		2: allSmiMetricsPage,
+		4: allSmiMetricsPage,
+	})
+
+	path := filepath.Join(t.TempDir(), "all-smi-targets.yml")
+	store, err := registry.NewStore(path, targets.DefaultLabels("production", "gpu-cluster"))
+	require.NoError(t, err)
+
+	existing := net.JoinHostPort("127.0.0.2", strconv.Itoa(port))
+	_, _, err = store.Add(registry.Worker{Target: existing})
+	require.NoError(t, err)
+
+	scanner := discovery.NewScanner(port)
+	scanner.Timeout = time.Second
+	d := &discovery.Discoverer{
+		Scanner: scanner,
+		Network: "127.0.0.0/29",
+		Store:   store,
+		DryRun:  true,
+		Logger:  log.New(io.Discard, "", 0),
+	}
+
+	// Dry run reports the new worker but leaves the targets file untouched
+	fresh, err := d.RunOnce(context.Background())
+	require.NoError(t, err)
+	require.Len(t, fresh, 1)
+	assert.Equal(t, net.JoinHostPort("127.0.0.4", strconv.Itoa(port)), fresh[0].String())
+	assert.Len(t, store.List(), 1)
+
+	d.DryRun = false
+	fresh, err = d.RunOnce(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, fresh, 1)
+
+	groups, err := targets.ReadFile(path)
+	require.NoError(t, err)
+	require.Len(t, groups, 1)
+	assert.Len(t, groups[0].Targets, 2)
+
+	// A second run finds nothing new
+	fresh, err = d.RunOnce(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, fresh)
+}
+
+func TestDiscoveryRunOnceSeesWorkersRegisteredElsewhere(t *testing.T) {
+	t.Parallel()
+
+	port := listenOnLoopbackHosts(t, map[int]string{
+		2: allSmiMetricsPage,
+	})
+
+	path := filepath.Join(t.TempDir(), "all-smi-targets.yml")
+	store, err := registry.NewStore(path, targets.DefaultLabels("production", "gpu-cluster"))
+	require.NoError(t, err)
+
+	// The registry daemon registers the worker, with its own labels, after
+	// the discovery store was loaded.
+	daemon, err := registry.NewStore(path, targets.DefaultLabels("production", "gpu-cluster"))
+	require.NoError(t, err)
+	worker := net.JoinHostPort("127.0.0.2", strconv.Itoa(port))
+	_, _, err = daemon.Add(registry.Worker{Target: worker, Labels: map[string]string{"gpu_type": "nvidia-tesla-t4"}})
+	require.NoError(t, err)
+
+	scanner := discovery.NewScanner(port)
+	scanner.Timeout = time.Second
+	d := &discovery.Discoverer{
+		Scanner: scanner,
+		Network: "127.0.0.0/29",
+		Store:   store,
+		Logger:  log.New(io.Discard, "", 0),
+	}
+
+	fresh, err := d.RunOnce(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, fresh, "A worker already in the file is not registered again")
+
+	groups, err := targets.ReadFile(path)
+	require.NoError(t, err)
+	require.Len(t, groups, 1)
+	assert.Equal(t, "nvidia-tesla-t4", groups[0].Labels["gpu_type"], "The registered labels are kept")
+}
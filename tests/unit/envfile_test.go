@@ -0,0 +1,140 @@
+package test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/appleparan/Algalon/pkg/envfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvfileParse(t *testing.T) {
+	t.Parallel()
+
+	lookup := envfile.Env{"HOME_DIR": "/home/algalon"}.Lookup
+
+	testCases := []struct {
+		name        string
+		content     string
+		expected    envfile.Env
+		expectError bool
+	}{
+		{
+			name:     "Comments And Blank Lines",
+			content:  "# Basic host\n\nALGALON_CLUSTER=production\n  # indented comment\n",
+			expected: envfile.Env{"ALGALON_CLUSTER": "production"},
+		},
+		{
+			name:     "Export Prefix",
+			content:  "export ALL_SMI_PORT=9090\n",
+			expected: envfile.Env{"ALL_SMI_PORT": "9090"},
+		},
+		{
+			name:     "Double Quotes",
+			content:  `VMAGENT_EXTERNAL_LABELS="cluster=prod,region=us-west"` + "\n",
+			expected: envfile.Env{"VMAGENT_EXTERNAL_LABELS": "cluster=prod,region=us-west"},
+		},
+		{
+			name:     "Inline Comment",
+			content:  "ALL_SMI_INTERVAL=5  # seconds\nGF_PASSWORD=pass#word\n",
+			expected: envfile.Env{"ALL_SMI_INTERVAL": "5", "GF_PASSWORD": "pass#word"},
+		},
+		{
+			name:     "Comment After Quoted Value",
+			content:  `ALGALON_ENVIRONMENT="staging" # override` + "\n",
+			expected: envfile.Env{"ALGALON_ENVIRONMENT": "staging"},
+		},
+		{
+			name:     "Empty Value",
+			content:  "ALGALON_TARGETS=\n",
+			expected: envfile.Env{"ALGALON_TARGETS": ""},
+		},
+		{
+			name:     "Expand Earlier Key",
+			content:  "CLUSTER=gpu-a\nVMAGENT_EXTERNAL_LABELS=cluster=${CLUSTER},env=$CLUSTER-dev\n",
+			expected: envfile.Env{"CLUSTER": "gpu-a", "VMAGENT_EXTERNAL_LABELS": "cluster=gpu-a,env=gpu-a-dev"},
+		},
+		{
+			name:     "Expand From Lookup",
+			content:  "DATA_DIR=${HOME_DIR}/data\n",
+			expected: envfile.Env{"DATA_DIR": "/home/algalon/data"},
+		},
+		{
+			name:     "Unresolved Reference",
+			content:  "DATA_DIR=${MISSING}/data\n",
+			expected: envfile.Env{"DATA_DIR": "/data"},
+		},
+		{
+			name:     "Single Quotes Are Literal",
+			content:  "GF_PASSWORD='${NOT_EXPANDED}'\n",
+			expected: envfile.Env{"GF_PASSWORD": "${NOT_EXPANDED}"},
+		},
+		{
+			name:     "Command Substitution Kept",
+			content:  "HOST_LABEL=instance=$(hostname)\n",
+			expected: envfile.Env{"HOST_LABEL": "instance=$(hostname)"},
+		},
+		{name: "Missing Equals", content: "ALGALON_TARGETS\n", expectError: true},
+		{name: "Invalid Key", content: "1TARGETS=worker1\n", expectError: true},
+		{name: "Unterminated Quote", content: `ALGALON_CLUSTER="production` + "\n", expectError: true},
+		{name: "Text After Quote", content: `ALGALON_CLUSTER="prod"uction` + "\n", expectError: true},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			env, err := envfile.Parse(strings.NewReader(tc.content), lookup)
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, env)
+		})
+	}
+}
+
+func TestEnvfileChain(t *testing.T) {
+	t.Parallel()
+
+	process := envfile.Env{"ALL_SMI_PORT": "9091"}
+	file := envfile.Env{"ALL_SMI_PORT": "9090", "ALL_SMI_INTERVAL": "5"}
+	lookup := envfile.Chain(process.Lookup, nil, file.Lookup)
+
+	port, ok := lookup("ALL_SMI_PORT")
+	assert.True(t, ok)
+	assert.Equal(t, "9091", port, "Earlier lookups should win")
+
+	interval, ok := lookup("ALL_SMI_INTERVAL")
+	assert.True(t, ok)
+	assert.Equal(t, "5", interval)
+
+	_, ok = lookup("ALL_SMI_VERSION")
+	assert.False(t, ok)
+
+	assert.Equal(t, []string{"ALL_SMI_INTERVAL=5", "ALL_SMI_PORT=9090"}, file.Environ())
+}
+
+func TestEnvfileShippedExamplesParse(t *testing.T) {
+	t.Parallel()
+
+	paths, err := filepath.Glob(filepath.Join("..", "..", "examples", "*-configs", "*.env"))
+	require.NoError(t, err)
+	require.NotEmpty(t, paths, "Example env files should be found")
+
+	for _, path := range paths {
+		path := path // capture range variable
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			t.Parallel()
+
+			env, err := envfile.ReadFile(path)
+			require.NoError(t, err)
+			assert.NotEmpty(t, env)
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/appleparan/Algalon/pkg/config"
+	"github.com/appleparan/Algalon/pkg/envfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShippedHostConfigs(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		file           string
+		retention      string
+		scrapeInterval time.Duration
+		cluster        string
+		environment    string
+		targetCount    int
+		https          bool
+		ha             bool
+	}{
+		{file: "basic-host.env", retention: "30d", scrapeInterval: 5 * time.Second, cluster: "local", environment: "development", targetCount: 1},
+		{file: "minimal-host.env", retention: "7d", scrapeInterval: 30 * time.Second, cluster: "production", environment: "edge", targetCount: 1},
+		{file: "multi-cluster-host.env", retention: "60d", scrapeInterval: 5 * time.Second, cluster: "production", environment: "multi-cluster", targetCount: 8, ha: true},
+		{file: "production-host.env", retention: "90d", scrapeInterval: 10 * time.Second, cluster: "main", environment: "production", targetCount: 3, https: true},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // capture range variable
+		t.Run(tc.file, func(t *testing.T) {
+			t.Parallel()
+
+			host, err := config.LoadHost(filepath.Join("..", "..", "examples", "host-configs", tc.file))
+			require.NoError(t, err)
+
+			assert.Equal(t, config.DefaultGrafanaPort, host.Grafana.Port)
+			assert.Equal(t, config.DefaultVictoriaMetricsPort, host.VictoriaMetrics.Port)
+			assert.Equal(t, tc.retention, host.VictoriaMetrics.Retention)
+			assert.Equal(t, tc.scrapeInterval, host.VMAgent.ScrapeInterval)
+			assert.Equal(t, tc.cluster, host.Targets.Cluster)
+			assert.Equal(t, tc.environment, host.Targets.Environment)
+			assert.Equal(t, tc.https, host.HTTPS.Enabled)
+			assert.Equal(t, tc.ha, host.HA.Enabled)
+
+			groups, err := host.Targets.Groups()
+			require.NoError(t, err)
+			require.Len(t, groups, 1)
+			assert.Len(t, groups[0].Targets, tc.targetCount)
+		})
+	}
+}
+
+func TestShippedWorkerConfigs(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		file     string
+		interval int
+		labels   map[string]string
+	}{
+		{file: "basic-worker.env", interval: 5, labels: map[string]string{}},
+		{file: "high-frequency-worker.env", interval: 1, labels: map[string]string{"monitoring_type": "realtime", "frequency": "high", "purpose": "training"}},
+		{file: "production-worker.env", interval: 5, labels: map[string]string{"environment": "production", "tier": "compute", "monitoring": "algalon"}},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // capture range variable
+		t.Run(tc.file, func(t *testing.T) {
+			t.Parallel()
+
+			worker, err := config.LoadWorker(filepath.Join("..", "..", "examples", "worker-configs", tc.file))
+			require.NoError(t, err)
+
+			assert.Equal(t, config.DefaultAllSmiVersion, worker.Version)
+			assert.Equal(t, config.DefaultAllSmiPort, worker.Port)
+			assert.Equal(t, tc.interval, worker.Interval)
+			assert.Equal(t, config.DefaultHostIP, worker.HostIP)
+			assert.Equal(t, tc.labels, worker.Labels)
+		})
+	}
+}
+
+func TestShippedExampleConfigsCovered(t *testing.T) {
+	t.Parallel()
+
+	hosts, err := filepath.Glob(filepath.Join("..", "..", "examples", "host-configs", "*.env"))
+	require.NoError(t, err)
+	workers, err := filepath.Glob(filepath.Join("..", "..", "examples", "worker-configs", "*.env"))
+	require.NoError(t, err)
+
+	// A new example must be added to TestShippedHostConfigs or
+	// TestShippedWorkerConfigs, so check every file validates here too.
+	assert.Len(t, hosts, 4)
+	assert.Len(t, workers, 3)
+	for _, path := range hosts {
+		_, err := config.LoadHost(path)
+		assert.NoError(t, err)
+	}
+	for _, path := range workers {
+		_, err := config.LoadWorker(path)
+		assert.NoError(t, err)
+	}
+}
+
+func TestHostConfigDefaults(t *testing.T) {
+	t.Parallel()
+
+	host, err := config.HostFromEnv(envfile.Env{}.Lookup)
+	require.NoError(t, err)
+	require.NoError(t, host.Validate())
+
+	assert.Equal(t, "http://localhost:3000", host.Grafana.URL())
+	assert.Equal(t, "http://localhost:8428", host.VictoriaMetrics.URL())
+	assert.Equal(t, config.DefaultWorkerTargets, host.Targets.Targets)
+	assert.Equal(t, "production", host.Targets.Cluster)
+	assert.Equal(t, "gpu-cluster", host.Targets.Environment)
+	assert.Equal(t, config.DefaultScrapeInterval, host.VMAgent.ScrapeInterval)
+}
+
+func TestHostConfigTargetPrecedence(t *testing.T) {
+	t.Parallel()
+
+	env := envfile.Env{
+		"WORKER_TARGETS":          "10.0.0.1:9090",
+		"ALGALON_TARGETS":         "10.0.0.2:9090",
+		"VMAGENT_EXTERNAL_LABELS": "cluster=gpu-a,environment=staging",
+		"ALGALON_ENVIRONMENT":     "prod",
+	}
+	host, err := config.HostFromEnv(env.Lookup)
+	require.NoError(t, err)
+
+	assert.Equal(t, "10.0.0.2:9090", host.Targets.Targets, "ALGALON_TARGETS should win over WORKER_TARGETS")
+	assert.Equal(t, "gpu-a", host.Targets.Cluster, "Cluster should fall back to VMAGENT_EXTERNAL_LABELS")
+	assert.Equal(t, "prod", host.Targets.Environment, "ALGALON_ENVIRONMENT should win over VMAGENT_EXTERNAL_LABELS")
+}
+
+func TestHostConfigValidation(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		env         envfile.Env
+		expectError bool
+	}{
+		{name: "Defaults", env: envfile.Env{}},
+		{name: "Custom Ports", env: envfile.Env{"GRAFANA_PORT": "3001", "VICTORIA_METRICS_PORT": "18428"}},
+		{name: "Port Out Of Range", env: envfile.Env{"GRAFANA_PORT": "70000"}, expectError: true},
+		{name: "Non-Numeric Port", env: envfile.Env{"VICTORIA_METRICS_PORT": "http"}, expectError: true},
+		{name: "Port Conflict", env: envfile.Env{"GRAFANA_PORT": "8428"}, expectError: true},
+		{name: "Bad Retention", env: envfile.Env{"VICTORIA_METRICS_RETENTION": "30 days"}, expectError: true},
+		{name: "Sub-Second Scrape Interval", env: envfile.Env{"VMAGENT_SCRAPE_INTERVAL": "500ms"}, expectError: true},
+		{name: "Bad Scrape Interval", env: envfile.Env{"VMAGENT_SCRAPE_INTERVAL": "5"}, expectError: true},
+		{name: "Malformed External Labels", env: envfile.Env{"VMAGENT_EXTERNAL_LABELS": "cluster"}, expectError: true},
+		{name: "Invalid External Label Name", env: envfile.Env{"VMAGENT_EXTERNAL_LABELS": "gpu-type=a100"}, expectError: true},
+		{name: "Malformed Worker Target", env: envfile.Env{"WORKER_TARGETS": "worker1:9090,worker_2:9090"}, expectError: true},
+		{name: "Duplicate Worker Target", env: envfile.Env{"WORKER_TARGETS": "worker1:9090,worker1:9090"}, expectError: true},
+		{name: "Malformed Algalon Target", env: envfile.Env{"ALGALON_TARGETS": "10.0.1.300:9090"}, expectError: true},
+		{name: "Bad Subnet", env: envfile.Env{"SUBNET_CIDR": "172.20.0.0"}, expectError: true},
+		{name: "Bad Memory Limit", env: envfile.Env{"GRAFANA_MEMORY_LIMIT": "1 GB"}, expectError: true},
+		{name: "Bad CPU Limit", env: envfile.Env{"VMAGENT_CPU_LIMIT": "half"}, expectError: true},
+		{name: "HTTPS Without Certificate", env: envfile.Env{"ENABLE_HTTPS": "true", "SSL_CERT_PATH": "/etc/ssl/certs/algalon.crt"}, expectError: true},
+		{name: "HTTPS With Certificate", env: envfile.Env{"ENABLE_HTTPS": "true", "SSL_CERT_PATH": "/a.crt", "SSL_KEY_PATH": "/a.key"}},
+		{name: "HA With One Replica", env: envfile.Env{"HA_ENABLED": "true", "HA_REPLICAS": "1"}, expectError: true},
+		{name: "Alertmanager Without Receiver", env: envfile.Env{"ALERTMANAGER_ENABLED": "true"}, expectError: true},
+		{name: "Alertmanager With Slack", env: envfile.Env{"ALERTMANAGER_ENABLED": "true", "SLACK_WEBHOOK_URL": "https://hooks.slack.com/services/T0/B0/X"}},
+		{name: "Alertmanager With SMTP", env: envfile.Env{"ALERTMANAGER_ENABLED": "true", "ALERT_SMTP_ADDR": "smtp.example.com:587", "ALERT_SMTP_FROM": "algalon@example.com", "ALERT_SMTP_TO": "oncall@example.com, gpu-team@example.com"}},
+		{name: "SMTP Without Port", env: envfile.Env{"ALERT_SMTP_ADDR": "smtp.example.com", "ALERT_SMTP_FROM": "a@example.com", "ALERT_SMTP_TO": "b@example.com"}, expectError: true},
+		{name: "SMTP Without Recipients", env: envfile.Env{"ALERT_SMTP_ADDR": "smtp.example.com:587", "ALERT_SMTP_FROM": "a@example.com"}, expectError: true},
+		{name: "SMTP Bad Address", env: envfile.Env{"ALERT_SMTP_ADDR": "smtp.example.com:587", "ALERT_SMTP_FROM": "a@example.com", "ALERT_SMTP_TO": "oncall"}, expectError: true},
+		{name: "Relative Webhook URL", env: envfile.Env{"ALERT_WEBHOOK_URL": "/alerts"}, expectError: true},
+		{name: "Alertmanager Notifier URL", env: envfile.Env{"ALERT_NOTIFIER_URL": "http://alertmanager:9093"}},
+		{name: "Notifier URL Without Scheme", env: envfile.Env{"ALERT_NOTIFIER_URL": "algalon-notify:8440"}, expectError: true},
+		{name: "Bad Boolean", env: envfile.Env{"GRAFANA_EXTERNAL_ACCESS": "yes"}, expectError: true},
+		{name: "Bad Log Level", env: envfile.Env{"LOG_LEVEL": "verbose"}, expectError: true},
+		{name: "Custom Alert Thresholds", env: envfile.Env{"ALERT_GPU_TEMPERATURE_WARNING": "75", "ALERT_GPU_TEMPERATURE_CRITICAL": "85.5", "ALERT_FOR": "2m"}},
+		{name: "Bad Alert Duration", env: envfile.Env{"ALERT_TARGET_DOWN_FOR": "5"}, expectError: true},
+		{name: "Non-Numeric Alert Threshold", env: envfile.Env{"ALERT_GPU_MEMORY_PERCENT": "high"}, expectError: true},
+		{name: "Alert Memory Above 100 Percent", env: envfile.Env{"ALERT_GPU_MEMORY_PERCENT": "120"}, expectError: true},
+		{name: "Warning Above Critical Temperature", env: envfile.Env{"ALERT_GPU_TEMPERATURE_WARNING": "95"}, expectError: true},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			host, err := config.HostFromEnv(tc.env.Lookup)
+			if err == nil {
+				err = host.Validate()
+			}
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestWorkerConfigValidation(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		env         envfile.Env
+		expectError bool
+	}{
+		{name: "Defaults", env: envfile.Env{}},
+		{name: "Main Branch", env: envfile.Env{"ALL_SMI_VERSION": "main"}},
+		{name: "Version With Spaces", env: envfile.Env{"ALL_SMI_VERSION": "v0.9.0 beta"}, expectError: true},
+		{name: "Port Zero", env: envfile.Env{"ALL_SMI_PORT": "0"}, expectError: true},
+		{name: "Port Out Of Range", env: envfile.Env{"ALL_SMI_PORT": "65536"}, expectError: true},
+		{name: "Interval One", env: envfile.Env{"ALL_SMI_INTERVAL": "1"}},
+		{name: "Interval Zero", env: envfile.Env{"ALL_SMI_INTERVAL": "0"}, expectError: true},
+		{name: "Fractional Interval", env: envfile.Env{"ALL_SMI_INTERVAL": "0.5"}, expectError: true},
+		{name: "Loopback Host IP", env: envfile.Env{"HOST_IP": "127.0.0.1"}},
+		{name: "DCGM Profile", env: envfile.Env{"DCGM_PROFILE": "profiling-heavy"}},
+		{name: "Unknown DCGM Profile", env: envfile.Env{"DCGM_PROFILE": "everything"}, expectError: true},
+		{name: "Registry URL", env: envfile.Env{"ALGALON_REGISTRY_URL": "http://10.128.0.10:8430"}},
+		{name: "Registry Without Scheme", env: envfile.Env{"ALGALON_REGISTRY_URL": "10.128.0.10:8430"}, expectError: true},
+		{name: "Hostname As Host IP", env: envfile.Env{"HOST_IP": "localhost"}, expectError: true},
+		{name: "Malformed Labels", env: envfile.Env{"WORKER_LABELS": "team=ml-ops,prod"}, expectError: true},
+		{name: "Duplicate Labels", env: envfile.Env{"WORKER_LABELS": "env=prod,env=dev"}, expectError: true},
+		{name: "Memory Limit", env: envfile.Env{"MEMORY_LIMIT": "512m", "CPU_LIMIT": "0.5"}},
+		{name: "Negative CPU Limit", env: envfile.Env{"CPU_LIMIT": "-1"}, expectError: true},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			worker, err := config.WorkerFromEnv(tc.env.Lookup)
+			if err == nil {
+				err = worker.Validate()
+			}
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,397 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/appleparan/Algalon/pkg/discovery"
+	"github.com/appleparan/Algalon/pkg/targets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func workerInstance(name, ip, cluster string, preemptible bool) discovery.Instance {
+	return discovery.Instance{
+		Name:        name,
+		Zone:        "us-central1-a",
+		Status:      discovery.StatusRunning,
+		InternalIP:  ip,
+		Preemptible: preemptible,
+		Labels: map[string]string{
+			"component":   "algalon-worker",
+			"cluster":     cluster,
+			"environment": "prod",
+		},
+	}
+}
+
+// fakeClock is advanced by hand to step through the grace period.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newInstanceDiscoverer(t *testing.T, compute discovery.Compute, clock *fakeClock) *discovery.InstanceDiscoverer {
+	return &discovery.InstanceDiscoverer{
+		Compute:     compute,
+		Port:        9090,
+		Path:        filepath.Join(t.TempDir(), discovery.DefaultInstanceFile),
+		GracePeriod: 5 * time.Minute,
+		Logger:      log.New(io.Discard, "", 0),
+		Now:         clock.Now,
+	}
+}
+
+func fileTargets(t *testing.T, path string) []string {
+	groups, err := targets.ReadFile(path)
+	require.NoError(t, err)
+
+	var all []string
+	for _, group := range groups {
+		all = append(all, group.Targets...)
+	}
+	return all
+}
+
+func TestInstanceDiscoveryFiltersByLabels(t *testing.T) {
+	t.Parallel()
+
+	compute := discovery.NewFakeCompute(
+		workerInstance("worker-1", "10.128.0.2", "training", false),
+		workerInstance("worker-2", "10.128.0.3", "inference", false),
+		discovery.Instance{Name: "algalon-host", Status: discovery.StatusRunning, InternalIP: "10.128.0.10", Labels: map[string]string{"component": "algalon-host"}},
+		discovery.Instance{Name: "booting", Status: discovery.StatusStaging, Labels: map[string]string{"component": "algalon-worker"}},
+	)
+	d := newInstanceDiscoverer(t, compute, &fakeClock{now: time.Unix(0, 0)})
+	d.Labels = map[string]string{"component": "algalon-worker", "cluster": "training"}
+
+	changes, err := d.RunOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.128.0.2:9090"}, changes.Added)
+	assert.Equal(t, 1, changes.Targets)
+
+	groups, err := targets.ReadFile(d.Path)
+	require.NoError(t, err)
+	require.Len(t, groups, 1)
+	want := targets.DefaultLabels("training", "prod")
+	want["__meta_algalon_zone"] = "us-central1-a"
+	want["__meta_algalon_instance_name"] = "worker-1"
+	want["__meta_algalon_preemptible"] = "false"
+	assert.Equal(t, want, groups[0].Labels)
+}
+
+func TestInstanceDiscoveryMetadataLabels(t *testing.T) {
+	t.Parallel()
+
+	gpu := workerInstance("gpu-worker-1", "10.128.0.2", "training", true)
+	gpu.GPUType = "nvidia-tesla-t4"
+	gpu.GPUsPerInstance = 2
+	compute := discovery.NewFakeCompute(gpu, workerInstance("cpu-worker-1", "10.128.0.3", "training", false))
+	d := newInstanceDiscoverer(t, compute, &fakeClock{now: time.Unix(0, 0)})
+
+	_, err := d.RunOnce(context.Background())
+	require.NoError(t, err)
+
+	groups, err := targets.ReadFile(d.Path)
+	require.NoError(t, err)
+	require.Len(t, groups, 2, "Each instance should get its own group for its instance_name")
+
+	byTarget := map[string]map[string]string{}
+	for _, group := range groups {
+		require.Len(t, group.Targets, 1)
+		byTarget[group.Targets[0]] = group.Labels
+	}
+	assert.Equal(t, map[string]string{
+		"job":                              "all-smi",
+		"cluster":                          "training",
+		"environment":                      "prod",
+		"monitoring_type":                  "comprehensive",
+		"__meta_algalon_gpu_type":          "nvidia-tesla-t4",
+		"__meta_algalon_gpus_per_instance": "2",
+		"__meta_algalon_zone":              "us-central1-a",
+		"__meta_algalon_instance_name":     "gpu-worker-1",
+		"__meta_algalon_preemptible":       "true",
+	}, byTarget["10.128.0.2:9090"])
+	assert.NotContains(t, byTarget["10.128.0.3:9090"], "__meta_algalon_gpu_type", "CPU-only workers have no GPU type")
+	assert.Equal(t, "cpu-worker-1", byTarget["10.128.0.3:9090"]["__meta_algalon_instance_name"])
+}
+
+func TestInstanceDiscoveryGroupsByCluster(t *testing.T) {
+	t.Parallel()
+
+	compute := discovery.NewFakeCompute(
+		workerInstance("worker-1", "10.128.0.2", "training", false),
+		workerInstance("worker-2", "10.128.0.3", "inference", false),
+		workerInstance("worker-3", "10.128.0.4", "training", false),
+	)
+	d := newInstanceDiscoverer(t, compute, &fakeClock{now: time.Unix(0, 0)})
+
+	_, err := d.RunOnce(context.Background())
+	require.NoError(t, err)
+
+	groups, err := targets.ReadFile(d.Path)
+	require.NoError(t, err)
+	require.Len(t, groups, 3, "Each instance carries its own instance_name")
+
+	byCluster := map[string][]string{}
+	for _, group := range groups {
+		byCluster[group.Labels["cluster"]] = append(byCluster[group.Labels["cluster"]], group.Targets...)
+	}
+	assert.ElementsMatch(t, []string{"10.128.0.2:9090", "10.128.0.4:9090"}, byCluster["training"])
+	assert.Equal(t, []string{"10.128.0.3:9090"}, byCluster["inference"])
+}
+
+func TestInstanceDiscoveryPreemptibleChurn(t *testing.T) {
+	t.Parallel()
+
+	compute := discovery.NewFakeCompute(
+		workerInstance("worker-1", "10.128.0.2", "training", true),
+		workerInstance("worker-2", "10.128.0.3", "training", true),
+		workerInstance("worker-3", "10.128.0.4", "training", true),
+	)
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	d := newInstanceDiscoverer(t, compute, clock)
+	ctx := context.Background()
+
+	_, err := d.RunOnce(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"10.128.0.2:9090", "10.128.0.3:9090", "10.128.0.4:9090"}, fileTargets(t, d.Path))
+
+	// A preempted instance is reported as TERMINATED and dropped at once.
+	compute.SetStatus("worker-1", discovery.StatusTerminated)
+	// A deleted instance vanishes from the list and is kept for the grace period.
+	compute.Delete("worker-2")
+	clock.Advance(time.Minute)
+
+	changes, err := d.RunOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.128.0.2:9090"}, changes.Removed)
+	assert.Equal(t, []string{"10.128.0.3:9090"}, changes.Missing)
+	assert.ElementsMatch(t, []string{"10.128.0.3:9090", "10.128.0.4:9090"}, fileTargets(t, d.Path))
+
+	// The preempted instance is recreated under the same name and IP.
+	compute.SetStatus("worker-1", discovery.StatusRunning)
+	clock.Advance(5 * time.Minute)
+
+	changes, err = d.RunOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.128.0.2:9090"}, changes.Added)
+	assert.Equal(t, []string{"10.128.0.3:9090"}, changes.Removed, "Grace period should have expired")
+	assert.Empty(t, changes.Missing)
+	assert.ElementsMatch(t, []string{"10.128.0.2:9090", "10.128.0.4:9090"}, fileTargets(t, d.Path))
+}
+
+func TestInstanceDiscoveryKeepsFileOnAPIError(t *testing.T) {
+	t.Parallel()
+
+	compute := discovery.NewFakeCompute(workerInstance("worker-1", "10.128.0.2", "training", false))
+	d := newInstanceDiscoverer(t, compute, &fakeClock{now: time.Unix(0, 0)})
+	ctx := context.Background()
+
+	_, err := d.RunOnce(ctx)
+	require.NoError(t, err)
+	before, err := os.ReadFile(d.Path)
+	require.NoError(t, err)
+
+	compute.FailWith(errors.New("quota exceeded"))
+	_, err = d.RunOnce(ctx)
+	require.Error(t, err)
+
+	after, err := os.ReadFile(d.Path)
+	require.NoError(t, err)
+	assert.Equal(t, string(before), string(after), "A failed list call must not touch the target file")
+}
+
+func TestInstanceDiscoveryResumesFromExistingFile(t *testing.T) {
+	t.Parallel()
+
+	compute := discovery.NewFakeCompute(workerInstance("worker-1", "10.128.0.2", "training", false))
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	d := newInstanceDiscoverer(t, compute, clock)
+	require.NoError(t, targets.WriteFile(d.Path, []targets.Group{{
+		Targets: []string{"10.128.0.2:9090", "10.128.0.7:9090"},
+		Labels:  targets.DefaultLabels("training", "prod"),
+	}}))
+
+	changes, err := d.RunOnce(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, changes.Added, "Targets already in the file are not new")
+	assert.Equal(t, []string{"10.128.0.7:9090"}, changes.Missing, "Unknown targets get the grace period after a restart")
+
+	clock.Advance(5 * time.Minute)
+	changes, err = d.RunOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.128.0.7:9090"}, changes.Removed)
+	assert.Equal(t, []string{"10.128.0.2:9090"}, fileTargets(t, d.Path))
+}
+
+func TestInstanceDiscoveryDryRun(t *testing.T) {
+	t.Parallel()
+
+	compute := discovery.NewFakeCompute(workerInstance("worker-1", "10.128.0.2", "training", false))
+	d := newInstanceDiscoverer(t, compute, &fakeClock{now: time.Unix(0, 0)})
+	d.DryRun = true
+
+	changes, err := d.RunOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.128.0.2:9090"}, changes.Added)
+	assert.NoFileExists(t, d.Path)
+}
+
+func TestInstanceDiscoverySkipsRegisteredWorkers(t *testing.T) {
+	t.Parallel()
+
+	compute := discovery.NewFakeCompute(
+		workerInstance("worker-1", "10.128.0.2", "training", false),
+		workerInstance("worker-2", "10.128.0.3", "training", false),
+	)
+	d := newInstanceDiscoverer(t, compute, &fakeClock{now: time.Unix(0, 0)})
+	d.RegistryPath = filepath.Join(filepath.Dir(d.Path), targets.DefaultFileName)
+	ctx := context.Background()
+
+	// worker-1 registered itself through algalon-registry.
+	require.NoError(t, targets.WriteFile(d.RegistryPath, []targets.Group{
+		{Targets: []string{"10.128.0.2:9090"}, Labels: targets.DefaultLabels("training", "prod")},
+	}))
+
+	changes, err := d.RunOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, changes.Targets)
+	assert.Equal(t, []string{"10.128.0.3:9090"}, fileTargets(t, d.Path), "A registered worker is scraped through the registry file only")
+
+	// Once it deregisters, discovery lists it again.
+	require.NoError(t, targets.WriteFile(d.RegistryPath, []targets.Group{}))
+	changes, err = d.RunOnce(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, changes.Added)
+	assert.ElementsMatch(t, []string{"10.128.0.2:9090", "10.128.0.3:9090"}, fileTargets(t, d.Path))
+}
+
+func TestInstanceDiscoveryWaitsForTheTargetLock(t *testing.T) {
+	t.Parallel()
+
+	compute := discovery.NewFakeCompute(workerInstance("worker-1", "10.128.0.2", "training", false))
+	d := newInstanceDiscoverer(t, compute, &fakeClock{now: time.Unix(0, 0)})
+
+	unlock, err := targets.LockDir(filepath.Dir(d.Path))
+	require.NoError(t, err)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := d.RunOnce(context.Background())
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("discovery did not wait for the lock: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	assert.NoFileExists(t, d.Path)
+	unlock()
+	require.NoError(t, <-done)
+	assert.Equal(t, []string{"10.128.0.2:9090"}, fileTargets(t, d.Path))
+}
+
+func TestGCEClientListInstances(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu      sync.Mutex
+		filters []string
+		tokens  int
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metadata/project/project-id", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Google", r.Header.Get("Metadata-Flavor"))
+		fmt.Fprint(w, "algalon-test")
+	})
+	mux.HandleFunc("/metadata/instance/service-accounts/default/token", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		tokens++
+		mu.Unlock()
+		fmt.Fprint(w, `{"access_token":"secret","expires_in":3600,"token_type":"Bearer"}`)
+	})
+	mux.HandleFunc("/compute/projects/algalon-test/aggregated/instances", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		mu.Lock()
+		filters = append(filters, r.URL.Query().Get("filter"))
+		mu.Unlock()
+
+		if r.URL.Query().Get("pageToken") == "" {
+			fmt.Fprint(w, `{"items":{
+				"zones/us-central1-a":{"instances":[{"name":"worker-1","zone":"https://www.googleapis.com/compute/v1/projects/algalon-test/zones/us-central1-a","status":"RUNNING","labels":{"component":"algalon-worker","cluster":"training"},"networkInterfaces":[{"networkIP":"10.128.0.2"}],"scheduling":{"preemptible":true},"guestAccelerators":[{"acceleratorType":"https://www.googleapis.com/compute/v1/projects/algalon-test/zones/us-central1-a/acceleratorTypes/nvidia-tesla-t4","acceleratorCount":2}]}]},
+				"zones/us-central1-b":{"warning":{"code":"NO_RESULTS_ON_PAGE"}}
+			},"nextPageToken":"page-2"}`)
+			return
+		}
+		fmt.Fprint(w, `{"items":{
+			"zones/us-central1-b":{"instances":[{"name":"worker-2","zone":"zones/us-central1-b","status":"TERMINATED","labels":{"component":"algalon-worker","cluster":"training"},"networkInterfaces":[{"networkIP":"10.128.0.3"}],"scheduling":{"provisioningModel":"SPOT"}}]}
+		}}`)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	client := discovery.NewGCEClient("", "")
+	client.ComputeURL = server.URL + "/compute"
+	client.MetadataURL = server.URL + "/metadata"
+
+	instances, err := client.ListInstances(context.Background(), map[string]string{"component": "algalon-worker", "cluster": "training"})
+	require.NoError(t, err)
+	assert.Equal(t, []discovery.Instance{
+		{Name: "worker-1", Zone: "us-central1-a", Status: "RUNNING", InternalIP: "10.128.0.2", Preemptible: true, Labels: map[string]string{"component": "algalon-worker", "cluster": "training"}, GPUType: "nvidia-tesla-t4", GPUsPerInstance: 2},
+		{Name: "worker-2", Zone: "us-central1-b", Status: "TERMINATED", InternalIP: "10.128.0.3", Preemptible: true, Labels: map[string]string{"component": "algalon-worker", "cluster": "training"}},
+	}, instances)
+
+	_, err = client.ListInstances(context.Background(), map[string]string{"component": "algalon-worker", "cluster": "training"})
+	require.NoError(t, err)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 1, tokens, "The access token should be cached")
+	require.NotEmpty(t, filters)
+	assert.Equal(t, `labels.cluster = "training" AND labels.component = "algalon-worker"`, filters[0])
+}
+
+func TestGCEClientReportsAPIErrors(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metadata/instance/service-accounts/default/token", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"access_token":"secret","expires_in":3600}`)
+	})
+	mux.HandleFunc("/compute/projects/algalon-test/zones/us-central1-a/instances", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"code":403,"message":"Required 'compute.instances.list' permission"}}`, http.StatusForbidden)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	client := discovery.NewGCEClient("algalon-test", "us-central1-a")
+	client.ComputeURL = server.URL + "/compute"
+	client.MetadataURL = server.URL + "/metadata"
+
+	_, err := client.ListInstances(context.Background(), discovery.WorkerLabels)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "compute.instances.list")
+}
@@ -0,0 +1,255 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/appleparan/Algalon/pkg/cost"
+	"github.com/appleparan/Algalon/pkg/vmclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRangeSeries is one synthetic series; values[i] is the sample at the
+// i-th step of the queried range and NaN is a gap.
+type fakeRangeSeries struct {
+	labels map[string]string
+	values []float64
+}
+
+// fakeRangeServer answers query_range like VictoriaMetrics, returning each
+// series sampled at the requested steps.
+type fakeRangeServer struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	params []map[string]string
+}
+
+func newFakeRangeServer(t *testing.T, series ...fakeRangeSeries) *fakeRangeServer {
+	t.Helper()
+
+	vm := &fakeRangeServer{}
+	vm.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/query_range", r.URL.Path)
+		vm.mu.Lock()
+		vm.params = append(vm.params, map[string]string{
+			"query": r.FormValue("query"), "start": r.FormValue("start"), "end": r.FormValue("end"), "step": r.FormValue("step"),
+		})
+		vm.mu.Unlock()
+
+		start, err := strconv.ParseFloat(r.FormValue("start"), 64)
+		require.NoError(t, err)
+		step, err := strconv.ParseFloat(r.FormValue("step"), 64)
+		require.NoError(t, err)
+
+		result := make([]string, 0, len(series))
+		for _, s := range series {
+			metric, err := json.Marshal(s.labels)
+			require.NoError(t, err)
+			var points []string
+			for i, value := range s.values {
+				if !math.IsNaN(value) {
+					points = append(points, fmt.Sprintf(`[%g,"%g"]`, start+float64(i)*step, value))
+				}
+			}
+			result = append(result, fmt.Sprintf(`{"metric":%s,"values":[%s]}`, metric, strings.Join(points, ",")))
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"matrix","result":[%s]}}`, strings.Join(result, ","))
+	}))
+	t.Cleanup(vm.Close)
+
+	return vm
+}
+
+func gpuSeries(cluster, instance, gpu, gpuType string, values ...float64) fakeRangeSeries {
+	labels := map[string]string{"__name__": "all_smi_gpu_utilization", "cluster": cluster, "instance": instance, "gpu_index": gpu, "gpu_name": "NVIDIA GPU"}
+	if gpuType != "" {
+		labels["gpu_type"] = gpuType
+	}
+	return fakeRangeSeries{labels: labels, values: values}
+}
+
+// newTestCostReport reports four hours sampled hourly: a half-idle T4, a
+// busy T4, an idle V100 and an unlabelled GPU that was gone half the time.
+func newTestCostReport(t *testing.T) (*cost.Report, *fakeRangeServer) {
+	t.Helper()
+
+	gap := math.NaN()
+	vm := newFakeRangeServer(t,
+		gpuSeries("production", "10.128.0.2:9090", "0", "nvidia-tesla-t4", 0, 0, 50, 0),
+		gpuSeries("production", "10.128.0.2:9090", "1", "nvidia-tesla-t4", 90, 90, 90, 90),
+		gpuSeries("production", "10.128.0.3:9090", "0", "nvidia-tesla-v100", 1, 2, 3, 4.9),
+		gpuSeries("staging", "10.128.1.2:9090", "0", "", 0, gap, 0, gap),
+	)
+
+	reporter := &cost.Reporter{
+		Querier:   vmclient.NewClient(vm.URL),
+		Prices:    cost.DefaultPrices(),
+		Threshold: 5,
+		Step:      time.Hour,
+	}
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	report, err := reporter.Generate(context.Background(), start, start.Add(4*time.Hour))
+	require.NoError(t, err)
+	return report, vm
+}
+
+func TestCostReportIdleHours(t *testing.T) {
+	t.Parallel()
+
+	report, vm := newTestCostReport(t)
+
+	require.Len(t, vm.params, 1)
+	assert.Equal(t, map[string]string{"query": "all_smi_gpu_utilization", "start": "1772326800", "end": "1772337600", "step": "3600"}, vm.params[0],
+		"Every sample covers the step before it, so the first is one step after the start")
+
+	require.Len(t, report.Rows, 4)
+	v100 := report.Rows[0]
+	assert.Equal(t, "nvidia-tesla-v100", v100.GPUType, "The most expensive idle GPU comes first")
+	assert.Equal(t, 4.0, v100.IdleHours)
+	assert.Equal(t, 100.0, v100.IdlePercent)
+	assert.InDelta(t, 4*2.48, v100.IdleCost, 1e-9)
+
+	t4 := report.Rows[1]
+	assert.Equal(t, "0", t4.GPU)
+	assert.Equal(t, 4.0, t4.ObservedHours)
+	assert.Equal(t, 3.0, t4.IdleHours, "A sample at or above the threshold is busy")
+	assert.InDelta(t, 3*0.35, t4.IdleCost, 1e-9)
+
+	unlabelled := report.Rows[2]
+	assert.Equal(t, "staging", unlabelled.Cluster)
+	assert.Equal(t, 2.0, unlabelled.ObservedHours, "Gaps count as neither busy nor idle")
+	assert.Equal(t, 2.0, unlabelled.IdleHours)
+	assert.False(t, unlabelled.Priced)
+	assert.Zero(t, unlabelled.IdleCost)
+
+	assert.Zero(t, report.Rows[3].IdleHours)
+
+	assert.Equal(t, 9.0, report.IdleHours)
+	assert.InDelta(t, 4*2.48+3*0.35, report.IdleCost, 1e-9)
+	assert.Equal(t, []string{""}, report.Unpriced)
+	require.Len(t, report.ByType, 3)
+	t4s := report.ByType[1]
+	assert.Equal(t, "nvidia-tesla-t4", t4s.GPUType)
+	assert.Equal(t, 2, t4s.GPUs)
+	assert.Equal(t, 3.0, t4s.IdleHours)
+	assert.InDelta(t, 3*0.35, t4s.IdleCost, 1e-9)
+}
+
+func TestCostReportFormats(t *testing.T) {
+	t.Parallel()
+
+	report, _ := newTestCostReport(t)
+
+	var csv bytes.Buffer
+	require.NoError(t, cost.Write(&csv, report, cost.FormatCSV))
+	assert.Equal(t, `cluster,instance,gpu,gpu_name,gpu_type,observed_hours,idle_hours,idle_percent,price_per_hour,idle_cost
+production,10.128.0.3:9090,0,NVIDIA GPU,nvidia-tesla-v100,4.00,4.00,100.0,2.48,9.92
+production,10.128.0.2:9090,0,NVIDIA GPU,nvidia-tesla-t4,4.00,3.00,75.0,0.35,1.05
+staging,10.128.1.2:9090,0,NVIDIA GPU,,2.00,2.00,100.0,,
+production,10.128.0.2:9090,1,NVIDIA GPU,nvidia-tesla-t4,4.00,0.00,0.0,0.35,0.00
+`, csv.String())
+
+	var encoded bytes.Buffer
+	require.NoError(t, cost.Write(&encoded, report, cost.FormatJSON))
+	var decoded cost.Report
+	require.NoError(t, json.Unmarshal(encoded.Bytes(), &decoded))
+	assert.Equal(t, *report, decoded)
+	assert.Contains(t, encoded.String(), `"threshold_percent": 5`)
+	assert.Contains(t, encoded.String(), `"step": "1h"`)
+
+	var markdown bytes.Buffer
+	require.NoError(t, cost.Write(&markdown, report, cost.FormatMarkdown))
+	assert.Contains(t, markdown.String(), "- Window: 2026-03-01T00:00:00Z to 2026-03-01T04:00:00Z (step 1h)\n")
+	assert.Contains(t, markdown.String(), "- Total: 9.00 idle GPU hours, 10.97 USD\n")
+	assert.Contains(t, markdown.String(), "- Not priced: `(no gpu_type)`\n")
+	assert.Contains(t, markdown.String(), "| nvidia-tesla-t4 | 2 | 3.00 | 1.05 |\n")
+	assert.Contains(t, markdown.String(), "| staging | 10.128.1.2:9090 | 0 | (no gpu_type) | 2.00 | 2.00 | 100.0 | n/a |\n")
+
+	assert.Error(t, cost.Write(&markdown, report, "xlsx"))
+}
+
+func TestCostReportRejectsBadInput(t *testing.T) {
+	t.Parallel()
+
+	vm := newFakeRangeServer(t)
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	reporter := &cost.Reporter{Querier: vmclient.NewClient(vm.URL), Prices: cost.DefaultPrices()}
+	_, err := reporter.Generate(context.Background(), start, start)
+	assert.Error(t, err, "An empty window")
+
+	reporter.Prices = cost.Prices{Currency: "USD", PerHour: map[string]float64{"nvidia-tesla-t4": -1}}
+	_, err = reporter.Generate(context.Background(), start, start.Add(time.Hour))
+	assert.Error(t, err, "A negative price")
+	assert.Empty(t, vm.params, "Nothing is queried for invalid input")
+}
+
+func TestCostPriceTables(t *testing.T) {
+	t.Parallel()
+
+	shipped, err := cost.LoadPrices(filepath.Join("..", "..", "examples", "cost", "gpu-prices.yml"))
+	require.NoError(t, err)
+	assert.Equal(t, cost.DefaultPrices(), shipped, "The example price table matches the built-in one")
+
+	testCases := []struct {
+		name   string
+		data   string
+		errMsg string
+	}{
+		{name: "Custom Prices", data: "currency: EUR\nper_gpu_hour:\n  t4: 0.21\n  a100: 1.9\n"},
+		{name: "Missing Currency", data: "per_gpu_hour:\n  t4: 0.21\n", errMsg: "no currency"},
+		{name: "No Prices", data: "currency: USD\n", errMsg: "no per_gpu_hour"},
+		{name: "Negative Price", data: "currency: USD\nper_gpu_hour:\n  t4: -0.21\n", errMsg: "must not be negative"},
+		{name: "Unknown Field", data: "currency: USD\nprices:\n  t4: 0.21\n", errMsg: "field prices not found"},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "prices.yml")
+			require.NoError(t, os.WriteFile(path, []byte(tc.data), 0o644))
+
+			_, err := cost.LoadPrices(path)
+			if tc.errMsg == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.errMsg)
+		})
+	}
+}
+
+func TestVMClientQueryRangeErrors(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[]}}`)
+	}))
+	t.Cleanup(server.Close)
+	client := vmclient.NewClient(server.URL)
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	_, err := client.QueryRange(context.Background(), "up", start, start.Add(time.Hour), time.Minute)
+	assert.ErrorContains(t, err, "want a matrix")
+	_, err = client.QueryRange(context.Background(), "up", start, start.Add(time.Hour), 0)
+	assert.ErrorContains(t, err, "step must be positive")
+	_, err = client.QueryRange(context.Background(), "up", start.Add(time.Hour), start, time.Minute)
+	assert.ErrorContains(t, err, "before it starts")
+}
@@ -1,19 +1,21 @@
 package test
 
 import (
+	"fmt"
+	"sort"
 	"testing"
 
 	"github.com/gruntwork-io/terratest/modules/terraform"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"algalon-terraform-testsupport"
 )
 
 func TestNetworkModuleDefaults(t *testing.T) {
 	t.Parallel()
 
-	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "../../terraform/modules/network",
-		NoColor:      true,
-	})
+	terraformOptions := testsupport.PlanOptions(t, testsupport.NetworkModuleDir, nil)
 
 	// Test that the module initializes and validates correctly with defaults
 	terraform.Init(t, terraformOptions)
@@ -26,14 +28,10 @@ func TestNetworkModuleDefaults(t *testing.T) {
 func TestNetworkModuleBasicPlan(t *testing.T) {
 	t.Parallel()
 
-	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "../../terraform/modules/network",
-		NoColor:      true,
-		Vars: map[string]interface{}{
-			"network_name": "test-network",
-			"region":       "us-central1",
-			"subnet_cidr":  "10.2.0.0/16",
-		},
+	terraformOptions := testsupport.PlanOptions(t, testsupport.NetworkModuleDir, map[string]interface{}{
+		"network_name": "test-network",
+		"region":       "us-central1",
+		"subnet_cidr":  "10.2.0.0/16",
 	})
 
 	terraform.Init(t, terraformOptions)
@@ -47,36 +45,55 @@ func TestNetworkModuleCustomConfiguration(t *testing.T) {
 	t.Parallel()
 
 	testCases := []struct {
-		name         string
-		networkName  string
-		region       string
-		subnetCIDR   string
-		enableSSH    bool
-		enableExtVM  bool
+		name              string
+		networkName       string
+		region            string
+		subnetCIDR        string
+		grafanaAllowedIPs []string
+		enableSSH         bool
+		enableExtVM       bool
+		expectedRules     []string
+		expectError       bool
 	}{
 		{
-			name:        "Production Network",
-			networkName: "algalon-prod-network",
-			region:      "us-central1",
-			subnetCIDR:  "10.10.0.0/16",
-			enableSSH:   true,
-			enableExtVM: false,
+			name:              "Production Network",
+			networkName:       "algalon-prod-network",
+			region:            "us-central1",
+			subnetCIDR:        "10.10.0.0/16",
+			grafanaAllowedIPs: []string{"203.0.113.0/24"},
+			enableSSH:         true,
+			enableExtVM:       false,
+			expectedRules:     []string{"grafana", "internal", "metrics-internal", "ssh"},
 		},
 		{
-			name:        "Development Network",
-			networkName: "algalon-dev-network",
-			region:      "us-west1",
-			subnetCIDR:  "10.20.0.0/16",
-			enableSSH:   true,
-			enableExtVM: true,
+			name:              "Development Network",
+			networkName:       "algalon-dev-network",
+			region:            "us-west1",
+			subnetCIDR:        "10.20.0.0/16",
+			grafanaAllowedIPs: []string{"203.0.113.0/24", "198.51.100.7/32"},
+			enableSSH:         true,
+			enableExtVM:       true,
+			expectedRules:     []string{"grafana", "internal", "metrics-internal", "ssh", "victoria-metrics"},
 		},
 		{
-			name:        "Secure Network",
-			networkName: "algalon-secure-network",
-			region:      "us-east1",
-			subnetCIDR:  "10.30.0.0/16",
-			enableSSH:   false,
-			enableExtVM: false,
+			name:              "Secure Network",
+			networkName:       "algalon-secure-network",
+			region:            "us-east1",
+			subnetCIDR:        "10.30.0.0/16",
+			grafanaAllowedIPs: []string{"35.235.240.0/20"},
+			enableSSH:         false,
+			enableExtVM:       false,
+			expectedRules:     []string{"grafana", "internal", "metrics-internal"},
+		},
+		{
+			name:              "Grafana Open To The World",
+			networkName:       "algalon-open-network",
+			region:            "us-central1",
+			subnetCIDR:        "10.40.0.0/16",
+			grafanaAllowedIPs: []string{"0.0.0.0/0"},
+			enableSSH:         false,
+			enableExtVM:       false,
+			expectError:       true,
 		},
 	}
 
@@ -85,23 +102,52 @@ func TestNetworkModuleCustomConfiguration(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
 
-			terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-				TerraformDir: "../../terraform/modules/network",
-				NoColor:      true,
-				Vars: map[string]interface{}{
-					"network_name":                    tc.networkName,
-					"region":                          tc.region,
-					"subnet_cidr":                     tc.subnetCIDR,
-					"enable_ssh_access":               tc.enableSSH,
-					"enable_external_victoria_metrics": tc.enableExtVM,
-				},
-			})
-
-			terraform.Init(t, terraformOptions)
-
-			// Test planning (includes validation)
-			_, planErr := terraform.PlanE(t, terraformOptions)
-			assert.NoError(t, planErr, "Network module should plan successfully for %s", tc.name)
+			vars := map[string]interface{}{
+				"network_name":                     tc.networkName,
+				"region":                           tc.region,
+				"subnet_cidr":                      tc.subnetCIDR,
+				"grafana_allowed_ips":              tc.grafanaAllowedIPs,
+				"enable_ssh_access":                tc.enableSSH,
+				"enable_external_victoria_metrics": tc.enableExtVM,
+			}
+
+			if tc.expectError {
+				terraformOptions := testsupport.PlanOptions(t, testsupport.NetworkModuleDir, vars)
+				terraform.Init(t, terraformOptions)
+				_, planErr := terraform.PlanE(t, terraformOptions)
+				assert.Error(t, planErr, "Network module should reject %v", tc.grafanaAllowedIPs)
+				return
+			}
+
+			plan := testsupport.PlanAndShow(t, testsupport.NetworkModuleDir, vars)
+
+			var expectedNames []string
+			for _, rule := range tc.expectedRules {
+				expectedNames = append(expectedNames, fmt.Sprintf("%s-%s", tc.networkName, rule))
+			}
+			rules := testsupport.PlannedResources(plan, "google_compute_firewall")
+			names := testsupport.PlannedNames(rules)
+			sort.Strings(names)
+			assert.Equal(t, expectedNames, names)
+
+			for _, rule := range rules {
+				switch rule.AttributeValues["name"] {
+				case tc.networkName + "-grafana":
+					assert.ElementsMatch(t, tc.grafanaAllowedIPs, testsupport.StringList(rule.AttributeValues["source_ranges"]))
+					assert.Equal(t, []string{"algalon-monitoring"}, testsupport.StringList(rule.AttributeValues["target_tags"]))
+					allow := testsupport.NestedBlocks(rule, "allow")
+					require.Len(t, allow, 1)
+					assert.Equal(t, "tcp", allow[0]["protocol"])
+					assert.Equal(t, []string{"3000"}, testsupport.StringList(allow[0]["ports"]))
+				case tc.networkName + "-internal":
+					assert.Equal(t, []string{tc.subnetCIDR}, testsupport.StringList(rule.AttributeValues["source_ranges"]))
+				}
+			}
+
+			subnets := testsupport.PlannedResources(plan, "google_compute_subnetwork")
+			require.Len(t, subnets, 1)
+			assert.Equal(t, tc.subnetCIDR, subnets[0].AttributeValues["ip_cidr_range"])
+			assert.Equal(t, tc.region, subnets[0].AttributeValues["region"])
 		})
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,284 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appleparan/Algalon/pkg/notify"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"algalon-terraform-testsupport"
+)
+
+// notifyFakes are the local fakes of every sink kind, each behind the sink
+// that talks to it.
+type notifyFakes struct {
+	webhook *testsupport.FakeWebhook
+	chat    *testsupport.FakeChat
+	smtp    *testsupport.FakeSMTP
+	sinks   []notify.Sink
+}
+
+func newNotifyFakes(t *testing.T) *notifyFakes {
+	t.Helper()
+
+	f := &notifyFakes{
+		webhook: testsupport.NewFakeWebhook(t),
+		chat:    testsupport.NewFakeChat(t),
+		smtp:    testsupport.NewFakeSMTP(t),
+	}
+	f.sinks = []notify.Sink{
+		&notify.WebhookSink{URL: f.webhook.URL},
+		&notify.ChatSink{URL: f.chat.URL},
+		&notify.SMTPSink{Addr: f.smtp.Addr(), From: "algalon@example.com", To: []string{"oncall@example.com"}, Username: "algalon", Password: "secret"},
+	}
+	return f
+}
+
+// messages decodes the notifications the webhook fake received.
+func (f *notifyFakes) messages(t *testing.T) []notify.Message {
+	t.Helper()
+
+	var messages []notify.Message
+	for _, body := range f.webhook.Bodies() {
+		var message notify.Message
+		require.NoError(t, json.Unmarshal(body, &message))
+		messages = append(messages, message)
+	}
+	return messages
+}
+
+func newTestGateway(sinks []notify.Sink, clock *fakeClock) *notify.Gateway {
+	g := notify.New(sinks...)
+	g.RepeatInterval = time.Hour
+	g.Now = clock.Now
+	return g
+}
+
+func testAlert(name, cluster, instance, status string) notify.Alert {
+	return notify.Alert{
+		Status:      status,
+		Labels:      map[string]string{"alertname": name, "cluster": cluster, "instance": instance, "severity": "critical"},
+		Annotations: map[string]string{"summary": name + " on " + instance},
+		StartsAt:    time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestNotifyGatewayGroupsByClusterAndInstance(t *testing.T) {
+	t.Parallel()
+
+	fakes := newNotifyFakes(t)
+	clock := &fakeClock{now: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)}
+	g := newTestGateway(fakes.sinks, clock)
+
+	changed := g.Receive([]notify.Alert{
+		testAlert("AlgalonGPUTemperatureHigh", "production", "10.128.0.2:9090", notify.StatusFiring),
+		testAlert("AlgalonGPUMemorySaturated", "production", "10.128.0.2:9090", notify.StatusFiring),
+		testAlert("AlgalonTargetDown", "production", "10.128.0.3:9090", notify.StatusFiring),
+		testAlert("AlgalonTargetDown", "staging", "10.128.0.3:9090", notify.StatusFiring),
+	})
+	assert.Equal(t, 4, changed)
+	require.NoError(t, g.Flush(context.Background()))
+
+	messages := fakes.messages(t)
+	require.Len(t, messages, 3, "One notification per cluster and instance")
+	byGroup := map[string]notify.Message{}
+	for _, message := range messages {
+		byGroup[message.GroupKey] = message
+		assert.Equal(t, notify.Receiver, message.Receiver)
+	}
+	worker := byGroup["production/10.128.0.2:9090"]
+	require.Len(t, worker.Alerts, 2)
+	assert.Equal(t, "AlgalonGPUMemorySaturated", worker.Alerts[0].Name())
+	assert.Equal(t, map[string]string{"cluster": "production", "instance": "10.128.0.2:9090"}, worker.GroupLabels)
+	assert.Equal(t, "critical", worker.CommonLabels["severity"])
+	assert.NotContains(t, worker.CommonLabels, "alertname")
+	assert.Contains(t, byGroup, "staging/10.128.0.3:9090", "The same instance in another cluster is a separate group")
+
+	texts := fakes.chat.Texts()
+	require.Len(t, texts, 3)
+	mails := fakes.smtp.Mails()
+	require.Len(t, mails, 3)
+	assert.Equal(t, "algalon@example.com", mails[0].From)
+	assert.Equal(t, []string{"oncall@example.com"}, mails[0].To)
+
+	assert.Len(t, g.Groups(), 3)
+}
+
+func TestNotifyGatewayDeduplicates(t *testing.T) {
+	t.Parallel()
+
+	fakes := newNotifyFakes(t)
+	clock := &fakeClock{now: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)}
+	g := newTestGateway(fakes.sinks, clock)
+
+	alert := testAlert("AlgalonTargetDown", "production", "10.128.0.3:9090", notify.StatusFiring)
+	g.Receive([]notify.Alert{alert, alert})
+	require.NoError(t, g.Flush(context.Background()))
+	require.Len(t, fakes.messages(t), 1)
+	assert.Len(t, fakes.messages(t)[0].Alerts, 1, "A duplicate in one batch is one alert")
+
+	// vmalert resends firing alerts on every evaluation.
+	for i := 0; i < 5; i++ {
+		clock.Advance(time.Minute)
+		assert.Zero(t, g.Receive([]notify.Alert{alert}))
+		require.NoError(t, g.Flush(context.Background()))
+	}
+	assert.Len(t, fakes.messages(t), 1, "Unchanged alerts are not sent again")
+	assert.Len(t, fakes.chat.Texts(), 1)
+	assert.Len(t, fakes.smtp.Mails(), 1)
+
+	clock.Advance(time.Hour)
+	require.NoError(t, g.Flush(context.Background()))
+	assert.Len(t, fakes.messages(t), 2, "A group still firing is resent after the repeat interval")
+
+	// A new alert in the group sends the whole group again.
+	clock.Advance(time.Minute)
+	g.Receive([]notify.Alert{testAlert("AlgalonGPUXIDError", "production", "10.128.0.3:9090", notify.StatusFiring)})
+	require.NoError(t, g.Flush(context.Background()))
+	messages := fakes.messages(t)
+	require.Len(t, messages, 3)
+	assert.Len(t, messages[2].Alerts, 2)
+}
+
+func TestNotifyGatewayResolves(t *testing.T) {
+	t.Parallel()
+
+	fakes := newNotifyFakes(t)
+	clock := &fakeClock{now: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)}
+	g := newTestGateway(fakes.sinks, clock)
+
+	g.Receive([]notify.Alert{
+		testAlert("AlgalonGPUTemperatureHigh", "production", "10.128.0.2:9090", notify.StatusFiring),
+		testAlert("AlgalonGPUMemorySaturated", "production", "10.128.0.2:9090", notify.StatusFiring),
+	})
+	require.NoError(t, g.Flush(context.Background()))
+
+	clock.Advance(time.Minute)
+	assert.Equal(t, 1, g.Receive([]notify.Alert{testAlert("AlgalonGPUTemperatureHigh", "production", "10.128.0.2:9090", notify.StatusResolved)}))
+	require.NoError(t, g.Flush(context.Background()))
+
+	messages := fakes.messages(t)
+	require.Len(t, messages, 2)
+	assert.Equal(t, notify.StatusFiring, messages[1].Status, "The group fires while any alert in it does")
+	assert.Equal(t, notify.StatusResolved, messages[1].Alerts[1].Status)
+	assert.Contains(t, fakes.chat.Texts()[1], "[resolved] AlgalonGPUTemperatureHigh")
+
+	clock.Advance(time.Minute)
+	g.Receive([]notify.Alert{testAlert("AlgalonGPUMemorySaturated", "production", "10.128.0.2:9090", notify.StatusResolved)})
+	require.NoError(t, g.Flush(context.Background()))
+
+	messages = fakes.messages(t)
+	require.Len(t, messages, 3)
+	assert.Equal(t, notify.StatusResolved, messages[2].Status)
+	assert.Len(t, messages[2].Alerts, 1, "Resolved alerts are sent once")
+	assert.Contains(t, fakes.smtp.Mails()[2].Data, "Subject: [RESOLVED:1] production/10.128.0.2:9090")
+	assert.Empty(t, g.Groups(), "A fully resolved group is forgotten")
+
+	// A resolved alert that was never seen firing is not sent.
+	g.Receive([]notify.Alert{testAlert("AlgalonTargetDown", "production", "10.128.0.9:9090", notify.StatusResolved)})
+	require.NoError(t, g.Flush(context.Background()))
+	assert.Len(t, fakes.messages(t), 3)
+}
+
+func TestNotifyGatewayRetriesFailedSinks(t *testing.T) {
+	t.Parallel()
+
+	fakes := newNotifyFakes(t)
+	clock := &fakeClock{now: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)}
+	g := newTestGateway(fakes.sinks, clock)
+
+	fakes.chat.FailWith(http.StatusInternalServerError)
+	fakes.smtp.Reject(true)
+	g.Receive([]notify.Alert{testAlert("AlgalonTargetDown", "production", "10.128.0.3:9090", notify.StatusFiring)})
+
+	err := g.Flush(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "chat")
+	assert.Contains(t, err.Error(), "smtp")
+	assert.Len(t, fakes.messages(t), 1)
+
+	fakes.chat.FailWith(0)
+	fakes.smtp.Reject(false)
+	clock.Advance(30 * time.Second)
+	require.NoError(t, g.Flush(context.Background()))
+	assert.Len(t, fakes.chat.Texts(), 1, "The failed sink is retried")
+	assert.Len(t, fakes.smtp.Mails(), 1)
+	assert.Len(t, fakes.messages(t), 1, "Sinks that succeeded are not sent the group again")
+}
+
+func TestNotifyHandlerAcceptsVMAlert(t *testing.T) {
+	t.Parallel()
+
+	fakes := newNotifyFakes(t)
+	clock := &fakeClock{now: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)}
+	g := newTestGateway(fakes.sinks[:1], clock)
+	server := httptest.NewServer(notify.NewHandler(g, log.New(io.Discard, "", 0)))
+	t.Cleanup(server.Close)
+
+	// vmalert posts alerts without a status; a firing alert carries an
+	// endsAt in the future.
+	body := `[{"labels": {"alertname": "AlgalonGPUXIDError", "cluster": "production", "instance": "10.128.0.4:9400", "gpu": "0"},
+	  "annotations": {"summary": "XID 79"}, "startsAt": "2026-03-01T11:59:00Z", "endsAt": "2026-03-01T12:04:00Z"}]`
+	resp, err := http.Post(server.URL+"/api/v2/alerts", "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	require.NoError(t, g.Flush(context.Background()))
+	messages := fakes.messages(t)
+	require.Len(t, messages, 1)
+	assert.Equal(t, notify.StatusFiring, messages[0].Status)
+
+	// vmalert stops sending an alert once it resolves; it resolves when its
+	// endsAt passes.
+	clock.Advance(5 * time.Minute)
+	require.NoError(t, g.Flush(context.Background()))
+	messages = fakes.messages(t)
+	require.Len(t, messages, 2)
+	assert.Equal(t, notify.StatusResolved, messages[1].Status)
+}
+
+func TestNotifyHandlerAcceptsAlertmanagerWebhook(t *testing.T) {
+	t.Parallel()
+
+	clock := &fakeClock{now: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)}
+	g := newTestGateway(nil, clock)
+	server := httptest.NewServer(notify.NewHandler(g, log.New(io.Discard, "", 0)))
+	t.Cleanup(server.Close)
+
+	testCases := []struct {
+		name   string
+		path   string
+		body   string
+		status int
+	}{
+		{
+			name:   "Webhook Payload",
+			path:   "/webhook",
+			body:   `{"version": "4", "status": "firing", "alerts": [{"status": "firing", "labels": {"alertname": "AlgalonTargetDown", "cluster": "production", "instance": "10.128.0.3:9090"}}]}`,
+			status: http.StatusOK,
+		},
+		{name: "Malformed Payload", path: "/webhook", body: `{"alerts": [`, status: http.StatusBadRequest},
+		{name: "Missing Alertname", path: "/api/v2/alerts", body: `[{"labels": {"instance": "10.128.0.3:9090"}}]`, status: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		resp, err := http.Post(server.URL+tc.path, "application/json", strings.NewReader(tc.body))
+		require.NoError(t, err, tc.name)
+		resp.Body.Close()
+		assert.Equal(t, tc.status, resp.StatusCode, tc.name)
+	}
+
+	groups := g.Groups()
+	require.Len(t, groups, 1)
+	assert.Equal(t, notify.Key{Cluster: "production", Instance: "10.128.0.3:9090"}, groups[0].Key)
+}
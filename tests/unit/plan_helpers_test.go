@@ -0,0 +1,92 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"algalon-terraform-testsupport"
+)
+
+// workerPlanJSON is a trimmed `terraform show -json` of the worker module
+// with total_gpu_count = 2, so the plan helpers can be checked without a
+// Terraform binary.
+const workerPlanJSON = `{
+  "format_version": "1.2",
+  "terraform_version": "1.9.0",
+  "planned_values": {
+    "root_module": {
+      "child_modules": [{
+        "address": "module.workers[0]",
+        "resources": [
+          {
+            "address": "module.workers[0].google_compute_instance.algalon_worker[1]",
+            "mode": "managed",
+            "type": "google_compute_instance",
+            "name": "algalon_worker",
+            "index": 1,
+            "values": {
+              "name": "algalon-worker-2",
+              "labels": {"component": "algalon-worker", "worker_index": "2"},
+              "guest_accelerator": [{"count": 1, "type": "nvidia-tesla-t4"}],
+              "scheduling": [{"preemptible": true, "on_host_maintenance": "TERMINATE"}],
+              "tags": ["algalon-worker"]
+            }
+          },
+          {
+            "address": "module.workers[0].google_compute_instance.algalon_worker[0]",
+            "mode": "managed",
+            "type": "google_compute_instance",
+            "name": "algalon_worker",
+            "index": 0,
+            "values": {
+              "name": "algalon-worker-1",
+              "labels": {"component": "algalon-worker", "worker_index": "1"},
+              "guest_accelerator": [{"count": 1, "type": "nvidia-tesla-t4"}],
+              "scheduling": [{"preemptible": true, "on_host_maintenance": "TERMINATE"}],
+              "tags": ["algalon-worker"]
+            }
+          }
+        ]
+      }],
+      "resources": [
+        {
+          "address": "google_compute_firewall.algalon_grafana",
+          "mode": "managed",
+          "type": "google_compute_firewall",
+          "name": "algalon_grafana",
+          "values": {"name": "algalon-network-grafana", "source_ranges": ["203.0.113.0/24"]}
+        }
+      ]
+    }
+  }
+}`
+
+func TestPlanHelpers(t *testing.T) {
+	t.Parallel()
+
+	plan, err := terraform.ParsePlanJSON(workerPlanJSON)
+	require.NoError(t, err)
+
+	// Resources from child modules are found and sorted by address
+	workers := testsupport.PlannedResources(plan, "google_compute_instance")
+	require.Len(t, workers, 2)
+	assert.Equal(t, []string{"algalon-worker-1", "algalon-worker-2"}, testsupport.PlannedNames(workers))
+
+	accelerators := testsupport.NestedBlocks(workers[0], "guest_accelerator")
+	require.Len(t, accelerators, 1)
+	assert.Equal(t, "nvidia-tesla-t4", accelerators[0]["type"])
+	assert.EqualValues(t, 1, accelerators[0]["count"])
+	assert.Empty(t, testsupport.NestedBlocks(workers[0], "attached_disk"))
+
+	assert.Equal(t, map[string]string{"component": "algalon-worker", "worker_index": "1"}, testsupport.StringMap(workers[0].AttributeValues["labels"]))
+	assert.Equal(t, []string{"algalon-worker"}, testsupport.StringList(workers[0].AttributeValues["tags"]))
+
+	rules := testsupport.PlannedResources(plan, "google_compute_firewall")
+	require.Len(t, rules, 1)
+	assert.Equal(t, []string{"203.0.113.0/24"}, testsupport.StringList(rules[0].AttributeValues["source_ranges"]))
+
+	assert.Empty(t, testsupport.PlannedResources(plan, "google_compute_address"))
+}
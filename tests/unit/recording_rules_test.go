@@ -0,0 +1,285 @@
+package test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appleparan/Algalon/pkg/catalog"
+	"github.com/appleparan/Algalon/pkg/rules"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/promql/promqltest"
+	promrules "github.com/prometheus/prometheus/rules"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordedSeries evaluates the recording groups once a minute over the
+// synthetic series in load, in order and writing the results back the way
+// vmalert does with remote write, so later rules read earlier ones. It
+// returns the value of each recorded series after the given number of
+// minutes, by record and label set.
+func recordedSeries(t *testing.T, groups []rules.Group, load string, minutes int) map[string]map[string]float64 {
+	t.Helper()
+
+	storage := promqltest.LoadedStorage(t, "load 1m\n"+strings.TrimSpace(load))
+	t.Cleanup(func() { storage.Close() })
+	engine := promqltest.NewTestEngine(t, false, 0, promqltest.DefaultMaxSamplesPerQuery)
+	query := promrules.EngineQueryFunc(engine, storage)
+	ctx := context.Background()
+
+	var recording []*promrules.RecordingRule
+	for _, group := range groups {
+		for _, rule := range group.Rules {
+			expr, err := parser.ParseExpr(rule.Expr)
+			require.NoError(t, err)
+			recording = append(recording, promrules.NewRecordingRule(rule.Record, expr, labels.FromMap(rule.Labels)))
+		}
+	}
+
+	series := map[string]map[string]float64{}
+	for minute := 0; minute <= minutes; minute++ {
+		ts := time.Unix(0, 0).Add(time.Duration(minute) * time.Minute)
+		for _, rule := range recording {
+			vector, err := rule.Eval(ctx, 0, ts, query, nil, 0)
+			require.NoError(t, err)
+
+			app := storage.Appender(ctx)
+			for _, sample := range vector {
+				_, err := app.Append(0, sample.Metric, ts.UnixMilli(), sample.F)
+				require.NoError(t, err)
+				if minute == minutes {
+					if series[rule.Name()] == nil {
+						series[rule.Name()] = map[string]float64{}
+					}
+					series[rule.Name()][sample.Metric.DropMetricName().String()] = sample.F
+				}
+			}
+			require.NoError(t, app.Commit())
+		}
+	}
+	return series
+}
+
+// gib is a GiB in bytes, as all-smi reports GPU memory.
+const gib = 1 << 30
+
+func TestRecordingRulesOnSyntheticSeries(t *testing.T) {
+	t.Parallel()
+
+	// Cluster a: w1 runs all-smi with two GPUs, w2 dcgm-exporter with one
+	// GPU of 40 GiB (FB fields in MiB), and w3 both exporters for the same
+	// GPU. Cluster b: one all-smi GPU on w4.
+	load := `
+all_smi_gpu_utilization{cluster="a", instance="w1:9090", gpu_index="0"} 80x10
+all_smi_gpu_utilization{cluster="a", instance="w1:9090", gpu_index="1"} 40x10
+all_smi_gpu_memory_used_bytes{cluster="a", instance="w1:9090", gpu_index="0"} 64424509440x10
+all_smi_gpu_memory_total_bytes{cluster="a", instance="w1:9090", gpu_index="0"} 85899345920x10
+all_smi_gpu_memory_used_bytes{cluster="a", instance="w1:9090", gpu_index="1"} 21474836480x10
+all_smi_gpu_memory_total_bytes{cluster="a", instance="w1:9090", gpu_index="1"} 85899345920x10
+DCGM_FI_DEV_GPU_UTIL{cluster="a", instance="w2:9400", gpu="0"} 100x10
+DCGM_FI_DEV_FB_USED{cluster="a", instance="w2:9400", gpu="0"} 30720x10
+DCGM_FI_DEV_FB_FREE{cluster="a", instance="w2:9400", gpu="0"} 10240x10
+all_smi_gpu_utilization{cluster="a", instance="w3:9090", gpu_index="0"} 60x10
+all_smi_gpu_memory_used_bytes{cluster="a", instance="w3:9090", gpu_index="0"} 0x10
+all_smi_gpu_memory_total_bytes{cluster="a", instance="w3:9090", gpu_index="0"} 85899345920x10
+DCGM_FI_DEV_GPU_UTIL{cluster="a", instance="w3:9400", gpu="0"} 61x10
+DCGM_FI_DEV_FB_USED{cluster="a", instance="w3:9400", gpu="0"} 0x10
+DCGM_FI_DEV_FB_FREE{cluster="a", instance="w3:9400", gpu="0"} 81920x10
+all_smi_gpu_utilization{cluster="b", instance="w4:9090", gpu_index="0"} 10x10
+all_smi_gpu_memory_used_bytes{cluster="b", instance="w4:9090", gpu_index="0"} 8589934592x10
+all_smi_gpu_memory_total_bytes{cluster="b", instance="w4:9090", gpu_index="0"} 85899345920x10
+`
+	series := recordedSeries(t, rules.RecordingGroups(), load, 5)
+
+	testCases := []struct {
+		name   string
+		record string
+		want   map[string]float64
+	}{
+		{
+			name:   "GPU Utilization Prefers All-Smi",
+			record: rules.RecordGPUUtilization,
+			want: map[string]float64{
+				`{cluster="a", gpu_index="0", host="w1", instance="w1:9090"}`: 80,
+				`{cluster="a", gpu_index="1", host="w1", instance="w1:9090"}`: 40,
+				`{cluster="a", gpu="0", host="w2", instance="w2:9400"}`:       100,
+				`{cluster="a", gpu_index="0", host="w3", instance="w3:9090"}`: 60,
+				`{cluster="b", gpu_index="0", host="w4", instance="w4:9090"}`: 10,
+			},
+		},
+		{
+			name:   "DCGM Memory In Bytes",
+			record: rules.RecordGPUMemoryTotal,
+			want: map[string]float64{
+				`{cluster="a", gpu_index="0", host="w1", instance="w1:9090"}`: 80 * gib,
+				`{cluster="a", gpu_index="1", host="w1", instance="w1:9090"}`: 80 * gib,
+				`{cluster="a", gpu="0", host="w2", instance="w2:9400"}`:       40 * gib,
+				`{cluster="a", gpu_index="0", host="w3", instance="w3:9090"}`: 80 * gib,
+				`{cluster="b", gpu_index="0", host="w4", instance="w4:9090"}`: 80 * gib,
+			},
+		},
+		{
+			name:   "Instance Utilization Average",
+			record: rules.RecordInstanceUtilizationAvg,
+			want: map[string]float64{
+				`{cluster="a", instance="w1:9090"}`: 60,
+				`{cluster="a", instance="w2:9400"}`: 100,
+				`{cluster="a", instance="w3:9090"}`: 60,
+				`{cluster="b", instance="w4:9090"}`: 10,
+			},
+		},
+		{
+			name:   "Instance Memory Ratio",
+			record: rules.RecordInstanceMemoryUsedRatio,
+			want: map[string]float64{
+				`{cluster="a", instance="w1:9090"}`: 0.5,
+				`{cluster="a", instance="w2:9400"}`: 0.75,
+				`{cluster="a", instance="w3:9090"}`: 0,
+				`{cluster="b", instance="w4:9090"}`: 0.1,
+			},
+		},
+		{
+			name:   "Instance Memory Max Ratio",
+			record: rules.RecordInstanceMemoryUsedMaxRatio,
+			want: map[string]float64{
+				`{cluster="a", instance="w1:9090"}`: 0.75,
+				`{cluster="a", instance="w2:9400"}`: 0.75,
+				`{cluster="a", instance="w3:9090"}`: 0,
+				`{cluster="b", instance="w4:9090"}`: 0.1,
+			},
+		},
+		{
+			name:   "Cluster Utilization Average",
+			record: rules.RecordClusterUtilizationAvg,
+			want:   map[string]float64{`{cluster="a"}`: 70, `{cluster="b"}`: 10},
+		},
+		{
+			// 40, 60, 80 and 100 interpolated at rank 0.95 * 3.
+			name:   "Cluster Utilization P95",
+			record: rules.RecordClusterUtilizationP95,
+			want:   map[string]float64{`{cluster="a"}`: 97, `{cluster="b"}`: 10},
+		},
+		{
+			// (60 + 20 + 30 + 0) / (80 + 80 + 40 + 80) GiB.
+			name:   "Cluster Memory Ratio",
+			record: rules.RecordClusterMemoryUsedRatio,
+			want:   map[string]float64{`{cluster="a"}`: 110.0 / 280, `{cluster="b"}`: 0.1},
+		},
+		{
+			name:   "Cluster Memory Max Ratio",
+			record: rules.RecordClusterMemoryUsedMaxRatio,
+			want:   map[string]float64{`{cluster="a"}`: 0.75, `{cluster="b"}`: 0.1},
+		},
+		{
+			name:   "Cluster GPU Count",
+			record: rules.RecordClusterGPUs,
+			want:   map[string]float64{`{cluster="a"}`: 4, `{cluster="b"}`: 1},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := series[tc.record]
+			require.Len(t, got, len(tc.want))
+			for metric, want := range tc.want {
+				require.Contains(t, got, metric)
+				assert.InDelta(t, want, got[metric], 1e-9, metric)
+			}
+		})
+	}
+}
+
+func TestRecordingRulesEdgeCases(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		record  string
+		load    string
+		minutes int
+		want    map[string]float64
+	}{
+		{
+			name:    "Zero Total Memory Has No Ratio",
+			record:  rules.RecordInstanceMemoryUsedRatio,
+			load:    `all_smi_gpu_memory_used_bytes{cluster="a", instance="w1:9090"} 0x5` + "\n" + `all_smi_gpu_memory_total_bytes{cluster="a", instance="w1:9090"} 0x5`,
+			minutes: 2,
+			want:    map[string]float64{},
+		},
+		{
+			name:    "DCGM Without Port",
+			record:  rules.RecordGPUMemoryUsedRatio,
+			load:    `DCGM_FI_DEV_FB_USED{cluster="a", instance="w1"} 1024x5` + "\n" + `DCGM_FI_DEV_FB_FREE{cluster="a", instance="w1"} 3072x5`,
+			minutes: 2,
+			want:    map[string]float64{`{cluster="a", host="w1", instance="w1"}`: 0.25},
+		},
+		{
+			// The last hour of a ramp from 0 to 100, one sample a minute.
+			name:    "Instance Utilization P95 Over One Hour",
+			record:  rules.RecordInstanceUtilizationP95,
+			load:    `all_smi_gpu_utilization{cluster="a", instance="w1:9090"} 0+1x100`,
+			minutes: 100,
+			want:    map[string]float64{`{cluster="a", instance="w1:9090"}`: 97.05},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := recordedSeries(t, rules.RecordingGroups(), tc.load, tc.minutes)[tc.record]
+			require.Len(t, got, len(tc.want))
+			for metric, want := range tc.want {
+				require.Contains(t, got, metric)
+				assert.InDelta(t, want, got[metric], 1e-9, metric)
+			}
+		})
+	}
+}
+
+func TestRecordingRulesValidate(t *testing.T) {
+	t.Parallel()
+
+	c := catalog.Default()
+	require.NoError(t, rules.Validate(rules.RecordingGroups(), c))
+	require.NoError(t, rules.Validate(append(rules.AlertGroups(rules.DefaultThresholds()), rules.RecordingGroups()...), c),
+		"The packs load into one vmalert")
+
+	err := rules.Validate(rules.RecordingGroups()[1:], c)
+	require.Error(t, err, "The instance and cluster groups read the gpu-level series")
+	assert.Contains(t, err.Error(), rules.RecordGPUUtilization)
+}
+
+func TestRecordingRulesRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "rules", rules.DefaultRecordingFile)
+	groups := rules.RecordingGroups()
+	require.NoError(t, rules.WriteFile(path, groups))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	parsed, err := rules.Parse(data)
+	require.NoError(t, err)
+	assert.Equal(t, groups, parsed)
+}
+
+func TestShippedRecordingRulesMatchDefaults(t *testing.T) {
+	t.Parallel()
+
+	shipped, err := os.ReadFile(filepath.Join("..", "..", "algalon_host", "rules", rules.DefaultRecordingFile))
+	require.NoError(t, err)
+
+	want, err := rules.Render(rules.DefaultRecordingFile, rules.RecordingGroups())
+	require.NoError(t, err)
+	assert.Equal(t, string(want), string(shipped), "Regenerate with: algalonctl rules generate")
+}
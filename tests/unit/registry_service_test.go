@@ -0,0 +1,235 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/appleparan/Algalon/pkg/registry"
+	"github.com/appleparan/Algalon/pkg/targets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRegistryServer(t *testing.T, path string) *httptest.Server {
+	t.Helper()
+
+	store, err := registry.NewStore(path, targets.DefaultLabels("production", "gpu-cluster"))
+	require.NoError(t, err)
+
+	server := httptest.NewServer(registry.NewHandler(store, log.New(io.Discard, "", 0)))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func postWorker(t *testing.T, serverURL string, worker registry.Worker) *http.Response {
+	t.Helper()
+
+	body, err := json.Marshal(worker)
+	require.NoError(t, err)
+
+	resp, err := http.Post(serverURL+"/workers", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
+
+func deleteWorker(t *testing.T, serverURL, target string) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodDelete, serverURL+"/workers/"+target, nil)
+	require.NoError(t, err)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
+
+func listWorkers(t *testing.T, serverURL string) []registry.Worker {
+	t.Helper()
+
+	resp, err := http.Get(serverURL + "/workers")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var workers []registry.Worker
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&workers))
+
+	return workers
+}
+
+func TestRegistryRegisterAndDeregister(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "all-smi-targets.yml")
+	server := newRegistryServer(t, path)
+
+	resp := postWorker(t, server.URL, registry.Worker{Target: "10.128.0.2:9090", Labels: map[string]string{"gpu_type": "nvidia-tesla-t4"}})
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp = postWorker(t, server.URL, registry.Worker{Target: "10.128.0.3:9090"})
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// Registering the same worker again is idempotent
+	resp = postWorker(t, server.URL, registry.Worker{Target: "10.128.0.3:9090"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	workers := listWorkers(t, server.URL)
+	require.Len(t, workers, 2)
+	assert.Equal(t, "10.128.0.2:9090", workers[0].Target)
+	assert.Equal(t, "nvidia-tesla-t4", workers[0].Labels["gpu_type"])
+	assert.Equal(t, "all-smi", workers[0].Labels["job"])
+	assert.Equal(t, "production", workers[1].Labels["cluster"])
+
+	// Workers with different labels land in separate file_sd groups
+	groups, err := targets.ReadFile(path)
+	require.NoError(t, err)
+	assert.Len(t, groups, 2)
+
+	resp = deleteWorker(t, server.URL, "10.128.0.2:9090")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp = deleteWorker(t, server.URL, "10.128.0.2:9090")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	groups, err = targets.ReadFile(path)
+	require.NoError(t, err)
+	require.Len(t, groups, 1)
+	assert.Equal(t, []string{"10.128.0.3:9090"}, groups[0].Targets)
+}
+
+func TestRegistryAcceptsMetadataLabels(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "all-smi-targets.yml")
+	server := newRegistryServer(t, path)
+
+	metadata := targets.Metadata{GPUType: "nvidia-tesla-t4", Zone: "us-central1-a", Preemptible: "true"}.Labels()
+	resp := postWorker(t, server.URL, registry.Worker{Target: "10.128.0.2:9090", Labels: metadata})
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	groups, err := targets.ReadFile(path)
+	require.NoError(t, err)
+	require.Len(t, groups, 1)
+	for name, value := range metadata {
+		assert.Equal(t, value, groups[0].Labels[name], name)
+	}
+	assert.Equal(t, "all-smi", groups[0].Labels["job"])
+}
+
+func TestRegistryRejectsInvalidRequests(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "all-smi-targets.yml")
+	server := newRegistryServer(t, path)
+
+	testCases := []struct {
+		name   string
+		worker registry.Worker
+	}{
+		{name: "Missing Port", worker: registry.Worker{Target: "10.128.0.2"}},
+		{name: "Port Out Of Range", worker: registry.Worker{Target: "10.128.0.2:90900"}},
+		{name: "Invalid Host", worker: registry.Worker{Target: "bad host:9090"}},
+		{name: "Invalid Label Name", worker: registry.Worker{Target: "10.128.0.2:9090", Labels: map[string]string{"gpu-type": "t4"}}},
+		{name: "Reserved Label Name", worker: registry.Worker{Target: "10.128.0.2:9090", Labels: map[string]string{"__address__": "x"}}},
+		{name: "Foreign Meta Label", worker: registry.Worker{Target: "10.128.0.2:9090", Labels: map[string]string{"__meta_gce_zone": "us-central1-a"}}},
+		{name: "Job Override", worker: registry.Worker{Target: "10.128.0.2:9090", Labels: map[string]string{"job": "other"}}},
+	}
+
+	for _, tc := range testCases {
+		resp := postWorker(t, server.URL, tc.worker)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, tc.name)
+	}
+
+	resp, err := http.Post(server.URL+"/workers", "application/json", bytes.NewReader([]byte(`{"target": 1}`)))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	assert.Empty(t, listWorkers(t, server.URL))
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err), "Rejected requests should not create the target file")
+}
+
+func TestRegistryConcurrentRegistrations(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "all-smi-targets.yml")
+	server := newRegistryServer(t, path)
+
+	const workerCount = 50
+
+	var wg sync.WaitGroup
+	statuses := make(chan int, workerCount)
+	for i := 0; i < workerCount; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body := fmt.Sprintf(`{"target": "10.128.1.%d:9090"}`, i+1)
+			resp, err := http.Post(server.URL+"/workers", "application/json", bytes.NewReader([]byte(body)))
+			if err != nil {
+				statuses <- 0
+				return
+			}
+			resp.Body.Close()
+			statuses <- resp.StatusCode
+		}(i)
+	}
+	wg.Wait()
+	close(statuses)
+
+	for status := range statuses {
+		assert.Equal(t, http.StatusCreated, status)
+	}
+
+	groups, err := targets.ReadFile(path)
+	require.NoError(t, err)
+	require.Len(t, groups, 1)
+	assert.Len(t, groups[0].Targets, workerCount)
+
+	// No temporary files are left next to the target file
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestRegistryLoadsExistingTargetsFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "all-smi-targets.yml")
+	require.NoError(t, os.WriteFile(path, []byte(`- targets:
+    - 'worker1:9090'
+    - 'worker2:9090'
+  labels:
+    job: 'all-smi'
+    cluster: 'staging'
+`), 0o644))
+
+	server := newRegistryServer(t, path)
+
+	workers := listWorkers(t, server.URL)
+	require.Len(t, workers, 2)
+	assert.Equal(t, "staging", workers[0].Labels["cluster"])
+
+	resp := deleteWorker(t, server.URL, "worker1:9090")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	groups, err := targets.ReadFile(path)
+	require.NoError(t, err)
+	require.Len(t, groups, 1)
+	assert.Equal(t, []string{"worker2:9090"}, groups[0].Targets)
+	assert.Equal(t, "staging", groups[0].Labels["cluster"])
+}
@@ -0,0 +1,410 @@
+package test
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/appleparan/Algalon/pkg/config"
+	"github.com/appleparan/Algalon/pkg/envfile"
+	"github.com/appleparan/Algalon/pkg/scrape"
+	"github.com/appleparan/Algalon/pkg/targets"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+var updateGolden = flag.Bool("update", false, "Rewrite the golden files under testdata")
+
+// assertGolden compares got with testdata/<name>, rewriting the file
+// instead when the tests run with -update.
+func assertGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, got, 0o644))
+	}
+
+	want, err := os.ReadFile(path)
+	require.NoError(t, err, "Run go test -run %s -update to create the golden file", t.Name())
+	assert.Equal(t, string(want), string(got), "Rendered output differs from %s; rerun with -update if the change is intended", path)
+}
+
+func TestScrapeConfigGolden(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name   string
+		golden string
+		config scrape.Config
+	}{
+		{
+			name:   "All Exporters",
+			golden: "scrape/all-exporters.yml",
+			config: settingsConfig(t, scrape.DefaultSettings()),
+		},
+		{
+			name:   "All-SMI Only",
+			golden: "scrape/all-smi.yml",
+			config: settingsConfig(t, scrape.Settings{Jobs: []scrape.JobSettings{{Exporter: targets.AllSmi.Name}}}),
+		},
+		{
+			name:   "DCGM Only In Custom Directory",
+			golden: "scrape/dcgm-exporter.yml",
+			config: settingsConfig(t, scrape.Settings{TargetsDir: "/srv/algalon/targets", Jobs: []scrape.JobSettings{{Exporter: targets.DCGMExporter.Name}}}),
+		},
+		{
+			name:   "Relabel Settings Example",
+			golden: "scrape/relabel-settings.yml",
+			config: loadSettingsConfig(t, filepath.Join("..", "..", "examples", "scrape", "relabel-settings.yml")),
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			data, err := scrape.Render(tc.config)
+			require.NoError(t, err)
+			assertGolden(t, tc.golden, data)
+
+			parsed, err := scrape.Parse(data)
+			require.NoError(t, err)
+			assert.Equal(t, tc.config, parsed, "Rendered configuration should parse back unchanged")
+		})
+	}
+}
+
+func TestShippedScrapeConfigMatchesDefaults(t *testing.T) {
+	t.Parallel()
+
+	shipped, err := os.ReadFile(filepath.Join("..", "..", "algalon_host", scrape.DefaultFileName))
+	require.NoError(t, err)
+
+	// algalonctl host up renders the file from the host env; without one it
+	// must match what is shipped.
+	host, err := config.HostFromEnv(envfile.Env{}.Lookup)
+	require.NoError(t, err)
+	cfg, err := host.Scrape.Config()
+	require.NoError(t, err)
+	assert.Equal(t, settingsConfig(t, scrape.DefaultSettings()), cfg)
+
+	rendered, err := scrape.Render(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, string(rendered), string(shipped), "Regenerate algalon_host/prometheus.yml with algalonctl scrape generate")
+}
+
+func loadSettingsConfig(t *testing.T, path string) scrape.Config {
+	t.Helper()
+
+	settings, err := scrape.LoadSettings(path)
+	require.NoError(t, err)
+	return settingsConfig(t, settings)
+}
+
+func settingsConfig(t *testing.T, settings scrape.Settings) scrape.Config {
+	t.Helper()
+
+	cfg, err := settings.Config()
+	require.NoError(t, err)
+	return cfg
+}
+
+func TestScrapeConfigJobsMatchTargetFiles(t *testing.T) {
+	t.Parallel()
+
+	cfg := settingsConfig(t, scrape.DefaultSettings())
+	for i, exporter := range targets.Exporters() {
+		job := cfg.ScrapeConfigs[i]
+		assert.Equal(t, exporter.Name, job.JobName)
+
+		// The default file and every spec file must match the job's glob.
+		glob := filepath.Base(job.FileSDConfigs[0].Files[0])
+		for _, name := range []string{exporter.DefaultFileName(), exporter.FileName("training")} {
+			matched, err := filepath.Match(glob, name)
+			require.NoError(t, err)
+			assert.True(t, matched, "%s should match %s", name, glob)
+		}
+		for _, other := range targets.Exporters() {
+			if other.Name == exporter.Name {
+				continue
+			}
+			matched, err := filepath.Match(glob, other.DefaultFileName())
+			require.NoError(t, err)
+			assert.False(t, matched, "%s targets must not be scraped by the %s job", other.Name, exporter.Name)
+		}
+	}
+}
+
+func TestScrapeConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name   string
+		data   string
+		errMsg string
+	}{
+		{name: "No Jobs", data: "global: {scrape_interval: 15s}\nscrape_configs: []\n", errMsg: "no jobs"},
+		{name: "Missing Job Name", data: "scrape_configs:\n  - file_sd_configs: [{files: [a.yml]}]\n", errMsg: "no job_name"},
+		{name: "Duplicate Job", data: "scrape_configs:\n  - {job_name: a, file_sd_configs: [{files: [a.yml]}]}\n  - {job_name: a, file_sd_configs: [{files: [b.yml]}]}\n", errMsg: "defined twice"},
+		{name: "No Target Files", data: "scrape_configs:\n  - {job_name: a, file_sd_configs: []}\n", errMsg: "reads no target files"},
+		{name: "Unknown Field", data: "scrape_configs:\n  - {job_name: a, static_configs: [], file_sd_configs: [{files: [a.yml]}]}\n", errMsg: "field static_configs not found"},
+		{name: "Bad Duration", data: "scrape_configs:\n  - {job_name: a, scrape_interval: soon, file_sd_configs: [{files: [a.yml]}]}\n", errMsg: "invalid scrape configuration"},
+		{name: "Timeout Exceeds Interval", data: "scrape_configs:\n  - {job_name: all-smi, scrape_interval: 5s, scrape_timeout: 10s, file_sd_configs: [{files: [a.yml]}]}\n", errMsg: `scrape job "all-smi": scrape_timeout 10s exceeds scrape_interval 5s`},
+		{name: "Timeout Exceeds Global Interval", data: "global: {scrape_interval: 5s}\nscrape_configs:\n  - {job_name: a, scrape_timeout: 6s, file_sd_configs: [{files: [a.yml]}]}\n", errMsg: "scrape_timeout 6s exceeds scrape_interval 5s"},
+		{name: "Global Timeout Exceeds Interval", data: "global: {scrape_interval: 5s, scrape_timeout: 10s}\nscrape_configs:\n  - {job_name: a, file_sd_configs: [{files: [a.yml]}]}\n", errMsg: "global scrape_timeout 10s exceeds scrape_interval 5s"},
+		{name: "Unknown Relabel Action", data: "scrape_configs:\n  - {job_name: a, file_sd_configs: [{files: [a.yml]}], relabel_configs: [{action: rename}]}\n", errMsg: `relabel_configs[0]: unknown relabel action "rename"`},
+		{name: "Bad Relabel Regex", data: "scrape_configs:\n  - {job_name: a, file_sd_configs: [{files: [a.yml]}], metric_relabel_configs: [{source_labels: [__name__], regex: 'gpu_(', action: drop}]}\n", errMsg: "metric_relabel_configs[0]: invalid relabel regex"},
+		{name: "Replace Without Target", data: "scrape_configs:\n  - {job_name: a, file_sd_configs: [{files: [a.yml]}], relabel_configs: [{source_labels: [__address__]}]}\n", errMsg: "relabel action replace needs a target_label"},
+		{name: "Drop Without Sources", data: "scrape_configs:\n  - {job_name: a, file_sd_configs: [{files: [a.yml]}], metric_relabel_configs: [{regex: 'x', action: drop}]}\n", errMsg: "relabel action drop needs source_labels"},
+		{name: "Hashmod Without Modulus", data: "scrape_configs:\n  - {job_name: a, file_sd_configs: [{files: [a.yml]}], relabel_configs: [{source_labels: [__address__], target_label: shard, action: hashmod}]}\n", errMsg: "hashmod needs a modulus"},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := scrape.Parse([]byte(tc.data))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.errMsg)
+		})
+	}
+}
+
+func TestScrapeSettings(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		settings scrape.Settings
+		interval model.Duration
+		timeout  model.Duration
+		errMsg   string
+	}{
+		{
+			name:     "Exporter Defaults",
+			settings: scrape.Settings{Jobs: []scrape.JobSettings{{Exporter: "dcgm-exporter"}}},
+			interval: model.Duration(15 * time.Second),
+			timeout:  model.Duration(10 * time.Second),
+		},
+		{
+			name:     "Default Timeout Capped At Interval",
+			settings: scrape.Settings{Jobs: []scrape.JobSettings{{Exporter: "all-smi", ScrapeInterval: model.Duration(2 * time.Second)}}},
+			interval: model.Duration(2 * time.Second),
+			timeout:  model.Duration(2 * time.Second),
+		},
+		{
+			name:     "Explicit Timeout",
+			settings: scrape.Settings{Jobs: []scrape.JobSettings{{Exporter: "all-smi", ScrapeInterval: model.Duration(30 * time.Second), ScrapeTimeout: model.Duration(20 * time.Second)}}},
+			interval: model.Duration(30 * time.Second),
+			timeout:  model.Duration(20 * time.Second),
+		},
+		{
+			name:     "Interval At Collection Interval",
+			settings: scrape.Settings{Jobs: []scrape.JobSettings{{Exporter: "all-smi", ScrapeInterval: model.Duration(5 * time.Second), MinInterval: model.Duration(5 * time.Second)}}},
+			interval: model.Duration(5 * time.Second),
+			timeout:  model.Duration(5 * time.Second),
+		},
+		{
+			name:     "Explicit Timeout Exceeds Interval",
+			settings: scrape.Settings{Jobs: []scrape.JobSettings{{Exporter: "all-smi", ScrapeInterval: model.Duration(5 * time.Second), ScrapeTimeout: model.Duration(10 * time.Second)}}},
+			errMsg:   "scrape_timeout 10s exceeds scrape_interval 5s",
+		},
+		{
+			name:     "Interval Below Collection Interval",
+			settings: scrape.Settings{Jobs: []scrape.JobSettings{{Exporter: "all-smi", ScrapeInterval: model.Duration(5 * time.Second), MinInterval: model.Duration(10 * time.Second)}}},
+			errMsg:   "scrape job all-smi: scrape_interval 5s is shorter than the exporter's collection interval 10s",
+		},
+		{
+			name:     "Unknown Exporter",
+			settings: scrape.Settings{Jobs: []scrape.JobSettings{{Exporter: "node-exporter"}}},
+			errMsg:   `unknown exporter "node-exporter"`,
+		},
+		{
+			name:     "Missing Exporter",
+			settings: scrape.Settings{Jobs: []scrape.JobSettings{{}}},
+			errMsg:   "no exporter",
+		},
+		{
+			name:     "Duplicate Job",
+			settings: scrape.Settings{Jobs: []scrape.JobSettings{{Exporter: "all-smi"}, {Exporter: "all-smi"}}},
+			errMsg:   "defined twice",
+		},
+		{
+			name:     "Relative Targets Dir",
+			settings: scrape.Settings{TargetsDir: "targets", Jobs: []scrape.JobSettings{{Exporter: "all-smi"}}},
+			errMsg:   "must be an absolute path",
+		},
+		{
+			name:     "No Jobs",
+			settings: scrape.Settings{},
+			errMsg:   "no jobs",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg, err := tc.settings.Config()
+			if tc.errMsg != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.errMsg)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, cfg.ScrapeConfigs, 1)
+			assert.Equal(t, tc.interval, cfg.ScrapeConfigs[0].ScrapeInterval)
+			assert.Equal(t, tc.timeout, cfg.ScrapeConfigs[0].ScrapeTimeout)
+		})
+	}
+}
+
+func TestHostScrapeSettings(t *testing.T) {
+	t.Parallel()
+
+	type job struct {
+		name              string
+		interval, timeout time.Duration
+	}
+
+	testCases := []struct {
+		name   string
+		env    envfile.Env
+		global time.Duration
+		jobs   []job
+		errMsg string
+	}{
+		{
+			name:   "Defaults",
+			env:    envfile.Env{},
+			global: 15 * time.Second,
+			jobs:   []job{{"all-smi", 5 * time.Second, 5 * time.Second}, {"dcgm-exporter", 15 * time.Second, 10 * time.Second}},
+		},
+		{
+			name:   "All-SMI Follows VMAgent Interval",
+			env:    envfile.Env{"VMAGENT_SCRAPE_INTERVAL": "30s", "ALL_SMI_INTERVAL": "10"},
+			global: 15 * time.Second,
+			jobs:   []job{{"all-smi", 30 * time.Second, 10 * time.Second}, {"dcgm-exporter", 15 * time.Second, 10 * time.Second}},
+		},
+		{
+			name:   "Per-Job Settings",
+			env:    envfile.Env{"SCRAPE_EXPORTERS": "dcgm-exporter", "DCGM_SCRAPE_INTERVAL": "1m", "DCGM_SCRAPE_TIMEOUT": "30s"},
+			global: 15 * time.Second,
+			jobs:   []job{{"dcgm-exporter", time.Minute, 30 * time.Second}},
+		},
+		{
+			name:   "All-SMI Timeout",
+			env:    envfile.Env{"VMAGENT_SCRAPE_INTERVAL": "10s", "ALL_SMI_SCRAPE_TIMEOUT": "8s", "SCRAPE_EXPORTERS": "all-smi"},
+			global: 15 * time.Second,
+			jobs:   []job{{"all-smi", 10 * time.Second, 8 * time.Second}},
+		},
+		{
+			name:   "Scraping Faster Than Workers Collect",
+			env:    envfile.Env{"VMAGENT_SCRAPE_INTERVAL": "5s", "ALL_SMI_INTERVAL": "10"},
+			errMsg: "invalid scrape settings: scrape job all-smi: scrape_interval 5s is shorter than the exporter's collection interval 10s",
+		},
+		{
+			name:   "All-SMI Timeout Exceeds Interval",
+			env:    envfile.Env{"ALL_SMI_SCRAPE_TIMEOUT": "10s"},
+			errMsg: `scrape job "all-smi": scrape_timeout 10s exceeds scrape_interval 5s`,
+		},
+		{
+			name:   "DCGM Timeout Exceeds Interval",
+			env:    envfile.Env{"DCGM_SCRAPE_INTERVAL": "10s", "DCGM_SCRAPE_TIMEOUT": "15s"},
+			errMsg: `scrape job "dcgm-exporter": scrape_timeout 15s exceeds scrape_interval 10s`,
+		},
+		{
+			name:   "Unknown Exporter",
+			env:    envfile.Env{"SCRAPE_EXPORTERS": "all-smi,node-exporter"},
+			errMsg: "invalid SCRAPE_EXPORTERS",
+		},
+		{
+			name:   "Bad Timeout",
+			env:    envfile.Env{"DCGM_SCRAPE_TIMEOUT": "soon"},
+			errMsg: "invalid DCGM_SCRAPE_TIMEOUT",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			host, err := config.HostFromEnv(tc.env.Lookup)
+			if err == nil {
+				err = host.Validate()
+			}
+			if tc.errMsg != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.errMsg)
+				return
+			}
+			require.NoError(t, err)
+
+			cfg, err := host.Scrape.Config()
+			require.NoError(t, err)
+			assert.Equal(t, model.Duration(tc.global), cfg.Global.ScrapeInterval)
+			require.Len(t, cfg.ScrapeConfigs, len(tc.jobs))
+			for i, want := range tc.jobs {
+				got := cfg.ScrapeConfigs[i]
+				assert.Equal(t, want.name, got.JobName)
+				assert.Equal(t, model.Duration(want.interval), got.ScrapeInterval, "%s interval", want.name)
+				assert.Equal(t, model.Duration(want.timeout), got.ScrapeTimeout, "%s timeout", want.name)
+			}
+		})
+	}
+}
+
+func TestScrapeConfigMetadataRelabeling(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := scrape.DefaultSettings().Config()
+	require.NoError(t, err)
+
+	for _, job := range cfg.ScrapeConfigs {
+		job := job // capture range variable
+		t.Run(job.JobName, func(t *testing.T) {
+			t.Parallel()
+
+			// Run the rendered rules through the Prometheus relabeling code,
+			// as vmagent would before the scrape.
+			data, err := yaml.Marshal(job.RelabelConfigs)
+			require.NoError(t, err)
+			var configs []*relabel.Config
+			require.NoError(t, yaml.Unmarshal(data, &configs))
+
+			target := labels.FromMap(map[string]string{
+				"__address__":                      "10.0.1.100:9090",
+				"job":                              job.JobName,
+				"cluster":                          "training",
+				"__meta_algalon_gpu_type":          "nvidia-tesla-t4",
+				"__meta_algalon_gpus_per_instance": "2",
+				"__meta_algalon_zone":              "us-central1-a",
+				"__meta_algalon_instance_name":     "algalon-worker-1",
+				"__meta_algalon_preemptible":       "true",
+				"__meta_algalon_unknown":           "dropped",
+			})
+			relabeled, keep := relabel.Process(target, configs...)
+			require.True(t, keep)
+
+			assert.Equal(t, "nvidia-tesla-t4", relabeled.Get("gpu_type"))
+			assert.Equal(t, "2", relabeled.Get("gpus_per_instance"))
+			assert.Equal(t, "us-central1-a", relabeled.Get("zone"))
+			assert.Equal(t, "algalon-worker-1", relabeled.Get("instance_name"))
+			assert.Equal(t, "true", relabeled.Get("preemptible"))
+			assert.Equal(t, "training", relabeled.Get("cluster"))
+			assert.Empty(t, relabeled.Get("unknown"), "Only the known metadata labels should be mapped")
+		})
+	}
+}
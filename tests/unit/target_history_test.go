@@ -0,0 +1,322 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/appleparan/Algalon/pkg/discovery"
+	"github.com/appleparan/Algalon/pkg/history"
+	"github.com/appleparan/Algalon/pkg/registry"
+	"github.com/appleparan/Algalon/pkg/targets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestHistory(t *testing.T) *history.History {
+	t.Helper()
+
+	root := t.TempDir()
+	targetsDir := filepath.Join(root, "node", "targets")
+	require.NoError(t, os.MkdirAll(targetsDir, 0o755))
+
+	clock := &fakeClock{now: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)}
+	h := history.New(filepath.Join(root, "backups", "history"), targetsDir)
+	h.Now = func() time.Time {
+		clock.Advance(time.Minute)
+		return clock.Now()
+	}
+	return h
+}
+
+func writeTargets(t *testing.T, h *history.History, name string, addresses ...string) {
+	t.Helper()
+	require.NoError(t, targets.WriteFile(filepath.Join(h.TargetsDir, name), []targets.Group{
+		{Targets: addresses, Labels: targets.DefaultLabels("production", "gpu-cluster")},
+	}))
+}
+
+func TestTargetHistoryRecord(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHistory(t)
+	writeTargets(t, h, targets.DefaultFileName, "10.128.0.2:9090")
+	// Neither file is file_sd read by VMAgent, so neither is versioned.
+	require.NoError(t, os.WriteFile(filepath.Join(h.TargetsDir, "quarantined-targets.yml"), []byte("[]\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(h.TargetsDir, "all-smi-targets.yml.template"), []byte("# template\n"), 0o644))
+
+	first, err := h.Record("alice", "register 10.128.0.2:9090")
+	require.NoError(t, err)
+	assert.Equal(t, 1, first.Number)
+	assert.Equal(t, "alice", first.Author)
+	assert.Equal(t, []string{targets.DefaultFileName}, first.Files)
+
+	latest, err := h.Record("bob", "nothing changed")
+	assert.ErrorIs(t, err, history.ErrNoChange)
+	assert.Equal(t, 1, latest.Number)
+
+	writeTargets(t, h, targets.DefaultFileName, "10.128.0.2:9090", "10.128.0.3:9090")
+	writeTargets(t, h, targets.SpecFileName("staging"), "10.128.1.2:9090")
+	second, err := h.Record("bob", "add staging")
+	require.NoError(t, err)
+	assert.Equal(t, 2, second.Number)
+	assert.Equal(t, []string{"all-smi-staging.yml", targets.DefaultFileName}, second.Files)
+
+	versions, err := h.Versions()
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.Equal(t, "register 10.128.0.2:9090", versions[0].Message)
+	assert.Equal(t, "bob", versions[1].Author)
+	assert.True(t, versions[1].Time.After(versions[0].Time))
+
+	_, err = h.Version(3)
+	assert.Error(t, err)
+}
+
+func TestTargetHistoryDiff(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHistory(t)
+	writeTargets(t, h, targets.DefaultFileName, "10.128.0.2:9090")
+	_, err := h.Record("alice", "first")
+	require.NoError(t, err)
+
+	writeTargets(t, h, targets.DefaultFileName, "10.128.0.3:9090")
+	writeTargets(t, h, targets.SpecFileName("staging"), "10.128.1.2:9090")
+	_, err = h.Record("alice", "second")
+	require.NoError(t, err)
+
+	diff, err := h.Diff(1, 2)
+	require.NoError(t, err)
+	assert.Contains(t, diff, "--- v1/all-smi-targets.yml\n+++ v2/all-smi-targets.yml\n")
+	assert.Contains(t, diff, "-    - 10.128.0.2:9090\n+    - 10.128.0.3:9090\n")
+	assert.Contains(t, diff, "--- /dev/null\n+++ v2/all-smi-staging.yml\n", "A new file diffs against /dev/null")
+
+	diff, err = h.Diff(2, history.Current)
+	require.NoError(t, err)
+	assert.Empty(t, diff, "The latest version matches the target directory")
+
+	require.NoError(t, os.Remove(filepath.Join(h.TargetsDir, "all-smi-staging.yml")))
+	diff, err = h.Diff(2, history.Current)
+	require.NoError(t, err)
+	assert.Contains(t, diff, "--- v2/all-smi-staging.yml\n+++ /dev/null\n")
+
+	_, err = h.Diff(1, 7)
+	assert.Error(t, err)
+}
+
+func TestTargetHistoryRollback(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHistory(t)
+	writeTargets(t, h, targets.DefaultFileName, "10.128.0.2:9090")
+	_, err := h.Record("alice", "first")
+	require.NoError(t, err)
+
+	writeTargets(t, h, targets.DefaultFileName, "10.128.0.3:9090")
+	writeTargets(t, h, targets.SpecFileName("staging"), "10.128.1.2:9090")
+	// An unrecorded edit is saved before it is rolled back.
+	writeTargets(t, h, targets.SpecFileName("edge"), "10.128.2.2:9090")
+
+	version, err := h.Rollback(1, "carol")
+	require.NoError(t, err)
+	assert.Equal(t, 3, version.Number)
+	assert.Equal(t, "carol", version.Author)
+	assert.Equal(t, "rollback to version 1", version.Message)
+
+	assert.Equal(t, []string{"10.128.0.2:9090"}, fileTargets(t, filepath.Join(h.TargetsDir, targets.DefaultFileName)))
+	assert.NoFileExists(t, filepath.Join(h.TargetsDir, "all-smi-staging.yml"), "Files the version lacks are removed")
+	assert.NoFileExists(t, filepath.Join(h.TargetsDir, "all-smi-edge.yml"))
+
+	versions, err := h.Versions()
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+	assert.Equal(t, "before rollback to version 1", versions[1].Message)
+	assert.Contains(t, versions[1].Files, "all-smi-edge.yml")
+
+	// The rollback can itself be undone.
+	_, err = h.Rollback(2, "carol")
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(h.TargetsDir, "all-smi-edge.yml"))
+}
+
+func TestTargetHistoryRollbackRejectsInvalidFileSD(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHistory(t)
+	path := filepath.Join(h.TargetsDir, targets.DefaultFileName)
+	require.NoError(t, os.WriteFile(path, []byte("- targets: [not a target]\n"), 0o644))
+	_, err := h.Record("alice", "hand edit")
+	require.NoError(t, err)
+
+	writeTargets(t, h, targets.DefaultFileName, "10.128.0.2:9090")
+	before, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	_, err = h.Rollback(1, "alice")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not valid file_sd")
+
+	after, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, string(before), string(after), "Nothing is written when a file fails validation")
+	versions, err := h.Versions()
+	require.NoError(t, err)
+	assert.Len(t, versions, 1)
+}
+
+func TestTargetHistoryRollbackWaitsForTheTargetLock(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHistory(t)
+	writeTargets(t, h, targets.DefaultFileName, "10.128.0.2:9090")
+	_, err := h.Record("alice", "initial")
+	require.NoError(t, err)
+	writeTargets(t, h, targets.DefaultFileName, "10.128.0.2:9090", "10.128.0.3:9090")
+
+	// Hold the lock like a registration in progress.
+	unlock, err := targets.LockDir(h.TargetsDir)
+	require.NoError(t, err)
+
+	rolledBack := make(chan error, 1)
+	go func() {
+		_, err := h.Rollback(1, "alice")
+		rolledBack <- err
+	}()
+	select {
+	case err := <-rolledBack:
+		t.Fatalf("rollback did not wait for the lock: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	require.NoError(t, <-rolledBack)
+
+	groups, err := targets.ReadFile(filepath.Join(h.TargetsDir, targets.DefaultFileName))
+	require.NoError(t, err)
+	require.Len(t, groups, 1)
+	assert.Equal(t, []string{"10.128.0.2:9090"}, groups[0].Targets)
+}
+
+func TestTargetHistoryRollbackRejectsUnsafeFileNames(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name string
+		file string
+	}{
+		{name: "Parent Directory", file: "../all-smi-escape.yml"},
+		{name: "Nested Parent Directory", file: "../../node/all-smi-escape.yml"},
+		{name: "Absolute Path", file: "/tmp/all-smi-escape.yml"},
+		{name: "Subdirectory", file: "sub/all-smi-escape.yml"},
+		{name: "Untracked Name", file: "prometheus.yml"},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			h := newTestHistory(t)
+			writeTargets(t, h, targets.DefaultFileName, "10.128.0.2:9090")
+			_, err := h.Record("alice", "initial")
+			require.NoError(t, err)
+
+			// Edit version.json as an attacker with write access to the
+			// history would, and place the file where the name points.
+			versionDir := filepath.Join(h.Dir, "000001")
+			meta := filepath.Join(versionDir, "version.json")
+			data, err := os.ReadFile(meta)
+			require.NoError(t, err)
+			var version history.Version
+			require.NoError(t, json.Unmarshal(data, &version))
+			version.Files = append(version.Files, tc.file)
+			data, err = json.Marshal(version)
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(meta, data, 0o644))
+
+			payload := filepath.Join(versionDir, tc.file)
+			if !filepath.IsAbs(tc.file) {
+				require.NoError(t, os.MkdirAll(filepath.Dir(payload), 0o755))
+				require.NoError(t, targets.WriteFile(payload, []targets.Group{{Targets: []string{"10.0.0.66:9090"}}}))
+			}
+
+			_, err = h.Files(1)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "invalid file name")
+
+			_, err = h.Rollback(1, "alice")
+			require.Error(t, err)
+			assert.NoFileExists(t, filepath.Join(h.TargetsDir, tc.file))
+			versions, err := h.Versions()
+			require.NoError(t, err)
+			assert.Len(t, versions, 1, "Nothing is recorded when a version is rejected")
+		})
+	}
+}
+
+func TestTargetHistoryRecordsInstanceDiscoveryChanges(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHistory(t)
+	compute := discovery.NewFakeCompute(workerInstance("worker-1", "10.128.0.2", "training", true))
+	d := &discovery.InstanceDiscoverer{
+		Compute:  compute,
+		Port:     9090,
+		Path:     filepath.Join(h.TargetsDir, discovery.DefaultInstanceFile),
+		Logger:   log.New(io.Discard, "", 0),
+		OnChange: history.Recorder(h.Dir, h.TargetsDir, "algalon-gce-discovery", log.New(io.Discard, "", 0)),
+	}
+	ctx := context.Background()
+
+	_, err := d.RunOnce(ctx)
+	require.NoError(t, err)
+	_, err = d.RunOnce(ctx)
+	require.NoError(t, err, "An unchanged instance list records nothing")
+	compute.SetStatus("worker-1", discovery.StatusTerminated)
+	_, err = d.RunOnce(ctx)
+	require.NoError(t, err)
+
+	versions, err := h.Versions()
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.Equal(t, "add 10.128.0.2:9090", versions[0].Message)
+	assert.Equal(t, "remove 10.128.0.2:9090", versions[1].Message)
+	assert.Equal(t, "algalon-gce-discovery", versions[1].Author)
+	assert.Equal(t, []string{discovery.DefaultInstanceFile}, versions[0].Files)
+}
+
+func TestTargetHistoryRecorderDisabled(t *testing.T) {
+	t.Parallel()
+
+	assert.Nil(t, history.Recorder("", t.TempDir(), "algalon-registry", log.New(io.Discard, "", 0)))
+}
+
+func TestTargetHistoryRecordsRegistryChanges(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHistory(t)
+	store, err := registry.NewStore(filepath.Join(h.TargetsDir, targets.DefaultFileName), targets.DefaultLabels("production", "gpu-cluster"))
+	require.NoError(t, err)
+	store.OnChange = func(message string) {
+		_, err := h.Record("algalon-registry", message)
+		assert.NoError(t, err)
+	}
+
+	_, _, err = store.Add(registry.Worker{Target: "10.128.0.2:9090"})
+	require.NoError(t, err)
+	_, _, err = store.Add(registry.Worker{Target: "10.128.0.2:9090"})
+	require.NoError(t, err, "An unchanged registration records nothing")
+	require.NoError(t, store.Remove("10.128.0.2:9090"))
+
+	versions, err := h.Versions()
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.Equal(t, "register 10.128.0.2:9090", versions[0].Message)
+	assert.Equal(t, "deregister 10.128.0.2:9090", versions[1].Message)
+	assert.Equal(t, "algalon-registry", versions[1].Author)
+}
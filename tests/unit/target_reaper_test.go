@@ -0,0 +1,414 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/appleparan/Algalon/pkg/reaper"
+	"github.com/appleparan/Algalon/pkg/registry"
+	"github.com/appleparan/Algalon/pkg/targets"
+	"github.com/appleparan/Algalon/pkg/vmclient"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/promql/promqltest"
+	"github.com/prometheus/prometheus/util/teststorage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeUpServer answers instant queries with one series per stale instance,
+// like VictoriaMetrics evaluating the reaper's query. promQuerier evaluates
+// the query itself.
+type fakeUpServer struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	stale   []string
+	queries []string
+	fail    bool
+}
+
+func newFakeUpServer(t *testing.T, stale ...string) *fakeUpServer {
+	t.Helper()
+
+	vm := &fakeUpServer{stale: stale}
+	vm.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vm.mu.Lock()
+		defer vm.mu.Unlock()
+
+		assert.Equal(t, "/api/v1/query", r.URL.Path)
+		vm.queries = append(vm.queries, r.FormValue("query"))
+		if vm.fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, `{"status":"error","errorType":"unavailable","error":"storage is starting"}`)
+			return
+		}
+
+		samples := make([]string, 0, len(vm.stale))
+		for _, instance := range vm.stale {
+			samples = append(samples, fmt.Sprintf(`{"metric":{"job":"all-smi","instance":%q},"value":[1700000000,"0"]}`, instance))
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[%s]}}`, strings.Join(samples, ","))
+	}))
+	t.Cleanup(vm.Close)
+
+	return vm
+}
+
+func (vm *fakeUpServer) SetStale(stale ...string) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+	vm.stale = stale
+}
+
+// fakeProber reports the targets in up as serving all-smi metrics.
+type fakeProber struct {
+	mu sync.Mutex
+	up map[string]bool
+}
+
+func (p *fakeProber) Probe(ctx context.Context, address string) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.up[address], nil
+}
+
+func (p *fakeProber) Set(address string, up bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.up == nil {
+		p.up = map[string]bool{}
+	}
+	p.up[address] = up
+}
+
+func newTestReaper(t *testing.T, vm *fakeUpServer, clock *fakeClock) *reaper.Reaper {
+	t.Helper()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, targets.DefaultFileName)
+	require.NoError(t, targets.WriteFile(path, []targets.Group{
+		{Targets: []string{"10.128.0.2:9090", "10.128.0.3:9090"}, Labels: targets.DefaultLabels("production", "gpu-cluster")},
+		{Targets: []string{"10.128.1.2:9090"}, Labels: targets.DefaultLabels("staging", "gpu-cluster")},
+	}))
+
+	return &reaper.Reaper{
+		Querier:        vmclient.NewClient(vm.URL),
+		Path:           path,
+		QuarantinePath: filepath.Join(dir, reaper.DefaultQuarantineFile),
+		GracePeriod:    10 * time.Minute,
+		Retention:      time.Hour,
+		Logger:         log.New(io.Discard, "", 0),
+		Now:            clock.Now,
+	}
+}
+
+func quarantinedTargets(t *testing.T, path string) []string {
+	entries, err := reaper.ReadQuarantine(path)
+	require.NoError(t, err)
+
+	var all []string
+	for _, entry := range entries {
+		all = append(all, entry.Target)
+	}
+	return all
+}
+
+func TestReaperQuery(t *testing.T) {
+	t.Parallel()
+
+	vm := newFakeUpServer(t)
+	r := newTestReaper(t, vm, &fakeClock{now: time.Now()})
+
+	_, err := r.RunOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{`max_over_time(up{job="all-smi"}[600s]) == 0 and count_over_time(up{job="all-smi"}[600s] offset 600s)`}, vm.queries,
+		"A target must be down for the whole grace period and registered before it")
+
+	matched, err := filepath.Match("all-smi-*.yml", reaper.DefaultQuarantineFile)
+	require.NoError(t, err)
+	assert.False(t, matched, "VMAgent must not scrape the quarantine file")
+}
+
+// promQuerier evaluates instant queries at a fixed time over synthetic
+// series, like VictoriaMetrics over the recorded up series.
+type promQuerier struct {
+	t       *testing.T
+	storage *teststorage.TestStorage
+	engine  *promql.Engine
+	at      time.Time
+}
+
+func newPromQuerier(t *testing.T, load string, at time.Time) *promQuerier {
+	t.Helper()
+
+	storage := promqltest.LoadedStorage(t, "load 1m\n"+strings.TrimSpace(load))
+	t.Cleanup(func() { storage.Close() })
+	engine := promqltest.NewTestEngine(t, false, 0, promqltest.DefaultMaxSamplesPerQuery)
+	return &promQuerier{t: t, storage: storage, engine: engine, at: at}
+}
+
+func (q *promQuerier) Query(ctx context.Context, query string) (vmclient.Vector, error) {
+	instant, err := q.engine.NewInstantQuery(ctx, q.storage, nil, query, q.at)
+	if err != nil {
+		return nil, err
+	}
+	defer instant.Close()
+
+	result, err := instant.Exec(ctx).Vector()
+	if err != nil {
+		return nil, err
+	}
+	vector := make(vmclient.Vector, 0, len(result))
+	for _, sample := range result {
+		vector = append(vector, vmclient.Sample{Metric: sample.Metric.Map(), Value: sample.F, Timestamp: time.UnixMilli(sample.T)})
+	}
+	return vector, nil
+}
+
+func TestReaperQueryOnSyntheticSeries(t *testing.T) {
+	t.Parallel()
+
+	// One sample a minute, evaluated at minute 30 with a 10m grace period.
+	load := `
+up{job="all-smi", instance="10.128.0.2:9090"} 1x30
+up{job="all-smi", instance="10.128.0.3:9090"} 1x10 0x20
+up{job="all-smi", instance="10.128.1.2:9090"} _x25 0x5
+up{job="all-smi", instance="10.128.1.3:9090"} 1x22 0x8
+up{job="all-smi", instance="10.128.1.4:9090"} 0x24 1 0x5
+up{job="dcgm-exporter", instance="10.128.0.2:9400"} 0x30
+`
+	vm := newFakeUpServer(t)
+	r := newTestReaper(t, vm, &fakeClock{now: time.Now()})
+	r.Querier = newPromQuerier(t, load, time.Unix(0, 0).Add(30*time.Minute))
+	require.NoError(t, targets.WriteFile(r.Path, []targets.Group{
+		{Targets: []string{"10.128.0.2:9090", "10.128.0.3:9090", "10.128.1.2:9090", "10.128.1.3:9090", "10.128.1.4:9090"}},
+	}))
+
+	changes, err := r.RunOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.128.0.3:9090"}, changes.Quarantined,
+		"Only the target down for the whole grace period since before it is stale")
+	assert.ElementsMatch(t, []string{"10.128.0.2:9090", "10.128.1.2:9090", "10.128.1.3:9090", "10.128.1.4:9090"}, fileTargets(t, r.Path),
+		"A new target that is still booting, one down for less than the grace period and one that answered within it are kept")
+}
+
+func TestReaperSharesTheTargetFileLock(t *testing.T) {
+	t.Parallel()
+
+	vm := newFakeUpServer(t, "10.128.0.3:9090")
+	r := newTestReaper(t, vm, &fakeClock{now: time.Now()})
+	store, err := registry.NewStore(r.Path, targets.DefaultLabels("production", "gpu-cluster"))
+	require.NoError(t, err)
+
+	// Hold the lock like a reaper run in progress: a registration waits
+	// for it and then sees the reaper's changes.
+	unlock, err := targets.LockDir(filepath.Dir(r.Path))
+	require.NoError(t, err)
+
+	added := make(chan error, 1)
+	go func() {
+		_, _, err := store.Add(registry.Worker{Target: "10.128.0.4:9090"})
+		added <- err
+	}()
+	select {
+	case err := <-added:
+		t.Fatalf("registration did not wait for the lock: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	require.NoError(t, <-added)
+
+	reaped := make(chan error, 1)
+	unlock, err = targets.LockDir(filepath.Dir(r.Path))
+	require.NoError(t, err)
+	go func() {
+		_, err := r.RunOnce(context.Background())
+		reaped <- err
+	}()
+	select {
+	case err := <-reaped:
+		t.Fatalf("reaper did not wait for the lock: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	require.NoError(t, <-reaped)
+
+	assert.ElementsMatch(t, []string{"10.128.0.2:9090", "10.128.0.4:9090", "10.128.1.2:9090"}, fileTargets(t, r.Path),
+		"The reaper keeps the registration made while it waited")
+}
+
+func TestReaperQuarantinesThenRemovesStaleTargets(t *testing.T) {
+	t.Parallel()
+
+	vm := newFakeUpServer(t, "10.128.0.3:9090", "10.128.1.2:9090", "10.200.0.9:9090")
+	clock := &fakeClock{now: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)}
+	r := newTestReaper(t, vm, clock)
+
+	changes, err := r.RunOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.128.0.3:9090", "10.128.1.2:9090"}, changes.Quarantined,
+		"Only targets from the reaped file are quarantined")
+	assert.Equal(t, 1, changes.Targets)
+	assert.Equal(t, 2, changes.InQuarantine)
+	assert.Equal(t, []string{"10.128.0.2:9090"}, fileTargets(t, r.Path))
+
+	entries, err := reaper.ReadQuarantine(r.QuarantinePath)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "10.128.1.2:9090", entries[1].Target)
+	assert.Equal(t, "staging", entries[1].Labels["cluster"], "Labels are kept for a later restore")
+	assert.True(t, entries[1].QuarantinedAt.Equal(clock.Now()))
+
+	// The series are gone from the query once the targets are not scraped.
+	vm.SetStale()
+	clock.Advance(30 * time.Minute)
+	changes, err = r.RunOnce(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, changes.Removed, "Targets stay quarantined for the retention period")
+	assert.Len(t, quarantinedTargets(t, r.QuarantinePath), 2)
+
+	clock.Advance(30 * time.Minute)
+	changes, err = r.RunOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.128.0.3:9090", "10.128.1.2:9090"}, changes.Removed)
+	assert.Empty(t, quarantinedTargets(t, r.QuarantinePath))
+	assert.Equal(t, []string{"10.128.0.2:9090"}, fileTargets(t, r.Path))
+}
+
+func TestReaperRestoresTargetsThatAnswerAgain(t *testing.T) {
+	t.Parallel()
+
+	vm := newFakeUpServer(t, "10.128.1.2:9090")
+	clock := &fakeClock{now: time.Now()}
+	prober := &fakeProber{}
+	r := newTestReaper(t, vm, clock)
+	r.Prober = prober
+
+	_, err := r.RunOnce(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{"10.128.1.2:9090"}, quarantinedTargets(t, r.QuarantinePath))
+
+	prober.Set("10.128.1.2:9090", true)
+	clock.Advance(5 * time.Minute)
+	changes, err := r.RunOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.128.1.2:9090"}, changes.Restored)
+	assert.Empty(t, changes.Quarantined,
+		"The up series still reads 0 within the grace period and must not quarantine the target again")
+	assert.Empty(t, quarantinedTargets(t, r.QuarantinePath))
+
+	groups, err := targets.ReadFile(r.Path)
+	require.NoError(t, err)
+	require.Len(t, groups, 2)
+	assert.Equal(t, []string{"10.128.1.2:9090"}, groups[1].Targets)
+	assert.Equal(t, "staging", groups[1].Labels["cluster"], "A restored target keeps its labels")
+}
+
+func TestReaperReleasesReregisteredTargets(t *testing.T) {
+	t.Parallel()
+
+	vm := newFakeUpServer(t, "10.128.0.3:9090")
+	r := newTestReaper(t, vm, &fakeClock{now: time.Now()})
+	store, err := registry.NewStore(r.Path, targets.DefaultLabels("production", "gpu-cluster"))
+	require.NoError(t, err)
+
+	_, err = r.RunOnce(context.Background())
+	require.NoError(t, err)
+
+	// A registration for another worker must not bring back the reaped one
+	// the store loaded at startup.
+	_, _, err = store.Add(registry.Worker{Target: "10.128.0.4:9090"})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"10.128.0.2:9090", "10.128.0.4:9090", "10.128.1.2:9090"}, fileTargets(t, r.Path))
+
+	_, created, err := store.Add(registry.Worker{Target: "10.128.0.3:9090"})
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	vm.SetStale()
+	changes, err := r.RunOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.128.0.3:9090"}, changes.Released)
+	assert.Empty(t, quarantinedTargets(t, r.QuarantinePath))
+	assert.Contains(t, fileTargets(t, r.Path), "10.128.0.3:9090")
+}
+
+func TestReaperDryRun(t *testing.T) {
+	t.Parallel()
+
+	vm := newFakeUpServer(t, "10.128.0.2:9090")
+	r := newTestReaper(t, vm, &fakeClock{now: time.Now()})
+	r.DryRun = true
+	before, err := os.ReadFile(r.Path)
+	require.NoError(t, err)
+
+	changes, err := r.RunOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.128.0.2:9090"}, changes.Quarantined)
+
+	after, err := os.ReadFile(r.Path)
+	require.NoError(t, err)
+	assert.Equal(t, string(before), string(after))
+	assert.NoFileExists(t, r.QuarantinePath)
+}
+
+func TestReaperDryRunKeepsGracePeriodState(t *testing.T) {
+	t.Parallel()
+
+	vm := newFakeUpServer(t, "10.128.1.2:9090")
+	clock := &fakeClock{now: time.Now()}
+	prober := &fakeProber{}
+	r := newTestReaper(t, vm, clock)
+	r.Prober = prober
+
+	_, err := r.RunOnce(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{"10.128.1.2:9090"}, quarantinedTargets(t, r.QuarantinePath))
+
+	// A dry run would restore the target, which exempts restored targets
+	// from quarantine for the grace period.
+	prober.Set("10.128.1.2:9090", true)
+	r.DryRun = true
+	changes, err := r.RunOnce(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{"10.128.1.2:9090"}, changes.Restored)
+
+	// The worker registers again but is still down.
+	prober.Set("10.128.1.2:9090", false)
+	store, err := registry.NewStore(r.Path, targets.DefaultLabels("staging", "gpu-cluster"))
+	require.NoError(t, err)
+	_, _, err = store.Add(registry.Worker{Target: "10.128.1.2:9090"})
+	require.NoError(t, err)
+
+	r.DryRun = false
+	clock.Advance(time.Minute)
+	changes, err = r.RunOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.128.1.2:9090"}, changes.Released)
+	assert.Equal(t, []string{"10.128.1.2:9090"}, changes.Quarantined,
+		"The dry run's restore must not exempt the target from quarantine")
+}
+
+func TestReaperQueryFailureLeavesFilesUntouched(t *testing.T) {
+	t.Parallel()
+
+	vm := newFakeUpServer(t, "10.128.0.2:9090")
+	vm.fail = true
+	r := newTestReaper(t, vm, &fakeClock{now: time.Now()})
+
+	_, err := r.RunOnce(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "storage is starting")
+	assert.Len(t, fileTargets(t, r.Path), 3)
+	assert.NoFileExists(t, r.QuarantinePath)
+}
@@ -0,0 +1,516 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/appleparan/Algalon/pkg/targets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTarget(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		raw         string
+		defaultPort int
+		expected    string
+		expectError bool
+	}{
+		{name: "Host With Port", raw: "worker1:9091", defaultPort: 9090, expected: "worker1:9091"},
+		{name: "Host Without Port", raw: "worker1", defaultPort: 9090, expected: "worker1:9090"},
+		{name: "IPv4 With Port", raw: "10.128.0.2:9090", defaultPort: 9090, expected: "10.128.0.2:9090"},
+		{name: "Surrounding Whitespace", raw: "  10.0.1.100:9090 ", defaultPort: 9090, expected: "10.0.1.100:9090"},
+		{name: "FQDN", raw: "gpu-node-1.example.com:9090", defaultPort: 9090, expected: "gpu-node-1.example.com:9090"},
+		{name: "Bracketed IPv6", raw: "[fd00::1]:9090", defaultPort: 9090, expected: "[fd00::1]:9090"},
+		{name: "Bracketed IPv6 Without Port", raw: "[fd00::1]", defaultPort: 9091, expected: "[fd00::1]:9091"},
+		{name: "Unbracketed IPv6", raw: "fd00::1", defaultPort: 9090, expectError: true},
+		{name: "Port Out Of Range", raw: "worker1:70000", defaultPort: 9090, expectError: true},
+		{name: "Port Zero", raw: "worker1:0", defaultPort: 9090, expectError: true},
+		{name: "Non-Numeric Port", raw: "worker1:http", defaultPort: 9090, expectError: true},
+		{name: "Empty Port", raw: "worker1:", defaultPort: 9090, expectError: true},
+		{name: "Malformed IPv4", raw: "10.0.1.300:9090", defaultPort: 9090, expectError: true},
+		{name: "Invalid Hostname", raw: "worker_1:9090", defaultPort: 9090, expectError: true},
+		{name: "Missing Port Without Default", raw: "worker1", defaultPort: 0, expectError: true},
+		{name: "Empty", raw: " ", defaultPort: 9090, expectError: true},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			target, err := targets.ParseTarget(tc.raw, tc.defaultPort)
+			if tc.expectError {
+				assert.Error(t, err, "Target %q should be rejected", tc.raw)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, target.String())
+		})
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		env         map[string]string
+		expected    targets.Config
+		expectError bool
+	}{
+		{
+			name: "Defaults",
+			env:  map[string]string{"ALGALON_TARGETS": "worker1:9090"},
+			expected: targets.Config{
+				Targets:     "worker1:9090",
+				Cluster:     "production",
+				Environment: "gpu-cluster",
+				DefaultPort: 9090,
+			},
+		},
+		{
+			name: "All Variables Set",
+			env: map[string]string{
+				"ALGALON_TARGETS":      "10.0.1.100,10.0.1.101",
+				"ALGALON_CLUSTER":      "staging",
+				"ALGALON_ENVIRONMENT":  "ml-training",
+				"ALGALON_DEFAULT_PORT": "9091",
+			},
+			expected: targets.Config{
+				Targets:     "10.0.1.100,10.0.1.101",
+				Cluster:     "staging",
+				Environment: "ml-training",
+				DefaultPort: 9091,
+			},
+		},
+		{
+			name: "Blank Cluster Falls Back To Default",
+			env:  map[string]string{"ALGALON_TARGETS": "worker1", "ALGALON_CLUSTER": " "},
+			expected: targets.Config{
+				Targets:     "worker1",
+				Cluster:     "production",
+				Environment: "gpu-cluster",
+				DefaultPort: 9090,
+			},
+		},
+		{
+			name: "DCGM Exporter Uses Its Port",
+			env:  map[string]string{"ALGALON_TARGETS": "worker1", "ALGALON_EXPORTER": "dcgm-exporter"},
+			expected: targets.Config{
+				Targets:     "worker1",
+				Cluster:     "production",
+				Environment: "gpu-cluster",
+				DefaultPort: 9400,
+				Exporter:    "dcgm-exporter",
+			},
+		},
+		{
+			name: "Default Port Overrides Exporter Port",
+			env:  map[string]string{"ALGALON_TARGETS": "worker1", "ALGALON_EXPORTER": "dcgm-exporter", "ALGALON_DEFAULT_PORT": "9401"},
+			expected: targets.Config{
+				Targets:     "worker1",
+				Cluster:     "production",
+				Environment: "gpu-cluster",
+				DefaultPort: 9401,
+				Exporter:    "dcgm-exporter",
+			},
+		},
+		{
+			name:        "Unknown Exporter",
+			env:         map[string]string{"ALGALON_EXPORTER": "node-exporter"},
+			expectError: true,
+		},
+		{
+			name:        "Non-Numeric Default Port",
+			env:         map[string]string{"ALGALON_DEFAULT_PORT": "ninety"},
+			expectError: true,
+		},
+		{
+			name: "Metadata",
+			env: map[string]string{
+				"ALGALON_TARGETS":           "worker1",
+				"ALGALON_GPU_TYPE":          "nvidia-tesla-t4",
+				"ALGALON_GPUS_PER_INSTANCE": "2",
+				"ALGALON_ZONE":              "us-central1-a",
+				"ALGALON_PREEMPTIBLE":       "1",
+				"ALGALON_INSTANCE_PREFIX":   "algalon-worker",
+			},
+			expected: targets.Config{
+				Targets:     "worker1",
+				Cluster:     "production",
+				Environment: "gpu-cluster",
+				DefaultPort: 9090,
+				Metadata: targets.Metadata{
+					GPUType:         "nvidia-tesla-t4",
+					GPUsPerInstance: 2,
+					Zone:            "us-central1-a",
+					Preemptible:     "true",
+				},
+				InstancePrefix: "algalon-worker",
+			},
+		},
+		{
+			name:        "Non-Numeric GPUs Per Instance",
+			env:         map[string]string{"ALGALON_GPUS_PER_INSTANCE": "two"},
+			expectError: true,
+		},
+		{
+			name:        "Non-Boolean Preemptible",
+			env:         map[string]string{"ALGALON_PREEMPTIBLE": "spot"},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg, err := targets.ConfigFromEnv(func(key string) (string, bool) {
+				value, ok := tc.env[key]
+				return value, ok
+			})
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, cfg)
+		})
+	}
+}
+
+func TestConfigGroups(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name            string
+		config          targets.Config
+		expectedTargets []string
+		expectError     bool
+	}{
+		{
+			name:            "Mixed Ports",
+			config:          targets.Config{Targets: "worker1:9090,worker2,10.0.1.100:9091", Cluster: "production", Environment: "gpu-cluster", DefaultPort: 9090},
+			expectedTargets: []string{"worker1:9090", "worker2:9090", "10.0.1.100:9091"},
+		},
+		{
+			name:            "Blank Entries Skipped",
+			config:          targets.Config{Targets: "worker1,, worker2 ,", Cluster: "production", Environment: "gpu-cluster", DefaultPort: 9092},
+			expectedTargets: []string{"worker1:9092", "worker2:9092"},
+		},
+		{
+			name:        "No Targets",
+			config:      targets.Config{Targets: "", Cluster: "production", Environment: "gpu-cluster", DefaultPort: 9090},
+			expectError: true,
+		},
+		{
+			name:        "Duplicate After Default Port",
+			config:      targets.Config{Targets: "worker1,worker1:9090", Cluster: "production", Environment: "gpu-cluster", DefaultPort: 9090},
+			expectError: true,
+		},
+		{
+			name:        "Invalid Default Port",
+			config:      targets.Config{Targets: "worker1", Cluster: "production", Environment: "gpu-cluster", DefaultPort: 0},
+			expectError: true,
+		},
+		{
+			name:        "One Bad Target Fails All",
+			config:      targets.Config{Targets: "worker1:9090,bad host:9090", Cluster: "production", Environment: "gpu-cluster", DefaultPort: 9090},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			groups, err := tc.config.Groups()
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Len(t, groups, 1)
+			assert.Equal(t, tc.expectedTargets, groups[0].Targets)
+			assert.Equal(t, map[string]string{
+				"job":             "all-smi",
+				"cluster":         tc.config.Cluster,
+				"environment":     tc.config.Environment,
+				"monitoring_type": "comprehensive",
+			}, groups[0].Labels)
+		})
+	}
+}
+
+func TestConfigGroupsMetadata(t *testing.T) {
+	t.Parallel()
+
+	metadata := targets.Metadata{GPUType: "nvidia-tesla-t4", GPUsPerInstance: 1, Zone: "us-central1-a", Preemptible: "false"}
+	base := targets.Config{Targets: "10.0.1.100,10.0.1.101", Cluster: "training", Environment: "prod", DefaultPort: 9090, Metadata: metadata}
+
+	testCases := []struct {
+		name     string
+		config   func(targets.Config) targets.Config
+		expected []targets.Group
+		errMsg   string
+	}{
+		{
+			name:   "Shared Metadata",
+			config: func(c targets.Config) targets.Config { return c },
+			expected: []targets.Group{{
+				Targets: []string{"10.0.1.100:9090", "10.0.1.101:9090"},
+				Labels: map[string]string{
+					"job": "all-smi", "cluster": "training", "environment": "prod", "monitoring_type": "comprehensive",
+					"__meta_algalon_gpu_type": "nvidia-tesla-t4", "__meta_algalon_gpus_per_instance": "1",
+					"__meta_algalon_zone": "us-central1-a", "__meta_algalon_preemptible": "false",
+				},
+			}},
+		},
+		{
+			name: "Instance Prefix",
+			config: func(c targets.Config) targets.Config {
+				c.Metadata = targets.Metadata{Zone: "us-central1-a"}
+				c.InstancePrefix = "algalon-worker"
+				return c
+			},
+			expected: []targets.Group{
+				{
+					Targets: []string{"10.0.1.100:9090"},
+					Labels: map[string]string{
+						"job": "all-smi", "cluster": "training", "environment": "prod", "monitoring_type": "comprehensive",
+						"__meta_algalon_zone": "us-central1-a", "__meta_algalon_instance_name": "algalon-worker-1",
+					},
+				},
+				{
+					Targets: []string{"10.0.1.101:9090"},
+					Labels: map[string]string{
+						"job": "all-smi", "cluster": "training", "environment": "prod", "monitoring_type": "comprehensive",
+						"__meta_algalon_zone": "us-central1-a", "__meta_algalon_instance_name": "algalon-worker-2",
+					},
+				},
+			},
+		},
+		{
+			name: "Invalid GPU Type",
+			config: func(c targets.Config) targets.Config {
+				c.Metadata.GPUType = "Tesla T4"
+				return c
+			},
+			errMsg: `invalid GPU type "Tesla T4"`,
+		},
+		{
+			name: "Invalid Instance Prefix",
+			config: func(c targets.Config) targets.Config {
+				c.InstancePrefix = "Worker"
+				return c
+			},
+			errMsg: "invalid instance prefix",
+		},
+		{
+			name: "Instance Name For Several Targets",
+			config: func(c targets.Config) targets.Config {
+				c.Metadata.InstanceName = "algalon-worker-1"
+				return c
+			},
+			errMsg: "can only label a single target",
+		},
+		{
+			name: "Invalid Preemptible",
+			config: func(c targets.Config) targets.Config {
+				c.Metadata.Preemptible = "yes"
+				return c
+			},
+			errMsg: `invalid preemptible "yes"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			groups, err := tc.config(base).Groups()
+			if tc.errMsg != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.errMsg)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, groups)
+
+			// Metadata labels must survive a target file round trip.
+			data, err := targets.Render(targets.DefaultFileName, groups)
+			require.NoError(t, err)
+			parsed, err := targets.Parse(data)
+			require.NoError(t, err)
+			assert.Equal(t, groups, parsed)
+		})
+	}
+}
+
+func TestConfigMetadataSummary(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name      string
+		config    targets.Config
+		instances int
+		expected  []string
+	}{
+		{name: "No Metadata", config: targets.Config{}, instances: 2},
+		{
+			name:      "Metadata In Label Order",
+			config:    targets.Config{Metadata: targets.Metadata{Zone: "us-central1-a", GPUType: "nvidia-tesla-t4", GPUsPerInstance: 2}},
+			instances: 1,
+			expected:  []string{"gpu_type: nvidia-tesla-t4", "gpus_per_instance: 2", "zone: us-central1-a"},
+		},
+		{
+			name:      "Instance Prefix",
+			config:    targets.Config{InstancePrefix: "algalon-worker", Metadata: targets.Metadata{Preemptible: "true"}},
+			instances: 3,
+			expected:  []string{"instance_name: algalon-worker-1 .. algalon-worker-3", "preemptible: true"},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.expected, tc.config.MetadataSummary(tc.instances))
+		})
+	}
+}
+
+func TestGroupByLabels(t *testing.T) {
+	t.Parallel()
+
+	training := targets.DefaultLabels("training", "prod")
+	inference := targets.DefaultLabels("inference", "prod")
+	groups := targets.GroupByLabels(map[string]map[string]string{
+		"10.0.1.101:9090": training,
+		"10.0.2.100:9090": inference,
+		"10.0.1.100:9090": targets.CopyLabels(training),
+	})
+
+	assert.Equal(t, []targets.Group{
+		{Targets: []string{"10.0.2.100:9090"}, Labels: inference},
+		{Targets: []string{"10.0.1.100:9090", "10.0.1.101:9090"}, Labels: training},
+	}, groups)
+	assert.True(t, targets.SameLabels(training, targets.CopyLabels(training)))
+	assert.False(t, targets.SameLabels(training, inference))
+}
+
+func TestTargetsFileRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	cfg := targets.Config{
+		Targets:     "10.128.0.2:9090,10.128.0.3:9090,[fd00::2]:9091",
+		Cluster:     "production",
+		Environment: "gpu-cluster",
+		DefaultPort: 9090,
+	}
+	groups, err := cfg.Groups()
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), targets.DefaultFileName)
+	require.NoError(t, targets.WriteFile(path, groups))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "# targets/all-smi-targets.yml")
+
+	parsed, err := targets.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, groups, parsed)
+}
+
+func TestParseTargetsFile(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		content     string
+		expectError bool
+	}{
+		{
+			name:    "Shipped Example",
+			content: "- targets:\n    - 'localhost:9090'\n  labels:\n    job: 'all-smi'\n",
+		},
+		{
+			name:        "Not A List",
+			content:     "targets: ['localhost:9090']\n",
+			expectError: true,
+		},
+		{
+			name:        "Empty Group",
+			content:     "- targets: []\n  labels:\n    job: 'all-smi'\n",
+			expectError: true,
+		},
+		{
+			name:        "Target Without Port",
+			content:     "- targets: ['localhost']\n",
+			expectError: true,
+		},
+		{
+			name:        "Duplicate Across Groups",
+			content:     "- targets: ['worker1:9090']\n- targets: ['worker1:9090']\n",
+			expectError: true,
+		},
+		{
+			name:    "Metadata Label",
+			content: "- targets: ['worker1:9090']\n  labels:\n    __meta_algalon_gpu_type: 'nvidia-tesla-t4'\n",
+		},
+		{
+			name:        "Metadata Label Set Twice",
+			content:     "- targets: ['worker1:9090']\n  labels:\n    gpu_type: 'a100'\n    __meta_algalon_gpu_type: 'nvidia-tesla-a100'\n",
+			expectError: true,
+		},
+		{
+			name:    "Metadata Label And Other Plain Label",
+			content: "- targets: ['worker1:9090']\n  labels:\n    gpu_type: 'a100'\n    __meta_algalon_zone: 'us-central1-a'\n",
+		},
+		{
+			name:        "Other Reserved Label",
+			content:     "- targets: ['worker1:9090']\n  labels:\n    __address__: 'worker2:9090'\n",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc // capture range variable
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := targets.Parse([]byte(tc.content))
+			if tc.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestShippedTargetFilesAreValid(t *testing.T) {
+	t.Parallel()
+
+	files, err := filepath.Glob("../../algalon_host/node/targets/*.yml")
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+
+	for _, file := range files {
+		_, err := targets.ReadFile(file)
+		assert.NoError(t, err, "Shipped target file %s should be valid file_sd YAML", file)
+	}
+}
@@ -5,6 +5,8 @@ import (
 
 	"github.com/gruntwork-io/terratest/modules/terraform"
 	"github.com/stretchr/testify/assert"
+
+	"algalon-terraform-testsupport"
 )
 
 func TestTerraformValidation(t *testing.T) {
@@ -50,11 +52,7 @@ func TestTerraformValidation(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
 
-			terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-				TerraformDir: tc.path,
-				NoColor:      true,
-				Vars:         tc.vars,
-			})
+			terraformOptions := testsupport.PlanOptions(t, tc.path, tc.vars)
 
 			// Initialize and validate using plan
 			// Plan includes validation and works with variables
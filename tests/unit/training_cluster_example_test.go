@@ -4,18 +4,18 @@ import (
 	"testing"
 
 	"github.com/gruntwork-io/terratest/modules/terraform"
+	tfjson "github.com/hashicorp/terraform-json"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"algalon-terraform-testsupport"
 )
 
 func TestTrainingClusterExampleValidation(t *testing.T) {
 	t.Parallel()
 
-	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "../../terraform/examples/training-cluster",
-		NoColor:      true,
-		Vars: map[string]interface{}{
-			"project_id": "test-project-id",
-		},
+	terraformOptions := testsupport.PlanOptions(t, testsupport.TrainingClusterExample, map[string]interface{}{
+		"project_id": "test-project-id",
 	})
 
 	// Test initialization and planning (includes validation)
@@ -27,13 +27,9 @@ func TestTrainingClusterExampleValidation(t *testing.T) {
 func TestTrainingClusterExamplePlan(t *testing.T) {
 	t.Parallel()
 
-	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "../../terraform/examples/training-cluster",
-		NoColor:      true,
-		Vars: map[string]interface{}{
-			"project_id": "test-project-123",
-			"region":     "us-central1",
-		},
+	terraformOptions := testsupport.PlanOptions(t, testsupport.TrainingClusterExample, map[string]interface{}{
+		"project_id": "test-project-123",
+		"region":     "us-central1",
 	})
 
 	terraform.Init(t, terraformOptions)
@@ -44,35 +40,79 @@ func TestTrainingClusterExamplePlan(t *testing.T) {
 func TestTrainingClusterExampleWithWorkers(t *testing.T) {
 	t.Parallel()
 
-	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "../../terraform/examples/training-cluster",
-		NoColor:      true,
-		Vars: map[string]interface{}{
-			"project_id":                "test-project-123",
-			"region":                    "us-central1",
-			"worker_count":              2,
-			"worker_machine_type":       "n1-standard-2",
-			"gpu_type":                  "nvidia-tesla-t4",
-			"gpu_count":                 1,
-			"enable_worker_external_ip": false,
-		},
+	plan := testsupport.PlanAndShow(t, testsupport.TrainingClusterExample, map[string]interface{}{
+		"project_id":                "test-project-123",
+		"region":                    "us-central1",
+		"deployment_name":           "algalon-plan",
+		"cluster_name":              "training",
+		"environment_name":          "staging",
+		"worker_count":              2,
+		"worker_machine_type":       "n1-standard-2",
+		"gpu_type":                  "nvidia-tesla-t4",
+		"gpu_count":                 1,
+		"use_preemptible_workers":   true,
+		"enable_worker_external_ip": false,
+		"grafana_allowed_ips":       []string{"203.0.113.0/24"},
 	})
 
-	terraform.Init(t, terraformOptions)
-	_, planErr := terraform.PlanE(t, terraformOptions)
-	assert.NoError(t, planErr)
+	var hosts, workers []*tfjson.StateResource
+	for _, instance := range testsupport.PlannedResources(plan, "google_compute_instance") {
+		switch testsupport.StringMap(instance.AttributeValues["labels"])["component"] {
+		case "algalon-host":
+			hosts = append(hosts, instance)
+		case "algalon-worker":
+			workers = append(workers, instance)
+		default:
+			t.Errorf("Unexpected instance %s", instance.Address)
+		}
+	}
+
+	require.Len(t, hosts, 1)
+	assert.Equal(t, "algalon-plan-monitoring", hosts[0].AttributeValues["name"])
+
+	// worker_count * gpu_count GPUs at gpu_count per instance
+	require.Len(t, workers, 2)
+	assert.Equal(t, []string{"algalon-plan-worker-1", "algalon-plan-worker-2"}, testsupport.PlannedNames(workers))
+
+	for _, worker := range workers {
+		assert.Equal(t, "n1-standard-2", worker.AttributeValues["machine_type"])
+
+		accelerators := testsupport.NestedBlocks(worker, "guest_accelerator")
+		require.Len(t, accelerators, 1)
+		assert.Equal(t, "nvidia-tesla-t4", accelerators[0]["type"])
+		assert.EqualValues(t, 1, accelerators[0]["count"])
+
+		scheduling := testsupport.NestedBlocks(worker, "scheduling")
+		require.Len(t, scheduling, 1)
+		assert.Equal(t, true, scheduling[0]["preemptible"])
+	}
+
+	for _, instance := range append(hosts, workers...) {
+		labels := testsupport.StringMap(instance.AttributeValues["labels"])
+		assert.Equal(t, "training", labels["cluster"], instance.Address)
+		assert.Equal(t, "staging", labels["environment"], instance.Address)
+	}
+
+	rules := testsupport.PlannedResources(plan, "google_compute_firewall")
+	assert.ElementsMatch(t, []string{
+		"algalon-network-grafana",
+		"algalon-network-internal",
+		"algalon-network-metrics-internal",
+		"algalon-network-ssh",
+	}, testsupport.PlannedNames(rules))
+	for _, rule := range rules {
+		if rule.AttributeValues["name"] == "algalon-network-grafana" {
+			assert.Equal(t, []string{"203.0.113.0/24"}, testsupport.StringList(rule.AttributeValues["source_ranges"]))
+		}
+	}
 }
 
 func TestTrainingClusterExampleMinimal(t *testing.T) {
 	t.Parallel()
 
-	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "../../terraform/examples/training-cluster",
-		NoColor:      true,
-		Vars: map[string]interface{}{
-			"project_id":    "test-project-minimal",
-			"worker_count":  0, // Host-only deployment
-		},
+	terraformOptions := testsupport.PlanOptions(t, testsupport.TrainingClusterExample, map[string]interface{}{
+		"project_id":    "test-project-minimal",
+		"worker_count":  0, // Host-only deployment
 	})
 
 	terraform.Init(t, terraformOptions)
@@ -0,0 +1,274 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/appleparan/Algalon/pkg/agent"
+	"github.com/appleparan/Algalon/pkg/registry"
+	"github.com/appleparan/Algalon/pkg/targets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chanNotice fires when its channel is closed.
+type chanNotice chan struct{}
+
+func (n chanNotice) Wait(ctx context.Context) error {
+	select {
+	case <-n:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (n chanNotice) String() string { return "test notice" }
+
+// newFlakyRegistryServer answers the first failFirst requests with 503, like a
+// registry that is still starting.
+func newFlakyRegistryServer(t *testing.T, failFirst int32) (*registry.Store, *httptest.Server) {
+	t.Helper()
+
+	store, err := registry.NewStore(filepath.Join(t.TempDir(), targets.DefaultFileName), targets.DefaultLabels("production", "gpu-cluster"))
+	require.NoError(t, err)
+
+	handler := registry.NewHandler(store, log.New(io.Discard, "", 0))
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= failFirst {
+			http.Error(w, "starting up", http.StatusServiceUnavailable)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	return store, server
+}
+
+func newTestAgent(registryURL, target string) *agent.Agent {
+	return &agent.Agent{
+		RegistryURL:       registryURL,
+		Target:            target,
+		Labels:            map[string]string{"gpu_type": "t4"},
+		HeartbeatInterval: 20 * time.Millisecond,
+		RetryInterval:     10 * time.Millisecond,
+		Logger:            log.New(io.Discard, "", 0),
+	}
+}
+
+func registered(store *registry.Store) []string {
+	var all []string
+	for _, worker := range store.List() {
+		all = append(all, worker.Target)
+	}
+	return all
+}
+
+func TestAgentRegistersHeartbeatsAndDeregisters(t *testing.T) {
+	t.Parallel()
+
+	store, server := newFlakyRegistryServer(t, 2)
+	a := newTestAgent(server.URL, "10.128.0.2:9090")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- a.Run(ctx) }()
+
+	require.Eventually(t, func() bool { return len(store.List()) == 1 }, 5*time.Second, 10*time.Millisecond,
+		"Agent should retry until the registry is up")
+	worker := store.List()[0]
+	assert.Equal(t, "10.128.0.2:9090", worker.Target)
+	assert.Equal(t, "t4", worker.Labels["gpu_type"])
+	assert.Equal(t, "production", worker.Labels["cluster"])
+
+	// A heartbeat restores a worker the host dropped, e.g. after a reaper run.
+	require.NoError(t, store.Remove("10.128.0.2:9090"))
+	require.Eventually(t, func() bool { return len(store.List()) == 1 }, 5*time.Second, 10*time.Millisecond,
+		"Heartbeat should re-register the worker")
+
+	cancel()
+	require.NoError(t, <-done)
+	assert.Empty(t, registered(store), "Shutdown should deregister the worker")
+}
+
+func TestAgentDeregistersOnNotice(t *testing.T) {
+	t.Parallel()
+
+	store, server := newFlakyRegistryServer(t, 0)
+	a := newTestAgent(server.URL, "10.128.0.3:9090")
+	preempted := make(chanNotice)
+	a.Notices = []agent.Notice{preempted}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- a.Run(ctx) }()
+
+	require.Eventually(t, func() bool { return len(store.List()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	close(preempted)
+
+	require.Eventually(t, func() bool { return len(store.List()) == 0 }, 5*time.Second, 10*time.Millisecond,
+		"A notice should deregister the worker")
+
+	// Returning would let Docker restart the agent and register the worker
+	// again, so Run waits for the shutdown instead.
+	select {
+	case err := <-done:
+		t.Fatalf("Run returned after a notice: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	assert.Empty(t, registered(store), "No heartbeat after the notice")
+
+	cancel()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run should return once the context is cancelled")
+	}
+	assert.Empty(t, registered(store))
+}
+
+func TestAgentWaitsForReady(t *testing.T) {
+	t.Parallel()
+
+	store, server := newFlakyRegistryServer(t, 0)
+	a := newTestAgent(server.URL, "10.128.0.4:9090")
+	ready := make(chan struct{})
+	a.Ready = func(ctx context.Context) error {
+		select {
+		case <-ready:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- a.Run(ctx) }()
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Empty(t, registered(store), "Agent must not register before all-smi is ready")
+
+	close(ready)
+	require.Eventually(t, func() bool { return len(store.List()) == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestAgentStopsOnRejectedTarget(t *testing.T) {
+	t.Parallel()
+
+	_, server := newFlakyRegistryServer(t, 0)
+	a := newTestAgent(server.URL, "worker_1:9090")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := a.Run(ctx)
+	require.Error(t, err, "A target the registry rejects should not be retried forever")
+	assert.Contains(t, err.Error(), "HTTP 400")
+	assert.NoError(t, ctx.Err())
+}
+
+func TestMetadataNoticeFiresOnPreemption(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu       sync.Mutex
+		requests []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/instance/preempted", r.URL.Path)
+		assert.Equal(t, "Google", r.Header.Get("Metadata-Flavor"))
+
+		mu.Lock()
+		requests = append(requests, r.URL.RawQuery)
+		n := len(requests)
+		mu.Unlock()
+
+		if n == 1 {
+			w.Header().Set("ETag", "etag-1")
+			fmt.Fprint(w, "FALSE")
+			return
+		}
+		w.Header().Set("ETag", "etag-2")
+		fmt.Fprint(w, "TRUE")
+	}))
+	t.Cleanup(server.Close)
+
+	notice := agent.PreemptionNotice()
+	notice.MetadataURL = server.URL
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, notice.Wait(ctx))
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"", "wait_for_change=true&last_etag=etag-1"}, requests,
+		"A plain read should be followed by a poll waiting for a change after its ETag")
+}
+
+func TestMetadataNoticeFiresWhenAlreadySet(t *testing.T) {
+	t.Parallel()
+
+	// The VM was preempted before the agent (re)started: the value is
+	// already TRUE and will not change again.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("wait_for_change") == "true" {
+			<-r.Context().Done()
+			return
+		}
+		w.Header().Set("ETag", "etag-1")
+		fmt.Fprint(w, "TRUE")
+	}))
+	t.Cleanup(server.Close)
+
+	notice := agent.PreemptionNotice()
+	notice.MetadataURL = server.URL
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, notice.Wait(ctx), "A notice that is already set should fire at once")
+}
+
+func TestMetadataNoticeStopsWithContext(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "NONE")
+	}))
+	t.Cleanup(server.Close)
+
+	notice := agent.MaintenanceNotice()
+	notice.MetadataURL = server.URL
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, notice.Wait(ctx), context.DeadlineExceeded)
+}
+
+func TestAdvertiseAddress(t *testing.T) {
+	t.Parallel()
+
+	ip, err := agent.AdvertiseAddress("http://127.0.0.1:8430")
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", ip)
+
+	_, err = agent.AdvertiseAddress("not a url")
+	assert.Error(t, err)
+}